@@ -1,14 +1,39 @@
 package command
 
+import "errors"
+
 type BaseParameters struct {
 	LogLevel string `json:"logLevel" yaml:"logLevel"`
+	// LogFormat is text, json or logfmt
+	LogFormat string `json:"logFormat" yaml:"logFormat"`
+	// LogFile is written to as well as stderr when set, rotated once it grows past
+	// LogMaxSize megabytes, keeping LogMaxBackups rotated files
+	LogFile       string `json:"logFile" yaml:"logFile"`
+	LogMaxSize    int    `json:"logMaxSize" yaml:"logMaxSize"`
+	LogMaxBackups int    `json:"logMaxBackups" yaml:"logMaxBackups"`
 }
 
 type Command struct {
-	Handle            func()
+	// Handle runs the command, its error is mapped to an exit code by ExitCode
+	Handle            func() error
 	GetBaseParameters func() BaseParameters
 }
 
-var Commands = map[string]func() *Command{
-	"deploy": createDeployCommand,
+var Commands = map[string]func() (*Command, error){
+	"deploy":   createDeployCommand,
+	"watch":    createWatchCommand,
+	"serve":    createServeCommand,
+	"rollback": createRollbackCommand,
+	"status":   createStatusCommand,
+	"plan":     createPlanCommand,
+}
+
+// parseError turns an error of a parameters parser into a config error, printing
+// the usage when parameters are missing
+func parseError(err error, printUsage PrintUsageFunc) error {
+	var missingParamsErr *missingParamsError
+	if errors.As(err, &missingParamsErr) {
+		printUsage()
+	}
+	return &configError{err: err}
 }
@@ -7,3 +7,33 @@ func TestDeployCommandExists(t *testing.T) {
 		t.Fatal("Command 'deploy' should exist in Commands map")
 	}
 }
+
+func TestWatchCommandExists(t *testing.T) {
+	if _, ok := Commands["watch"]; !ok {
+		t.Fatal("Command 'watch' should exist in Commands map")
+	}
+}
+
+func TestServeCommandExists(t *testing.T) {
+	if _, ok := Commands["serve"]; !ok {
+		t.Fatal("Command 'serve' should exist in Commands map")
+	}
+}
+
+func TestRollbackCommandExists(t *testing.T) {
+	if _, ok := Commands["rollback"]; !ok {
+		t.Fatal("Command 'rollback' should exist in Commands map")
+	}
+}
+
+func TestStatusCommandExists(t *testing.T) {
+	if _, ok := Commands["status"]; !ok {
+		t.Fatal("Command 'status' should exist in Commands map")
+	}
+}
+
+func TestPlanCommandExists(t *testing.T) {
+	if _, ok := Commands["plan"]; !ok {
+		t.Fatal("Command 'plan' should exist in Commands map")
+	}
+}
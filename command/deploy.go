@@ -1,22 +1,65 @@
 package command
 
 import (
-	"errors"
+	"cmp"
+	"context"
+	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/gnugomez/voyage/docker"
 	"github.com/gnugomez/voyage/git"
+	"github.com/gnugomez/voyage/lock"
 	"github.com/gnugomez/voyage/log"
+	"github.com/gnugomez/voyage/notify"
+	"github.com/gnugomez/voyage/registry"
+	"github.com/gnugomez/voyage/secrets"
+	"github.com/gnugomez/voyage/state"
 )
 
+// maxRetryBackoff caps the exponential backoff between retries of a failed deploy
+const maxRetryBackoff = time.Hour
+
 type Syncer interface {
-	Sync(subDirs []string) ([]string, error)
+	HeadReader
+	Sync(targets []git.Target) (*git.SyncResult, error)
+}
+
+// HeadReader returns the commit checked out in the clone, or "" before it is cloned
+type HeadReader interface {
+	Head() (string, error)
 }
 
 type Deployer interface {
-	DeployCompose(targetPath string, daemonMode bool) error
+	DeployCompose(options docker.ComposeOptions, healthTimeout time.Duration) error
+}
+
+type ImageChecker interface {
+	UpdatedImages(ctx context.Context, options docker.ComposeOptions) ([]string, error)
+}
+
+type SecretDecrypter interface {
+	Decrypt(dirs []string) ([]string, error)
+}
+
+type Notifier interface {
+	Notify(ctx context.Context, event notify.Event) error
+}
+
+type Locker interface {
+	Acquire() error
+	Release() error
+}
+
+type StateStore interface {
+	Load() (*state.State, error)
+	Save(st *state.State) error
+	// Exists reports whether the state was ever saved
+	Exists() (bool, error)
 }
 
 // stringSlice implements flag.Value interface for handling multiple string values
@@ -38,92 +81,879 @@ type DeployCommandParameters struct {
 	OutPath            string   `json:"outPath" yaml:"outPath"`
 	RemoteComposePaths []string `json:"remoteComposePaths" yaml:"remoteComposePaths"`
 	Force              bool     `json:"force" yaml:"force"`
+	RetryMaxAttempts   int      `json:"retryMaxAttempts" yaml:"retryMaxAttempts"`
+	RetryBackoff       Duration `json:"retryBackoff" yaml:"retryBackoff"`
+	// Tag is a glob or semver range of the release tags to deploy from instead of
+	// the head of Branch, see git.TagPattern
+	Tag string `json:"tag" yaml:"tag"`
+	// LockTimeout is how long a run waits for another run on the same OutPath to
+	// release its lock, zero fails right away
+	LockTimeout Duration `json:"lockTimeout" yaml:"lockTimeout"`
+	// HealthTimeout is how long a deploy waits for its containers to become
+	// healthy before it counts as failed, zero disables the health gate
+	HealthTimeout Duration `json:"healthTimeout" yaml:"healthTimeout"`
+	// GitBackend selects how voyage runs git, see git.NewGitService
+	GitBackend string `json:"gitBackend" yaml:"gitBackend"`
+	// GitSSHKey and GitKnownHosts are the private key and the only known hosts
+	// file used for ssh remotes
+	GitSSHKey     string `json:"gitSSHKey" yaml:"gitSSHKey"`
+	GitKnownHosts string `json:"gitKnownHosts" yaml:"gitKnownHosts"`
+	// GitUsername is sent to https remotes with the token read from GitTokenFile,
+	// or from the environment variable named by GitTokenEnv
+	GitUsername  string `json:"gitUsername" yaml:"gitUsername"`
+	GitTokenFile string `json:"gitTokenFile" yaml:"gitTokenFile"`
+	GitTokenEnv  string `json:"gitTokenEnv" yaml:"gitTokenEnv"`
+	// GitDepth limits the clone to that many commits of history, 0 clones all of it
+	GitDepth int `json:"gitDepth" yaml:"gitDepth"`
+	// GitFilter is the partial clone filter, such as blob:none
+	GitFilter string `json:"gitFilter" yaml:"gitFilter"`
+	// GitSparse checks out only the directories of the compose files and what they reference
+	GitSparse bool `json:"gitSparse" yaml:"gitSparse"`
+	// DockerBackend selects how voyage talks to docker, see docker.NewDockerService
+	DockerBackend string `json:"dockerBackend" yaml:"dockerBackend"`
+	// AutoRevert redeploys the last good commit of a compose path when its deploy fails
+	AutoRevert bool `json:"autoRevert" yaml:"autoRevert"`
+	// ImageUpdates redeploys a compose path when an image tag it uses points at a
+	// new digest in its registry
+	ImageUpdates bool `json:"imageUpdates" yaml:"imageUpdates"`
+	// SopsAgeKeyFile is the age key SOPS encrypted files are decrypted with before
+	// a deploy, decryption is disabled when it is empty
+	SopsAgeKeyFile string `json:"sopsAgeKeyFile" yaml:"sopsAgeKeyFile"`
+	// Notifications lists the services deploy results are sent to
+	Notifications []notify.Config `json:"notifications" yaml:"notifications"`
+	// FailurePolicy is what a deploy does when a stack fails to deploy, FailFast
+	// skips the stacks after it and Continue deploys them
+	FailurePolicy string `json:"failurePolicy" yaml:"failurePolicy"`
+	// MaxParallel is how many stacks are deployed at once
+	MaxParallel int `json:"maxParallel" yaml:"maxParallel"`
+	// SummaryFile is where the outcome of every deploy cycle is written as JSON
+	SummaryFile string `json:"summaryFile" yaml:"summaryFile"`
+	// Stacks holds per compose path options, keyed by compose path
+	Stacks map[string]StackParameters `json:"stacks" yaml:"stacks"`
+}
+
+// StackParameters overrides the deploy options of a single compose path
+type StackParameters struct {
+	HealthTimeout *Duration `json:"healthTimeout" yaml:"healthTimeout"`
+	AutoRevert    *bool     `json:"autoRevert" yaml:"autoRevert"`
+	ImageUpdates  *bool     `json:"imageUpdates" yaml:"imageUpdates"`
+	// Overrides are compose files merged, in order, on top of the compose path
+	// into one project, like 'docker compose -f compose.yml -f compose.override.yml'
+	Overrides []string `json:"overrides" yaml:"overrides"`
+	// ProjectName replaces the project name compose derives from the directory
+	ProjectName string   `json:"projectName" yaml:"projectName"`
+	Profiles    []string `json:"profiles" yaml:"profiles"`
+	// EnvFiles and ProjectDirectory are relative to the repository
+	EnvFiles         []string `json:"envFiles" yaml:"envFiles"`
+	ProjectDirectory string   `json:"projectDirectory" yaml:"projectDirectory"`
+	RemoveOrphans    bool     `json:"removeOrphans" yaml:"removeOrphans"`
+	Build            bool     `json:"build" yaml:"build"`
+	// Pull is the pull policy of compose up, one of docker.PullPolicies
+	Pull          string `json:"pull" yaml:"pull"`
+	ForceRecreate bool   `json:"forceRecreate" yaml:"forceRecreate"`
+}
+
+// composeFiles returns the compose files of a stack, relative to the repository:
+// the compose path followed by its overrides.
+func (p DeployCommandParameters) composeFiles(composePath string) []string {
+	return append([]string{composePath}, p.Stacks[composePath].Overrides...)
+}
+
+// composeFilePaths returns the compose files of a stack in the checked out repository
+func (p DeployCommandParameters) composeFilePaths(composePath string) []string {
+	var paths []string
+	for _, file := range p.composeFiles(composePath) {
+		paths = append(paths, filepath.Join(p.OutPath, file))
+	}
+	return paths
+}
+
+// composeOptions returns the options compose deploys a stack with
+func (p DeployCommandParameters) composeOptions(composePath string) docker.ComposeOptions {
+	stack := p.Stacks[composePath]
+	options := docker.ComposeOptions{
+		Files:         p.composeFilePaths(composePath),
+		ProjectName:   stack.ProjectName,
+		Profiles:      stack.Profiles,
+		Detach:        true,
+		RemoveOrphans: stack.RemoveOrphans,
+		Build:         stack.Build,
+		Pull:          stack.Pull,
+		ForceRecreate: stack.ForceRecreate,
+	}
+	for _, envFile := range stack.EnvFiles {
+		options.EnvFiles = append(options.EnvFiles, filepath.Join(p.OutPath, envFile))
+	}
+	if stack.ProjectDirectory != "" {
+		options.ProjectDirectory = filepath.Join(p.OutPath, stack.ProjectDirectory)
+	}
+	return options
+}
+
+// subDirs returns the repository subdirectories the compose files of a stack live in
+func (p DeployCommandParameters) subDirs(composePath string) []string {
+	var subDirs []string
+	for _, file := range p.composeFiles(composePath) {
+		if subDir := composeSubDir(file); !slices.Contains(subDirs, subDir) {
+			subDirs = append(subDirs, subDir)
+		}
+	}
+	return subDirs
+}
+
+// checkPinnable returns an error when a directory of composePath overlaps one of
+// another compose path. Pins check out whole directories, so pinning such a stack
+// would also change the files the other stack is deployed from.
+func (p DeployCommandParameters) checkPinnable(composePath string) error {
+	subDirs := p.subDirs(composePath)
+	for _, other := range p.RemoteComposePaths {
+		if other == composePath {
+			continue
+		}
+		for _, otherSubDir := range p.subDirs(other) {
+			for _, subDir := range subDirs {
+				if inSubDirs(subDir, []string{otherSubDir}) || inSubDirs(otherSubDir, []string{subDir}) {
+					return fmt.Errorf("stack %s shares directory %q with stack %s, which would be rolled back with it", composePath, cmp.Or(subDir, "."), other)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// healthTimeout returns the health gate timeout of a compose path
+func (p DeployCommandParameters) healthTimeout(composePath string) time.Duration {
+	if stack, ok := p.Stacks[composePath]; ok && stack.HealthTimeout != nil {
+		return time.Duration(*stack.HealthTimeout)
+	}
+	return time.Duration(p.HealthTimeout)
+}
+
+// gitAuth returns the credentials git talks to the remote with, reading the token
+// from its file or environment variable
+func (p DeployCommandParameters) gitAuth() (git.Auth, error) {
+	auth := git.Auth{SSHKeyFile: p.GitSSHKey, KnownHostsFile: p.GitKnownHosts, Username: p.GitUsername}
+	switch {
+	case p.GitTokenFile != "":
+		content, err := os.ReadFile(p.GitTokenFile)
+		if err != nil {
+			return git.Auth{}, fmt.Errorf("failed to read git token: %w", err)
+		}
+		auth.Token = strings.TrimSpace(string(content))
+	case p.GitTokenEnv != "":
+		auth.Token = os.Getenv(p.GitTokenEnv)
+		if auth.Token == "" {
+			return git.Auth{}, fmt.Errorf("environment variable %s holding the git token is empty", p.GitTokenEnv)
+		}
+	}
+	return auth, nil
+}
+
+// tracked describes the ref stacks are deployed from, for messages
+func (p DeployCommandParameters) tracked() string {
+	if p.Tag != "" {
+		return "tags " + p.Tag
+	}
+	return p.Branch
+}
+
+// locker creates the lock runs on OutPath share. The lock file sits next to OutPath,
+// which may not be cloned yet.
+func (p DeployCommandParameters) locker() *lock.Lock {
+	return lock.New(filepath.Clean(p.OutPath)+".lock", time.Duration(p.LockTimeout))
+}
+
+// repository creates the git repository the parameters describe
+func (p DeployCommandParameters) repository() (*git.Repository, error) {
+	auth, err := p.gitAuth()
+	if err != nil {
+		return nil, err
+	}
+	gitService, err := git.NewGitService(p.GitBackend, auth)
+	if err != nil {
+		return nil, err
+	}
+	repo := git.CreateRepository(p.Repo, p.Branch, p.OutPath, gitService)
+	if p.Tag != "" {
+		if repo.Tag, err = git.ParseTagPattern(p.Tag); err != nil {
+			return nil, err
+		}
+	}
+	repo.Depth = p.GitDepth
+	repo.Filter = p.GitFilter
+	repo.Sparse = p.GitSparse
+	repo.References = func(target git.Target) []string { return p.references(target.Name) }
+	return repo, nil
+}
+
+// references returns the paths outside their directories that the checked out compose
+// files of a stack refer to, and its env files, relative to the repository. Paths outside
+// the repository are skipped.
+func (p DeployCommandParameters) references(composePath string) []string {
+	root, err := filepath.Abs(p.OutPath)
+	if err != nil {
+		return nil
+	}
+
+	options := p.composeOptions(composePath)
+	var paths []string
+	for _, composeFilePath := range options.Files {
+		if _, err := os.Stat(composeFilePath); err != nil {
+			return nil // not cloned yet, every compose file is deployed anyway
+		}
+
+		filePaths, err := docker.ComposeReferences(composeFilePath)
+		if err != nil {
+			log.Error("Error reading the files referenced by compose file, only its directory is checked for changes", "composePath", composePath, "composeFilePath", composeFilePath, "error", err)
+			continue
+		}
+		paths = append(paths, filePaths...)
+	}
+	for _, envFile := range options.EnvFiles {
+		if path, err := filepath.Abs(envFile); err == nil {
+			paths = append(paths, path)
+		}
+	}
+
+	subDirs := p.subDirs(composePath)
+	var references []string
+	for _, path := range paths {
+		rel, err := filepath.Rel(root, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			log.Debug("Skipping file referenced from outside the repository", "composePath", composePath, "path", path)
+			continue
+		}
+		rel = filepath.ToSlash(rel)
+		if inSubDirs(rel, subDirs) || slices.Contains(references, rel) {
+			continue // already covered by a compose file's directory
+		}
+		references = append(references, rel)
+	}
+	return references
 }
 
+// inSubDirs reports whether the repository path is inside one of subDirs
+func inSubDirs(path string, subDirs []string) bool {
+	for _, subDir := range subDirs {
+		if subDir == "" || path == subDir || strings.HasPrefix(path, subDir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// autoRevert reports whether a failed deploy of a compose path is reverted
+func (p DeployCommandParameters) autoRevert(composePath string) bool {
+	if stack, ok := p.Stacks[composePath]; ok && stack.AutoRevert != nil {
+		return *stack.AutoRevert
+	}
+	return p.AutoRevert
+}
+
+// imageUpdates reports whether a compose path is redeployed when its images are updated
+func (p DeployCommandParameters) imageUpdates(composePath string) bool {
+	if stack, ok := p.Stacks[composePath]; ok && stack.ImageUpdates != nil {
+		return *stack.ImageUpdates
+	}
+	return p.ImageUpdates
+}
+
+// deployOptions returns the options compose runs a deploy with, pulling the images
+// when their update triggered it.
+func (p DeployCommandParameters) deployOptions(decision deployDecision) docker.ComposeOptions {
+	options := p.composeOptions(decision.ComposePath)
+	if len(decision.Images) > 0 {
+		options.Pull = "always"
+	}
+	return options
+}
+
+// Failure policies of a deploy
+const (
+	FailFast = "fail-fast"
+	Continue = "continue"
+)
+
 type deployCommand struct {
-	params   DeployCommandParameters
-	syncer   Syncer
-	deployer Deployer
+	// mu guards the deployment state, the state file and the working tree while
+	// stacks are deployed in parallel
+	mu        sync.Mutex
+	params    DeployCommandParameters
+	syncer    Syncer
+	deployer  Deployer
+	reverter  Reverter
+	images    ImageChecker
+	decrypter SecretDecrypter
+	notifier  Notifier
+	locker    Locker
+	store     StateStore
 }
 
 func (d *deployCommand) GetBaseParameters() BaseParameters {
 	return d.params.BaseParameters
 }
 
-func (d *deployCommand) Handle() {
+func (d *deployCommand) Handle() error {
+	summary := &deploySummary{Branch: d.params.Branch, StartedAt: time.Now()}
+	err := d.run(summary)
+	if d.params.SummaryFile != "" {
+		summary.finish(err, time.Now())
+		if err := writeSummary(d.params.SummaryFile, summary); err != nil {
+			log.Error("Error writing summary file", "path", d.params.SummaryFile, "error", err)
+		}
+	}
+	return err
+}
+
+// run executes a deploy cycle, recording the result of every stack in summary
+func (d *deployCommand) run(summary *deploySummary) error {
 	// Lazy initialization of dependencies. In tests, these will be pre-filled with mocks.
-	if d.syncer == nil {
-		d.syncer = git.CreateRepository(d.params.Repo, d.params.Branch, d.params.OutPath)
+	if d.syncer == nil || d.reverter == nil {
+		repository, err := d.params.repository()
+		if err != nil {
+			return fmt.Errorf("failed to create git service: %w", err)
+		}
+		if d.syncer == nil {
+			d.syncer = repository
+		}
+		if d.reverter == nil {
+			d.reverter = repository
+		}
+	}
+	if d.deployer == nil || d.images == nil {
+		dockerService, err := docker.NewDockerService(d.params.DockerBackend)
+		if err != nil {
+			return fmt.Errorf("failed to create docker service: %w", err)
+		}
+		if d.deployer == nil {
+			d.deployer = docker.NewDeployer(dockerService)
+		}
+		if d.images == nil {
+			d.images = docker.NewImageChecker(dockerService, registry.NewClient())
+		}
+	}
+	if d.decrypter == nil && d.params.SopsAgeKeyFile != "" {
+		d.decrypter = secrets.NewDecrypter(d.params.OutPath, d.params.SopsAgeKeyFile)
+	}
+	if d.notifier == nil && len(d.params.Notifications) > 0 {
+		dispatcher, err := notify.NewDispatcher(d.params.Notifications)
+		if err != nil {
+			return &configError{err: err}
+		}
+		d.notifier = dispatcher
 	}
-	if d.deployer == nil {
-		d.deployer = docker.NewDeployer()
+	if d.locker == nil {
+		d.locker = d.params.locker()
+	}
+	if d.store == nil {
+		d.store = state.NewStore(d.params.OutPath)
 	}
 
 	log.Debug("Running command with parameters", "repo", d.params.Repo, "branch", d.params.Branch, "remoteComposePaths", d.params.RemoteComposePaths, "out-path", d.params.OutPath)
 
-	// Create map of subdirectories to compose paths for change detection
-	subDirToComposePaths := make(map[string][]string)
-	var subDirs []string
-
-	for _, composePath := range d.params.RemoteComposePaths {
-		subDir := filepath.Dir(composePath)
-		if subDir == "." {
-			subDir = "" // root of repo
+	// Hold the lock from the sync to the last deploy, so runs never share the working tree
+	if err := d.locker.Acquire(); err != nil {
+		return err
+	}
+	defer func() {
+		if err := d.locker.Release(); err != nil {
+			log.Error("Error releasing lock", "error", err)
 		}
+	}()
 
-		// Add to map
-		if _, exists := subDirToComposePaths[subDir]; !exists {
-			subDirToComposePaths[subDir] = []string{}
-			subDirs = append(subDirs, subDir)
-		}
-		subDirToComposePaths[subDir] = append(subDirToComposePaths[subDir], composePath)
+	st, stateExists, err := d.params.loadState(d.store, d.syncer)
+	if err != nil {
+		return err
 	}
 
-	updatedSubDirs, err := d.syncer.Sync(subDirs)
+	result, err := d.syncer.Sync(d.targets(st))
 	if err != nil {
 		log.Error("Error syncing repository", "error", err)
-		return
+		return &syncError{err: err}
+	}
+	summary.Commit = result.Commit
+	// Once the state is saved, the stacks missing from it were never deployed
+	if !stateExists {
+		if err := d.store.Save(st); err != nil {
+			return fmt.Errorf("failed to save deployment state: %w", err)
+		}
+	}
+
+	for _, composePath := range result.Updated {
+		if pin := st.Pin(composePath); pin != nil {
+			log.Info("Branch moved past pinned commit, releasing pin", "composePath", composePath, "pinnedCommit", pin.Commit)
+			st.ClearPin(composePath)
+		}
+	}
+
+	now := time.Now()
+	var toDeploy []deployDecision
+	decisions := d.checkImages(st, d.decide(st, result.Changes, now))
+	for _, decision := range decisions {
+		if decision.Deploy {
+			toDeploy = append(toDeploy, decision)
+		} else {
+			log.Debug("Skipping compose file", "composePath", decision.ComposePath, "reason", decision.Reason)
+			summary.Stacks = append(summary.Stacks, stackSummary{Stack: decision.ComposePath, Result: stackSkipped, Commit: st.Commit(decision.ComposePath), Reason: decision.Reason})
+		}
+	}
+
+	if len(toDeploy) == 0 {
+		log.Info("No changes detected, skipping docker-compose up")
+		sendNotification(d.notifier, d.params, notify.Event{Type: notify.NoChanges, To: result.Commit})
+		return ErrNoChanges
+	}
+
+	// Deploy the collected compose files, up to MaxParallel at once. Results are kept
+	// in the order of toDeploy, so the report does not depend on which finished first.
+	stacks := make([]stackSummary, len(toDeploy))
+	errs := make([]error, len(toDeploy))
+	var saveErr error
+	var stopReason string
+	slots := make(chan struct{}, max(d.params.MaxParallel, 1))
+	var wg sync.WaitGroup
+	for i, decision := range toDeploy {
+		slots <- struct{}{}
+		d.mu.Lock()
+		if stopReason != "" {
+			stacks[i] = stackSummary{Stack: decision.ComposePath, Result: stackSkipped, Commit: st.Commit(decision.ComposePath), Reason: stopReason}
+			d.mu.Unlock()
+			<-slots
+			continue
+		}
+		d.mu.Unlock()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			stack, deployErr, err := d.deployStack(st, decision, result.Commit, now)
+
+			d.mu.Lock()
+			defer d.mu.Unlock()
+			stacks[i], errs[i] = stack, deployErr
+			switch {
+			case err != nil:
+				saveErr = cmp.Or(saveErr, err)
+				stopReason = "the deployment state could not be saved"
+			case deployErr != nil && d.params.FailurePolicy == FailFast:
+				stopReason = "an earlier deploy failed"
+			}
+		}()
+	}
+	wg.Wait()
+
+	var deployed []string
+	var failed []stackError
+	for i, stack := range stacks {
+		summary.Stacks = append(summary.Stacks, stack)
+		switch stack.Result {
+		case stackDeployed:
+			deployed = append(deployed, stack.Stack)
+		case stackFailed:
+			failed = append(failed, stackError{Stack: stack.Stack, Err: errs[i]})
+		}
+	}
+	logReport(summary.Stacks)
+	if saveErr != nil {
+		return saveErr
+	}
+	if len(failed) > 0 {
+		return &deployError{Failed: failed, Deployed: deployed}
+	}
+	return nil
+}
+
+// deployStack deploys the compose path of decision and records the outcome in st.
+// It returns the error the stack failed to deploy with as deployErr, and err when
+// the outcome could not be saved. Stacks deployed in parallel have their compose
+// output buffered and printed, prefixed, once they are done.
+func (d *deployCommand) deployStack(st *state.State, decision deployDecision, head string, now time.Time) (stack stackSummary, deployErr error, err error) {
+	composePath := decision.ComposePath
+	var output *stackOutput
+	if d.params.MaxParallel > 1 {
+		output = &stackOutput{}
+		defer output.flush(composePath, os.Stdout, os.Stderr)
+	}
+
+	d.mu.Lock()
+	// A pinned compose path can only get here when forced, its files are still at the pin
+	commit := head
+	if pin := st.Pin(composePath); pin != nil {
+		commit = pin.Commit
+	}
+	previous := st.Commit(composePath)
+	var lastError string
+	if decision.Retry {
+		lastError = st.Failure(composePath).LastError
+	}
+	d.mu.Unlock()
+	logger := log.With("stack", composePath, "commit", commit)
+
+	if decision.Retry {
+		logger.Info("Retrying failed deploy", "attempt", decision.Attempt, "maxAttempts", d.params.RetryMaxAttempts, "lastError", lastError)
+	}
+	if len(decision.Images) > 0 {
+		logger.Info("Images were updated in their registry, pulling them", "images", decision.Images)
+	}
+	logger.Info("Deploying compose file", "reason", decision.Reason)
+	d.mu.Lock()
+	deployErr = decryptSecrets(logger, d.decrypter, d.params, composePath)
+	d.mu.Unlock()
+	if deployErr == nil {
+		deployErr = d.deployer.DeployCompose(output.options(d.params.deployOptions(decision)), d.params.healthTimeout(composePath))
+	}
+
+	if deployErr != nil {
+		d.mu.Lock()
+		failure := d.recordFailure(st, composePath, commit, decision.Retry, deployErr, now)
+		d.mu.Unlock()
+		logger.Error("Error running docker-compose up", "error", deployErr, "attempt", failure.Attempts, "maxAttempts", d.params.RetryMaxAttempts)
+		sendNotification(d.notifier, d.params, notify.Event{
+			Type:   notify.Failed,
+			Stack:  composePath,
+			From:   previous,
+			To:     commit,
+			Reason: decision.Reason,
+			Error:  deployErr.Error(),
+		})
+		stack = stackSummary{Stack: composePath, Result: stackFailed, Commit: commit, Reason: decision.Reason, Error: deployErr.Error()}
+		if d.params.autoRevert(composePath) {
+			stack.RevertedTo = d.revert(logger, output, st, composePath, commit, head, failure)
+		}
+
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if err := d.store.Save(st); err != nil {
+			return stack, deployErr, fmt.Errorf("failed to save deployment state: %w", err)
+		}
+		return stack, deployErr, nil
 	}
 
-	var composePathsToDeploy []string
+	// Persist after every deploy so an interrupted run never forgets finished work
+	d.mu.Lock()
+	st.MarkDeployed(composePath, commit, time.Now())
+	err = d.store.Save(st)
+	d.mu.Unlock()
+	stack = stackSummary{Stack: composePath, Result: stackDeployed, Commit: commit, Reason: decision.Reason}
+	if err != nil {
+		return stack, nil, fmt.Errorf("failed to save deployment state: %w", err)
+	}
+	sendNotification(d.notifier, d.params, notify.Event{Type: notify.Deployed, Stack: composePath, From: previous, To: commit, Reason: decision.Reason})
+	return stack, nil, nil
+}
 
-	if len(updatedSubDirs) > 0 {
-		log.Info("Running docker-compose up for updated subdirectories", "updatedSubDirs", updatedSubDirs)
-		// Collect compose files for updated subdirectories
-		for _, updatedSubDir := range updatedSubDirs {
-			composePaths := subDirToComposePaths[updatedSubDir]
-			composePathsToDeploy = append(composePathsToDeploy, composePaths...)
+// logReport logs the result of every stack a deploy cycle tried to deploy, so the
+// outcome of a cycle can be read at its end
+func logReport(stacks []stackSummary) {
+	var deployed, failed, skipped int
+	for _, stack := range stacks {
+		switch stack.Result {
+		case stackDeployed:
+			deployed++
+		case stackFailed:
+			failed++
+		default:
+			skipped++
 		}
-	} else if d.params.Force {
-		log.Info("Force flag set, running docker-compose up for all compose files")
-		composePathsToDeploy = d.params.RemoteComposePaths
+	}
+	if failed > 0 {
+		log.Warn("Deploy finished with failures", "deployed", deployed, "failed", failed, "skipped", skipped)
 	} else {
-		log.Info("No changes detected, skipping docker-compose up")
+		log.Info("Deploy finished", "deployed", deployed, "skipped", skipped)
 	}
 
-	// Deploy all collected compose files
-	for _, composePath := range composePathsToDeploy {
-		log.Info("Deploying compose file", "composePath", composePath)
-		err := d.deployer.DeployCompose(filepath.Join(d.params.OutPath, composePath), true)
-		if err != nil {
-			log.Error("Error running docker-compose up", "error", err, "composePath", composePath)
-			return
+	for _, stack := range stacks {
+		switch {
+		case stack.Result == stackDeployed:
+			log.Info("Stack deployed", "stack", stack.Stack, "commit", shortCommit(stack.Commit))
+		case stack.Result == stackFailed && stack.RevertedTo != "":
+			log.Error("Stack failed, reverted to last good commit", "stack", stack.Stack, "commit", shortCommit(stack.Commit), "revertedTo", shortCommit(stack.RevertedTo), "error", stack.Error)
+		case stack.Result == stackFailed:
+			log.Error("Stack failed", "stack", stack.Stack, "commit", shortCommit(stack.Commit), "error", stack.Error)
 		}
 	}
 }
 
-func createDeployCommand() *Command {
-	params, printUsage, err := deployCommandParametersParser(os.Args[1:])
+// loadState loads the deployment state and reports whether it was ever saved. A
+// clone deployed by a voyage that kept no state has no state file: its stacks are
+// recorded as deployed at the commit it has checked out, so they are not all
+// deployed again. A fresh clone has no commit, and every stack is deployed.
+func (p DeployCommandParameters) loadState(store StateStore, repository HeadReader) (*state.State, bool, error) {
+	st, err := store.Load()
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to load deployment state: %w", err)
+	}
+	exists, err := store.Exists()
+	if err != nil || exists {
+		return st, exists, err
+	}
 
+	head, err := repository.Head()
 	if err != nil {
-		var missingParamsErr *missingParamsError
-		if errors.As(err, &missingParamsErr) {
-			log.Error("Error parsing parameters", "error", err)
-			printUsage()
-			os.Exit(1)
+		return nil, false, &syncError{err: fmt.Errorf("failed to read the checked out commit: %w", err)}
+	}
+	if head == "" {
+		return st, false, nil
+	}
+	log.Info("No deployment state found, recording the stacks as deployed at the checked out commit", "commit", head)
+	now := time.Now()
+	for _, composePath := range p.RemoteComposePaths {
+		st.MarkDeployed(composePath, head, now)
+	}
+	return st, false, nil
+}
+
+// targets returns the sync targets for every compose path. Each is tracked on its
+// own against the commit it was last deployed from. A compose path with a failed
+// deploy is checked for changes made after that failure instead, so new commits
+// reset its retries, and a pinned one for changes made after it was pinned, which
+// release the pin.
+func (d *deployCommand) targets(st *state.State) []git.Target {
+	var targets []git.Target
+	for _, composePath := range d.params.RemoteComposePaths {
+		target := git.Target{
+			Name:       composePath,
+			SubDirs:    d.params.subDirs(composePath),
+			References: d.params.references(composePath),
+			Since:      st.Commit(composePath),
+		}
+
+		if pin := st.Pin(composePath); pin != nil {
+			target.Since = pin.Base
+			target.Pin = pin.Commit
+		} else if failure := st.Failure(composePath); failure != nil {
+			target.Since = failure.Commit
+		}
+
+		targets = append(targets, target)
+	}
+	return targets
+}
+
+// deployDecision is whether, and why, a compose path is deployed in a cycle
+type deployDecision struct {
+	ComposePath string
+	Deploy      bool
+	Reason      string
+	// Files lists the changed files that triggered the deploy, if any
+	Files []string
+	// Retry is set when the deploy retries a failed one, Attempt is then its number
+	Retry   bool
+	Attempt int
+	// Images lists the images updated in their registry that triggered the deploy, if any
+	Images []string
+}
+
+// decide chooses which compose paths to deploy given the changes detected by a sync.
+// Changed compose paths are deployed, as are failed ones whose backoff has elapsed
+// and that still have attempts left. When nothing else is deployed, the force flag
+// deploys everything.
+func (d *deployCommand) decide(st *state.State, changes []git.Change, now time.Time) []deployDecision {
+	decisions := make([]deployDecision, 0, len(d.params.RemoteComposePaths))
+	deploying := false
+
+	for _, composePath := range d.params.RemoteComposePaths {
+		decision := deployDecision{ComposePath: composePath}
+		failure := st.Failure(composePath)
+		pin := st.Pin(composePath)
+
+		if i := slices.IndexFunc(changes, func(c git.Change) bool { return c.Target == composePath }); i >= 0 {
+			decision.Deploy = true
+			decision.Reason = string(changes[i].Reason)
+			decision.Files = changes[i].Files
 		} else {
-			log.Fatal("Error parsing parameters", "error", err)
+			switch {
+			case pin != nil:
+				decision.Reason = "pinned at " + shortCommit(pin.Commit)
+			case failure == nil:
+				decision.Reason = "no changes"
+			case failure.Attempts >= d.params.RetryMaxAttempts:
+				decision.Reason = fmt.Sprintf("failed %d times, waiting for new changes", failure.Attempts)
+			case now.Before(failure.NextAttempt):
+				decision.Reason = fmt.Sprintf("failed, next retry at %s", failure.NextAttempt.Format(time.DateTime))
+			default:
+				decision.Deploy = true
+				decision.Retry = true
+				decision.Attempt = failure.Attempts + 1
+				decision.Reason = fmt.Sprintf("retry %d/%d after failure", decision.Attempt, d.params.RetryMaxAttempts)
+			}
+		}
+
+		deploying = deploying || decision.Deploy
+		decisions = append(decisions, decision)
+	}
+
+	if !deploying && d.params.Force {
+		for i := range decisions {
+			decisions[i].Deploy = true
+			decisions[i].Reason = "force"
+		}
+	}
+
+	return decisions
+}
+
+// checkImages deploys the compose paths that would be skipped for lack of changes
+// when an image they use was updated in its registry. Compose paths that are pinned,
+// failed or never deployed are left to decide.
+func (d *deployCommand) checkImages(st *state.State, decisions []deployDecision) []deployDecision {
+	if d.images == nil {
+		return decisions
+	}
+
+	for i, decision := range decisions {
+		composePath := decision.ComposePath
+		if decision.Deploy || !d.params.imageUpdates(composePath) || st.Commit(composePath) == "" || st.Pin(composePath) != nil || st.Failure(composePath) != nil {
+			continue
+		}
+
+		images, err := d.images.UpdatedImages(context.Background(), d.params.composeOptions(composePath))
+		if err != nil {
+			log.Warn("Error checking images for updates", "stack", composePath, "error", err)
+			continue
+		}
+		if len(images) > 0 {
+			decisions[i].Deploy = true
+			decisions[i].Reason = "image updated"
+			decisions[i].Images = images
+		}
+	}
+	return decisions
+}
+
+// revert redeploys the last good commit of a compose path after a failed deploy
+// of commit, and pins it there until new changes are pushed, like a rollback.
+// head is the commit checked out in the repository. The failure stays recorded,
+// which marks the pin as a revert. It returns the commit reverted to, or an empty
+// string when the stack could not be reverted.
+func (d *deployCommand) revert(logger log.Logger, output *stackOutput, st *state.State, composePath, commit, head string, failure state.Failure) string {
+	d.mu.Lock()
+	good := st.Commit(composePath)
+	d.mu.Unlock()
+	if good == "" || good == commit {
+		logger.Info("No earlier deployed commit to revert to")
+		return ""
+	}
+
+	if err := d.params.checkPinnable(composePath); err != nil {
+		logger.Warn("Not reverting compose file to last good commit", "goodCommit", good, "error", err)
+		return ""
+	}
+
+	subDirs := d.params.subDirs(composePath)
+	logger.Info("Reverting compose file to last good commit", "goodCommit", good)
+	// Checkouts share the index of the repository, one runs at a time
+	d.mu.Lock()
+	err := d.reverter.Checkout(good, subDirs)
+	if err != nil {
+		d.mu.Unlock()
+		logger.Error("Error checking out last good commit", "goodCommit", good, "error", err)
+		return ""
+	}
+	err = decryptSecrets(logger, d.decrypter, d.params, composePath)
+	d.mu.Unlock()
+
+	if err == nil {
+		err = d.deployer.DeployCompose(output.options(d.params.composeOptions(composePath)), d.params.healthTimeout(composePath))
+	}
+	if err != nil {
+		logger.Error("Error running docker-compose up for last good commit, restoring compose file", "goodCommit", good, "error", err)
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if err := d.reverter.Checkout("HEAD", subDirs); err != nil {
+			logger.Error("Error restoring compose file", "error", err)
 		}
+		return ""
+	}
+
+	d.mu.Lock()
+	st.MarkRolledBack(composePath, state.Pin{Commit: good, Base: head, PinnedAt: time.Now()})
+	st.MarkFailed(composePath, failure)
+	d.mu.Unlock()
+	logger.Info("Rolled back compose file to last good commit, it stays there until new changes are pushed", "goodCommit", good)
+	sendNotification(d.notifier, d.params, notify.Event{
+		Type:   notify.RolledBack,
+		Stack:  composePath,
+		From:   commit,
+		To:     good,
+		Reason: "automatic revert after a failed deploy",
+		Error:  failure.LastError,
+	})
+	return good
+}
+
+// decryptSecrets writes the plaintext of the encrypted files of a compose path before
+// it is deployed. Without a decrypter, encrypted files are left alone.
+func decryptSecrets(logger log.Logger, decrypter SecretDecrypter, params DeployCommandParameters, composePath string) error {
+	if decrypter == nil {
+		return nil
+	}
+	files, err := decrypter.Decrypt(params.subDirs(composePath))
+	if err != nil {
+		return fmt.Errorf("failed to decrypt secrets: %w", err)
+	}
+	if len(files) > 0 {
+		logger.Info("Decrypted secret files", "files", files)
+	}
+	return nil
+}
+
+// sendNotification sends an event about the repository of params. Notifications
+// are best effort, a failure to send one is logged and does not fail the deploy.
+func sendNotification(notifier Notifier, params DeployCommandParameters, event notify.Event) {
+	if notifier == nil {
+		return
+	}
+	event.Repo = params.Repo
+	event.Branch = params.Branch
+	event.Time = time.Now()
+	if err := notifier.Notify(context.Background(), event); err != nil {
+		log.Warn("Error sending notification", "event", event.Type, "stack", event.Stack, "error", err)
+	}
+}
+
+// composeSubDir returns the repository subdirectory a compose file lives in
+func composeSubDir(composePath string) string {
+	subDir := filepath.Dir(composePath)
+	if subDir == "." {
+		return "" // root of repo
+	}
+	return subDir
+}
+
+// recordFailure stores a failed deploy in st and schedules the next retry with an
+// exponential backoff. A retry continues counting attempts, any other deploy starts over.
+func (d *deployCommand) recordFailure(st *state.State, composePath, commit string, retry bool, err error, now time.Time) state.Failure {
+	attempts := 1
+	if previous := st.Failure(composePath); retry && previous != nil {
+		attempts = previous.Attempts + 1
+	}
+
+	failure := state.Failure{
+		Commit:      commit,
+		Attempts:    attempts,
+		LastError:   err.Error(),
+		FailedAt:    now,
+		NextAttempt: now.Add(retryBackoff(time.Duration(d.params.RetryBackoff), attempts)),
+	}
+	st.MarkFailed(composePath, failure)
+	return failure
+}
+
+// retryBackoff doubles base for every attempt after the first, up to maxRetryBackoff
+func retryBackoff(base time.Duration, attempts int) time.Duration {
+	backoff := base
+	for i := 1; i < attempts && backoff < maxRetryBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, maxRetryBackoff)
+}
+
+func createDeployCommand() (*Command, error) {
+	params, printUsage, err := deployCommandParametersParser(os.Args[1:])
+	if err != nil {
+		return nil, parseError(err, printUsage)
 	}
 
 	d := &deployCommand{
@@ -133,5 +963,5 @@ func createDeployCommand() *Command {
 	return &Command{
 		Handle:            d.Handle,
 		GetBaseParameters: d.GetBaseParameters,
-	}
+	}, nil
 }
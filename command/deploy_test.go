@@ -1,40 +1,181 @@
 package command
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/gnugomez/voyage/docker"
+	"github.com/gnugomez/voyage/git"
+	"github.com/gnugomez/voyage/lock"
+	"github.com/gnugomez/voyage/notify"
+	"github.com/gnugomez/voyage/state"
 )
 
 // --- Mocks ---
 
 type mockSyncer struct {
-	SyncFunc func(subDirs []string) ([]string, error)
+	SyncFunc func(targets []git.Target) (*git.SyncResult, error)
+	HeadFunc func() (string, error)
+}
+
+func (m *mockSyncer) Head() (string, error) {
+	if m.HeadFunc != nil {
+		return m.HeadFunc()
+	}
+	return "", nil
 }
 
-func (m *mockSyncer) Sync(subDirs []string) ([]string, error) {
+func (m *mockSyncer) Sync(targets []git.Target) (*git.SyncResult, error) {
 	if m.SyncFunc != nil {
-		return m.SyncFunc(subDirs)
+		return m.SyncFunc(targets)
 	}
-	return nil, nil
+	return &git.SyncResult{}, nil
 }
 
 type mockDeployer struct {
-	DeployComposeFunc func(targetPath string, daemonMode bool) error
+	DeployComposeFunc func(options docker.ComposeOptions, healthTimeout time.Duration) error
 }
 
-func (m *mockDeployer) DeployCompose(targetPath string, daemonMode bool) error {
+func (m *mockDeployer) DeployCompose(options docker.ComposeOptions, healthTimeout time.Duration) error {
 	if m.DeployComposeFunc != nil {
-		return m.DeployComposeFunc(targetPath, daemonMode)
+		return m.DeployComposeFunc(options, healthTimeout)
+	}
+	return nil
+}
+
+type mockImageChecker struct {
+	UpdatedImagesFunc func(ctx context.Context, options docker.ComposeOptions) ([]string, error)
+}
+
+func (m *mockImageChecker) UpdatedImages(ctx context.Context, options docker.ComposeOptions) ([]string, error) {
+	if m.UpdatedImagesFunc != nil {
+		return m.UpdatedImagesFunc(ctx, options)
+	}
+	return nil, nil
+}
+
+type mockSecretDecrypter struct {
+	DecryptFunc func(dirs []string) ([]string, error)
+}
+
+func (m *mockSecretDecrypter) Decrypt(dirs []string) ([]string, error) {
+	if m.DecryptFunc != nil {
+		return m.DecryptFunc(dirs)
+	}
+	return nil, nil
+}
+
+// mockNotifier records the events it is sent
+type mockNotifier struct {
+	events []notify.Event
+}
+
+func (m *mockNotifier) Notify(ctx context.Context, event notify.Event) error {
+	m.events = append(m.events, event)
+	return nil
+}
+
+type mockLocker struct {
+	AcquireFunc func() error
+	ReleaseFunc func() error
+}
+
+func (m *mockLocker) Acquire() error {
+	if m.AcquireFunc != nil {
+		return m.AcquireFunc()
+	}
+	return nil
+}
+
+func (m *mockLocker) Release() error {
+	if m.ReleaseFunc != nil {
+		return m.ReleaseFunc()
 	}
 	return nil
 }
 
+// mockStateStore keeps the deployment state in memory
+type mockStateStore struct {
+	state      *state.State
+	saves      int
+	SaveFunc   func(st *state.State) error
+	ExistsFunc func() (bool, error)
+}
+
+func (m *mockStateStore) Load() (*state.State, error) {
+	if m.state == nil {
+		m.state = state.New()
+	}
+	return m.state, nil
+}
+
+func (m *mockStateStore) Exists() (bool, error) {
+	if m.ExistsFunc != nil {
+		return m.ExistsFunc()
+	}
+	return true, nil
+}
+
+func (m *mockStateStore) Save(st *state.State) error {
+	m.state = st
+	m.saves++
+	if m.SaveFunc != nil {
+		return m.SaveFunc(st)
+	}
+	return nil
+}
+
+func TestDeployCommandParameters_gitAuth(t *testing.T) {
+	t.Run("Reads the token from its file", func(t *testing.T) {
+		tokenFile := filepath.Join(t.TempDir(), "token")
+		os.WriteFile(tokenFile, []byte("s3cret\n"), 0o600)
+		params := DeployCommandParameters{GitSSHKey: "/etc/voyage/id_ed25519", GitUsername: "deploy", GitTokenFile: tokenFile}
+
+		auth, err := params.gitAuth()
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if expected := (git.Auth{SSHKeyFile: "/etc/voyage/id_ed25519", Username: "deploy", Token: "s3cret"}); auth != expected {
+			t.Errorf("Expected %+v, but got %+v", expected, auth)
+		}
+	})
+
+	t.Run("Reads the token from its environment variable", func(t *testing.T) {
+		t.Setenv("VOYAGE_TEST_TOKEN", "s3cret")
+
+		auth, err := DeployCommandParameters{GitTokenEnv: "VOYAGE_TEST_TOKEN"}.gitAuth()
+		if err != nil || auth.Token != "s3cret" {
+			t.Errorf("Expected the token from the environment, but got %q, %v", auth.Token, err)
+		}
+	})
+
+	t.Run("Fails when the token is missing", func(t *testing.T) {
+		if _, err := (DeployCommandParameters{GitTokenFile: filepath.Join(t.TempDir(), "missing")}).gitAuth(); err == nil {
+			t.Error("Expected an error for a missing token file, but got nil")
+		}
+		if _, err := (DeployCommandParameters{GitTokenEnv: "VOYAGE_TEST_UNSET_TOKEN"}).gitAuth(); err == nil {
+			t.Error("Expected an error for an empty token variable, but got nil")
+		}
+	})
+}
+
 func TestDeployCommand_Handle(t *testing.T) {
 	t.Run("Changes detected, should deploy", func(t *testing.T) {
 		syncer := &mockSyncer{}
 		deployer := &mockDeployer{}
 
 		dc := &deployCommand{
+			locker: &mockLocker{},
 			params: DeployCommandParameters{
 				Repo:               "repo",
 				Branch:             "main",
@@ -43,14 +184,15 @@ func TestDeployCommand_Handle(t *testing.T) {
 			},
 			syncer:   syncer,
 			deployer: deployer,
+			store:    &mockStateStore{},
 		}
 
-		syncer.SyncFunc = func(subDirs []string) ([]string, error) {
-			return []string{"app1"}, nil
+		syncer.SyncFunc = func(targets []git.Target) (*git.SyncResult, error) {
+			return changedResult("c2", "app1/docker-compose.yml"), nil
 		}
 
 		deployerCalled := false
-		deployer.DeployComposeFunc = func(targetPath string, daemonMode bool) error {
+		deployer.DeployComposeFunc = func(options docker.ComposeOptions, healthTimeout time.Duration) error {
 			deployerCalled = true
 			return nil
 		}
@@ -67,6 +209,7 @@ func TestDeployCommand_Handle(t *testing.T) {
 		deployer := &mockDeployer{}
 
 		dc := &deployCommand{
+			locker: &mockLocker{},
 			params: DeployCommandParameters{
 				Repo:               "repo",
 				Branch:             "main",
@@ -76,14 +219,15 @@ func TestDeployCommand_Handle(t *testing.T) {
 			},
 			syncer:   syncer,
 			deployer: deployer,
+			store:    &mockStateStore{},
 		}
 
-		syncer.SyncFunc = func(subDirs []string) ([]string, error) {
-			return []string{}, nil // No changes
+		syncer.SyncFunc = func(targets []git.Target) (*git.SyncResult, error) {
+			return &git.SyncResult{Commit: "c1"}, nil // No changes
 		}
 
 		deployerCalled := false
-		deployer.DeployComposeFunc = func(targetPath string, daemonMode bool) error {
+		deployer.DeployComposeFunc = func(options docker.ComposeOptions, healthTimeout time.Duration) error {
 			deployerCalled = true
 			return nil
 		}
@@ -100,6 +244,7 @@ func TestDeployCommand_Handle(t *testing.T) {
 		deployer := &mockDeployer{}
 
 		dc := &deployCommand{
+			locker: &mockLocker{},
 			params: DeployCommandParameters{
 				Repo:               "repo",
 				Branch:             "main",
@@ -109,14 +254,15 @@ func TestDeployCommand_Handle(t *testing.T) {
 			},
 			syncer:   syncer,
 			deployer: deployer,
+			store:    &mockStateStore{},
 		}
 
-		syncer.SyncFunc = func(subDirs []string) ([]string, error) {
-			return []string{}, nil // No changes
+		syncer.SyncFunc = func(targets []git.Target) (*git.SyncResult, error) {
+			return &git.SyncResult{Commit: "c1"}, nil // No changes
 		}
 
 		deployerCalled := false
-		deployer.DeployComposeFunc = func(targetPath string, daemonMode bool) error {
+		deployer.DeployComposeFunc = func(options docker.ComposeOptions, healthTimeout time.Duration) error {
 			deployerCalled = true
 			return nil
 		}
@@ -133,6 +279,7 @@ func TestDeployCommand_Handle(t *testing.T) {
 		deployer := &mockDeployer{}
 
 		dc := &deployCommand{
+			locker: &mockLocker{},
 			params: DeployCommandParameters{
 				Repo:               "repo",
 				Branch:             "main",
@@ -141,14 +288,15 @@ func TestDeployCommand_Handle(t *testing.T) {
 			},
 			syncer:   syncer,
 			deployer: deployer,
+			store:    &mockStateStore{},
 		}
 
-		syncer.SyncFunc = func(subDirs []string) ([]string, error) {
+		syncer.SyncFunc = func(targets []git.Target) (*git.SyncResult, error) {
 			return nil, errors.New("sync failed")
 		}
 
 		deployerCalled := false
-		deployer.DeployComposeFunc = func(targetPath string, daemonMode bool) error {
+		deployer.DeployComposeFunc = func(options docker.ComposeOptions, healthTimeout time.Duration) error {
 			deployerCalled = true
 			return nil
 		}
@@ -159,4 +307,1185 @@ func TestDeployCommand_Handle(t *testing.T) {
 			t.Error("Deployer.DeployCompose should not have been called when sync fails, but it was")
 		}
 	})
+	t.Run("Targets use the last deployed commit and successful deploys are recorded", func(t *testing.T) {
+		syncer := &mockSyncer{}
+		store := &mockStateStore{state: state.New()}
+		store.state.MarkDeployed("app1/docker-compose.yml", "c1", time.Now())
+
+		dc := &deployCommand{
+			locker: &mockLocker{},
+			params: DeployCommandParameters{
+				Repo:               "repo",
+				Branch:             "main",
+				OutPath:            "/tmp",
+				RemoteComposePaths: []string{"app1/docker-compose.yml", "docker-compose.yml"},
+			},
+			syncer:   syncer,
+			deployer: &mockDeployer{},
+			store:    store,
+		}
+
+		var gotTargets []git.Target
+		syncer.SyncFunc = func(targets []git.Target) (*git.SyncResult, error) {
+			gotTargets = targets
+			return changedResult("c2", "docker-compose.yml"), nil
+		}
+
+		dc.Handle()
+
+		expected := []git.Target{
+			{Name: "app1/docker-compose.yml", SubDirs: []string{"app1"}, Since: "c1"},
+			{Name: "docker-compose.yml", SubDirs: []string{""}, Since: ""},
+		}
+		if !reflect.DeepEqual(gotTargets, expected) {
+			t.Errorf("Unexpected sync targets.\nGot:      %+v\nExpected: %+v", gotTargets, expected)
+		}
+
+		if commit := store.state.Commit("docker-compose.yml"); commit != "c2" {
+			t.Errorf("Expected deployed compose file to be recorded at c2, got %q", commit)
+		}
+		if commit := store.state.Commit("app1/docker-compose.yml"); commit != "c1" {
+			t.Errorf("Expected untouched compose file to stay at c1, got %q", commit)
+		}
+	})
+
+	t.Run("Passes the health timeout of each stack to the deployer", func(t *testing.T) {
+		disabled := Duration(0)
+		timeouts := map[string]time.Duration{}
+		dc := &deployCommand{
+			locker: &mockLocker{},
+			params: DeployCommandParameters{
+				Repo:               "repo",
+				Branch:             "main",
+				OutPath:            "/tmp",
+				RemoteComposePaths: []string{"app1/docker-compose.yml", "app2/docker-compose.yml"},
+				HealthTimeout:      Duration(time.Minute),
+				Stacks:             map[string]StackParameters{"app2/docker-compose.yml": {HealthTimeout: &disabled}},
+			},
+			syncer: &mockSyncer{SyncFunc: func(targets []git.Target) (*git.SyncResult, error) {
+				return changedResult("c2", "app1/docker-compose.yml", "app2/docker-compose.yml"), nil
+			}},
+			deployer: &mockDeployer{DeployComposeFunc: func(options docker.ComposeOptions, healthTimeout time.Duration) error {
+				timeouts[options.Files[0]] = healthTimeout
+				return nil
+			}},
+			store: &mockStateStore{},
+		}
+
+		dc.Handle()
+
+		expected := map[string]time.Duration{"/tmp/app1/docker-compose.yml": time.Minute, "/tmp/app2/docker-compose.yml": 0}
+		if !reflect.DeepEqual(timeouts, expected) {
+			t.Errorf("Expected health timeouts %v, but got %v", expected, timeouts)
+		}
+	})
+
+	t.Run("Targets include the files a compose file references outside its directory", func(t *testing.T) {
+		outPath := t.TempDir()
+		if err := os.MkdirAll(filepath.Join(outPath, "app1"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		compose := "services:\n  web:\n    env_file: [../shared/web.env, web.env, /etc/outside.env]\n    build: ../images/web\n"
+		if err := os.WriteFile(filepath.Join(outPath, "app1/docker-compose.yml"), []byte(compose), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		var gotTargets []git.Target
+		dc := &deployCommand{
+			locker: &mockLocker{},
+			params: DeployCommandParameters{
+				Repo:               "repo",
+				Branch:             "main",
+				OutPath:            outPath,
+				RemoteComposePaths: []string{"app1/docker-compose.yml"},
+			},
+			syncer: &mockSyncer{SyncFunc: func(targets []git.Target) (*git.SyncResult, error) {
+				gotTargets = targets
+				return &git.SyncResult{Commit: "c1"}, nil
+			}},
+			deployer: &mockDeployer{},
+			store:    &mockStateStore{},
+		}
+
+		dc.Handle()
+
+		expected := []string{"images/web", "shared/web.env"}
+		if len(gotTargets) != 1 || !reflect.DeepEqual(gotTargets[0].References, expected) {
+			t.Errorf("Expected references %v, but got %+v", expected, gotTargets)
+		}
+	})
+
+	t.Run("Targets include the env files of a stack", func(t *testing.T) {
+		outPath := t.TempDir()
+		if err := os.MkdirAll(filepath.Join(outPath, "app1"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(outPath, "app1/compose.yml"), []byte("services:\n  web:\n    image: nginx\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		var gotTargets []git.Target
+		dc := &deployCommand{
+			locker: &mockLocker{},
+			params: DeployCommandParameters{
+				Repo:               "repo",
+				Branch:             "main",
+				OutPath:            outPath,
+				RemoteComposePaths: []string{"app1/compose.yml"},
+				Stacks:             map[string]StackParameters{"app1/compose.yml": {EnvFiles: []string{"env/prod.env", "app1/.env"}}},
+			},
+			syncer: &mockSyncer{SyncFunc: func(targets []git.Target) (*git.SyncResult, error) {
+				gotTargets = targets
+				return &git.SyncResult{Commit: "c1"}, nil
+			}},
+			deployer: &mockDeployer{},
+			store:    &mockStateStore{},
+		}
+
+		dc.Handle()
+
+		expected := []string{"env/prod.env"}
+		if len(gotTargets) != 1 || !reflect.DeepEqual(gotTargets[0].References, expected) {
+			t.Errorf("Expected references %v, but got %+v", expected, gotTargets)
+		}
+	})
+
+	t.Run("Deploys override files together with their compose file", func(t *testing.T) {
+		var gotTargets []git.Target
+		var deployed [][]string
+		store := &mockStateStore{}
+		dc := &deployCommand{
+			locker: &mockLocker{},
+			params: DeployCommandParameters{
+				Repo:               "repo",
+				Branch:             "main",
+				OutPath:            "/tmp",
+				RemoteComposePaths: []string{"app1/compose.yml"},
+				Stacks: map[string]StackParameters{
+					"app1/compose.yml": {Overrides: []string{"app1/compose.override.yml", "shared/logging.yml"}},
+				},
+			},
+			syncer: &mockSyncer{SyncFunc: func(targets []git.Target) (*git.SyncResult, error) {
+				gotTargets = targets
+				return changedResult("c2", "app1/compose.yml"), nil
+			}},
+			deployer: &mockDeployer{DeployComposeFunc: func(options docker.ComposeOptions, healthTimeout time.Duration) error {
+				deployed = append(deployed, options.Files)
+				return nil
+			}},
+			store: store,
+		}
+
+		dc.Handle()
+
+		if len(gotTargets) != 1 || !reflect.DeepEqual(gotTargets[0].SubDirs, []string{"app1", "shared"}) {
+			t.Errorf("Expected one target watching app1 and shared, but got %+v", gotTargets)
+		}
+		expected := [][]string{{"/tmp/app1/compose.yml", "/tmp/app1/compose.override.yml", "/tmp/shared/logging.yml"}}
+		if !reflect.DeepEqual(deployed, expected) {
+			t.Errorf("Expected one deploy of %v, but got %v", expected, deployed)
+		}
+		if commit := store.state.Commit("app1/compose.yml"); commit != "c2" {
+			t.Errorf("Expected the stack to be recorded at c2, got %q", commit)
+		}
+	})
+
+	t.Run("Failed deploy is not recorded", func(t *testing.T) {
+		store := &mockStateStore{}
+		dc := &deployCommand{
+			locker: &mockLocker{},
+			params: DeployCommandParameters{
+				Repo:               "repo",
+				Branch:             "main",
+				OutPath:            "/tmp",
+				RemoteComposePaths: []string{"app1/docker-compose.yml"},
+			},
+			syncer: &mockSyncer{SyncFunc: func(targets []git.Target) (*git.SyncResult, error) {
+				return changedResult("c2", "app1/docker-compose.yml"), nil
+			}},
+			deployer: &mockDeployer{DeployComposeFunc: func(options docker.ComposeOptions, healthTimeout time.Duration) error {
+				return errors.New("compose failed")
+			}},
+			store: store,
+		}
+
+		dc.Handle()
+
+		if commit := store.state.Commit("app1/docker-compose.yml"); commit != "" {
+			t.Errorf("Expected failed deploy not to be recorded, got %q", commit)
+		}
+	})
+}
+
+func TestDeployCommand_MissingState(t *testing.T) {
+	stacks := []string{"app1/compose.yml", "app2/compose.yml"}
+	newCommand := func(head string, syncer *mockSyncer, deployer *mockDeployer) (*deployCommand, *mockStateStore) {
+		store := &mockStateStore{ExistsFunc: func() (bool, error) { return false, nil }}
+		syncer.HeadFunc = func() (string, error) { return head, nil }
+		return &deployCommand{
+			locker: &mockLocker{},
+			params: DeployCommandParameters{
+				Repo:               "repo",
+				Branch:             "main",
+				OutPath:            "/tmp",
+				RemoteComposePaths: stacks,
+				RetryMaxAttempts:   3,
+			},
+			syncer:   syncer,
+			deployer: deployer,
+			store:    store,
+		}, store
+	}
+
+	t.Run("Records the stacks of an existing clone as deployed at its checked out commit", func(t *testing.T) {
+		syncer := &mockSyncer{SyncFunc: func(targets []git.Target) (*git.SyncResult, error) {
+			for _, target := range targets {
+				if target.Since != "c1" {
+					t.Errorf("Expected %s to be compared with the checked out commit c1, but got %q", target.Name, target.Since)
+				}
+			}
+			return &git.SyncResult{Commit: "c1"}, nil
+		}}
+		deployer := &mockDeployer{DeployComposeFunc: func(options docker.ComposeOptions, healthTimeout time.Duration) error {
+			t.Error("Expected no deploy without changes")
+			return nil
+		}}
+		command, store := newCommand("c1", syncer, deployer)
+
+		if err := command.Handle(); !errors.Is(err, ErrNoChanges) {
+			t.Errorf("Expected ErrNoChanges, but got %v", err)
+		}
+		if store.saves != 1 || store.state.Commit("app2/compose.yml") != "c1" {
+			t.Errorf("Expected the state to be saved with the stacks at c1, but got %d saves of %+v", store.saves, store.state)
+		}
+	})
+
+	t.Run("Deploys every stack of a fresh clone", func(t *testing.T) {
+		syncer := &mockSyncer{SyncFunc: func(targets []git.Target) (*git.SyncResult, error) {
+			result := &git.SyncResult{Commit: "c1", Updated: stacks}
+			for _, target := range targets {
+				if target.Since != "" {
+					t.Errorf("Expected %s never to have been deployed, but got %q", target.Name, target.Since)
+				}
+				result.Changes = append(result.Changes, git.Change{Target: target.Name, Reason: git.ReasonFirstClone})
+			}
+			return result, nil
+		}}
+		var deployed []string
+		deployer := &mockDeployer{DeployComposeFunc: func(options docker.ComposeOptions, healthTimeout time.Duration) error {
+			deployed = append(deployed, options.Files[0])
+			return nil
+		}}
+		command, store := newCommand("", syncer, deployer)
+
+		if err := command.Handle(); err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if len(deployed) != 2 {
+			t.Errorf("Expected both stacks to be deployed, but got %v", deployed)
+		}
+		// Saved once after the clone, then after every deploy
+		if store.saves != 3 {
+			t.Errorf("Expected 3 saves, but got %d", store.saves)
+		}
+	})
+}
+
+func TestDeployCommand_Retries(t *testing.T) {
+	newCommand := func(store *mockStateStore, deployer *mockDeployer, syncer *mockSyncer) *deployCommand {
+		return &deployCommand{
+			locker: &mockLocker{},
+			params: DeployCommandParameters{
+				Repo:               "repo",
+				Branch:             "main",
+				OutPath:            "/tmp",
+				RemoteComposePaths: []string{"app1/docker-compose.yml"},
+				RetryMaxAttempts:   3,
+				RetryBackoff:       Duration(time.Minute),
+			},
+			syncer:   syncer,
+			deployer: deployer,
+			store:    store,
+		}
+	}
+	noChanges := &mockSyncer{SyncFunc: func(targets []git.Target) (*git.SyncResult, error) {
+		return &git.SyncResult{Commit: "c2"}, nil
+	}}
+
+	t.Run("Failed deploy is retried once its backoff elapsed", func(t *testing.T) {
+		store := &mockStateStore{state: state.New()}
+		store.state.MarkDeployed("app1/docker-compose.yml", "c1", time.Now())
+		store.state.MarkFailed("app1/docker-compose.yml", state.Failure{Commit: "c2", Attempts: 1, NextAttempt: time.Now().Add(-time.Second)})
+
+		var gotTargets []git.Target
+		syncer := &mockSyncer{SyncFunc: func(targets []git.Target) (*git.SyncResult, error) {
+			gotTargets = targets
+			return &git.SyncResult{Commit: "c2"}, nil
+		}}
+		deployerCalled := false
+		deployer := &mockDeployer{DeployComposeFunc: func(options docker.ComposeOptions, healthTimeout time.Duration) error {
+			deployerCalled = true
+			return nil
+		}}
+
+		newCommand(store, deployer, syncer).Handle()
+
+		if gotTargets[0].Since != "c2" {
+			t.Errorf("Expected failed compose file to be diffed against the failed commit c2, got %q", gotTargets[0].Since)
+		}
+		if !deployerCalled {
+			t.Fatal("Expected failed deploy to be retried, but it wasn't")
+		}
+		if store.state.Failure("app1/docker-compose.yml") != nil {
+			t.Error("Expected successful retry to clear the failure")
+		}
+		if commit := store.state.Commit("app1/docker-compose.yml"); commit != "c2" {
+			t.Errorf("Expected successful retry to be recorded at c2, got %q", commit)
+		}
+	})
+
+	t.Run("Failed deploy waits for its backoff", func(t *testing.T) {
+		store := &mockStateStore{state: state.New()}
+		store.state.MarkFailed("app1/docker-compose.yml", state.Failure{Commit: "c2", Attempts: 1, NextAttempt: time.Now().Add(time.Hour)})
+
+		deployerCalled := false
+		deployer := &mockDeployer{DeployComposeFunc: func(options docker.ComposeOptions, healthTimeout time.Duration) error {
+			deployerCalled = true
+			return nil
+		}}
+
+		newCommand(store, deployer, noChanges).Handle()
+
+		if deployerCalled {
+			t.Error("Expected failed deploy not to be retried before its backoff elapsed")
+		}
+	})
+
+	t.Run("Failed deploy is not retried after the maximum attempts", func(t *testing.T) {
+		store := &mockStateStore{state: state.New()}
+		store.state.MarkFailed("app1/docker-compose.yml", state.Failure{Commit: "c2", Attempts: 3, NextAttempt: time.Now().Add(-time.Hour)})
+
+		deployerCalled := false
+		deployer := &mockDeployer{DeployComposeFunc: func(options docker.ComposeOptions, healthTimeout time.Duration) error {
+			deployerCalled = true
+			return nil
+		}}
+
+		newCommand(store, deployer, noChanges).Handle()
+
+		if deployerCalled {
+			t.Error("Expected failed deploy not to be retried after the maximum attempts")
+		}
+	})
+
+	t.Run("Failing retry increases attempts and backoff", func(t *testing.T) {
+		store := &mockStateStore{state: state.New()}
+		store.state.MarkFailed("app1/docker-compose.yml", state.Failure{Commit: "c2", Attempts: 2, NextAttempt: time.Now().Add(-time.Second)})
+
+		deployer := &mockDeployer{DeployComposeFunc: func(options docker.ComposeOptions, healthTimeout time.Duration) error {
+			return errors.New("still broken")
+		}}
+
+		before := time.Now()
+		newCommand(store, deployer, noChanges).Handle()
+
+		failure := store.state.Failure("app1/docker-compose.yml")
+		if failure == nil || failure.Attempts != 3 || failure.LastError != "still broken" {
+			t.Fatalf("Unexpected failure after failing retry: %+v", failure)
+		}
+		if failure.NextAttempt.Before(before.Add(4 * time.Minute)) {
+			t.Errorf("Expected third attempt to back off for 4m, next attempt is %s", failure.NextAttempt.Sub(before))
+		}
+	})
+
+	t.Run("New changes restart the attempts", func(t *testing.T) {
+		store := &mockStateStore{state: state.New()}
+		store.state.MarkFailed("app1/docker-compose.yml", state.Failure{Commit: "c2", Attempts: 3, NextAttempt: time.Now().Add(time.Hour)})
+
+		syncer := &mockSyncer{SyncFunc: func(targets []git.Target) (*git.SyncResult, error) {
+			return changedResult("c3", "app1/docker-compose.yml"), nil
+		}}
+		deployer := &mockDeployer{DeployComposeFunc: func(options docker.ComposeOptions, healthTimeout time.Duration) error {
+			return errors.New("broken again")
+		}}
+
+		newCommand(store, deployer, syncer).Handle()
+
+		failure := store.state.Failure("app1/docker-compose.yml")
+		if failure == nil || failure.Attempts != 1 || failure.Commit != "c3" {
+			t.Fatalf("Expected attempts to restart at c3, got %+v", failure)
+		}
+	})
+}
+
+func TestDeployCommand_Pins(t *testing.T) {
+	newCommand := func(store *mockStateStore, deployer *mockDeployer, syncer *mockSyncer) *deployCommand {
+		return &deployCommand{
+			locker: &mockLocker{},
+			params: DeployCommandParameters{
+				Repo:               "repo",
+				Branch:             "main",
+				OutPath:            "/tmp",
+				RemoteComposePaths: []string{"app1/docker-compose.yml"},
+			},
+			syncer:   syncer,
+			deployer: deployer,
+			store:    store,
+		}
+	}
+	pinned := func() *mockStateStore {
+		store := &mockStateStore{state: state.New()}
+		store.state.MarkRolledBack("app1/docker-compose.yml", state.Pin{Commit: "c1", Base: "c2"})
+		return store
+	}
+
+	t.Run("Pinned stack is diffed against its base and not deployed", func(t *testing.T) {
+		store := pinned()
+		var gotTargets []git.Target
+		syncer := &mockSyncer{SyncFunc: func(targets []git.Target) (*git.SyncResult, error) {
+			gotTargets = targets
+			return &git.SyncResult{Commit: "c3"}, nil
+		}}
+		deployerCalled := false
+		deployer := &mockDeployer{DeployComposeFunc: func(options docker.ComposeOptions, healthTimeout time.Duration) error {
+			deployerCalled = true
+			return nil
+		}}
+
+		newCommand(store, deployer, syncer).Handle()
+
+		expected := []git.Target{{Name: "app1/docker-compose.yml", SubDirs: []string{"app1"}, Since: "c2", Pin: "c1"}}
+		if !reflect.DeepEqual(gotTargets, expected) {
+			t.Errorf("Unexpected sync targets.\nGot:      %+v\nExpected: %+v", gotTargets, expected)
+		}
+		if deployerCalled {
+			t.Error("Expected pinned stack not to be deployed")
+		}
+		if store.state.Pin("app1/docker-compose.yml") == nil {
+			t.Error("Expected pin to be kept")
+		}
+	})
+
+	t.Run("New changes release the pin", func(t *testing.T) {
+		store := pinned()
+		syncer := &mockSyncer{SyncFunc: func(targets []git.Target) (*git.SyncResult, error) {
+			return changedResult("c3", "app1/docker-compose.yml"), nil
+		}}
+
+		newCommand(store, &mockDeployer{}, syncer).Handle()
+
+		if store.state.Pin("app1/docker-compose.yml") != nil {
+			t.Error("Expected pin to be released")
+		}
+		if commit := store.state.Commit("app1/docker-compose.yml"); commit != "c3" {
+			t.Errorf("Expected stack to be deployed at c3, got %q", commit)
+		}
+	})
+}
+
+func TestRetryBackoff(t *testing.T) {
+	testCases := []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{attempts: 1, expected: time.Minute},
+		{attempts: 2, expected: 2 * time.Minute},
+		{attempts: 4, expected: 8 * time.Minute},
+		{attempts: 20, expected: maxRetryBackoff},
+	}
+
+	for _, tc := range testCases {
+		if got := retryBackoff(time.Minute, tc.attempts); got != tc.expected {
+			t.Errorf("For %d attempts, expected backoff %s, got %s", tc.attempts, tc.expected, got)
+		}
+	}
+}
+
+func TestDeployCommand_AutoRevert(t *testing.T) {
+	newCommand := func(store *mockStateStore, deployer *mockDeployer, reverter *mockReverter) *deployCommand {
+		return &deployCommand{
+			locker: &mockLocker{},
+			params: DeployCommandParameters{
+				Repo:               "repo",
+				Branch:             "main",
+				OutPath:            "/tmp",
+				RemoteComposePaths: []string{"app1/docker-compose.yml"},
+				RetryMaxAttempts:   3,
+				RetryBackoff:       Duration(time.Minute),
+				AutoRevert:         true,
+			},
+			syncer: &mockSyncer{SyncFunc: func(targets []git.Target) (*git.SyncResult, error) {
+				return changedResult("c2", "app1/docker-compose.yml"), nil
+			}},
+			deployer: deployer,
+			reverter: reverter,
+			store:    store,
+		}
+	}
+	deployed := func() *mockStateStore {
+		store := &mockStateStore{state: state.New()}
+		store.state.MarkDeployed("app1/docker-compose.yml", "c1", time.Now())
+		return store
+	}
+	recordCheckouts := func(checkouts *[]string) *mockReverter {
+		return &mockReverter{CheckoutFunc: func(rev string, subDirs []string) error {
+			*checkouts = append(*checkouts, rev)
+			return nil
+		}}
+	}
+
+	t.Run("Failed deploy is reverted to the last good commit and pinned there", func(t *testing.T) {
+		store := deployed()
+		var checkouts []string
+		calls := 0
+		deployer := &mockDeployer{DeployComposeFunc: func(options docker.ComposeOptions, healthTimeout time.Duration) error {
+			calls++
+			if calls == 1 {
+				return errors.New("unhealthy")
+			}
+			return nil
+		}}
+
+		newCommand(store, deployer, recordCheckouts(&checkouts)).Handle()
+
+		if !reflect.DeepEqual(checkouts, []string{"c1"}) {
+			t.Errorf("Expected the last good commit to be checked out, got %v", checkouts)
+		}
+		if calls != 2 {
+			t.Errorf("Expected the last good commit to be deployed, got %d deploys", calls)
+		}
+		pin := store.state.Pin("app1/docker-compose.yml")
+		if pin == nil || pin.Commit != "c1" || pin.Base != "c2" {
+			t.Errorf("Expected stack to be pinned at c1 with base c2, got %+v", pin)
+		}
+		if failure := store.state.Failure("app1/docker-compose.yml"); failure == nil || failure.Commit != "c2" {
+			t.Errorf("Expected failure of c2 to stay recorded, got %+v", failure)
+		}
+	})
+
+	t.Run("Stacks sharing a directory are not reverted", func(t *testing.T) {
+		store := deployed()
+		store.state.MarkDeployed("app1/worker.yml", "c1", time.Now())
+		var checkouts []string
+		deployer := &mockDeployer{DeployComposeFunc: func(options docker.ComposeOptions, healthTimeout time.Duration) error {
+			if options.Files[0] == "/tmp/app1/docker-compose.yml" {
+				return errors.New("unhealthy")
+			}
+			return nil
+		}}
+		command := newCommand(store, deployer, recordCheckouts(&checkouts))
+		command.params.RemoteComposePaths = []string{"app1/docker-compose.yml", "app1/worker.yml"}
+		command.params.MaxParallel = 2
+
+		command.Handle()
+
+		if len(checkouts) != 0 {
+			t.Errorf("Expected the shared directory not to be checked out, got %v", checkouts)
+		}
+		if pin := store.state.Pin("app1/docker-compose.yml"); pin != nil {
+			t.Errorf("Expected no pin, got %+v", pin)
+		}
+		if failure := store.state.Failure("app1/docker-compose.yml"); failure == nil || failure.Commit != "c2" {
+			t.Errorf("Expected failure of c2 to be recorded, got %+v", failure)
+		}
+	})
+
+	t.Run("Failed revert restores the stack files", func(t *testing.T) {
+		store := deployed()
+		var checkouts []string
+		deployer := &mockDeployer{DeployComposeFunc: func(options docker.ComposeOptions, healthTimeout time.Duration) error {
+			return errors.New("compose failed")
+		}}
+
+		newCommand(store, deployer, recordCheckouts(&checkouts)).Handle()
+
+		if !reflect.DeepEqual(checkouts, []string{"c1", "HEAD"}) {
+			t.Errorf("Expected files to be restored after the failed revert, got %v", checkouts)
+		}
+		if store.state.Pin("app1/docker-compose.yml") != nil {
+			t.Error("Expected no pin after a failed revert")
+		}
+		if store.state.Failure("app1/docker-compose.yml") == nil {
+			t.Error("Expected failure to be recorded")
+		}
+	})
+
+	t.Run("Stack options can disable the revert", func(t *testing.T) {
+		store := deployed()
+		var checkouts []string
+		deployer := &mockDeployer{DeployComposeFunc: func(options docker.ComposeOptions, healthTimeout time.Duration) error {
+			return errors.New("compose failed")
+		}}
+		dc := newCommand(store, deployer, recordCheckouts(&checkouts))
+		disabled := false
+		dc.params.Stacks = map[string]StackParameters{"app1/docker-compose.yml": {AutoRevert: &disabled}}
+
+		dc.Handle()
+
+		if len(checkouts) != 0 {
+			t.Errorf("Expected no revert, got checkouts %v", checkouts)
+		}
+	})
+
+	t.Run("Never deployed stack has nothing to revert to", func(t *testing.T) {
+		store := &mockStateStore{state: state.New()}
+		var checkouts []string
+		deployer := &mockDeployer{DeployComposeFunc: func(options docker.ComposeOptions, healthTimeout time.Duration) error {
+			return errors.New("compose failed")
+		}}
+
+		newCommand(store, deployer, recordCheckouts(&checkouts)).Handle()
+
+		if len(checkouts) != 0 {
+			t.Errorf("Expected no revert, got checkouts %v", checkouts)
+		}
+	})
+}
+
+// changedResult returns a sync result in which every target in updated has changed files
+func changedResult(commit string, updated ...string) *git.SyncResult {
+	result := &git.SyncResult{Commit: commit, Updated: updated}
+	for _, target := range updated {
+		result.Changes = append(result.Changes, git.Change{Target: target, Reason: git.ReasonChangedFiles, Files: []string{target}})
+	}
+	return result
+}
+
+func TestDeployCommand_ImageUpdates(t *testing.T) {
+	newCommand := func(store *mockStateStore, deployer *mockDeployer, images *mockImageChecker) *deployCommand {
+		disabled := false
+		return &deployCommand{
+			locker: &mockLocker{},
+			params: DeployCommandParameters{
+				Repo:               "repo",
+				Branch:             "main",
+				OutPath:            "/tmp",
+				RemoteComposePaths: []string{"app1/compose.yml", "app2/compose.yml"},
+				RetryMaxAttempts:   3,
+				ImageUpdates:       true,
+				Stacks:             map[string]StackParameters{"app2/compose.yml": {ImageUpdates: &disabled}},
+			},
+			syncer:   &mockSyncer{SyncFunc: func(targets []git.Target) (*git.SyncResult, error) { return &git.SyncResult{Commit: "c1"}, nil }},
+			deployer: deployer,
+			images:   images,
+			store:    store,
+		}
+	}
+
+	t.Run("Pulls and redeploys a stack whose image was updated", func(t *testing.T) {
+		store := &mockStateStore{state: state.New()}
+		store.state.MarkDeployed("app1/compose.yml", "c1", time.Now())
+		store.state.MarkDeployed("app2/compose.yml", "c1", time.Now())
+
+		var checked []string
+		images := &mockImageChecker{UpdatedImagesFunc: func(ctx context.Context, options docker.ComposeOptions) ([]string, error) {
+			checked = append(checked, options.Files[0])
+			return []string{"nginx:latest"}, nil
+		}}
+		var deployed []docker.ComposeOptions
+		deployer := &mockDeployer{DeployComposeFunc: func(options docker.ComposeOptions, healthTimeout time.Duration) error {
+			deployed = append(deployed, options)
+			return nil
+		}}
+
+		newCommand(store, deployer, images).Handle()
+
+		if !reflect.DeepEqual(checked, []string{"/tmp/app1/compose.yml"}) {
+			t.Errorf("Expected only app1 to be checked for image updates, but got %v", checked)
+		}
+		if len(deployed) != 1 || deployed[0].Files[0] != "/tmp/app1/compose.yml" || deployed[0].Pull != "always" {
+			t.Errorf("Expected app1 to be deployed pulling its images, but got %+v", deployed)
+		}
+		if commit := store.state.Commit("app1/compose.yml"); commit != "c1" {
+			t.Errorf("Expected app1 to stay recorded at c1, got %q", commit)
+		}
+	})
+
+	t.Run("Skips stacks that are never deployed, failed or changed", func(t *testing.T) {
+		store := &mockStateStore{state: state.New()}
+		store.state.MarkFailed("app1/compose.yml", state.Failure{Commit: "c1", Attempts: 3})
+		images := &mockImageChecker{UpdatedImagesFunc: func(ctx context.Context, options docker.ComposeOptions) ([]string, error) {
+			t.Errorf("Expected no image checks, but %v was checked", options.Files)
+			return nil, nil
+		}}
+
+		newCommand(store, &mockDeployer{}, images).Handle()
+	})
+
+	t.Run("Check errors do not stop the other stacks", func(t *testing.T) {
+		store := &mockStateStore{state: state.New()}
+		store.state.MarkDeployed("app1/compose.yml", "c1", time.Now())
+		images := &mockImageChecker{UpdatedImagesFunc: func(ctx context.Context, options docker.ComposeOptions) ([]string, error) {
+			return nil, errors.New("registry unreachable")
+		}}
+		deployer := &mockDeployer{DeployComposeFunc: func(options docker.ComposeOptions, healthTimeout time.Duration) error {
+			t.Error("Expected no deploy, but got one")
+			return nil
+		}}
+
+		newCommand(store, deployer, images).Handle()
+	})
+}
+
+func TestDeployCommand_Secrets(t *testing.T) {
+	newCommand := func(store *mockStateStore, deployer *mockDeployer, decrypter *mockSecretDecrypter) *deployCommand {
+		return &deployCommand{
+			locker: &mockLocker{},
+			params: DeployCommandParameters{
+				Repo:               "repo",
+				Branch:             "main",
+				OutPath:            "/tmp",
+				RemoteComposePaths: []string{"app/compose.yml"},
+				RetryMaxAttempts:   3,
+			},
+			syncer: &mockSyncer{SyncFunc: func(targets []git.Target) (*git.SyncResult, error) {
+				return changedResult("c1", "app/compose.yml"), nil
+			}},
+			deployer:  deployer,
+			decrypter: decrypter,
+			store:     store,
+		}
+	}
+
+	t.Run("Decrypts the secrets of a stack before deploying it", func(t *testing.T) {
+		var calls []string
+		decrypter := &mockSecretDecrypter{DecryptFunc: func(dirs []string) ([]string, error) {
+			calls = append(calls, "decrypt")
+			if !reflect.DeepEqual(dirs, []string{"app"}) {
+				t.Errorf("Expected the app directory to be decrypted, but got %v", dirs)
+			}
+			return []string{"app/app.env"}, nil
+		}}
+		deployer := &mockDeployer{DeployComposeFunc: func(options docker.ComposeOptions, healthTimeout time.Duration) error {
+			calls = append(calls, "deploy")
+			return nil
+		}}
+
+		newCommand(&mockStateStore{}, deployer, decrypter).Handle()
+
+		if !reflect.DeepEqual(calls, []string{"decrypt", "deploy"}) {
+			t.Errorf("Expected decrypt before deploy, but got %v", calls)
+		}
+	})
+
+	t.Run("Records a failure without deploying when decryption fails", func(t *testing.T) {
+		store := &mockStateStore{}
+		decrypter := &mockSecretDecrypter{DecryptFunc: func(dirs []string) ([]string, error) {
+			return nil, errors.New("no matching age key")
+		}}
+		deployer := &mockDeployer{DeployComposeFunc: func(options docker.ComposeOptions, healthTimeout time.Duration) error {
+			t.Error("Expected no deploy, but got one")
+			return nil
+		}}
+
+		newCommand(store, deployer, decrypter).Handle()
+
+		failure := store.state.Failure("app/compose.yml")
+		if failure == nil || failure.Attempts != 1 {
+			t.Errorf("Expected a first failed attempt to be recorded, but got %+v", failure)
+		}
+	})
+}
+
+func TestDeployCommand_Notifications(t *testing.T) {
+	newCommand := func(store *mockStateStore, syncer *mockSyncer, deployer *mockDeployer, notifier *mockNotifier) *deployCommand {
+		return &deployCommand{
+			locker: &mockLocker{},
+			params: DeployCommandParameters{
+				Repo:               "repo",
+				Branch:             "main",
+				OutPath:            "/tmp",
+				RemoteComposePaths: []string{"app/compose.yml"},
+				RetryMaxAttempts:   3,
+			},
+			syncer:   syncer,
+			deployer: deployer,
+			reverter: &mockReverter{},
+			notifier: notifier,
+			store:    store,
+		}
+	}
+	changed := &mockSyncer{SyncFunc: func(targets []git.Target) (*git.SyncResult, error) {
+		return changedResult("c2", "app/compose.yml"), nil
+	}}
+
+	t.Run("Sends deployed with the commit range", func(t *testing.T) {
+		store := &mockStateStore{state: state.New()}
+		store.state.MarkDeployed("app/compose.yml", "c1", time.Now())
+		notifier := &mockNotifier{}
+
+		newCommand(store, changed, &mockDeployer{}, notifier).Handle()
+
+		if len(notifier.events) != 1 {
+			t.Fatalf("Expected one event, but got %+v", notifier.events)
+		}
+		event := notifier.events[0]
+		if event.Type != notify.Deployed || event.Stack != "app/compose.yml" || event.From != "c1" || event.To != "c2" || event.Repo != "repo" || event.Branch != "main" {
+			t.Errorf("Expected deployed app/compose.yml from c1 to c2, but got %+v", event)
+		}
+	})
+
+	t.Run("Sends failed with the deploy error, then rolled back", func(t *testing.T) {
+		store := &mockStateStore{state: state.New()}
+		store.state.MarkDeployed("app/compose.yml", "c1", time.Now())
+		deployer := &mockDeployer{DeployComposeFunc: func(options docker.ComposeOptions, healthTimeout time.Duration) error {
+			if store.state.Failure("app/compose.yml") == nil {
+				return errors.New("container app exited with code 1")
+			}
+			return nil
+		}}
+		notifier := &mockNotifier{}
+		command := newCommand(store, changed, deployer, notifier)
+		command.params.AutoRevert = true
+
+		command.Handle()
+
+		var types []notify.EventType
+		for _, event := range notifier.events {
+			types = append(types, event.Type)
+		}
+		if !reflect.DeepEqual(types, []notify.EventType{notify.Failed, notify.RolledBack}) {
+			t.Fatalf("Expected failed then rolled back, but got %v", types)
+		}
+		failed, rolledBack := notifier.events[0], notifier.events[1]
+		if failed.Error != "container app exited with code 1" || failed.From != "c1" || failed.To != "c2" {
+			t.Errorf("Expected the deploy error of c1..c2, but got %+v", failed)
+		}
+		if rolledBack.From != "c2" || rolledBack.To != "c1" || rolledBack.Error != failed.Error {
+			t.Errorf("Expected a revert from c2 to c1, but got %+v", rolledBack)
+		}
+	})
+
+	t.Run("Sends no-op when nothing changed", func(t *testing.T) {
+		store := &mockStateStore{state: state.New()}
+		store.state.MarkDeployed("app/compose.yml", "c1", time.Now())
+		unchanged := &mockSyncer{SyncFunc: func(targets []git.Target) (*git.SyncResult, error) { return &git.SyncResult{Commit: "c1"}, nil }}
+		notifier := &mockNotifier{}
+
+		newCommand(store, unchanged, &mockDeployer{}, notifier).Handle()
+
+		if len(notifier.events) != 1 || notifier.events[0].Type != notify.NoChanges || notifier.events[0].To != "c1" {
+			t.Errorf("Expected a no-op event at c1, but got %+v", notifier.events)
+		}
+	})
+}
+
+func TestDeployCommand_Result(t *testing.T) {
+	newCommand := func(syncer *mockSyncer, deployer *mockDeployer) *deployCommand {
+		return &deployCommand{
+			locker: &mockLocker{},
+			params: DeployCommandParameters{
+				Repo:               "repo",
+				Branch:             "main",
+				OutPath:            "/tmp",
+				RemoteComposePaths: []string{"app1/compose.yml", "app2/compose.yml", "app3/compose.yml"},
+				RetryMaxAttempts:   3,
+			},
+			syncer:   syncer,
+			deployer: deployer,
+			store:    &mockStateStore{},
+		}
+	}
+	changed := &mockSyncer{SyncFunc: func(targets []git.Target) (*git.SyncResult, error) {
+		return changedResult("c1", "app1/compose.yml", "app2/compose.yml", "app3/compose.yml"), nil
+	}}
+
+	t.Run("Returns nil when stacks were deployed", func(t *testing.T) {
+		if err := newCommand(changed, &mockDeployer{}).Handle(); err != nil {
+			t.Errorf("Expected no error, but got %v", err)
+		}
+	})
+
+	t.Run("Returns ErrNoChanges when nothing was deployed", func(t *testing.T) {
+		unchanged := &mockSyncer{SyncFunc: func(targets []git.Target) (*git.SyncResult, error) { return &git.SyncResult{Commit: "c1"}, nil }}
+
+		if err := newCommand(unchanged, &mockDeployer{}).Handle(); ExitCode(err) != ExitNoChanges {
+			t.Errorf("Expected exit code %d, but got %d (%v)", ExitNoChanges, ExitCode(err), err)
+		}
+	})
+
+	t.Run("Returns a sync error when the repository cannot be synced", func(t *testing.T) {
+		offline := &mockSyncer{SyncFunc: func(targets []git.Target) (*git.SyncResult, error) { return nil, errors.New("offline") }}
+
+		if err := newCommand(offline, &mockDeployer{}).Handle(); ExitCode(err) != ExitSyncFailed {
+			t.Errorf("Expected exit code %d, but got %d (%v)", ExitSyncFailed, ExitCode(err), err)
+		}
+	})
+
+	t.Run("Deploys the other stacks, returns a deploy error and writes the summary after a partial deploy", func(t *testing.T) {
+		deployer := &mockDeployer{DeployComposeFunc: func(options docker.ComposeOptions, healthTimeout time.Duration) error {
+			if options.Files[0] == "/tmp/app2/compose.yml" {
+				return errors.New("compose failed")
+			}
+			return nil
+		}}
+		command := newCommand(changed, deployer)
+		command.params.SummaryFile = filepath.Join(t.TempDir(), "summary.json")
+
+		err := command.Handle()
+
+		var deployErr *deployError
+		if !errors.As(err, &deployErr) {
+			t.Fatalf("Expected a deploy error, but got %v", err)
+		}
+		if !reflect.DeepEqual(deployErr.Deployed, []string{"app1/compose.yml", "app3/compose.yml"}) || len(deployErr.Failed) != 1 || deployErr.Failed[0].Stack != "app2/compose.yml" {
+			t.Errorf("Expected app1 and app3 deployed and app2 failed, but got %+v", deployErr)
+		}
+
+		content, err := os.ReadFile(command.params.SummaryFile)
+		if err != nil {
+			t.Fatalf("Expected the summary file to be written, but got %v", err)
+		}
+		var summary deploySummary
+		if err := json.Unmarshal(content, &summary); err != nil {
+			t.Fatalf("Expected a JSON summary, but got %q", content)
+		}
+		if summary.Status != "deployFailed" || summary.ExitCode != ExitDeployFailed || summary.Commit != "c1" {
+			t.Errorf("Expected a failed deploy of c1, but got %+v", summary)
+		}
+		var results []string
+		for _, stack := range summary.Stacks {
+			results = append(results, stack.Stack+"="+stack.Result)
+		}
+		expected := []string{"app1/compose.yml=deployed", "app2/compose.yml=failed", "app3/compose.yml=deployed"}
+		if !reflect.DeepEqual(results, expected) {
+			t.Errorf("Expected stack results %v, but got %v", expected, results)
+		}
+		if summary.Stacks[1].Error != "compose failed" {
+			t.Errorf("Expected the deploy error in the summary, but got %q", summary.Stacks[1].Error)
+		}
+	})
+
+	t.Run("Collects the errors of every failed stack", func(t *testing.T) {
+		var attempted []string
+		deployer := &mockDeployer{DeployComposeFunc: func(options docker.ComposeOptions, healthTimeout time.Duration) error {
+			attempted = append(attempted, options.Files[0])
+			if options.Files[0] != "/tmp/app2/compose.yml" {
+				return errors.New("compose failed")
+			}
+			return nil
+		}}
+
+		err := newCommand(changed, deployer).Handle()
+
+		var deployErr *deployError
+		if !errors.As(err, &deployErr) || len(deployErr.Failed) != 2 || deployErr.Failed[0].Stack != "app1/compose.yml" || deployErr.Failed[1].Stack != "app3/compose.yml" {
+			t.Errorf("Expected app1 and app3 to fail, but got %v", err)
+		}
+		if len(attempted) != 3 {
+			t.Errorf("Expected every stack to be attempted, but got %v", attempted)
+		}
+	})
+
+	t.Run("Stops at the first failed stack with fail-fast", func(t *testing.T) {
+		var attempted []string
+		deployer := &mockDeployer{DeployComposeFunc: func(options docker.ComposeOptions, healthTimeout time.Duration) error {
+			attempted = append(attempted, options.Files[0])
+			return errors.New("compose failed")
+		}}
+		command := newCommand(changed, deployer)
+		command.params.FailurePolicy = FailFast
+		command.params.SummaryFile = filepath.Join(t.TempDir(), "summary.json")
+
+		err := command.Handle()
+
+		if ExitCode(err) != ExitDeployFailed {
+			t.Errorf("Expected exit code %d, but got %d (%v)", ExitDeployFailed, ExitCode(err), err)
+		}
+		if !reflect.DeepEqual(attempted, []string{"/tmp/app1/compose.yml"}) {
+			t.Errorf("Expected only app1 to be attempted, but got %v", attempted)
+		}
+		content, _ := os.ReadFile(command.params.SummaryFile)
+		var summary deploySummary
+		json.Unmarshal(content, &summary)
+		if len(summary.Stacks) != 3 || summary.Stacks[2].Result != stackSkipped || summary.Stacks[2].Reason != "an earlier deploy failed" {
+			t.Errorf("Expected the stacks after app1 to be skipped, but got %+v", summary.Stacks)
+		}
+	})
+
+	t.Run("Stops and reports the stacks when the state cannot be saved", func(t *testing.T) {
+		for _, deployErr := range []error{nil, errors.New("compose failed")} {
+			command := newCommand(changed, &mockDeployer{DeployComposeFunc: func(options docker.ComposeOptions, healthTimeout time.Duration) error {
+				return deployErr
+			}})
+			command.store = &mockStateStore{SaveFunc: func(st *state.State) error { return errors.New("disk full") }}
+			command.params.SummaryFile = filepath.Join(t.TempDir(), "summary.json")
+
+			err := command.Handle()
+
+			if ExitCode(err) != ExitError || !strings.Contains(err.Error(), "disk full") {
+				t.Errorf("Expected the save error with exit code %d, but got %d (%v)", ExitError, ExitCode(err), err)
+			}
+			content, _ := os.ReadFile(command.params.SummaryFile)
+			var summary deploySummary
+			json.Unmarshal(content, &summary)
+			var results []string
+			for _, stack := range summary.Stacks {
+				results = append(results, stack.Stack+"="+stack.Result)
+			}
+			first := "app1/compose.yml=deployed"
+			if deployErr != nil {
+				first = "app1/compose.yml=failed"
+			}
+			expected := []string{first, "app2/compose.yml=skipped", "app3/compose.yml=skipped"}
+			if !reflect.DeepEqual(results, expected) {
+				t.Errorf("Expected stack results %v, but got %v", expected, results)
+			}
+		}
+	})
+}
+
+func TestDeployCommand_Parallel(t *testing.T) {
+	stacks := []string{"app1/compose.yml", "app2/compose.yml", "app3/compose.yml", "app4/compose.yml"}
+	newCommand := func(deployer *mockDeployer, maxParallel int) *deployCommand {
+		return &deployCommand{
+			locker: &mockLocker{},
+			params: DeployCommandParameters{
+				Repo:               "repo",
+				Branch:             "main",
+				OutPath:            "/tmp",
+				RemoteComposePaths: stacks,
+				RetryMaxAttempts:   3,
+				MaxParallel:        maxParallel,
+			},
+			syncer: &mockSyncer{SyncFunc: func(targets []git.Target) (*git.SyncResult, error) {
+				return changedResult("c1", stacks...), nil
+			}},
+			deployer: deployer,
+			store:    &mockStateStore{},
+		}
+	}
+
+	t.Run("Deploys up to MaxParallel stacks at once", func(t *testing.T) {
+		var mu sync.Mutex
+		running, maxRunning := 0, 0
+		deployer := &mockDeployer{DeployComposeFunc: func(options docker.ComposeOptions, healthTimeout time.Duration) error {
+			mu.Lock()
+			running++
+			maxRunning = max(maxRunning, running)
+			mu.Unlock()
+			time.Sleep(10 * time.Millisecond)
+			mu.Lock()
+			running--
+			mu.Unlock()
+			return nil
+		}}
+
+		if err := newCommand(deployer, 2).Handle(); err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if maxRunning != 2 {
+			t.Errorf("Expected 2 stacks deployed at once, but got %d", maxRunning)
+		}
+	})
+
+	t.Run("Reports the stacks in order whichever finishes first", func(t *testing.T) {
+		deployer := &mockDeployer{DeployComposeFunc: func(options docker.ComposeOptions, healthTimeout time.Duration) error {
+			if options.Files[0] == "/tmp/app1/compose.yml" {
+				time.Sleep(20 * time.Millisecond)
+				return errors.New("compose failed")
+			}
+			return nil
+		}}
+		command := newCommand(deployer, 4)
+		command.params.SummaryFile = filepath.Join(t.TempDir(), "summary.json")
+
+		err := command.Handle()
+
+		var deployErr *deployError
+		if !errors.As(err, &deployErr) || !reflect.DeepEqual(deployErr.Deployed, stacks[1:]) {
+			t.Errorf("Expected app2 to app4 deployed in order, but got %v", err)
+		}
+		content, _ := os.ReadFile(command.params.SummaryFile)
+		var summary deploySummary
+		json.Unmarshal(content, &summary)
+		var results []string
+		for _, stack := range summary.Stacks {
+			results = append(results, stack.Stack+"="+stack.Result)
+		}
+		expected := []string{"app1/compose.yml=failed", "app2/compose.yml=deployed", "app3/compose.yml=deployed", "app4/compose.yml=deployed"}
+		if !reflect.DeepEqual(results, expected) {
+			t.Errorf("Expected stack results %v, but got %v", expected, results)
+		}
+		if failure := command.store.(*mockStateStore).state.Failure("app1/compose.yml"); failure == nil {
+			t.Error("Expected the failure of app1 to be recorded, but it wasn't")
+		}
+	})
+
+	t.Run("Buffers compose output only when deploying in parallel", func(t *testing.T) {
+		var buffered atomic.Int32
+		deployer := &mockDeployer{DeployComposeFunc: func(options docker.ComposeOptions, healthTimeout time.Duration) error {
+			if options.Stdout != nil && options.Stderr != nil {
+				buffered.Add(1)
+			}
+			return nil
+		}}
+
+		newCommand(deployer, 1).Handle()
+		if buffered.Load() != 0 {
+			t.Errorf("Expected compose to write to the deployer's output, but %d stacks were buffered", buffered.Load())
+		}
+
+		newCommand(deployer, 2).Handle()
+		if buffered.Load() != int32(len(stacks)) {
+			t.Errorf("Expected every stack to be buffered, but got %d", buffered.Load())
+		}
+	})
+}
+
+func TestDeployCommand_Lock(t *testing.T) {
+	newCommand := func(locker *mockLocker, syncer *mockSyncer) *deployCommand {
+		return &deployCommand{
+			params: DeployCommandParameters{
+				Repo:               "repo",
+				Branch:             "main",
+				OutPath:            "/tmp",
+				RemoteComposePaths: []string{"app/compose.yml"},
+				RetryMaxAttempts:   3,
+			},
+			syncer:   syncer,
+			deployer: &mockDeployer{},
+			locker:   locker,
+			store:    &mockStateStore{},
+		}
+	}
+
+	t.Run("Holds the lock from the sync to the deploy", func(t *testing.T) {
+		var calls []string
+		locker := &mockLocker{
+			AcquireFunc: func() error { calls = append(calls, "acquire"); return nil },
+			ReleaseFunc: func() error { calls = append(calls, "release"); return nil },
+		}
+		syncer := &mockSyncer{SyncFunc: func(targets []git.Target) (*git.SyncResult, error) {
+			calls = append(calls, "sync")
+			return changedResult("c1", "app/compose.yml"), nil
+		}}
+		command := newCommand(locker, syncer)
+		command.deployer = &mockDeployer{DeployComposeFunc: func(options docker.ComposeOptions, healthTimeout time.Duration) error {
+			calls = append(calls, "deploy")
+			return nil
+		}}
+
+		if err := command.Handle(); err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if expected := []string{"acquire", "sync", "deploy", "release"}; !reflect.DeepEqual(calls, expected) {
+			t.Errorf("Expected calls %v, but got %v", expected, calls)
+		}
+	})
+
+	t.Run("Returns a locked error without syncing while another run holds the lock", func(t *testing.T) {
+		locker := &mockLocker{
+			AcquireFunc: func() error { return fmt.Errorf("%w: /tmp.lock is held by process 42", lock.ErrLocked) },
+			ReleaseFunc: func() error { t.Error("Expected a lock that was not acquired not to be released"); return nil },
+		}
+		syncer := &mockSyncer{SyncFunc: func(targets []git.Target) (*git.SyncResult, error) {
+			t.Error("Expected no sync while locked")
+			return nil, nil
+		}}
+
+		if err := newCommand(locker, syncer).Handle(); ExitCode(err) != ExitLocked {
+			t.Errorf("Expected exit code %d, but got %d (%v)", ExitLocked, ExitCode(err), err)
+		}
+	})
 }
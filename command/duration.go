@@ -0,0 +1,45 @@
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Duration is a time.Duration that is written as a string such as "30s" or "5m"
+// in configuration files.
+type Duration time.Duration
+
+// UnmarshalJSON implements the json.Unmarshaler interface for Duration
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("duration must be a string such as \"5m\": %w", err)
+	}
+	return d.parse(value)
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface for Duration
+func (d *Duration) UnmarshalYAML(node *yaml.Node) error {
+	var value string
+	if err := node.Decode(&value); err != nil {
+		return fmt.Errorf("duration must be a string such as \"5m\": %w", err)
+	}
+	return d.parse(value)
+}
+
+func (d *Duration) parse(value string) error {
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q: %w", value, err)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// String returns the duration formatted like time.Duration
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
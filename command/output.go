@@ -0,0 +1,51 @@
+package command
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/gnugomez/voyage/docker"
+)
+
+// outputMu keeps the output of stacks deployed at once from interleaving
+var outputMu sync.Mutex
+
+// stackOutput buffers the compose output of a stack deployed alongside others,
+// until it can be printed at once
+type stackOutput struct {
+	stdout bytes.Buffer
+	stderr bytes.Buffer
+}
+
+// options makes compose write the output of options to the buffers. A nil output
+// leaves options alone, compose then writes straight to the deployer's output.
+func (o *stackOutput) options(options docker.ComposeOptions) docker.ComposeOptions {
+	if o != nil {
+		options.Stdout = &o.stdout
+		options.Stderr = &o.stderr
+	}
+	return options
+}
+
+// flush prints the buffered output to stdout and stderr with every line prefixed by stack
+func (o *stackOutput) flush(stack string, stdout, stderr io.Writer) {
+	if o == nil {
+		return
+	}
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	writePrefixed(stdout, stack, o.stdout.String())
+	writePrefixed(stderr, stack, o.stderr.String())
+}
+
+func writePrefixed(w io.Writer, stack, output string) {
+	if output == "" {
+		return
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(output, "\n"), "\n") {
+		fmt.Fprintf(w, "[%s] %s\n", stack, line)
+	}
+}
@@ -0,0 +1,42 @@
+package command
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/gnugomez/voyage/docker"
+)
+
+func TestStackOutput(t *testing.T) {
+	t.Run("Prints the buffered output with every line prefixed", func(t *testing.T) {
+		output := &stackOutput{}
+		options := output.options(docker.ComposeOptions{})
+		fmt.Fprint(options.Stdout, "web-1 Started\ndb-1 Started\n")
+		fmt.Fprint(options.Stderr, "Pulling web")
+
+		var stdout, stderr bytes.Buffer
+		output.flush("app/compose.yml", &stdout, &stderr)
+
+		if expected := "[app/compose.yml] web-1 Started\n[app/compose.yml] db-1 Started\n"; stdout.String() != expected {
+			t.Errorf("Expected stdout %q, but got %q", expected, stdout.String())
+		}
+		if expected := "[app/compose.yml] Pulling web\n"; stderr.String() != expected {
+			t.Errorf("Expected stderr %q, but got %q", expected, stderr.String())
+		}
+	})
+
+	t.Run("Leaves the options alone without a buffer", func(t *testing.T) {
+		var output *stackOutput
+		options := output.options(docker.ComposeOptions{})
+		if options.Stdout != nil || options.Stderr != nil {
+			t.Errorf("Expected no output writers, but got %+v", options)
+		}
+
+		var stdout bytes.Buffer
+		output.flush("app/compose.yml", &stdout, &stdout)
+		if stdout.Len() != 0 {
+			t.Errorf("Expected nothing printed, but got %q", stdout.String())
+		}
+	})
+}
@@ -5,16 +5,35 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"regexp"
+	"slices"
 	"strings"
+	"time"
 
+	"github.com/gnugomez/voyage/docker"
+	"github.com/gnugomez/voyage/git"
+	"github.com/gnugomez/voyage/log"
 	"gopkg.in/yaml.v3"
 )
 
 // Constants for default values and configuration
 const (
-	defaultLogLevel = "info"
+	defaultLogLevel         = "info"
+	defaultLogFormat        = "text"
+	defaultLogMaxSize       = 10
+	defaultLogMaxBackups    = 3
+	defaultRetryMaxAttempts = 5
+	defaultRetryBackoff     = time.Minute
+	defaultMaxParallel      = 1
+	defaultWatchInterval    = time.Minute
+	defaultListenAddress    = ":8080"
+	defaultDebounce         = 5 * time.Second
+	webhookSecretEnv        = "VOYAGE_WEBHOOK_SECRET"
 )
 
+// composeProjectName matches the project names compose accepts
+var composeProjectName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
 // PrintUsageFunc represents a function that prints command usage information
 type PrintUsageFunc func()
 
@@ -45,8 +64,8 @@ func deployCommandParametersParser(args []string) (DeployCommandParameters, Prin
 		return DeployCommandParameters{}, fs.Usage, err
 	}
 
-	params, err := loadConfigFromFile(fs)
-	if err != nil {
+	params := DeployCommandParameters{}
+	if err := loadConfigFromFile(fs, &params); err != nil {
 		return DeployCommandParameters{}, fs.Usage, err
 	}
 
@@ -71,43 +90,73 @@ func setupDeployFlags() *flag.FlagSet {
 		fmt.Fprintf(fs.Output(), "  voyage deploy -config my-config.json\n")
 	}
 
+	registerDeployFlags(fs)
+
+	return fs
+}
+
+// registerDeployFlags adds the flags shared by every command that runs a deploy cycle
+func registerDeployFlags(fs *flag.FlagSet) {
 	fs.String("config", "", "path to a JSON configuration file")
 	fs.String("r", "", "repository name")
-	fs.Var(&stringSlice{}, "c", "path to docker-compose.yml (can be specified multiple times)")
+	fs.Var(&stringSlice{}, "c", "path to docker-compose.yml (can be specified multiple times), override files deployed with it follow after commas")
 	fs.String("b", "", "branch name")
+	fs.String("tag", "", "deploy the newest tag matching this glob (v1.*) or semver range (>=2.0.0 <3) instead of the branch head")
 	fs.String("o", "", "out path")
 	fs.Bool("f", false, "force deployment even if no changes detected")
-	fs.String("l", defaultLogLevel, "log level (debug, info, error, fatal)")
-
-	return fs
+	fs.String("l", defaultLogLevel, "log level (debug, info, warn, error, fatal)")
+	fs.String("log-format", "", fmt.Sprintf("log line format: %s, %s or %s (default %s)", log.TextFormat, log.JSONFormat, log.LogfmtFormat, defaultLogFormat))
+	fs.String("log-file", "", "also write logs to this file, rotated when it grows past -log-max-size")
+	fs.Int("log-max-size", 0, fmt.Sprintf("size in megabytes the log file is rotated at (default %d)", defaultLogMaxSize))
+	fs.Int("log-max-backups", 0, fmt.Sprintf("number of rotated log files kept (default %d)", defaultLogMaxBackups))
+	fs.Int("retry-max", 0, fmt.Sprintf("maximum deploy attempts for a failing compose file before waiting for new changes (default %d)", defaultRetryMaxAttempts))
+	fs.Duration("retry-backoff", 0, fmt.Sprintf("delay before retrying a failed deploy, doubled on every attempt (default %s)", defaultRetryBackoff))
+	fs.Duration("health-timeout", 0, "wait up to this long for the containers to become healthy after compose up, 0 disables the wait")
+	fs.String("git-backend", "", fmt.Sprintf("how to run git: %s runs the git command, %s uses a built-in implementation (default %s)", git.BackendCLI, git.BackendGo, git.BackendCLI))
+	fs.String("git-ssh-key", "", "private key used to clone and fetch ssh remotes")
+	fs.String("git-known-hosts", "", "known_hosts file the host keys of ssh remotes must be in")
+	fs.String("git-username", "", "username sent to https remotes with the git token (default git)")
+	fs.String("git-token-file", "", "file holding the token used to clone and fetch https remotes")
+	fs.String("git-token-env", "", "environment variable holding the token used to clone and fetch https remotes")
+	fs.Int("git-depth", 0, "clone only this many commits of history, older commits are fetched when needed, 0 clones all of it")
+	fs.String("git-filter", "", "partial clone filter such as blob:none, files are downloaded when checked out")
+	fs.Bool("git-sparse", false, "check out only the directories of the compose files and the files they reference")
+	fs.String("docker-backend", "", fmt.Sprintf("how to talk to docker: %s runs the docker command, %s calls the Engine API on DOCKER_HOST (default %s)", docker.BackendCLI, docker.BackendEngine, docker.BackendCLI))
+	fs.Duration("lock-timeout", 0, "wait up to this long for another voyage run on the same output directory to finish, 0 fails right away")
+	fs.Bool("auto-revert", false, "redeploy the last good commit of a compose file when its deploy fails")
+	fs.Bool("image-updates", false, "redeploy a compose file when the registry digest of an image tag it uses changes")
+	fs.Int("max-parallel", 0, fmt.Sprintf("number of stacks deployed at once, their compose output is buffered and prefixed when above 1 (default %d)", defaultMaxParallel))
+	fs.String("failure-policy", "", fmt.Sprintf("what to do when a stack fails to deploy: %s stops deploying, %s deploys the other stacks (default %s)", FailFast, Continue, Continue))
+	fs.String("summary-file", "", "write the outcome of every deploy cycle to this file as JSON")
+	fs.String("sops-age-key", "", "path to the age key used to decrypt *.enc.env and secrets/*.sops.yaml files before a deploy")
 }
 
-// loadConfigFromFile loads parameters from configuration file if provided
-func loadConfigFromFile(fs *flag.FlagSet) (DeployCommandParameters, error) {
-	params := DeployCommandParameters{}
+// loadConfigFromFile decodes the configuration file, if provided, into params.
+// params must be a pointer to a parameters struct.
+func loadConfigFromFile(fs *flag.FlagSet, params any) error {
 	configPath := fs.Lookup("config").Value.String()
 
 	if configPath != "" {
 		file, err := os.ReadFile(configPath)
 		if err != nil {
-			return params, fmt.Errorf("error reading config file %s: %w", configPath, err)
+			return fmt.Errorf("error reading config file %s: %w", configPath, err)
 		}
 
 		if strings.HasSuffix(configPath, ".json") {
-			if err := json.Unmarshal(file, &params); err != nil {
-				return params, fmt.Errorf("error parsing JSON config file %s: %w", configPath, err)
+			if err := json.Unmarshal(file, params); err != nil {
+				return fmt.Errorf("error parsing JSON config file %s: %w", configPath, err)
 			}
 		} else if strings.HasSuffix(configPath, ".yaml") || strings.HasSuffix(configPath, ".yml") {
-			if err := yaml.Unmarshal(file, &params); err != nil {
-				return params, fmt.Errorf("error parsing YAML config file %s: %w", configPath, err)
+			if err := yaml.Unmarshal(file, params); err != nil {
+				return fmt.Errorf("error parsing YAML config file %s: %w", configPath, err)
 			}
 		} else {
-			return params, fmt.Errorf("unsupported config file format: %s", configPath)
+			return fmt.Errorf("unsupported config file format: %s", configPath)
 		}
 
 	}
 
-	return params, nil
+	return nil
 }
 
 // overrideWithFlags applies command-line flag values to override config file values
@@ -122,11 +171,16 @@ func overrideWithFlags(fs *flag.FlagSet, params DeployCommandParameters) DeployC
 			params.RemoteComposePaths = []string(*composePaths)
 		}
 	}
+	params = groupComposeFiles(params)
 
 	if branch := fs.Lookup("b").Value.String(); branch != "" {
 		params.Branch = branch
 	}
 
+	if tag := fs.Lookup("tag").Value.String(); tag != "" {
+		params.Tag = tag
+	}
+
 	if outPath := fs.Lookup("o").Value.String(); outPath != "" {
 		params.OutPath = outPath
 	}
@@ -136,6 +190,90 @@ func overrideWithFlags(fs *flag.FlagSet, params DeployCommandParameters) DeployC
 		params.Force = true
 	}
 
+	if retryMax := fs.Lookup("retry-max").Value.(flag.Getter).Get().(int); retryMax != 0 {
+		params.RetryMaxAttempts = retryMax
+	} else if params.RetryMaxAttempts == 0 {
+		params.RetryMaxAttempts = defaultRetryMaxAttempts
+	}
+
+	if retryBackoff := fs.Lookup("retry-backoff").Value.(flag.Getter).Get().(time.Duration); retryBackoff != 0 {
+		params.RetryBackoff = Duration(retryBackoff)
+	} else if params.RetryBackoff == 0 {
+		params.RetryBackoff = Duration(defaultRetryBackoff)
+	}
+
+	if lockTimeout := fs.Lookup("lock-timeout").Value.(flag.Getter).Get().(time.Duration); lockTimeout != 0 {
+		params.LockTimeout = Duration(lockTimeout)
+	}
+
+	if healthTimeout := fs.Lookup("health-timeout").Value.(flag.Getter).Get().(time.Duration); healthTimeout != 0 {
+		params.HealthTimeout = Duration(healthTimeout)
+	}
+
+	if gitBackend := fs.Lookup("git-backend").Value.String(); gitBackend != "" {
+		params.GitBackend = gitBackend
+	} else if params.GitBackend == "" {
+		params.GitBackend = git.BackendCLI
+	}
+
+	for name, value := range map[string]*string{
+		"git-ssh-key":     &params.GitSSHKey,
+		"git-known-hosts": &params.GitKnownHosts,
+		"git-username":    &params.GitUsername,
+		"git-token-file":  &params.GitTokenFile,
+		"git-token-env":   &params.GitTokenEnv,
+	} {
+		if flagValue := fs.Lookup(name).Value.String(); flagValue != "" {
+			*value = flagValue
+		}
+	}
+
+	if gitDepth := fs.Lookup("git-depth").Value.(flag.Getter).Get().(int); gitDepth != 0 {
+		params.GitDepth = gitDepth
+	}
+
+	if gitFilter := fs.Lookup("git-filter").Value.String(); gitFilter != "" {
+		params.GitFilter = gitFilter
+	}
+
+	if gitSparseFlag := fs.Lookup("git-sparse"); gitSparseFlag.Value.String() == "true" {
+		params.GitSparse = true
+	}
+
+	if dockerBackend := fs.Lookup("docker-backend").Value.String(); dockerBackend != "" {
+		params.DockerBackend = dockerBackend
+	} else if params.DockerBackend == "" {
+		params.DockerBackend = docker.BackendCLI
+	}
+
+	if autoRevertFlag := fs.Lookup("auto-revert"); autoRevertFlag.Value.String() == "true" {
+		params.AutoRevert = true
+	}
+
+	if imageUpdatesFlag := fs.Lookup("image-updates"); imageUpdatesFlag.Value.String() == "true" {
+		params.ImageUpdates = true
+	}
+
+	if maxParallel := fs.Lookup("max-parallel").Value.(flag.Getter).Get().(int); maxParallel != 0 {
+		params.MaxParallel = maxParallel
+	} else if params.MaxParallel == 0 {
+		params.MaxParallel = defaultMaxParallel
+	}
+
+	if failurePolicy := fs.Lookup("failure-policy").Value.String(); failurePolicy != "" {
+		params.FailurePolicy = failurePolicy
+	} else if params.FailurePolicy == "" {
+		params.FailurePolicy = Continue
+	}
+
+	if summaryFile := fs.Lookup("summary-file").Value.String(); summaryFile != "" {
+		params.SummaryFile = summaryFile
+	}
+
+	if sopsAgeKey := fs.Lookup("sops-age-key").Value.String(); sopsAgeKey != "" {
+		params.SopsAgeKeyFile = sopsAgeKey
+	}
+
 	// Handle log level - always override if different from default
 	if logLevel := fs.Lookup("l").Value.String(); logLevel != defaultLogLevel {
 		params.LogLevel = logLevel
@@ -143,6 +281,54 @@ func overrideWithFlags(fs *flag.FlagSet, params DeployCommandParameters) DeployC
 		params.LogLevel = defaultLogLevel
 	}
 
+	if logFormat := fs.Lookup("log-format").Value.String(); logFormat != "" {
+		params.LogFormat = logFormat
+	} else if params.LogFormat == "" {
+		params.LogFormat = defaultLogFormat
+	}
+
+	if logFile := fs.Lookup("log-file").Value.String(); logFile != "" {
+		params.LogFile = logFile
+	}
+
+	if logMaxSize := fs.Lookup("log-max-size").Value.(flag.Getter).Get().(int); logMaxSize != 0 {
+		params.LogMaxSize = logMaxSize
+	} else if params.LogMaxSize == 0 {
+		params.LogMaxSize = defaultLogMaxSize
+	}
+
+	if logMaxBackups := fs.Lookup("log-max-backups").Value.(flag.Getter).Get().(int); logMaxBackups != 0 {
+		params.LogMaxBackups = logMaxBackups
+	} else if params.LogMaxBackups == 0 {
+		params.LogMaxBackups = defaultLogMaxBackups
+	}
+
+	return params
+}
+
+// groupComposeFiles splits compose paths given as a comma separated list of compose
+// files, like app/compose.yml,app/compose.prod.yml, into the compose path that names
+// the stack and the override files deployed together with it.
+func groupComposeFiles(params DeployCommandParameters) DeployCommandParameters {
+	composePaths := make([]string, 0, len(params.RemoteComposePaths))
+	for _, composePath := range params.RemoteComposePaths {
+		files := strings.Split(composePath, ",")
+		for i := range files {
+			files[i] = strings.TrimSpace(files[i])
+		}
+		composePaths = append(composePaths, files[0])
+		if len(files) == 1 {
+			continue
+		}
+
+		if params.Stacks == nil {
+			params.Stacks = map[string]StackParameters{}
+		}
+		stack := params.Stacks[files[0]]
+		stack.Overrides = files[1:]
+		params.Stacks[files[0]] = stack
+	}
+	params.RemoteComposePaths = composePaths
 	return params
 }
 
@@ -156,7 +342,7 @@ func validateParameters(params DeployCommandParameters) error {
 	if len(params.RemoteComposePaths) == 0 {
 		missingParams = append(missingParams, "-c (compose path)")
 	}
-	if params.Branch == "" {
+	if params.Branch == "" && params.Tag == "" {
 		missingParams = append(missingParams, "-b (branch)")
 	}
 	if params.OutPath == "" {
@@ -167,5 +353,390 @@ func validateParameters(params DeployCommandParameters) error {
 		return &missingParamsError{params: missingParams}
 	}
 
+	if !slices.Contains(log.LogFormats, log.LogFormat(params.LogFormat)) {
+		return fmt.Errorf("unsupported log format %q, expected %s, %s or %s", params.LogFormat, log.TextFormat, log.JSONFormat, log.LogfmtFormat)
+	}
+	if params.LogMaxSize < 1 || params.LogMaxBackups < 1 {
+		return fmt.Errorf("logMaxSize and logMaxBackups must be at least 1, got %d and %d", params.LogMaxSize, params.LogMaxBackups)
+	}
+	if params.RetryMaxAttempts < 1 {
+		return fmt.Errorf("retryMaxAttempts must be at least 1, got %d", params.RetryMaxAttempts)
+	}
+	if params.RetryBackoff < 0 {
+		return fmt.Errorf("retryBackoff must not be negative, got %s", params.RetryBackoff)
+	}
+	if params.GitBackend != git.BackendCLI && params.GitBackend != git.BackendGo {
+		return fmt.Errorf("unsupported git backend %q, expected %s or %s", params.GitBackend, git.BackendCLI, git.BackendGo)
+	}
+	if params.GitTokenFile != "" && params.GitTokenEnv != "" {
+		return fmt.Errorf("gitTokenFile and gitTokenEnv cannot be used together")
+	}
+	if params.Tag != "" {
+		if _, err := git.ParseTagPattern(params.Tag); err != nil {
+			return err
+		}
+	}
+	if params.GitDepth < 0 {
+		return fmt.Errorf("gitDepth must not be negative, got %d", params.GitDepth)
+	}
+	if (params.GitFilter != "" || params.GitSparse) && params.GitBackend != git.BackendCLI {
+		return fmt.Errorf("gitFilter and gitSparse need the %s git backend", git.BackendCLI)
+	}
+	if params.DockerBackend != docker.BackendCLI && params.DockerBackend != docker.BackendEngine {
+		return fmt.Errorf("unsupported docker backend %q, expected %s or %s", params.DockerBackend, docker.BackendCLI, docker.BackendEngine)
+	}
+	if params.MaxParallel < 1 {
+		return fmt.Errorf("maxParallel must be at least 1, got %d", params.MaxParallel)
+	}
+	if params.FailurePolicy != FailFast && params.FailurePolicy != Continue {
+		return fmt.Errorf("unsupported failure policy %q, expected %s or %s", params.FailurePolicy, FailFast, Continue)
+	}
+	if params.LockTimeout < 0 {
+		return fmt.Errorf("lockTimeout must not be negative, got %s", params.LockTimeout)
+	}
+	if params.HealthTimeout < 0 {
+		return fmt.Errorf("healthTimeout must not be negative, got %s", params.HealthTimeout)
+	}
+
+	for i, notification := range params.Notifications {
+		if err := notification.Validate(); err != nil {
+			return fmt.Errorf("notification %d: %w", i+1, err)
+		}
+	}
+
+	for composePath, stack := range params.Stacks {
+		if !slices.Contains(params.RemoteComposePaths, composePath) {
+			return fmt.Errorf("stack options given for %s, which is not one of the compose paths", composePath)
+		}
+		if stack.HealthTimeout != nil && *stack.HealthTimeout < 0 {
+			return fmt.Errorf("healthTimeout of stack %s must not be negative, got %s", composePath, *stack.HealthTimeout)
+		}
+		if stack.ProjectName != "" && !composeProjectName.MatchString(stack.ProjectName) {
+			return fmt.Errorf("projectName of stack %s must contain only lowercase letters, digits, dashes and underscores, got %q", composePath, stack.ProjectName)
+		}
+		if stack.Pull != "" && !slices.Contains(docker.PullPolicies, stack.Pull) {
+			return fmt.Errorf("pull of stack %s must be one of %s, got %q", composePath, strings.Join(docker.PullPolicies, ", "), stack.Pull)
+		}
+		if slices.Contains(stack.Profiles, "") || slices.Contains(stack.EnvFiles, "") {
+			return fmt.Errorf("stack %s has an empty profile or env file", composePath)
+		}
+		for i, override := range stack.Overrides {
+			if override == "" {
+				return fmt.Errorf("stack %s has an empty override file", composePath)
+			}
+			if override == composePath || slices.Contains(stack.Overrides[:i], override) {
+				return fmt.Errorf("override file %s is given more than once for stack %s", override, composePath)
+			}
+		}
+	}
+
+	return nil
+}
+
+// watchCommandParametersParser parses command line arguments and configuration file
+// to create WatchCommandParameters for the watch command.
+//
+// It accepts every deploy flag plus the watch specific ones, using the same
+// precedence rules as deployCommandParametersParser.
+func watchCommandParametersParser(args []string) (WatchCommandParameters, PrintUsageFunc, error) {
+	fs := setupWatchFlags()
+
+	if err := fs.Parse(args); err != nil {
+		return WatchCommandParameters{}, fs.Usage, err
+	}
+
+	params := WatchCommandParameters{}
+	if err := loadConfigFromFile(fs, &params); err != nil {
+		return WatchCommandParameters{}, fs.Usage, err
+	}
+
+	params.DeployCommandParameters = overrideWithFlags(fs, params.DeployCommandParameters)
+	params = overrideWatchWithFlags(fs, params)
+
+	if err := validateParameters(params.DeployCommandParameters); err != nil {
+		return WatchCommandParameters{}, fs.Usage, err
+	}
+	if err := validateWatchParameters(params); err != nil {
+		return WatchCommandParameters{}, fs.Usage, err
+	}
+
+	return params, fs.Usage, nil
+}
+
+// setupWatchFlags creates and configures the flag set for watch command
+func setupWatchFlags() *flag.FlagSet {
+	fs := flag.NewFlagSet("watch", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage of %s:\n", fs.Name())
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "\nYou can provide parameters either via command-line flags or a JSON configuration file.\n")
+		fmt.Fprintf(fs.Output(), "\nExample:\n")
+		fmt.Fprintf(fs.Output(), "  voyage watch -r my-repo -c docker-compose.yml -b main -o /tmp/deploy -i 5m -j 30s\n")
+		fmt.Fprintf(fs.Output(), "  voyage watch -config my-config.json\n")
+	}
+
+	registerDeployFlags(fs)
+	fs.Duration("i", 0, fmt.Sprintf("interval between deploy cycles (default %s)", defaultWatchInterval))
+	fs.Duration("j", 0, "maximum random jitter added to every interval")
+
+	return fs
+}
+
+// overrideWatchWithFlags applies watch specific flag values and defaults
+func overrideWatchWithFlags(fs *flag.FlagSet, params WatchCommandParameters) WatchCommandParameters {
+	if interval := fs.Lookup("i").Value.(flag.Getter).Get().(time.Duration); interval != 0 {
+		params.Interval = Duration(interval)
+	} else if params.Interval == 0 {
+		params.Interval = Duration(defaultWatchInterval)
+	}
+
+	if jitter := fs.Lookup("j").Value.(flag.Getter).Get().(time.Duration); jitter != 0 {
+		params.Jitter = Duration(jitter)
+	}
+
+	return params
+}
+
+// validateWatchParameters validates the watch specific parameters
+func validateWatchParameters(params WatchCommandParameters) error {
+	if params.Interval <= 0 {
+		return fmt.Errorf("interval must be positive, got %s", params.Interval)
+	}
+	if params.Jitter < 0 {
+		return fmt.Errorf("jitter must not be negative, got %s", params.Jitter)
+	}
+	return nil
+}
+
+// serveCommandParametersParser parses command line arguments and configuration file
+// to create ServeCommandParameters for the serve command.
+//
+// The webhook secret is only read from the configuration file or the
+// VOYAGE_WEBHOOK_SECRET environment variable so it never shows up in process listings.
+func serveCommandParametersParser(args []string) (ServeCommandParameters, PrintUsageFunc, error) {
+	fs := setupServeFlags()
+
+	if err := fs.Parse(args); err != nil {
+		return ServeCommandParameters{}, fs.Usage, err
+	}
+
+	params := ServeCommandParameters{}
+	if err := loadConfigFromFile(fs, &params); err != nil {
+		return ServeCommandParameters{}, fs.Usage, err
+	}
+
+	params.DeployCommandParameters = overrideWithFlags(fs, params.DeployCommandParameters)
+	params = overrideServeWithFlags(fs, params)
+
+	if err := validateParameters(params.DeployCommandParameters); err != nil {
+		return ServeCommandParameters{}, fs.Usage, err
+	}
+	if err := validateServeParameters(params); err != nil {
+		return ServeCommandParameters{}, fs.Usage, err
+	}
+
+	return params, fs.Usage, nil
+}
+
+// setupServeFlags creates and configures the flag set for serve command
+func setupServeFlags() *flag.FlagSet {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage of %s:\n", fs.Name())
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "\nYou can provide parameters either via command-line flags or a JSON configuration file.\n")
+		fmt.Fprintf(fs.Output(), "The webhook secret is read from the config file or the %s environment variable.\n", webhookSecretEnv)
+		fmt.Fprintf(fs.Output(), "\nExample:\n")
+		fmt.Fprintf(fs.Output(), "  voyage serve -r my-repo -c docker-compose.yml -b main -o /tmp/deploy -listen :8080\n")
+		fmt.Fprintf(fs.Output(), "  voyage serve -config my-config.json\n")
+	}
+
+	registerDeployFlags(fs)
+	fs.String("listen", "", fmt.Sprintf("address the webhook listener binds to (default %s)", defaultListenAddress))
+	fs.Duration("debounce", 0, fmt.Sprintf("time to wait for more pushes before deploying (default %s)", defaultDebounce))
+
+	return fs
+}
+
+// overrideServeWithFlags applies serve specific flag values and defaults
+func overrideServeWithFlags(fs *flag.FlagSet, params ServeCommandParameters) ServeCommandParameters {
+	if listen := fs.Lookup("listen").Value.String(); listen != "" {
+		params.Listen = listen
+	} else if params.Listen == "" {
+		params.Listen = defaultListenAddress
+	}
+
+	if debounce := fs.Lookup("debounce").Value.(flag.Getter).Get().(time.Duration); debounce != 0 {
+		params.Debounce = Duration(debounce)
+	} else if params.Debounce == 0 {
+		params.Debounce = Duration(defaultDebounce)
+	}
+
+	if params.WebhookSecret == "" {
+		params.WebhookSecret = os.Getenv(webhookSecretEnv)
+	}
+
+	return params
+}
+
+// validateServeParameters validates the serve specific parameters
+func validateServeParameters(params ServeCommandParameters) error {
+	if params.WebhookSecret == "" {
+		return &missingParamsError{params: []string{"webhookSecret (config file or " + webhookSecretEnv + ")"}}
+	}
+	if params.Debounce < 0 {
+		return fmt.Errorf("debounce must not be negative, got %s", params.Debounce)
+	}
 	return nil
 }
+
+// rollbackCommandParametersParser parses command line arguments and configuration file
+// to create RollbackCommandParameters for the rollback command.
+//
+// The stack to roll back is a positional argument, accepted either before or after the flags.
+func rollbackCommandParametersParser(args []string) (RollbackCommandParameters, PrintUsageFunc, error) {
+	fs := setupRollbackFlags()
+
+	// The flag package stops at the first positional argument, so take the stack off the front
+	var stack string
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		stack, args = args[0], args[1:]
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return RollbackCommandParameters{}, fs.Usage, err
+	}
+
+	positional := fs.Args()
+	if stack == "" && len(positional) > 0 {
+		stack, positional = positional[0], positional[1:]
+	}
+	if len(positional) > 0 {
+		return RollbackCommandParameters{}, fs.Usage, fmt.Errorf("unexpected arguments: %s", strings.Join(positional, " "))
+	}
+
+	params := RollbackCommandParameters{}
+	if err := loadConfigFromFile(fs, &params.DeployCommandParameters); err != nil {
+		return RollbackCommandParameters{}, fs.Usage, err
+	}
+
+	params.DeployCommandParameters = overrideWithFlags(fs, params.DeployCommandParameters)
+	params.Stack = stack
+	params.To = fs.Lookup("to").Value.String()
+	params.Clear = fs.Lookup("clear").Value.String() == "true"
+
+	if err := validateParameters(params.DeployCommandParameters); err != nil {
+		return RollbackCommandParameters{}, fs.Usage, err
+	}
+	if params.Stack == "" {
+		return RollbackCommandParameters{}, fs.Usage, &missingParamsError{params: []string{"<stack>"}}
+	}
+	if params.Clear && params.To != "" {
+		return RollbackCommandParameters{}, fs.Usage, fmt.Errorf("-clear and -to cannot be used together")
+	}
+
+	return params, fs.Usage, nil
+}
+
+// setupRollbackFlags creates and configures the flag set for rollback command
+func setupRollbackFlags() *flag.FlagSet {
+	fs := flag.NewFlagSet("rollback", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage of %s: voyage rollback <stack> [-to <commit>] [-clear] [options]\n", fs.Name())
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "\n<stack> is one of the configured compose paths.\n")
+		fmt.Fprintf(fs.Output(), "\nExample:\n")
+		fmt.Fprintf(fs.Output(), "  voyage rollback app/compose.yml -config my-config.json\n")
+		fmt.Fprintf(fs.Output(), "  voyage rollback app/compose.yml -to 1a2b3c4 -config my-config.json\n")
+		fmt.Fprintf(fs.Output(), "  voyage rollback app/compose.yml -clear -config my-config.json\n")
+	}
+
+	registerDeployFlags(fs)
+	fs.String("to", "", "commit to roll back to (default: the previously deployed commit)")
+	fs.Bool("clear", false, "release the rollback pin so the next deploy brings the stack up to date")
+
+	return fs
+}
+
+// statusCommandParametersParser parses command line arguments and configuration file
+// to create StatusCommandParameters for the status command.
+func statusCommandParametersParser(args []string) (StatusCommandParameters, PrintUsageFunc, error) {
+	fs := setupStatusFlags()
+
+	if err := fs.Parse(args); err != nil {
+		return StatusCommandParameters{}, fs.Usage, err
+	}
+
+	params := StatusCommandParameters{}
+	if err := loadConfigFromFile(fs, &params.DeployCommandParameters); err != nil {
+		return StatusCommandParameters{}, fs.Usage, err
+	}
+
+	params.DeployCommandParameters = overrideWithFlags(fs, params.DeployCommandParameters)
+	params.Format = fs.Lookup("format").Value.String()
+
+	if err := validateParameters(params.DeployCommandParameters); err != nil {
+		return StatusCommandParameters{}, fs.Usage, err
+	}
+	if params.Format != statusFormatTable && params.Format != statusFormatJSON {
+		return StatusCommandParameters{}, fs.Usage, fmt.Errorf("unsupported format %q, expected %s or %s", params.Format, statusFormatTable, statusFormatJSON)
+	}
+
+	return params, fs.Usage, nil
+}
+
+// setupStatusFlags creates and configures the flag set for status command
+func setupStatusFlags() *flag.FlagSet {
+	fs := flag.NewFlagSet("status", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage of %s:\n", fs.Name())
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "\nExample:\n")
+		fmt.Fprintf(fs.Output(), "  voyage status -config my-config.json\n")
+		fmt.Fprintf(fs.Output(), "  voyage status -config my-config.json -format json\n")
+	}
+
+	registerDeployFlags(fs)
+	fs.String("format", statusFormatTable, "output format (table, json)")
+
+	return fs
+}
+
+// planCommandParametersParser parses command line arguments and configuration file
+// for the plan command, which takes the same parameters as the deploy command.
+func planCommandParametersParser(args []string) (DeployCommandParameters, PrintUsageFunc, error) {
+	fs := setupPlanFlags()
+
+	if err := fs.Parse(args); err != nil {
+		return DeployCommandParameters{}, fs.Usage, err
+	}
+
+	params := DeployCommandParameters{}
+	if err := loadConfigFromFile(fs, &params); err != nil {
+		return DeployCommandParameters{}, fs.Usage, err
+	}
+
+	params = overrideWithFlags(fs, params)
+
+	if err := validateParameters(params); err != nil {
+		return DeployCommandParameters{}, fs.Usage, err
+	}
+
+	return params, fs.Usage, nil
+}
+
+// setupPlanFlags creates and configures the flag set for plan command
+func setupPlanFlags() *flag.FlagSet {
+	fs := flag.NewFlagSet("plan", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage of %s:\n", fs.Name())
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "\nShows what deploy would do with the same parameters, without pulling or deploying anything.\n")
+		fmt.Fprintf(fs.Output(), "\nExample:\n")
+		fmt.Fprintf(fs.Output(), "  voyage plan -config my-config.json\n")
+	}
+
+	registerDeployFlags(fs)
+
+	return fs
+}
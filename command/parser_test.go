@@ -4,7 +4,12 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/gnugomez/voyage/docker"
+	"github.com/gnugomez/voyage/notify"
 )
 
 func TestDeployCommandParametersParser(t *testing.T) {
@@ -170,4 +175,611 @@ logLevel: debug
 			t.Fatal("Expected an error for non-existent config file, but got nil")
 		}
 	})
+
+	t.Run("Loads per stack health timeouts from YAML config file", func(t *testing.T) {
+		tempDir := t.TempDir()
+		configPath := filepath.Join(tempDir, "config.yaml")
+		configContent := `
+repo: my-repo
+branch: main
+outPath: /tmp/voyage
+remoteComposePaths:
+  - app1/docker-compose.yml
+  - app2/docker-compose.yml
+healthTimeout: 2m
+stacks:
+  app2/docker-compose.yml:
+    healthTimeout: 0s
+`
+		if err := os.WriteFile(configPath, []byte(configContent), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		params, _, err := deployCommandParametersParser([]string{"-config", configPath})
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+
+		if got := params.healthTimeout("app1/docker-compose.yml"); got != 2*time.Minute {
+			t.Errorf("Expected app1 to use the default health timeout, got %s", got)
+		}
+		if got := params.healthTimeout("app2/docker-compose.yml"); got != 0 {
+			t.Errorf("Expected app2 to disable the health gate, got %s", got)
+		}
+	})
+
+	t.Run("Health timeout flag overrides the default", func(t *testing.T) {
+		params, _, err := deployCommandParametersParser([]string{"-r", "repo", "-b", "main", "-o", "/tmp/out", "-c", "compose.yml", "-health-timeout", "90s"})
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if params.HealthTimeout != Duration(90*time.Second) {
+			t.Errorf("Expected health timeout 1m30s, got %s", params.HealthTimeout)
+		}
+	})
+
+	t.Run("Defaults to the cli docker backend and rejects unknown ones", func(t *testing.T) {
+		args := []string{"-r", "repo", "-b", "main", "-o", "/tmp/out", "-c", "compose.yml"}
+		params, _, err := deployCommandParametersParser(args)
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if params.DockerBackend != "cli" {
+			t.Errorf("Expected docker backend cli, got %s", params.DockerBackend)
+		}
+
+		if _, _, err := deployCommandParametersParser(append(args, "-docker-backend", "podman")); err == nil {
+			t.Fatal("Expected an error for an unknown docker backend, but got nil")
+		}
+	})
+
+	t.Run("Parses the git credentials and rejects two token sources", func(t *testing.T) {
+		args := []string{"-r", "repo", "-b", "main", "-o", "/tmp/out", "-c", "compose.yml", "-git-ssh-key", "/etc/voyage/id_ed25519", "-git-known-hosts", "/etc/voyage/known_hosts", "-git-username", "deploy", "-git-token-env", "GIT_TOKEN"}
+		params, _, err := deployCommandParametersParser(args)
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if params.GitSSHKey != "/etc/voyage/id_ed25519" || params.GitKnownHosts != "/etc/voyage/known_hosts" || params.GitUsername != "deploy" || params.GitTokenEnv != "GIT_TOKEN" {
+			t.Errorf("Expected the git credentials from the flags, got %+v", params)
+		}
+
+		if _, _, err := deployCommandParametersParser(append(args, "-git-token-file", "/etc/voyage/token")); err == nil {
+			t.Fatal("Expected an error for a token file and variable, but got nil")
+		}
+	})
+
+	t.Run("Tracks release tags without a branch and rejects invalid patterns", func(t *testing.T) {
+		args := []string{"-r", "repo", "-o", "/tmp/out", "-c", "compose.yml"}
+		if _, _, err := deployCommandParametersParser(args); err == nil {
+			t.Fatal("Expected an error without a branch or tag, but got nil")
+		}
+
+		params, _, err := deployCommandParametersParser(append(args, "-tag", ">=2.0.0 <3"))
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if params.Tag != ">=2.0.0 <3" || params.Branch != "" {
+			t.Errorf("Expected tag >=2.0.0 <3 and no branch, got %q and %q", params.Tag, params.Branch)
+		}
+
+		if _, _, err := deployCommandParametersParser(append(args, "-tag", ">=two")); err == nil {
+			t.Fatal("Expected an error for an invalid tag range, but got nil")
+		}
+	})
+
+	t.Run("Parses the clone options and rejects them on the go backend", func(t *testing.T) {
+		args := []string{"-r", "repo", "-b", "main", "-o", "/tmp/out", "-c", "compose.yml", "-git-depth", "1", "-git-filter", "blob:none", "-git-sparse"}
+		params, _, err := deployCommandParametersParser(args)
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if params.GitDepth != 1 || params.GitFilter != "blob:none" || !params.GitSparse {
+			t.Errorf("Expected the clone options from the flags, got %+v", params)
+		}
+
+		if _, _, err := deployCommandParametersParser(append(args, "-git-backend", "go")); err == nil {
+			t.Fatal("Expected an error for a sparse clone on the go backend, but got nil")
+		}
+		if _, _, err := deployCommandParametersParser(append(args, "-git-depth", "-1")); err == nil {
+			t.Fatal("Expected an error for a negative depth, but got nil")
+		}
+	})
+
+	t.Run("Parses the lock timeout and rejects negative ones", func(t *testing.T) {
+		args := []string{"-r", "repo", "-b", "main", "-o", "/tmp/out", "-c", "compose.yml"}
+		params, _, err := deployCommandParametersParser(append(args, "-lock-timeout", "2m"))
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if params.LockTimeout != Duration(2*time.Minute) {
+			t.Errorf("Expected lock timeout 2m, got %s", params.LockTimeout)
+		}
+
+		if _, _, err := deployCommandParametersParser(append(args, "-lock-timeout", "-1s")); err == nil {
+			t.Fatal("Expected an error for a negative lock timeout, but got nil")
+		}
+	})
+
+	t.Run("Defaults to deploying one stack at a time", func(t *testing.T) {
+		args := []string{"-r", "repo", "-b", "main", "-o", "/tmp/out", "-c", "compose.yml"}
+		params, _, err := deployCommandParametersParser(args)
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if params.MaxParallel != 1 {
+			t.Errorf("Expected max parallel 1, got %d", params.MaxParallel)
+		}
+
+		params, _, err = deployCommandParametersParser(append(args, "-max-parallel", "4"))
+		if err != nil || params.MaxParallel != 4 {
+			t.Errorf("Expected max parallel 4, got %d (%v)", params.MaxParallel, err)
+		}
+
+		if _, _, err := deployCommandParametersParser(append(args, "-max-parallel", "-1")); err == nil {
+			t.Fatal("Expected an error for a negative max parallel, but got nil")
+		}
+	})
+
+	t.Run("Defaults to the continue failure policy and rejects unknown ones", func(t *testing.T) {
+		args := []string{"-r", "repo", "-b", "main", "-o", "/tmp/out", "-c", "compose.yml"}
+		params, _, err := deployCommandParametersParser(args)
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if params.FailurePolicy != Continue {
+			t.Errorf("Expected failure policy %s, got %s", Continue, params.FailurePolicy)
+		}
+
+		params, _, err = deployCommandParametersParser(append(args, "-failure-policy", "fail-fast"))
+		if err != nil || params.FailurePolicy != FailFast {
+			t.Errorf("Expected failure policy %s, got %s (%v)", FailFast, params.FailurePolicy, err)
+		}
+
+		if _, _, err := deployCommandParametersParser(append(args, "-failure-policy", "retry")); err == nil {
+			t.Fatal("Expected an error for an unknown failure policy, but got nil")
+		}
+	})
+
+	t.Run("Defaults the log options and rejects unknown formats", func(t *testing.T) {
+		args := []string{"-r", "repo", "-b", "main", "-o", "/tmp/out", "-c", "compose.yml"}
+		params, _, err := deployCommandParametersParser(args)
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if params.LogFormat != "text" || params.LogFile != "" || params.LogMaxSize != 10 || params.LogMaxBackups != 3 {
+			t.Errorf("Expected the default log options, got %+v", params.BaseParameters)
+		}
+
+		params, _, err = deployCommandParametersParser(append(args, "--log-format", "json", "--log-file", "/var/log/voyage.log", "--log-max-size", "50"))
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if params.LogFormat != "json" || params.LogFile != "/var/log/voyage.log" || params.LogMaxSize != 50 {
+			t.Errorf("Expected the log flags to be applied, got %+v", params.BaseParameters)
+		}
+
+		if _, _, err := deployCommandParametersParser(append(args, "-log-format", "xml")); err == nil {
+			t.Fatal("Expected an error for an unknown log format, but got nil")
+		}
+	})
+
+	t.Run("Returns error for options of an unknown stack", func(t *testing.T) {
+		tempDir := t.TempDir()
+		configPath := filepath.Join(tempDir, "config.json")
+		configContent := `{
+			"repo": "my-repo",
+			"branch": "main",
+			"outPath": "/tmp/voyage",
+			"remoteComposePaths": ["docker-compose.yml"],
+			"stacks": {"other/docker-compose.yml": {"healthTimeout": "1m"}}
+		}`
+		if err := os.WriteFile(configPath, []byte(configContent), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, _, err := deployCommandParametersParser([]string{"-config", configPath}); err == nil {
+			t.Fatal("Expected an error for an unknown stack, but got nil")
+		}
+	})
+
+	t.Run("Groups comma separated compose files into one stack", func(t *testing.T) {
+		args := []string{"-r", "repo", "-b", "main", "-o", "/tmp/out", "-c", "app/compose.yml,app/compose.override.yml", "-c", "other/compose.yml"}
+		params, _, err := deployCommandParametersParser(args)
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+
+		expectedPaths := []string{"app/compose.yml", "other/compose.yml"}
+		if !reflect.DeepEqual(params.RemoteComposePaths, expectedPaths) {
+			t.Errorf("Expected compose paths %v, but got %v", expectedPaths, params.RemoteComposePaths)
+		}
+		expectedFiles := []string{"/tmp/out/app/compose.yml", "/tmp/out/app/compose.override.yml"}
+		if got := params.composeFilePaths("app/compose.yml"); !reflect.DeepEqual(got, expectedFiles) {
+			t.Errorf("Expected compose files %v, but got %v", expectedFiles, got)
+		}
+		if got := params.composeFiles("other/compose.yml"); !reflect.DeepEqual(got, []string{"other/compose.yml"}) {
+			t.Errorf("Expected other/compose.yml on its own, but got %v", got)
+		}
+
+		if _, _, err := deployCommandParametersParser([]string{"-r", "repo", "-b", "main", "-o", "/tmp/out", "-c", "compose.yml,compose.yml"}); err == nil {
+			t.Fatal("Expected an error for a repeated compose file, but got nil")
+		}
+	})
+
+	t.Run("Loads override files from YAML config file", func(t *testing.T) {
+		tempDir := t.TempDir()
+		configPath := filepath.Join(tempDir, "config.yaml")
+		configContent := `
+repo: my-repo
+branch: main
+outPath: /tmp/voyage
+remoteComposePaths:
+  - app/compose.yml
+stacks:
+  app/compose.yml:
+    overrides:
+      - app/compose.prod.yml
+      - shared/logging.yml
+`
+		if err := os.WriteFile(configPath, []byte(configContent), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		params, _, err := deployCommandParametersParser([]string{"-config", configPath})
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+
+		expected := []string{"app/compose.yml", "app/compose.prod.yml", "shared/logging.yml"}
+		if got := params.composeFiles("app/compose.yml"); !reflect.DeepEqual(got, expected) {
+			t.Errorf("Expected compose files %v, but got %v", expected, got)
+		}
+		if got := params.subDirs("app/compose.yml"); !reflect.DeepEqual(got, []string{"app", "shared"}) {
+			t.Errorf("Expected subdirectories [app shared], but got %v", got)
+		}
+	})
+
+	t.Run("Loads compose options of a stack from YAML config file", func(t *testing.T) {
+		tempDir := t.TempDir()
+		configPath := filepath.Join(tempDir, "config.yaml")
+		configContent := `
+repo: my-repo
+branch: main
+outPath: /tmp/voyage
+remoteComposePaths:
+  - app/compose.yml
+stacks:
+  app/compose.yml:
+    projectName: web
+    profiles: [prod]
+    envFiles: [env/prod.env]
+    projectDirectory: app
+    removeOrphans: true
+    build: true
+    pull: always
+    forceRecreate: true
+`
+		if err := os.WriteFile(configPath, []byte(configContent), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		params, _, err := deployCommandParametersParser([]string{"-config", configPath})
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+
+		expected := docker.ComposeOptions{
+			Files:            []string{"/tmp/voyage/app/compose.yml"},
+			ProjectName:      "web",
+			ProjectDirectory: "/tmp/voyage/app",
+			Profiles:         []string{"prod"},
+			EnvFiles:         []string{"/tmp/voyage/env/prod.env"},
+			Detach:           true,
+			RemoveOrphans:    true,
+			Build:            true,
+			Pull:             "always",
+			ForceRecreate:    true,
+		}
+		if got := params.composeOptions("app/compose.yml"); !reflect.DeepEqual(got, expected) {
+			t.Errorf("Expected compose options %+v, but got %+v", expected, got)
+		}
+	})
+
+	t.Run("Returns error for invalid compose options", func(t *testing.T) {
+		testCases := map[string]string{
+			"unknown pull policy":  `{"pull": "sometimes"}`,
+			"invalid project name": `{"projectName": "My App"}`,
+			"empty profile":        `{"profiles": [""]}`,
+		}
+		for name, stack := range testCases {
+			t.Run(name, func(t *testing.T) {
+				configPath := filepath.Join(t.TempDir(), "config.json")
+				configContent := `{"repo": "r", "branch": "main", "outPath": "/tmp/voyage", "remoteComposePaths": ["compose.yml"], "stacks": {"compose.yml": ` + stack + `}}`
+				if err := os.WriteFile(configPath, []byte(configContent), 0644); err != nil {
+					t.Fatal(err)
+				}
+
+				if _, _, err := deployCommandParametersParser([]string{"-config", configPath}); err == nil {
+					t.Fatal("Expected an error, but got nil")
+				}
+			})
+		}
+	})
+	t.Run("Loads notifications from YAML config file", func(t *testing.T) {
+		configPath := filepath.Join(t.TempDir(), "config.yaml")
+		configContent := `
+repo: my-repo
+branch: main
+outPath: /tmp/voyage
+remoteComposePaths:
+  - compose.yml
+notifications:
+  - type: ntfy
+    url: https://ntfy.sh/deploys
+    events: [failed, rolledBack]
+  - type: email
+    host: smtp.example.com:587
+    from: voyage@example.com
+    to: [ops@example.com]
+`
+		if err := os.WriteFile(configPath, []byte(configContent), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		params, _, err := deployCommandParametersParser([]string{"-config", configPath})
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+
+		expected := []notify.Config{
+			{Type: notify.Ntfy, URL: "https://ntfy.sh/deploys", Events: []notify.EventType{notify.Failed, notify.RolledBack}},
+			{Type: notify.Email, Host: "smtp.example.com:587", From: "voyage@example.com", To: []string{"ops@example.com"}},
+		}
+		if !reflect.DeepEqual(params.Notifications, expected) {
+			t.Errorf("Expected notifications %+v, but got %+v", expected, params.Notifications)
+		}
+	})
+
+	t.Run("Returns error for an invalid notification", func(t *testing.T) {
+		configPath := filepath.Join(t.TempDir(), "config.json")
+		configContent := `{"repo": "r", "branch": "main", "outPath": "/tmp/voyage", "remoteComposePaths": ["compose.yml"], "notifications": [{"type": "gotify", "url": "https://gotify.example.com"}]}`
+		if err := os.WriteFile(configPath, []byte(configContent), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		_, _, err := deployCommandParametersParser([]string{"-config", configPath})
+		if err == nil || !strings.Contains(err.Error(), "gotify notifier is missing token") {
+			t.Errorf("Expected a missing token error, but got %v", err)
+		}
+	})
+}
+
+func TestWatchCommandParametersParser(t *testing.T) {
+	t.Run("Loads interval and jitter from YAML config file", func(t *testing.T) {
+		tempDir := t.TempDir()
+		configPath := filepath.Join(tempDir, "config.yaml")
+		configContent := `
+repo: my-repo
+branch: main
+outPath: /tmp/voyage
+remoteComposePaths:
+  - docker-compose.yml
+interval: 5m
+jitter: 30s
+`
+		if err := os.WriteFile(configPath, []byte(configContent), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		params, _, err := watchCommandParametersParser([]string{"-config", configPath})
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+
+		if params.Repo != "my-repo" {
+			t.Errorf("Expected repo to be 'my-repo', got '%s'", params.Repo)
+		}
+		if time.Duration(params.Interval) != 5*time.Minute {
+			t.Errorf("Expected interval to be 5m, got %s", params.Interval)
+		}
+		if time.Duration(params.Jitter) != 30*time.Second {
+			t.Errorf("Expected jitter to be 30s, got %s", params.Jitter)
+		}
+	})
+
+	t.Run("Loads interval from JSON config file and flags take precedence", func(t *testing.T) {
+		tempDir := t.TempDir()
+		configPath := filepath.Join(tempDir, "config.json")
+		configContent := `{
+			"repo": "my-repo",
+			"branch": "main",
+			"outPath": "/tmp/voyage",
+			"remoteComposePaths": ["docker-compose.yml"],
+			"interval": "5m",
+			"jitter": "30s"
+		}`
+		if err := os.WriteFile(configPath, []byte(configContent), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		params, _, err := watchCommandParametersParser([]string{"-config", configPath, "-i", "10m"})
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+
+		if time.Duration(params.Interval) != 10*time.Minute {
+			t.Errorf("Expected interval to be 10m, got %s", params.Interval)
+		}
+		if time.Duration(params.Jitter) != 30*time.Second {
+			t.Errorf("Expected jitter to be 30s, got %s", params.Jitter)
+		}
+	})
+
+	t.Run("Defaults interval when not provided", func(t *testing.T) {
+		args := []string{"-r", "repo", "-b", "main", "-o", "/tmp/out", "-c", "compose.yml"}
+
+		params, _, err := watchCommandParametersParser(args)
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+
+		if time.Duration(params.Interval) != defaultWatchInterval {
+			t.Errorf("Expected default interval %s, got %s", defaultWatchInterval, params.Interval)
+		}
+	})
+
+	t.Run("Returns error for invalid interval in config", func(t *testing.T) {
+		tempDir := t.TempDir()
+		configPath := filepath.Join(tempDir, "config.json")
+		if err := os.WriteFile(configPath, []byte(`{"interval": "soon"}`), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, _, err := watchCommandParametersParser([]string{"-config", configPath}); err == nil {
+			t.Fatal("Expected an error for invalid interval, but got nil")
+		}
+	})
+}
+
+func TestServeCommandParametersParser(t *testing.T) {
+	t.Run("Loads listener settings from config file", func(t *testing.T) {
+		tempDir := t.TempDir()
+		configPath := filepath.Join(tempDir, "config.yaml")
+		configContent := `
+repo: my-repo
+branch: main
+outPath: /tmp/voyage
+remoteComposePaths:
+  - docker-compose.yml
+listen: 127.0.0.1:9000
+webhookSecret: s3cret
+debounce: 2s
+`
+		if err := os.WriteFile(configPath, []byte(configContent), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		params, _, err := serveCommandParametersParser([]string{"-config", configPath})
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+
+		if params.Listen != "127.0.0.1:9000" {
+			t.Errorf("Expected listen to be '127.0.0.1:9000', got '%s'", params.Listen)
+		}
+		if params.WebhookSecret != "s3cret" {
+			t.Errorf("Expected webhook secret to be loaded from config")
+		}
+		if time.Duration(params.Debounce) != 2*time.Second {
+			t.Errorf("Expected debounce to be 2s, got %s", params.Debounce)
+		}
+	})
+
+	t.Run("Reads secret from environment and applies defaults", func(t *testing.T) {
+		t.Setenv(webhookSecretEnv, "from-env")
+		args := []string{"-r", "repo", "-b", "main", "-o", "/tmp/out", "-c", "compose.yml"}
+
+		params, _, err := serveCommandParametersParser(args)
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+
+		if params.WebhookSecret != "from-env" {
+			t.Errorf("Expected webhook secret to be read from %s", webhookSecretEnv)
+		}
+		if params.Listen != defaultListenAddress {
+			t.Errorf("Expected default listen address %s, got %s", defaultListenAddress, params.Listen)
+		}
+		if time.Duration(params.Debounce) != defaultDebounce {
+			t.Errorf("Expected default debounce %s, got %s", defaultDebounce, params.Debounce)
+		}
+	})
+
+	t.Run("Returns error when no secret is configured", func(t *testing.T) {
+		t.Setenv(webhookSecretEnv, "")
+		args := []string{"-r", "repo", "-b", "main", "-o", "/tmp/out", "-c", "compose.yml"}
+
+		if _, _, err := serveCommandParametersParser(args); err == nil {
+			t.Fatal("Expected an error for missing webhook secret, but got nil")
+		}
+	})
+}
+
+func TestRollbackCommandParametersParser(t *testing.T) {
+	baseArgs := []string{"-r", "repo", "-b", "main", "-o", "/tmp/out", "-c", "app/compose.yml"}
+
+	t.Run("Accepts the stack before the flags", func(t *testing.T) {
+		args := append([]string{"app/compose.yml", "-to", "abc123"}, baseArgs...)
+
+		params, _, err := rollbackCommandParametersParser(args)
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if params.Stack != "app/compose.yml" || params.To != "abc123" {
+			t.Errorf("Unexpected params %+v", params)
+		}
+	})
+
+	t.Run("Accepts the stack after the flags", func(t *testing.T) {
+		args := append(append([]string{"-clear"}, baseArgs...), "app/compose.yml")
+
+		params, _, err := rollbackCommandParametersParser(args)
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if params.Stack != "app/compose.yml" || !params.Clear {
+			t.Errorf("Unexpected params %+v", params)
+		}
+	})
+
+	t.Run("Returns error without a stack", func(t *testing.T) {
+		if _, _, err := rollbackCommandParametersParser(baseArgs); err == nil {
+			t.Fatal("Expected an error for a missing stack, but got nil")
+		}
+	})
+
+	t.Run("Returns error for -clear with -to", func(t *testing.T) {
+		args := append([]string{"app/compose.yml", "-clear", "-to", "abc"}, baseArgs...)
+		if _, _, err := rollbackCommandParametersParser(args); err == nil {
+			t.Fatal("Expected an error for -clear with -to, but got nil")
+		}
+	})
+}
+
+func TestStatusCommandParametersParser(t *testing.T) {
+	baseArgs := []string{"-r", "repo", "-b", "main", "-o", "/tmp/out", "-c", "app/compose.yml"}
+
+	t.Run("Defaults to table format", func(t *testing.T) {
+		params, _, err := statusCommandParametersParser(baseArgs)
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if params.Format != statusFormatTable {
+			t.Errorf("Expected format %s, got %s", statusFormatTable, params.Format)
+		}
+	})
+
+	t.Run("Returns error for unknown format", func(t *testing.T) {
+		if _, _, err := statusCommandParametersParser(append(baseArgs, "-format", "xml")); err == nil {
+			t.Fatal("Expected an error for an unknown format, but got nil")
+		}
+	})
+}
+
+func TestPlanCommandParametersParser(t *testing.T) {
+	t.Run("Accepts the deploy parameters", func(t *testing.T) {
+		params, _, err := planCommandParametersParser([]string{"-r", "repo", "-b", "main", "-o", "/tmp/out", "-c", "app/compose.yml", "-f"})
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if params.Repo != "repo" || !params.Force || params.RetryMaxAttempts != defaultRetryMaxAttempts {
+			t.Errorf("Expected deploy parameters with defaults, got %+v", params)
+		}
+	})
+
+	t.Run("Returns error for missing parameters", func(t *testing.T) {
+		if _, _, err := planCommandParametersParser([]string{"-r", "repo"}); err == nil {
+			t.Fatal("Expected an error for missing flags, but got nil")
+		}
+	})
 }
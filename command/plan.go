@@ -0,0 +1,155 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gnugomez/voyage/docker"
+	"github.com/gnugomez/voyage/git"
+	"github.com/gnugomez/voyage/log"
+	"github.com/gnugomez/voyage/registry"
+	"github.com/gnugomez/voyage/state"
+)
+
+// ChangeDetector reports which targets changed without touching the working tree
+type ChangeDetector interface {
+	HeadReader
+	Detect(targets []git.Target) ([]git.Change, error)
+}
+
+// WaitPlanner tells whether compose waits for the containers of a deploy, as the
+// Deployer runs it
+type WaitPlanner interface {
+	WaitOptions(options docker.ComposeOptions, healthTimeout time.Duration) docker.ComposeOptions
+}
+
+type planCommand struct {
+	params   DeployCommandParameters
+	detector ChangeDetector
+	images   ImageChecker
+	waits    WaitPlanner
+	store    StateStore
+	locker   Locker
+	out      io.Writer
+}
+
+func (p *planCommand) GetBaseParameters() BaseParameters {
+	return p.params.BaseParameters
+}
+
+func (p *planCommand) Handle() error {
+	// Lazy initialization of dependencies. In tests, these will be pre-filled with mocks.
+	if p.detector == nil {
+		repository, err := p.params.repository()
+		if err != nil {
+			return fmt.Errorf("failed to create git service: %w", err)
+		}
+		p.detector = repository
+	}
+	if p.images == nil || p.waits == nil {
+		dockerService, err := docker.NewDockerService(p.params.DockerBackend)
+		if err != nil {
+			return fmt.Errorf("failed to create docker service: %w", err)
+		}
+		if p.images == nil {
+			p.images = docker.NewImageChecker(dockerService, registry.NewClient())
+		}
+		if p.waits == nil {
+			p.waits = docker.NewDeployer(dockerService)
+		}
+	}
+	if p.store == nil {
+		p.store = state.NewStore(p.params.OutPath)
+	}
+	if p.locker == nil {
+		p.locker = p.params.locker()
+	}
+	if p.out == nil {
+		p.out = os.Stdout
+	}
+
+	// Detecting changes fetches, which must not race the pull of a deploy
+	if err := p.locker.Acquire(); err != nil {
+		return err
+	}
+	defer func() {
+		if err := p.locker.Release(); err != nil {
+			log.Error("Error releasing lock", "error", err)
+		}
+	}()
+
+	st, _, err := p.params.loadState(p.store, p.detector)
+	if err != nil {
+		return err
+	}
+
+	// The plan is made by the same code the deploy command decides with
+	deploy := &deployCommand{params: p.params, images: p.images}
+	changes, err := p.detector.Detect(deploy.targets(st))
+	if err != nil {
+		return &syncError{err: fmt.Errorf("failed to detect changes: %w", err)}
+	}
+
+	if slices.ContainsFunc(changes, func(c git.Change) bool { return c.Reason == git.ReasonFirstClone }) {
+		fmt.Fprintf(p.out, "Repository %s (%s) is not cloned yet, it would be cloned into %s\n\n", p.params.Repo, p.params.tracked(), p.params.OutPath)
+	}
+
+	decisions := deploy.checkImages(st, deploy.decide(st, changes, time.Now()))
+	if err := writePlan(p.out, p.params, p.waits, st, decisions); err != nil {
+		return fmt.Errorf("failed to write plan: %w", err)
+	}
+	return nil
+}
+
+// writePlan prints every decision with the files and the command behind it
+func writePlan(out io.Writer, params DeployCommandParameters, waits WaitPlanner, st *state.State, decisions []deployDecision) error {
+	deploys := 0
+	for _, decision := range decisions {
+		if !decision.Deploy {
+			fmt.Fprintf(out, "%s: skip (%s)\n", decision.ComposePath, decision.Reason)
+			continue
+		}
+
+		deploys++
+		fmt.Fprintf(out, "%s: deploy (%s)\n", decision.ComposePath, decision.Reason)
+		for _, file := range decision.Files {
+			fmt.Fprintf(out, "    changed: %s\n", file)
+		}
+		for _, image := range decision.Images {
+			fmt.Fprintf(out, "    updated image: %s\n", image)
+		}
+		timeout := params.healthTimeout(decision.ComposePath)
+		options := waits.WaitOptions(params.deployOptions(decision), timeout)
+		fmt.Fprintf(out, "    command: docker %s\n", strings.Join(docker.ComposeUpArgs(options), " "))
+		// Without compose waiting, the deploy polls the containers itself
+		if timeout > 0 && options.Detach && options.WaitTimeout == 0 {
+			fmt.Fprintf(out, "    health: polls the containers for up to %s until they are healthy\n", timeout)
+		}
+		if good := st.Commit(decision.ComposePath); good != "" && params.autoRevert(decision.ComposePath) {
+			fmt.Fprintf(out, "    on failure: reverts to %s\n", shortCommit(good))
+		}
+	}
+
+	_, err := fmt.Fprintf(out, "\n%d of %d compose files would be deployed\n", deploys, len(decisions))
+	return err
+}
+
+func createPlanCommand() (*Command, error) {
+	params, printUsage, err := planCommandParametersParser(os.Args[1:])
+	if err != nil {
+		return nil, parseError(err, printUsage)
+	}
+
+	p := &planCommand{
+		params: params,
+	}
+
+	return &Command{
+		Handle:            p.Handle,
+		GetBaseParameters: p.GetBaseParameters,
+	}, nil
+}
@@ -0,0 +1,236 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gnugomez/voyage/docker"
+	"github.com/gnugomez/voyage/git"
+	"github.com/gnugomez/voyage/lock"
+	"github.com/gnugomez/voyage/state"
+)
+
+type mockChangeDetector struct {
+	DetectFunc func(targets []git.Target) ([]git.Change, error)
+	HeadFunc   func() (string, error)
+}
+
+func (m *mockChangeDetector) Head() (string, error) {
+	if m.HeadFunc != nil {
+		return m.HeadFunc()
+	}
+	return "", nil
+}
+
+func (m *mockChangeDetector) Detect(targets []git.Target) ([]git.Change, error) {
+	if m.DetectFunc != nil {
+		return m.DetectFunc(targets)
+	}
+	return nil, nil
+}
+
+type mockWaitPlanner struct {
+	WaitOptionsFunc func(options docker.ComposeOptions, healthTimeout time.Duration) docker.ComposeOptions
+}
+
+func (m *mockWaitPlanner) WaitOptions(options docker.ComposeOptions, healthTimeout time.Duration) docker.ComposeOptions {
+	if m.WaitOptionsFunc != nil {
+		return m.WaitOptionsFunc(options, healthTimeout)
+	}
+	return options
+}
+
+func TestPlanCommand_Handle(t *testing.T) {
+	newCommand := func(out *bytes.Buffer, st *state.State, detector *mockChangeDetector) *planCommand {
+		return &planCommand{
+			params: DeployCommandParameters{
+				Repo:               "repo",
+				Branch:             "main",
+				OutPath:            "/tmp",
+				RemoteComposePaths: []string{"app1/docker-compose.yml", "app2/docker-compose.yml"},
+				RetryMaxAttempts:   3,
+			},
+			detector: detector,
+			waits:    &mockWaitPlanner{},
+			store:    &mockStateStore{state: st},
+			locker:   &mockLocker{},
+			out:      out,
+		}
+	}
+
+	t.Run("Explains changed and unchanged stacks", func(t *testing.T) {
+		st := state.New()
+		st.MarkDeployed("app1/docker-compose.yml", "c1", time.Now())
+		st.MarkDeployed("app2/docker-compose.yml", "c1", time.Now())
+		out := &bytes.Buffer{}
+		detector := &mockChangeDetector{DetectFunc: func(targets []git.Target) ([]git.Change, error) {
+			if len(targets) != 2 || targets[0].Since != "c1" {
+				t.Errorf("Expected targets to be built from the deployment state, but got %+v", targets)
+			}
+			return []git.Change{{Target: "app1/docker-compose.yml", Reason: git.ReasonChangedFiles, Files: []string{"app1/.env"}}}, nil
+		}}
+
+		newCommand(out, st, detector).Handle()
+
+		expected := `app1/docker-compose.yml: deploy (changed files)
+    changed: app1/.env
+    command: docker compose -f /tmp/app1/docker-compose.yml up -d
+app2/docker-compose.yml: skip (no changes)
+
+1 of 2 compose files would be deployed
+`
+		if out.String() != expected {
+			t.Errorf("Expected plan:\n%s\nbut got:\n%s", expected, out.String())
+		}
+	})
+
+	t.Run("Mentions the clone when the repository is missing", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		detector := &mockChangeDetector{DetectFunc: func(targets []git.Target) ([]git.Change, error) {
+			return []git.Change{
+				{Target: "app1/docker-compose.yml", Reason: git.ReasonFirstClone},
+				{Target: "app2/docker-compose.yml", Reason: git.ReasonFirstClone},
+			}, nil
+		}}
+
+		newCommand(out, state.New(), detector).Handle()
+
+		if !strings.HasPrefix(out.String(), "Repository repo (main) is not cloned yet") {
+			t.Errorf("Expected plan to mention the clone, but got:\n%s", out.String())
+		}
+		if !strings.Contains(out.String(), "2 of 2 compose files would be deployed") {
+			t.Errorf("Expected both stacks to be deployed, but got:\n%s", out.String())
+		}
+	})
+
+	t.Run("Explains retries, pins and failures", func(t *testing.T) {
+		st := state.New()
+		st.MarkDeployed("app1/docker-compose.yml", "c1", time.Now())
+		st.MarkFailed("app1/docker-compose.yml", state.Failure{Commit: "c2", Attempts: 1, NextAttempt: time.Now().Add(-time.Minute)})
+		st.MarkRolledBack("app2/docker-compose.yml", state.Pin{Commit: "0123456789", Base: "c2"})
+		out := &bytes.Buffer{}
+
+		newCommand(out, st, &mockChangeDetector{}).Handle()
+
+		for _, line := range []string{
+			"app1/docker-compose.yml: deploy (retry 2/3 after failure)",
+			"app2/docker-compose.yml: skip (pinned at 0123456)",
+		} {
+			if !strings.Contains(out.String(), line) {
+				t.Errorf("Expected plan to contain %q, but got:\n%s", line, out.String())
+			}
+		}
+	})
+
+	t.Run("Force deploys everything when nothing changed", func(t *testing.T) {
+		st := state.New()
+		st.MarkDeployed("app1/docker-compose.yml", "c1", time.Now())
+		st.MarkDeployed("app2/docker-compose.yml", "c1", time.Now())
+		out := &bytes.Buffer{}
+		command := newCommand(out, st, &mockChangeDetector{})
+		command.params.Force = true
+
+		command.Handle()
+
+		if strings.Count(out.String(), ": deploy (force)") != 2 {
+			t.Errorf("Expected both stacks to be forced, but got:\n%s", out.String())
+		}
+	})
+
+	t.Run("Explains image updates", func(t *testing.T) {
+		st := state.New()
+		st.MarkDeployed("app1/docker-compose.yml", "c1", time.Now())
+		st.MarkDeployed("app2/docker-compose.yml", "c1", time.Now())
+		out := &bytes.Buffer{}
+		command := newCommand(out, st, &mockChangeDetector{})
+		enabled := true
+		command.params.Stacks = map[string]StackParameters{"app1/docker-compose.yml": {ImageUpdates: &enabled}}
+		command.images = &mockImageChecker{UpdatedImagesFunc: func(ctx context.Context, options docker.ComposeOptions) ([]string, error) {
+			return []string{"nginx:latest"}, nil
+		}}
+
+		command.Handle()
+
+		expected := `app1/docker-compose.yml: deploy (image updated)
+    updated image: nginx:latest
+    command: docker compose -f /tmp/app1/docker-compose.yml up -d --pull always
+app2/docker-compose.yml: skip (no changes)
+`
+		if !strings.HasPrefix(out.String(), expected) {
+			t.Errorf("Expected plan:\n%s\nbut got:\n%s", expected, out.String())
+		}
+	})
+
+	t.Run("Prints the wait of compose with a health timeout", func(t *testing.T) {
+		st := state.New()
+		st.MarkDeployed("app2/docker-compose.yml", "c1", time.Now())
+		out := &bytes.Buffer{}
+		command := newCommand(out, st, &mockChangeDetector{DetectFunc: func(targets []git.Target) ([]git.Change, error) {
+			return []git.Change{{Target: "app1/docker-compose.yml", Reason: git.ReasonNeverDeployed}}, nil
+		}})
+		command.params.HealthTimeout = Duration(time.Minute)
+		command.waits = &mockWaitPlanner{WaitOptionsFunc: func(options docker.ComposeOptions, healthTimeout time.Duration) docker.ComposeOptions {
+			options.WaitTimeout = healthTimeout
+			return options
+		}}
+
+		command.Handle()
+
+		expected := "    command: docker compose -f /tmp/app1/docker-compose.yml up -d --wait --wait-timeout 60\n"
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected plan to contain %q, but got:\n%s", expected, out.String())
+		}
+		if strings.Contains(out.String(), "health:") {
+			t.Errorf("Expected no polling when compose waits, but got:\n%s", out.String())
+		}
+	})
+
+	t.Run("Mentions polling when compose cannot wait", func(t *testing.T) {
+		st := state.New()
+		st.MarkDeployed("app2/docker-compose.yml", "c1", time.Now())
+		out := &bytes.Buffer{}
+		command := newCommand(out, st, &mockChangeDetector{DetectFunc: func(targets []git.Target) ([]git.Change, error) {
+			return []git.Change{{Target: "app1/docker-compose.yml", Reason: git.ReasonNeverDeployed}}, nil
+		}})
+		command.params.HealthTimeout = Duration(time.Minute)
+
+		command.Handle()
+
+		expected := `    command: docker compose -f /tmp/app1/docker-compose.yml up -d
+    health: polls the containers for up to 1m0s until they are healthy
+`
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected plan to contain %q, but got:\n%s", expected, out.String())
+		}
+	})
+
+	t.Run("Detection errors print nothing", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		detector := &mockChangeDetector{DetectFunc: func(targets []git.Target) ([]git.Change, error) {
+			return nil, errors.New("offline")
+		}}
+
+		newCommand(out, state.New(), detector).Handle()
+
+		if out.Len() != 0 {
+			t.Errorf("Expected no plan, but got:\n%s", out.String())
+		}
+	})
+	t.Run("Fails without detecting while a deploy holds the lock", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		detector := &mockChangeDetector{DetectFunc: func(targets []git.Target) ([]git.Change, error) {
+			t.Error("Expected no detection while the lock is held")
+			return nil, nil
+		}}
+		command := newCommand(out, state.New(), detector)
+		command.locker = &mockLocker{AcquireFunc: func() error { return lock.ErrLocked }}
+
+		if err := command.Handle(); !errors.Is(err, lock.ErrLocked) {
+			t.Errorf("Expected ErrLocked, but got %v", err)
+		}
+	})
+}
@@ -0,0 +1,186 @@
+package command
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gnugomez/voyage/lock"
+)
+
+// Exit codes of the voyage process, one per outcome of a command
+const (
+	// ExitOK means the command succeeded, for deploy that at least one stack was deployed
+	ExitOK = 0
+	// ExitError means an unexpected error, such as an unreadable state file
+	ExitError = 1
+	// ExitConfigError means invalid flags, configuration file or parameters
+	ExitConfigError = 2
+	// ExitNoChanges means a deploy found nothing to deploy
+	ExitNoChanges = 3
+	// ExitSyncFailed means the repository could not be cloned, fetched or checked out
+	ExitSyncFailed = 4
+	// ExitDeployFailed means at least one stack failed to deploy, others may have been deployed
+	ExitDeployFailed = 5
+	// ExitLocked means another voyage run holds the lock of the output directory
+	ExitLocked = 6
+)
+
+// ErrNoChanges is returned by a deploy that found nothing to deploy
+var ErrNoChanges = errors.New("no changes to deploy")
+
+// configError is returned when the parameters of a command are invalid
+type configError struct {
+	err error
+}
+
+func (e *configError) Error() string { return e.err.Error() }
+func (e *configError) Unwrap() error { return e.err }
+
+// syncError is returned when the repository could not be synced
+type syncError struct {
+	err error
+}
+
+func (e *syncError) Error() string { return "failed to sync repository: " + e.err.Error() }
+func (e *syncError) Unwrap() error { return e.err }
+
+// stackError is the error a stack failed to deploy with
+type stackError struct {
+	Stack string
+	Err   error
+}
+
+// deployError is returned when stacks of a deploy cycle failed to deploy
+type deployError struct {
+	Failed []stackError
+	// Deployed lists the stacks deployed in the same cycle
+	Deployed []string
+}
+
+func (e *deployError) Error() string {
+	if len(e.Failed) == 1 {
+		return fmt.Sprintf("failed to deploy %s: %s", e.Failed[0].Stack, e.Failed[0].Err)
+	}
+	failures := make([]string, len(e.Failed))
+	for i, failure := range e.Failed {
+		failures[i] = failure.Stack + ": " + failure.Err.Error()
+	}
+	return fmt.Sprintf("failed to deploy %d stacks: %s", len(e.Failed), strings.Join(failures, "; "))
+}
+
+func (e *deployError) Unwrap() []error {
+	errs := make([]error, len(e.Failed))
+	for i, failure := range e.Failed {
+		errs[i] = failure.Err
+	}
+	return errs
+}
+
+// ExitCode returns the exit code for the error a command returned
+func ExitCode(err error) int {
+	var configErr *configError
+	var syncErr *syncError
+	var deployErr *deployError
+	switch {
+	case err == nil, errors.Is(err, flag.ErrHelp):
+		return ExitOK
+	case errors.Is(err, ErrNoChanges):
+		return ExitNoChanges
+	case errors.Is(err, lock.ErrLocked):
+		return ExitLocked
+	case errors.As(err, &configErr):
+		return ExitConfigError
+	case errors.As(err, &deployErr):
+		return ExitDeployFailed
+	case errors.As(err, &syncErr):
+		return ExitSyncFailed
+	default:
+		return ExitError
+	}
+}
+
+// exitStatuses names the exit codes in the summary file
+var exitStatuses = map[int]string{
+	ExitOK:           "deployed",
+	ExitError:        "error",
+	ExitConfigError:  "configError",
+	ExitNoChanges:    "noChanges",
+	ExitSyncFailed:   "syncFailed",
+	ExitDeployFailed: "deployFailed",
+	ExitLocked:       "locked",
+}
+
+// Results of a stack in a deploy cycle
+const (
+	stackDeployed = "deployed"
+	stackFailed   = "failed"
+	stackSkipped  = "skipped"
+)
+
+// deploySummary is the machine readable outcome of a deploy cycle, written to
+// the summary file for wrapper scripts
+type deploySummary struct {
+	Status     string         `json:"status"`
+	ExitCode   int            `json:"exitCode"`
+	Branch     string         `json:"branch"`
+	Commit     string         `json:"commit,omitempty"`
+	StartedAt  time.Time      `json:"startedAt"`
+	FinishedAt time.Time      `json:"finishedAt"`
+	Stacks     []stackSummary `json:"stacks"`
+	Error      string         `json:"error,omitempty"`
+}
+
+type stackSummary struct {
+	Stack  string `json:"stack"`
+	Result string `json:"result"`
+	Commit string `json:"commit,omitempty"`
+	Reason string `json:"reason,omitempty"`
+	Error  string `json:"error,omitempty"`
+	// RevertedTo is the last good commit a failed stack was reverted to
+	RevertedTo string `json:"revertedTo,omitempty"`
+}
+
+// finish records the outcome of the cycle from the error it returned
+func (s *deploySummary) finish(err error, at time.Time) {
+	s.ExitCode = ExitCode(err)
+	s.Status = exitStatuses[s.ExitCode]
+	if err != nil && !errors.Is(err, ErrNoChanges) {
+		s.Error = err.Error()
+	}
+	s.FinishedAt = at
+}
+
+// writeSummary replaces the summary file at path, so readers never see a partial one
+func writeSummary(path string, summary *deploySummary) error {
+	if summary.Stacks == nil {
+		summary.Stacks = []stackSummary{}
+	}
+	content, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(content, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
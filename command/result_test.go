@@ -0,0 +1,85 @@
+package command
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gnugomez/voyage/lock"
+)
+
+func TestExitCode(t *testing.T) {
+	testCases := []struct {
+		name     string
+		err      error
+		expected int
+	}{
+		{name: "Success", err: nil, expected: ExitOK},
+		{name: "Help", err: &configError{err: flag.ErrHelp}, expected: ExitOK},
+		{name: "No changes", err: ErrNoChanges, expected: ExitNoChanges},
+		{name: "Config error", err: &configError{err: errors.New("missing required parameter: -r (repository)")}, expected: ExitConfigError},
+		{name: "Sync failure", err: &syncError{err: errors.New("could not read from remote")}, expected: ExitSyncFailed},
+		{name: "Deploy failure", err: &deployError{Failed: []stackError{{Stack: "app/compose.yml", Err: errors.New("compose failed")}}}, expected: ExitDeployFailed},
+		{name: "Wrapped deploy failure", err: fmt.Errorf("cycle: %w", &deployError{}), expected: ExitDeployFailed},
+		{name: "Locked", err: fmt.Errorf("%w: /srv/voyage.lock is held by process 42", lock.ErrLocked), expected: ExitLocked},
+		{name: "Unexpected error", err: errors.New("permission denied"), expected: ExitError},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := ExitCode(tc.err); got != tc.expected {
+				t.Errorf("Expected exit code %d, but got %d", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestDeployError_Error(t *testing.T) {
+	single := &deployError{Failed: []stackError{{Stack: "app/compose.yml", Err: errors.New("compose failed")}}}
+	if msg := single.Error(); msg != "failed to deploy app/compose.yml: compose failed" {
+		t.Errorf("Unexpected error message %q", msg)
+	}
+
+	cause := errors.New("unhealthy")
+	several := &deployError{Failed: []stackError{{Stack: "a/compose.yml", Err: errors.New("compose failed")}, {Stack: "b/compose.yml", Err: cause}}}
+	if msg := several.Error(); msg != "failed to deploy 2 stacks: a/compose.yml: compose failed; b/compose.yml: unhealthy" {
+		t.Errorf("Unexpected error message %q", msg)
+	}
+	if !errors.Is(several, cause) {
+		t.Error("Expected the errors of the stacks to be wrapped")
+	}
+}
+
+func TestWriteSummary(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "summary.json")
+	summary := &deploySummary{Branch: "main", Commit: "c1", StartedAt: time.Now()}
+	summary.finish(ErrNoChanges, time.Now())
+
+	if err := writeSummary(path, summary); err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var written map[string]any
+	if err := json.Unmarshal(content, &written); err != nil {
+		t.Fatalf("Expected a JSON summary, but got %q", content)
+	}
+	if written["status"] != "noChanges" || written["exitCode"] != float64(ExitNoChanges) || written["error"] != nil {
+		t.Errorf("Expected a no changes summary, but got %v", written)
+	}
+	if stacks, ok := written["stacks"].([]any); !ok || len(stacks) != 0 {
+		t.Errorf("Expected an empty stacks list, but got %v", written["stacks"])
+	}
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Errorf("Expected only the summary file, but got %v", entries)
+	}
+}
@@ -0,0 +1,195 @@
+package command
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/gnugomez/voyage/docker"
+	"github.com/gnugomez/voyage/log"
+	"github.com/gnugomez/voyage/notify"
+	"github.com/gnugomez/voyage/secrets"
+	"github.com/gnugomez/voyage/state"
+)
+
+// Reverter checks out a stack's files at a given revision
+type Reverter interface {
+	Checkout(rev string, subDirs []string) error
+	Resolve(rev string) (string, error)
+	RemoteCommit() (string, error)
+}
+
+type RollbackCommandParameters struct {
+	DeployCommandParameters
+	// Stack is the compose path to roll back
+	Stack string
+	// To is the revision to roll back to, defaults to the previously deployed commit
+	To string
+	// Clear releases an existing pin instead of rolling back
+	Clear bool
+}
+
+type rollbackCommand struct {
+	params    RollbackCommandParameters
+	reverter  Reverter
+	deployer  Deployer
+	decrypter SecretDecrypter
+	notifier  Notifier
+	locker    Locker
+	store     StateStore
+}
+
+func (r *rollbackCommand) GetBaseParameters() BaseParameters {
+	return r.params.BaseParameters
+}
+
+func (r *rollbackCommand) Handle() error {
+	// Lazy initialization of dependencies. In tests, these will be pre-filled with mocks.
+	if r.reverter == nil {
+		repository, err := r.params.repository()
+		if err != nil {
+			return fmt.Errorf("failed to create git service: %w", err)
+		}
+		r.reverter = repository
+	}
+	if r.deployer == nil {
+		dockerService, err := docker.NewDockerService(r.params.DockerBackend)
+		if err != nil {
+			return fmt.Errorf("failed to create docker service: %w", err)
+		}
+		r.deployer = docker.NewDeployer(dockerService)
+	}
+	if r.decrypter == nil && r.params.SopsAgeKeyFile != "" {
+		r.decrypter = secrets.NewDecrypter(r.params.OutPath, r.params.SopsAgeKeyFile)
+	}
+	if r.notifier == nil && len(r.params.Notifications) > 0 {
+		dispatcher, err := notify.NewDispatcher(r.params.Notifications)
+		if err != nil {
+			return &configError{err: err}
+		}
+		r.notifier = dispatcher
+	}
+	if r.locker == nil {
+		r.locker = r.params.locker()
+	}
+	if r.store == nil {
+		r.store = state.NewStore(r.params.OutPath)
+	}
+
+	stack := r.params.Stack
+	if !slices.Contains(r.params.RemoteComposePaths, stack) {
+		return &configError{err: fmt.Errorf("unknown stack %s, it must be one of the compose paths %v", stack, r.params.RemoteComposePaths)}
+	}
+	if !r.params.Clear {
+		if err := r.params.checkPinnable(stack); err != nil {
+			return &configError{err: err}
+		}
+	}
+	subDirs := r.params.subDirs(stack)
+
+	if err := r.locker.Acquire(); err != nil {
+		return err
+	}
+	defer func() {
+		if err := r.locker.Release(); err != nil {
+			log.Error("Error releasing lock", "error", err)
+		}
+	}()
+
+	st, err := r.store.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load deployment state: %w", err)
+	}
+
+	if r.params.Clear {
+		return r.clearPin(st, stack, subDirs)
+	}
+
+	rev := r.params.To
+	if rev == "" {
+		rev = st.Previous(stack)
+	}
+	if rev == "" {
+		return fmt.Errorf("no previous deployment recorded for stack %s, use -to to choose a commit", stack)
+	}
+
+	commit, err := r.reverter.Resolve(rev)
+	if err != nil {
+		return fmt.Errorf("failed to resolve rollback commit %s: %w", rev, err)
+	}
+	base, err := r.reverter.RemoteCommit()
+	if err != nil {
+		return &syncError{err: fmt.Errorf("failed to resolve the remote commit of %s: %w", r.params.tracked(), err)}
+	}
+
+	log.Info("Rolling back stack", "stack", stack, "from", st.Commit(stack), "to", commit)
+	if err := r.reverter.Checkout(commit, subDirs); err != nil {
+		return &syncError{err: fmt.Errorf("failed to check out %s: %w", commit, err)}
+	}
+
+	err = decryptSecrets(log.With("stack", stack, "commit", commit), r.decrypter, r.params.DeployCommandParameters, stack)
+	if err == nil {
+		err = r.deployer.DeployCompose(r.params.composeOptions(stack), r.params.healthTimeout(stack))
+	}
+	if err != nil {
+		// A pinned stack is restored to its pin, which the state still records
+		restore := "HEAD"
+		if pin := st.Pin(stack); pin != nil {
+			restore = pin.Commit
+		}
+		log.Error("Error running docker-compose up, restoring stack files", "stack", stack, "commit", commit, "restoreTo", restore, "error", err)
+		if err := r.reverter.Checkout(restore, subDirs); err != nil {
+			log.Error("Error restoring stack files", "stack", stack, "error", err)
+		}
+		return &deployError{Failed: []stackError{{Stack: stack, Err: err}}}
+	}
+
+	previous := st.Commit(stack)
+	st.MarkRolledBack(stack, state.Pin{Commit: commit, Base: base, PinnedAt: time.Now()})
+	if err := r.store.Save(st); err != nil {
+		return fmt.Errorf("failed to save deployment state: %w", err)
+	}
+	sendNotification(r.notifier, r.params.DeployCommandParameters, notify.Event{Type: notify.RolledBack, Stack: stack, From: previous, To: commit, Reason: "manual rollback"})
+
+	log.Info("Rolled back stack, it stays pinned until new changes are pushed or the pin is cleared", "stack", stack, "commit", commit)
+	return nil
+}
+
+// clearPin releases the pin of a stack and restores its files to HEAD. The next
+// deploy picks the stack up, since HEAD differs from the pinned commit.
+func (r *rollbackCommand) clearPin(st *state.State, stack string, subDirs []string) error {
+	pin := st.Pin(stack)
+	if pin == nil {
+		log.Info("Stack is not pinned, nothing to clear", "stack", stack)
+		return nil
+	}
+
+	if err := r.reverter.Checkout("HEAD", subDirs); err != nil {
+		return &syncError{err: fmt.Errorf("failed to restore stack files: %w", err)}
+	}
+
+	st.ClearPin(stack)
+	if err := r.store.Save(st); err != nil {
+		return fmt.Errorf("failed to save deployment state: %w", err)
+	}
+
+	log.Info("Cleared pin, the next deploy will bring the stack up to date", "stack", stack, "pinnedCommit", pin.Commit)
+	return nil
+}
+
+func createRollbackCommand() (*Command, error) {
+	params, printUsage, err := rollbackCommandParametersParser(os.Args[1:])
+	if err != nil {
+		return nil, parseError(err, printUsage)
+	}
+
+	r := &rollbackCommand{
+		params: params,
+	}
+
+	return &Command{
+		Handle:            r.Handle,
+		GetBaseParameters: r.GetBaseParameters,
+	}, nil
+}
@@ -0,0 +1,257 @@
+package command
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gnugomez/voyage/docker"
+	"github.com/gnugomez/voyage/lock"
+	"github.com/gnugomez/voyage/notify"
+	"github.com/gnugomez/voyage/state"
+)
+
+type mockReverter struct {
+	CheckoutFunc     func(rev string, subDirs []string) error
+	ResolveFunc      func(rev string) (string, error)
+	RemoteCommitFunc func() (string, error)
+}
+
+func (m *mockReverter) Checkout(rev string, subDirs []string) error {
+	if m.CheckoutFunc != nil {
+		return m.CheckoutFunc(rev, subDirs)
+	}
+	return nil
+}
+
+func (m *mockReverter) Resolve(rev string) (string, error) {
+	if m.ResolveFunc != nil {
+		return m.ResolveFunc(rev)
+	}
+	return rev, nil
+}
+
+func (m *mockReverter) RemoteCommit() (string, error) {
+	if m.RemoteCommitFunc != nil {
+		return m.RemoteCommitFunc()
+	}
+	return "remote", nil
+}
+
+func TestRollbackCommand_Handle(t *testing.T) {
+	newCommand := func(store *mockStateStore, reverter *mockReverter, deployer *mockDeployer) *rollbackCommand {
+		return &rollbackCommand{
+			locker: &mockLocker{},
+			params: RollbackCommandParameters{
+				DeployCommandParameters: DeployCommandParameters{
+					Repo:               "repo",
+					Branch:             "main",
+					OutPath:            "/tmp",
+					RemoteComposePaths: []string{"app1/docker-compose.yml"},
+				},
+				Stack: "app1/docker-compose.yml",
+			},
+			reverter: reverter,
+			deployer: deployer,
+			store:    store,
+		}
+	}
+	deployedTwice := func() *mockStateStore {
+		store := &mockStateStore{state: state.New()}
+		store.state.MarkDeployed("app1/docker-compose.yml", "c1", time.Now())
+		store.state.MarkDeployed("app1/docker-compose.yml", "c2", time.Now())
+		return store
+	}
+
+	t.Run("Rolls back to the previous commit and pins it", func(t *testing.T) {
+		store := deployedTwice()
+		var checkouts []string
+		reverter := &mockReverter{CheckoutFunc: func(rev string, subDirs []string) error {
+			checkouts = append(checkouts, rev)
+			if !reflect.DeepEqual(subDirs, []string{"app1"}) {
+				t.Errorf("Expected checkout of [app1], got %v", subDirs)
+			}
+			return nil
+		}}
+		deployerCalled := false
+		deployer := &mockDeployer{DeployComposeFunc: func(options docker.ComposeOptions, healthTimeout time.Duration) error {
+			deployerCalled = true
+			return nil
+		}}
+
+		newCommand(store, reverter, deployer).Handle()
+
+		if !reflect.DeepEqual(checkouts, []string{"c1"}) {
+			t.Errorf("Expected checkout of c1, got %v", checkouts)
+		}
+		if !deployerCalled {
+			t.Error("Expected Deployer.DeployCompose to be called, but it wasn't")
+		}
+		pin := store.state.Pin("app1/docker-compose.yml")
+		if pin == nil || pin.Commit != "c1" || pin.Base != "remote" {
+			t.Errorf("Unexpected pin %+v", pin)
+		}
+		if commit := store.state.Commit("app1/docker-compose.yml"); commit != "c1" {
+			t.Errorf("Expected stack to be recorded at c1, got %q", commit)
+		}
+	})
+
+	t.Run("Sends rolled back", func(t *testing.T) {
+		notifier := &mockNotifier{}
+		command := newCommand(deployedTwice(), &mockReverter{}, &mockDeployer{})
+		command.notifier = notifier
+
+		command.Handle()
+
+		if len(notifier.events) != 1 {
+			t.Fatalf("Expected one event, but got %+v", notifier.events)
+		}
+		if event := notifier.events[0]; event.Type != notify.RolledBack || event.From != "c2" || event.To != "c1" || event.Stack != "app1/docker-compose.yml" {
+			t.Errorf("Expected app1 rolled back from c2 to c1, but got %+v", event)
+		}
+	})
+
+	t.Run("Returns a config error for an unknown stack", func(t *testing.T) {
+		command := newCommand(deployedTwice(), &mockReverter{}, &mockDeployer{})
+		command.params.Stack = "app2/docker-compose.yml"
+
+		if err := command.Handle(); ExitCode(err) != ExitConfigError {
+			t.Errorf("Expected exit code %d, but got %d (%v)", ExitConfigError, ExitCode(err), err)
+		}
+	})
+
+	t.Run("Refuses to pin a stack sharing its directory with another stack", func(t *testing.T) {
+		command := newCommand(deployedTwice(), &mockReverter{CheckoutFunc: func(rev string, subDirs []string) error {
+			t.Error("Expected no checkout of the shared directory")
+			return nil
+		}}, &mockDeployer{})
+		command.params.RemoteComposePaths = []string{"app1/docker-compose.yml", "app1/worker.yml"}
+
+		err := command.Handle()
+		if ExitCode(err) != ExitConfigError {
+			t.Errorf("Expected exit code %d, but got %d (%v)", ExitConfigError, ExitCode(err), err)
+		}
+		if err == nil || !strings.Contains(err.Error(), "app1/worker.yml") {
+			t.Errorf("Expected the error to name the other stack, but got %v", err)
+		}
+	})
+
+	t.Run("Does not touch the stack while another run holds the lock", func(t *testing.T) {
+		command := newCommand(deployedTwice(), &mockReverter{CheckoutFunc: func(rev string, subDirs []string) error {
+			t.Error("Expected no checkout while locked")
+			return nil
+		}}, &mockDeployer{})
+		command.locker = &mockLocker{AcquireFunc: func() error { return lock.ErrLocked }}
+
+		if err := command.Handle(); ExitCode(err) != ExitLocked {
+			t.Errorf("Expected exit code %d, but got %d (%v)", ExitLocked, ExitCode(err), err)
+		}
+	})
+
+	t.Run("Rolls back to an explicit commit", func(t *testing.T) {
+		store := deployedTwice()
+		var checkouts []string
+		reverter := &mockReverter{
+			CheckoutFunc: func(rev string, subDirs []string) error {
+				checkouts = append(checkouts, rev)
+				return nil
+			},
+			ResolveFunc: func(rev string) (string, error) { return rev + "-full", nil },
+		}
+
+		cmd := newCommand(store, reverter, &mockDeployer{})
+		cmd.params.To = "abc"
+		cmd.Handle()
+
+		if !reflect.DeepEqual(checkouts, []string{"abc-full"}) {
+			t.Errorf("Expected checkout of abc-full, got %v", checkouts)
+		}
+	})
+
+	t.Run("Restores files when compose up fails", func(t *testing.T) {
+		store := deployedTwice()
+		var checkouts []string
+		reverter := &mockReverter{CheckoutFunc: func(rev string, subDirs []string) error {
+			checkouts = append(checkouts, rev)
+			return nil
+		}}
+		deployer := &mockDeployer{DeployComposeFunc: func(options docker.ComposeOptions, healthTimeout time.Duration) error {
+			return errors.New("compose failed")
+		}}
+
+		err := newCommand(store, reverter, deployer).Handle()
+
+		if ExitCode(err) != ExitDeployFailed {
+			t.Errorf("Expected exit code %d, but got %d (%v)", ExitDeployFailed, ExitCode(err), err)
+		}
+		if !reflect.DeepEqual(checkouts, []string{"c1", "HEAD"}) {
+			t.Errorf("Expected checkout of c1 then HEAD, got %v", checkouts)
+		}
+		if store.state.Pin("app1/docker-compose.yml") != nil {
+			t.Error("Expected failed rollback not to pin the stack")
+		}
+	})
+
+	t.Run("Restores the files of a pinned stack to its pin when compose up fails", func(t *testing.T) {
+		store := deployedTwice()
+		store.state.MarkRolledBack("app1/docker-compose.yml", state.Pin{Commit: "c1", Base: "remote"})
+		var checkouts []string
+		reverter := &mockReverter{CheckoutFunc: func(rev string, subDirs []string) error {
+			checkouts = append(checkouts, rev)
+			return nil
+		}}
+		deployer := &mockDeployer{DeployComposeFunc: func(options docker.ComposeOptions, healthTimeout time.Duration) error {
+			return errors.New("compose failed")
+		}}
+		command := newCommand(store, reverter, deployer)
+		command.params.To = "c0"
+
+		command.Handle()
+
+		if !reflect.DeepEqual(checkouts, []string{"c0", "c1"}) {
+			t.Errorf("Expected checkout of c0 then the pin c1, got %v", checkouts)
+		}
+		if pin := store.state.Pin("app1/docker-compose.yml"); pin == nil || pin.Commit != "c1" {
+			t.Errorf("Expected the stack to stay pinned at c1, but got %+v", pin)
+		}
+	})
+
+	t.Run("Does nothing without a previous deployment", func(t *testing.T) {
+		store := &mockStateStore{state: state.New()}
+		store.state.MarkDeployed("app1/docker-compose.yml", "c1", time.Now())
+		deployerCalled := false
+		deployer := &mockDeployer{DeployComposeFunc: func(options docker.ComposeOptions, healthTimeout time.Duration) error {
+			deployerCalled = true
+			return nil
+		}}
+
+		newCommand(store, &mockReverter{}, deployer).Handle()
+
+		if deployerCalled {
+			t.Error("Expected no deploy without a previous commit")
+		}
+	})
+
+	t.Run("Clear releases the pin and restores files", func(t *testing.T) {
+		store := deployedTwice()
+		store.state.MarkRolledBack("app1/docker-compose.yml", state.Pin{Commit: "c1", Base: "c2"})
+		var checkouts []string
+		reverter := &mockReverter{CheckoutFunc: func(rev string, subDirs []string) error {
+			checkouts = append(checkouts, rev)
+			return nil
+		}}
+
+		cmd := newCommand(store, reverter, &mockDeployer{})
+		cmd.params.Clear = true
+		cmd.Handle()
+
+		if !reflect.DeepEqual(checkouts, []string{"HEAD"}) {
+			t.Errorf("Expected checkout of HEAD, got %v", checkouts)
+		}
+		if store.state.Pin("app1/docker-compose.yml") != nil {
+			t.Error("Expected pin to be cleared")
+		}
+	})
+}
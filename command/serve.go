@@ -0,0 +1,157 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/gnugomez/voyage/log"
+	"github.com/gnugomez/voyage/webhook"
+)
+
+const (
+	webhookPath     = "/webhook"
+	shutdownTimeout = 10 * time.Second
+)
+
+type ServeCommandParameters struct {
+	DeployCommandParameters `yaml:",inline"`
+	Listen                  string   `json:"listen" yaml:"listen"`
+	WebhookSecret           string   `json:"webhookSecret" yaml:"webhookSecret"`
+	Debounce                Duration `json:"debounce" yaml:"debounce"`
+}
+
+type serveCommand struct {
+	params   ServeCommandParameters
+	deploy   *deployCommand
+	triggers chan struct{}
+}
+
+func (s *serveCommand) GetBaseParameters() BaseParameters {
+	return s.params.BaseParameters
+}
+
+func (s *serveCommand) Handle() error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	listener, err := net.Listen("tcp", s.params.Listen)
+	if err != nil {
+		return fmt.Errorf("failed to start webhook listener on %s: %w", s.params.Listen, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(webhookPath, &webhook.Handler{
+		Secret: s.params.WebhookSecret,
+		Branch: s.params.Branch,
+		Tags:   s.params.Tag != "",
+		OnPush: func(*webhook.Push) { s.trigger() },
+	})
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: shutdownTimeout}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Info("Listening for push webhooks", "listen", listener.Addr().String(), "path", webhookPath, "ref", s.params.tracked())
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			serveErr <- fmt.Errorf("webhook listener stopped: %w", err)
+			stop()
+		}
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		s.runDeploys(ctx)
+		close(done)
+	}()
+
+	<-ctx.Done()
+	log.Info("Shutdown requested, stopping webhook listener")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Error("Error shutting down webhook listener", "error", err)
+	}
+
+	<-done
+
+	select {
+	case err := <-serveErr:
+		return err
+	default:
+		return nil
+	}
+}
+
+// trigger requests a deploy cycle. Requests made while one is already pending
+// are merged into it.
+func (s *serveCommand) trigger() {
+	select {
+	case s.triggers <- struct{}{}:
+	default:
+	}
+}
+
+// runDeploys runs a deploy cycle at startup, to catch up on pushes missed while
+// voyage was down, and then one cycle per burst of triggers until ctx is cancelled.
+// Cycles run one after another on the calling goroutine, so they never overlap.
+func (s *serveCommand) runDeploys(ctx context.Context) {
+	runDeployCycle(s.deploy)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.triggers:
+		}
+
+		if !s.settle(ctx) {
+			return
+		}
+		runDeployCycle(s.deploy)
+	}
+}
+
+// settle waits until no trigger has arrived for the debounce window, so a burst
+// of pushes results in a single deploy. It returns false if ctx is cancelled.
+func (s *serveCommand) settle(ctx context.Context) bool {
+	debounce := time.Duration(s.params.Debounce)
+	timer := time.NewTimer(debounce)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-s.triggers:
+			log.Debug("Merging push into pending deploy")
+			timer.Reset(debounce)
+		case <-timer.C:
+			return true
+		}
+	}
+}
+
+func createServeCommand() (*Command, error) {
+	params, printUsage, err := serveCommandParametersParser(os.Args[1:])
+	if err != nil {
+		return nil, parseError(err, printUsage)
+	}
+
+	s := &serveCommand{
+		params:   params,
+		deploy:   &deployCommand{params: params.DeployCommandParameters},
+		triggers: make(chan struct{}, 1),
+	}
+
+	return &Command{
+		Handle:            s.Handle,
+		GetBaseParameters: s.GetBaseParameters,
+	}, nil
+}
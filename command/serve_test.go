@@ -0,0 +1,71 @@
+package command
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gnugomez/voyage/git"
+)
+
+func TestServeCommand_RunDeploys(t *testing.T) {
+	t.Run("Merges a burst of pushes into a single deploy", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		var cycles atomic.Int32
+		cycleDone := make(chan struct{}, 10)
+		syncer := &mockSyncer{
+			SyncFunc: func(targets []git.Target) (*git.SyncResult, error) {
+				cycles.Add(1)
+				cycleDone <- struct{}{}
+				return &git.SyncResult{}, nil
+			},
+		}
+
+		params := ServeCommandParameters{
+			DeployCommandParameters: DeployCommandParameters{
+				Repo:               "repo",
+				Branch:             "main",
+				OutPath:            "/tmp",
+				RemoteComposePaths: []string{"app1/docker-compose.yml"},
+			},
+			Debounce: Duration(20 * time.Millisecond),
+		}
+		s := &serveCommand{
+			params:   params,
+			deploy:   &deployCommand{params: params.DeployCommandParameters, locker: &mockLocker{}, syncer: syncer, deployer: &mockDeployer{}, store: &mockStateStore{}},
+			triggers: make(chan struct{}, 1),
+		}
+
+		done := make(chan struct{})
+		go func() {
+			s.runDeploys(ctx)
+			close(done)
+		}()
+
+		// Startup cycle
+		<-cycleDone
+
+		for range 5 {
+			s.trigger()
+			time.Sleep(2 * time.Millisecond)
+		}
+
+		select {
+		case <-cycleDone:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected a deploy cycle after the pushes, but none ran")
+		}
+
+		// Give a wrongly scheduled extra cycle the chance to run
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+		<-done
+
+		if got := cycles.Load(); got != 2 {
+			t.Errorf("Expected 2 cycles (startup and one merged push), got %d", got)
+		}
+	})
+}
@@ -0,0 +1,246 @@
+package command
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gnugomez/voyage/docker"
+	"github.com/gnugomez/voyage/log"
+	"github.com/gnugomez/voyage/state"
+)
+
+const (
+	statusFormatTable = "table"
+	statusFormatJSON  = "json"
+)
+
+// RepositoryInspector reports how far deployed commits are behind the remote branch
+type RepositoryInspector interface {
+	Fetch() error
+	CommitsBehind(commit string) (int, error)
+}
+
+// ContainerLister lists the containers of a compose project
+type ContainerLister interface {
+	ComposePs(options docker.ComposeOptions) ([]docker.Container, error)
+}
+
+type StatusCommandParameters struct {
+	DeployCommandParameters
+	Format string
+}
+
+// stackStatus is the status of a single stack as printed by the status command
+type stackStatus struct {
+	Stack      string             `json:"stack"`
+	Commit     string             `json:"commit,omitempty"`
+	DeployedAt *time.Time         `json:"deployedAt,omitempty"`
+	Behind     *int               `json:"behind,omitempty"`
+	Pin        *state.Pin         `json:"pin,omitempty"`
+	Failure    *state.Failure     `json:"failure,omitempty"`
+	Containers []docker.Container `json:"containers"`
+	Error      string             `json:"error,omitempty"`
+}
+
+type statusCommand struct {
+	params     StatusCommandParameters
+	repository RepositoryInspector
+	containers ContainerLister
+	store      StateStore
+	locker     Locker
+	out        io.Writer
+}
+
+func (s *statusCommand) GetBaseParameters() BaseParameters {
+	return s.params.BaseParameters
+}
+
+func (s *statusCommand) Handle() error {
+	// Lazy initialization of dependencies. In tests, these will be pre-filled with mocks.
+	if s.repository == nil {
+		repository, err := s.params.repository()
+		if err != nil {
+			return fmt.Errorf("failed to create git service: %w", err)
+		}
+		s.repository = repository
+	}
+	if s.containers == nil {
+		dockerService, err := docker.NewDockerService(s.params.DockerBackend)
+		if err != nil {
+			return fmt.Errorf("failed to create docker service: %w", err)
+		}
+		s.containers = dockerService
+	}
+	if s.store == nil {
+		s.store = state.NewStore(s.params.OutPath)
+	}
+	if s.locker == nil {
+		s.locker = s.params.locker()
+	}
+	if s.out == nil {
+		s.out = os.Stdout
+	}
+
+	st, err := s.store.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load deployment state: %w", err)
+	}
+
+	// The fetch must not race the pull of a deploy, which holds the lock
+	fetched := false
+	if err := s.locker.Acquire(); err != nil {
+		log.Warn("Error locking repository, commits behind are unknown", "error", err)
+	} else {
+		if err := s.repository.Fetch(); err != nil {
+			log.Warn("Error fetching repository, commits behind are unknown", "error", err)
+		} else {
+			fetched = true
+		}
+		if err := s.locker.Release(); err != nil {
+			log.Error("Error releasing lock", "error", err)
+		}
+	}
+
+	statuses := make([]stackStatus, 0, len(s.params.RemoteComposePaths))
+	for _, composePath := range s.params.RemoteComposePaths {
+		statuses = append(statuses, s.stackStatus(st, composePath, fetched))
+	}
+
+	if s.params.Format == statusFormatJSON {
+		encoder := json.NewEncoder(s.out)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(statuses); err != nil {
+			return fmt.Errorf("failed to write status: %w", err)
+		}
+		return nil
+	}
+
+	if err := writeStatusTable(s.out, statuses); err != nil {
+		return fmt.Errorf("failed to write status: %w", err)
+	}
+	return nil
+}
+
+func (s *statusCommand) stackStatus(st *state.State, composePath string, fetched bool) stackStatus {
+	status := stackStatus{Stack: composePath, Containers: []docker.Container{}}
+
+	if stack, ok := st.Stacks[composePath]; ok {
+		status.Commit = stack.Commit
+		status.Pin = stack.Pin
+		status.Failure = stack.Failure
+		if !stack.DeployedAt.IsZero() {
+			status.DeployedAt = &stack.DeployedAt
+		}
+	}
+
+	var errs []error
+	if status.Commit != "" && fetched {
+		behind, err := s.repository.CommitsBehind(status.Commit)
+		if err != nil {
+			errs = append(errs, err)
+		} else {
+			status.Behind = &behind
+		}
+	}
+
+	containers, err := s.containers.ComposePs(s.params.composeOptions(composePath))
+	if err != nil {
+		errs = append(errs, err)
+	} else {
+		status.Containers = containers
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		log.Debug("Error collecting stack status", "stack", composePath, "error", err)
+		status.Error = err.Error()
+	}
+	return status
+}
+
+func writeStatusTable(out io.Writer, statuses []stackStatus) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STACK\tCOMMIT\tBEHIND\tDEPLOYED\tSTATE\tCONTAINERS")
+
+	for _, status := range statuses {
+		commit, behind, deployed := "-", "-", "-"
+		if status.Commit != "" {
+			commit = shortCommit(status.Commit)
+		}
+		if status.Behind != nil {
+			behind = strconv.Itoa(*status.Behind)
+		}
+		if status.DeployedAt != nil {
+			deployed = status.DeployedAt.Local().Format(time.DateTime)
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", status.Stack, commit, behind, deployed, stackState(status), containerSummary(status))
+	}
+
+	return w.Flush()
+}
+
+// stackState summarizes the deployment state of a stack in a word
+func stackState(status stackStatus) string {
+	switch {
+	case status.Commit == "" && status.Failure == nil:
+		return "never deployed"
+	case status.Pin != nil && status.Failure != nil:
+		return "rolled back"
+	case status.Pin != nil:
+		return "pinned"
+	case status.Failure != nil:
+		return fmt.Sprintf("failed (%d attempts)", status.Failure.Attempts)
+	default:
+		return "deployed"
+	}
+}
+
+// containerSummary lists every container as service=state, with its health if it has one
+func containerSummary(status stackStatus) string {
+	if status.Error != "" && len(status.Containers) == 0 {
+		return "error: " + status.Error
+	}
+	if len(status.Containers) == 0 {
+		return "-"
+	}
+
+	parts := make([]string, 0, len(status.Containers))
+	for _, container := range status.Containers {
+		part := container.Service + "=" + container.State
+		if container.Health != "" {
+			part += "/" + container.Health
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func shortCommit(commit string) string {
+	if len(commit) > 7 {
+		return commit[:7]
+	}
+	return commit
+}
+
+func createStatusCommand() (*Command, error) {
+	params, printUsage, err := statusCommandParametersParser(os.Args[1:])
+	if err != nil {
+		return nil, parseError(err, printUsage)
+	}
+
+	s := &statusCommand{
+		params: params,
+	}
+
+	return &Command{
+		Handle:            s.Handle,
+		GetBaseParameters: s.GetBaseParameters,
+	}, nil
+}
@@ -0,0 +1,156 @@
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gnugomez/voyage/docker"
+	"github.com/gnugomez/voyage/lock"
+	"github.com/gnugomez/voyage/state"
+)
+
+type mockRepositoryInspector struct {
+	FetchFunc         func() error
+	CommitsBehindFunc func(commit string) (int, error)
+}
+
+func (m *mockRepositoryInspector) Fetch() error {
+	if m.FetchFunc != nil {
+		return m.FetchFunc()
+	}
+	return nil
+}
+
+func (m *mockRepositoryInspector) CommitsBehind(commit string) (int, error) {
+	if m.CommitsBehindFunc != nil {
+		return m.CommitsBehindFunc(commit)
+	}
+	return 0, nil
+}
+
+type mockContainerLister struct {
+	ComposePsFunc func(options docker.ComposeOptions) ([]docker.Container, error)
+}
+
+func (m *mockContainerLister) ComposePs(options docker.ComposeOptions) ([]docker.Container, error) {
+	if m.ComposePsFunc != nil {
+		return m.ComposePsFunc(options)
+	}
+	return nil, nil
+}
+
+func TestStatusCommand_Handle(t *testing.T) {
+	newCommand := func(format string, out *bytes.Buffer, repository *mockRepositoryInspector) *statusCommand {
+		store := &mockStateStore{state: state.New()}
+		store.state.MarkDeployed("app1/docker-compose.yml", "0123456789abcdef", time.Now())
+
+		return &statusCommand{
+			params: StatusCommandParameters{
+				DeployCommandParameters: DeployCommandParameters{
+					Repo:               "repo",
+					Branch:             "main",
+					OutPath:            "/tmp",
+					RemoteComposePaths: []string{"app1/docker-compose.yml", "app2/docker-compose.yml"},
+				},
+				Format: format,
+			},
+			repository: repository,
+			containers: &mockContainerLister{ComposePsFunc: func(options docker.ComposeOptions) ([]docker.Container, error) {
+				if options.Files[0] == "/tmp/app1/docker-compose.yml" {
+					return []docker.Container{{Service: "web", State: "running", Health: "healthy"}}, nil
+				}
+				return []docker.Container{}, nil
+			}},
+			store:  store,
+			locker: &mockLocker{},
+			out:    out,
+		}
+	}
+
+	t.Run("Prints a table", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		repository := &mockRepositoryInspector{CommitsBehindFunc: func(commit string) (int, error) { return 3, nil }}
+
+		newCommand(statusFormatTable, out, repository).Handle()
+
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		if len(lines) != 3 {
+			t.Fatalf("Expected a header and 2 rows, got:\n%s", out.String())
+		}
+		for _, expected := range []string{"app1/docker-compose.yml", "0123456", "3", "deployed", "web=running/healthy"} {
+			if !strings.Contains(lines[1], expected) {
+				t.Errorf("Expected row %q to contain %q", lines[1], expected)
+			}
+		}
+		if !strings.Contains(lines[2], "never deployed") {
+			t.Errorf("Expected row %q to show a never deployed stack", lines[2])
+		}
+	})
+
+	t.Run("Prints JSON", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		repository := &mockRepositoryInspector{CommitsBehindFunc: func(commit string) (int, error) { return 3, nil }}
+
+		newCommand(statusFormatJSON, out, repository).Handle()
+
+		var statuses []stackStatus
+		if err := json.Unmarshal(out.Bytes(), &statuses); err != nil {
+			t.Fatalf("Expected valid JSON, got %v:\n%s", err, out.String())
+		}
+		if len(statuses) != 2 {
+			t.Fatalf("Expected 2 stacks, got %d", len(statuses))
+		}
+		if statuses[0].Commit != "0123456789abcdef" || statuses[0].Behind == nil || *statuses[0].Behind != 3 {
+			t.Errorf("Unexpected status %+v", statuses[0])
+		}
+		if len(statuses[0].Containers) != 1 || statuses[0].Containers[0].Service != "web" {
+			t.Errorf("Unexpected containers %+v", statuses[0].Containers)
+		}
+		if statuses[1].Commit != "" || statuses[1].Behind != nil {
+			t.Errorf("Expected never deployed stack to have no commit, got %+v", statuses[1])
+		}
+	})
+
+	t.Run("Still reports when fetch fails", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		behindCalled := false
+		repository := &mockRepositoryInspector{
+			FetchFunc: func() error { return errors.New("offline") },
+			CommitsBehindFunc: func(commit string) (int, error) {
+				behindCalled = true
+				return 0, nil
+			},
+		}
+
+		newCommand(statusFormatJSON, out, repository).Handle()
+
+		var statuses []stackStatus
+		if err := json.Unmarshal(out.Bytes(), &statuses); err != nil {
+			t.Fatalf("Expected valid JSON, got %v", err)
+		}
+		if behindCalled || statuses[0].Behind != nil {
+			t.Error("Expected commits behind to be unknown when fetch fails")
+		}
+	})
+	t.Run("Does not fetch while a deploy holds the lock", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		repository := &mockRepositoryInspector{FetchFunc: func() error {
+			t.Error("Expected no fetch while the lock is held")
+			return nil
+		}}
+		command := newCommand(statusFormatJSON, out, repository)
+		command.locker = &mockLocker{AcquireFunc: func() error { return lock.ErrLocked }}
+
+		if err := command.Handle(); err != nil {
+			t.Fatalf("Expected the status to be reported, but got %v", err)
+		}
+		var statuses []stackStatus
+		if err := json.Unmarshal(out.Bytes(), &statuses); err != nil || statuses[0].Behind != nil {
+			t.Errorf("Expected commits behind to be unknown, but got %+v, %v", statuses, err)
+		}
+	})
+}
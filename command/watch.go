@@ -0,0 +1,93 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/gnugomez/voyage/log"
+)
+
+type WatchCommandParameters struct {
+	DeployCommandParameters `yaml:",inline"`
+	Interval                Duration `json:"interval" yaml:"interval"`
+	Jitter                  Duration `json:"jitter" yaml:"jitter"`
+}
+
+type watchCommand struct {
+	params WatchCommandParameters
+	deploy *deployCommand
+}
+
+func (w *watchCommand) GetBaseParameters() BaseParameters {
+	return w.params.BaseParameters
+}
+
+func (w *watchCommand) Handle() error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	w.run(ctx)
+	return nil
+}
+
+// run executes deploy cycles until ctx is cancelled. Cycles run one after another
+// on the calling goroutine, so two cycles never overlap and a shutdown signal
+// received mid-cycle only takes effect once that cycle has finished.
+func (w *watchCommand) run(ctx context.Context) {
+	log.Info("Watching repository", "repo", w.params.Repo, "ref", w.params.tracked(), "interval", w.params.Interval, "jitter", w.params.Jitter)
+
+	for {
+		runDeployCycle(w.deploy)
+
+		delay := w.nextDelay()
+		log.Debug("Waiting for next deploy cycle", "delay", delay)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			log.Info("Shutdown requested, stopping watch")
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// nextDelay returns the configured interval plus a random jitter in [0, Jitter)
+func (w *watchCommand) nextDelay() time.Duration {
+	delay := time.Duration(w.params.Interval)
+	if jitter := time.Duration(w.params.Jitter); jitter > 0 {
+		delay += rand.N(jitter)
+	}
+	return delay
+}
+
+func createWatchCommand() (*Command, error) {
+	params, printUsage, err := watchCommandParametersParser(os.Args[1:])
+	if err != nil {
+		return nil, parseError(err, printUsage)
+	}
+
+	w := &watchCommand{
+		params: params,
+		deploy: &deployCommand{params: params.DeployCommandParameters},
+	}
+
+	return &Command{
+		Handle:            w.Handle,
+		GetBaseParameters: w.GetBaseParameters,
+	}, nil
+}
+
+// runDeployCycle runs a deploy cycle of a long running command. A failed cycle
+// is logged, the next one may succeed.
+func runDeployCycle(deploy *deployCommand) {
+	if err := deploy.Handle(); err != nil && !errors.Is(err, ErrNoChanges) {
+		log.Error("Deploy cycle failed", "error", err)
+	}
+}
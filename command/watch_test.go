@@ -0,0 +1,80 @@
+package command
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gnugomez/voyage/git"
+)
+
+func TestWatchCommand_Run(t *testing.T) {
+	t.Run("Runs cycles sequentially until cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		var cycles, running, overlaps atomic.Int32
+		syncer := &mockSyncer{
+			SyncFunc: func(targets []git.Target) (*git.SyncResult, error) {
+				if running.Add(1) > 1 {
+					overlaps.Add(1)
+				}
+				defer running.Add(-1)
+
+				time.Sleep(2 * time.Millisecond)
+				if cycles.Add(1) == 3 {
+					cancel()
+				}
+				return &git.SyncResult{}, nil
+			},
+		}
+
+		params := WatchCommandParameters{
+			DeployCommandParameters: DeployCommandParameters{
+				Repo:               "repo",
+				Branch:             "main",
+				OutPath:            "/tmp",
+				RemoteComposePaths: []string{"app1/docker-compose.yml"},
+			},
+			Interval: Duration(time.Millisecond),
+		}
+		w := &watchCommand{
+			params: params,
+			deploy: &deployCommand{params: params.DeployCommandParameters, locker: &mockLocker{}, syncer: syncer, deployer: &mockDeployer{}, store: &mockStateStore{}},
+		}
+
+		done := make(chan struct{})
+		go func() {
+			w.run(ctx)
+			close(done)
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("watch did not stop after the context was cancelled")
+		}
+
+		if got := cycles.Load(); got != 3 {
+			t.Errorf("Expected 3 cycles, got %d", got)
+		}
+		if overlaps.Load() != 0 {
+			t.Error("Expected cycles to never overlap")
+		}
+	})
+
+	t.Run("Delay stays within interval plus jitter", func(t *testing.T) {
+		w := &watchCommand{params: WatchCommandParameters{
+			Interval: Duration(time.Minute),
+			Jitter:   Duration(10 * time.Second),
+		}}
+
+		for range 100 {
+			delay := w.nextDelay()
+			if delay < time.Minute || delay >= time.Minute+10*time.Second {
+				t.Fatalf("Delay %s is outside [1m, 1m10s)", delay)
+			}
+		}
+	})
+}
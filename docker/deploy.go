@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"time"
 )
 
 // Deployer handles the logic for deploying a docker-compose application.
@@ -12,32 +13,85 @@ type Deployer struct {
 	fileExists    func(path string) bool
 	stdout        io.Writer
 	stderr        io.Writer
+	// now, after and pollInterval drive the health polling, tests replace them
+	now          func() time.Time
+	after        func(time.Duration) <-chan time.Time
+	pollInterval time.Duration
 }
 
-// NewDeployer creates a new Deployer with default dependencies.
-func NewDeployer() *Deployer {
+// NewDeployer creates a new Deployer that runs compose through dockerService.
+func NewDeployer(dockerService DockerService) *Deployer {
 	return &Deployer{
-		dockerService: NewCliDockerService(),
+		dockerService: dockerService,
 		fileExists:    osFileExists,
 		stdout:        os.Stdout,
 		stderr:        os.Stderr,
+		now:           time.Now,
+		after:         time.After,
+		pollInterval:  defaultHealthPollInterval,
 	}
 }
 
-// DeployCompose checks the environment and runs 'docker compose up'.
-func (d *Deployer) DeployCompose(targetPath string, daemonMode bool) error {
+// DeployCompose checks the environment and runs 'docker compose up' for the project of
+// options. With a positive healthTimeout, a detached deploy only succeeds once every
+// container is running and healthy.
+func (d *Deployer) DeployCompose(options ComposeOptions, healthTimeout time.Duration) error {
 	// Check docker availability
 	if err := d.isDockerAvailable(); err != nil {
 		return err
 	}
 
-	// Check target file
-	if !d.fileExists(targetPath) {
-		return fmt.Errorf("target path does not exist: %s", targetPath)
+	// Check target files
+	if len(options.Files) == 0 {
+		return fmt.Errorf("no compose files to deploy")
+	}
+	for _, path := range options.Files {
+		if !d.fileExists(path) {
+			return fmt.Errorf("target path does not exist: %s", path)
+		}
+	}
+
+	stdout, stderr := d.stdout, d.stderr
+	if options.Stdout != nil {
+		stdout = options.Stdout
+	}
+	if options.Stderr != nil {
+		stderr = options.Stderr
 	}
 
-	// Run compose
-	return d.dockerService.ComposeUp(targetPath, daemonMode, d.stdout, d.stderr)
+	options = d.WaitOptions(options, healthTimeout)
+	if healthTimeout <= 0 || !options.Detach {
+		return d.dockerService.ComposeUp(options, stdout, stderr)
+	}
+
+	// Let compose wait for the containers when it can, otherwise poll them ourselves
+	if options.WaitTimeout > 0 {
+		output := &healthErrorWriter{w: stderr}
+		if err := d.dockerService.ComposeUp(options, stdout, output); err != nil {
+			// Compose also fails before waiting, on pulls, invalid files or port conflicts
+			if line := output.healthError(); line != "" {
+				return fmt.Errorf("stack did not become healthy within %s: %s: %w", healthTimeout, line, err)
+			}
+			return err
+		}
+		return nil
+	}
+
+	if err := d.dockerService.ComposeUp(options, stdout, stderr); err != nil {
+		return err
+	}
+	return d.waitHealthy(options, healthTimeout)
+}
+
+// WaitOptions returns options as DeployCompose runs compose with them. With a
+// positive healthTimeout, compose waits for the containers of a detached deploy
+// when it supports it, otherwise DeployCompose polls them once compose returns.
+func (d *Deployer) WaitOptions(options ComposeOptions, healthTimeout time.Duration) ComposeOptions {
+	options.WaitTimeout = 0
+	if healthTimeout > 0 && options.Detach && d.supportsComposeWait() {
+		options.WaitTimeout = healthTimeout
+	}
+	return options
 }
 
 func (d *Deployer) isDockerAvailable() error {
@@ -4,14 +4,21 @@ import (
 	"bytes"
 	"errors"
 	"io"
+	"strings"
 	"testing"
+	"time"
 )
 
 // mockDockerService is a mock implementation of the DockerService interface for testing.
 type mockDockerService struct {
 	IsDaemonRunningFunc    func() (bool, error)
 	IsComposeInstalledFunc func() (bool, error)
-	ComposeUpFunc          func(composeFilePath string, daemonMode bool, stdout, stderr io.Writer) error
+	ComposeVersionFunc     func() (string, error)
+	ComposeUpFunc          func(options ComposeOptions, stdout, stderr io.Writer) error
+	ComposePsFunc          func(options ComposeOptions) ([]Container, error)
+	RestartCountFunc       func(containerID string) (int, error)
+	ComposeImagesFunc      func(options ComposeOptions) (map[string]string, error)
+	ImageDigestsFunc       func(containerID string) ([]string, error)
 }
 
 func (m *mockDockerService) IsDaemonRunning() (bool, error) {
@@ -28,13 +35,48 @@ func (m *mockDockerService) IsComposeInstalled() (bool, error) {
 	return false, nil
 }
 
-func (m *mockDockerService) ComposeUp(composeFilePath string, daemonMode bool, stdout, stderr io.Writer) error {
+func (m *mockDockerService) ComposeVersion() (string, error) {
+	if m.ComposeVersionFunc != nil {
+		return m.ComposeVersionFunc()
+	}
+	return "", errors.New("unknown version")
+}
+
+func (m *mockDockerService) ComposeUp(options ComposeOptions, stdout, stderr io.Writer) error {
 	if m.ComposeUpFunc != nil {
-		return m.ComposeUpFunc(composeFilePath, daemonMode, stdout, stderr)
+		return m.ComposeUpFunc(options, stdout, stderr)
 	}
 	return nil
 }
 
+func (m *mockDockerService) ComposePs(options ComposeOptions) ([]Container, error) {
+	if m.ComposePsFunc != nil {
+		return m.ComposePsFunc(options)
+	}
+	return nil, nil
+}
+
+func (m *mockDockerService) RestartCount(containerID string) (int, error) {
+	if m.RestartCountFunc != nil {
+		return m.RestartCountFunc(containerID)
+	}
+	return 0, nil
+}
+
+func (m *mockDockerService) ComposeImages(options ComposeOptions) (map[string]string, error) {
+	if m.ComposeImagesFunc != nil {
+		return m.ComposeImagesFunc(options)
+	}
+	return map[string]string{}, nil
+}
+
+func (m *mockDockerService) ImageDigests(containerID string) ([]string, error) {
+	if m.ImageDigestsFunc != nil {
+		return m.ImageDigestsFunc(containerID)
+	}
+	return nil, nil
+}
+
 func TestDeployer_DeployCompose(t *testing.T) {
 	t.Run("Success case", func(t *testing.T) {
 		mock := &mockDockerService{}
@@ -49,12 +91,12 @@ func TestDeployer_DeployCompose(t *testing.T) {
 		mock.IsComposeInstalledFunc = func() (bool, error) { return true, nil }
 
 		composeUpCalled := false
-		mock.ComposeUpFunc = func(path string, daemon bool, stdout, stderr io.Writer) error {
+		mock.ComposeUpFunc = func(options ComposeOptions, stdout, stderr io.Writer) error {
 			composeUpCalled = true
 			return nil
 		}
 
-		err := d.DeployCompose("docker-compose.yml", false)
+		err := d.DeployCompose(ComposeOptions{Files: []string{"docker-compose.yml"}}, 0)
 		if err != nil {
 			t.Fatalf("Expected no error, but got %v", err)
 		}
@@ -70,7 +112,7 @@ func TestDeployer_DeployCompose(t *testing.T) {
 
 		mock.IsDaemonRunningFunc = func() (bool, error) { return false, errors.New("daemon error") }
 
-		if err := d.DeployCompose("path", false); err == nil {
+		if err := d.DeployCompose(ComposeOptions{Files: []string{"path"}}, 0); err == nil {
 			t.Fatal("Expected an error, but got nil")
 		}
 	})
@@ -85,7 +127,7 @@ func TestDeployer_DeployCompose(t *testing.T) {
 		mock.IsDaemonRunningFunc = func() (bool, error) { return true, nil }
 		mock.IsComposeInstalledFunc = func() (bool, error) { return true, nil }
 
-		if err := d.DeployCompose("path", false); err == nil {
+		if err := d.DeployCompose(ComposeOptions{Files: []string{"path"}}, 0); err == nil {
 			t.Fatal("Expected an error, but got nil")
 		}
 	})
@@ -101,12 +143,160 @@ func TestDeployer_DeployCompose(t *testing.T) {
 
 		mock.IsDaemonRunningFunc = func() (bool, error) { return true, nil }
 		mock.IsComposeInstalledFunc = func() (bool, error) { return true, nil }
-		mock.ComposeUpFunc = func(path string, daemon bool, stdout, stderr io.Writer) error {
+		mock.ComposeUpFunc = func(options ComposeOptions, stdout, stderr io.Writer) error {
 			return errors.New("compose failed")
 		}
 
-		if err := d.DeployCompose("path", false); err == nil {
+		if err := d.DeployCompose(ComposeOptions{Files: []string{"path"}}, 0); err == nil {
 			t.Fatal("Expected an error, but got nil")
 		}
 	})
+
+	t.Run("Writes compose output to the writers of the options", func(t *testing.T) {
+		var deployerOutput, stackOutput bytes.Buffer
+		mock := &mockDockerService{
+			IsDaemonRunningFunc:    func() (bool, error) { return true, nil },
+			IsComposeInstalledFunc: func() (bool, error) { return true, nil },
+			ComposeUpFunc: func(options ComposeOptions, stdout, stderr io.Writer) error {
+				io.WriteString(stdout, "web-1 Started\n")
+				io.WriteString(stderr, "Pulling web\n")
+				return nil
+			},
+		}
+		d := &Deployer{
+			dockerService: mock,
+			fileExists:    func(path string) bool { return true },
+			stdout:        &deployerOutput,
+			stderr:        &deployerOutput,
+		}
+
+		err := d.DeployCompose(ComposeOptions{Files: []string{"path"}, Stdout: &stackOutput, Stderr: &stackOutput}, 0)
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if stackOutput.String() != "web-1 Started\nPulling web\n" || deployerOutput.Len() != 0 {
+			t.Errorf("Expected the output in the options writers only, but got %q and %q", stackOutput.String(), deployerOutput.String())
+		}
+	})
+
+	t.Run("Health timeout uses compose wait when supported", func(t *testing.T) {
+		mock := &mockDockerService{
+			IsDaemonRunningFunc:    func() (bool, error) { return true, nil },
+			IsComposeInstalledFunc: func() (bool, error) { return true, nil },
+			ComposeVersionFunc:     func() (string, error) { return "2.29.1", nil },
+			ComposePsFunc: func(options ComposeOptions) ([]Container, error) {
+				t.Error("Expected containers not to be polled when compose waits for them")
+				return nil, nil
+			},
+		}
+		var gotWait time.Duration
+		mock.ComposeUpFunc = func(options ComposeOptions, stdout, stderr io.Writer) error {
+			gotWait = options.WaitTimeout
+			return nil
+		}
+		d := &Deployer{dockerService: mock, fileExists: func(path string) bool { return true }}
+
+		if err := d.DeployCompose(ComposeOptions{Files: []string{"path"}, Detach: true}, time.Minute); err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if gotWait != time.Minute {
+			t.Errorf("Expected compose to wait %s, but got %s", time.Minute, gotWait)
+		}
+	})
+
+	t.Run("Health timeout reports the containers compose waited for", func(t *testing.T) {
+		var output bytes.Buffer
+		mock := &mockDockerService{
+			IsDaemonRunningFunc:    func() (bool, error) { return true, nil },
+			IsComposeInstalledFunc: func() (bool, error) { return true, nil },
+			ComposeVersionFunc:     func() (string, error) { return "2.29.1", nil },
+			ComposeUpFunc: func(options ComposeOptions, stdout, stderr io.Writer) error {
+				io.WriteString(stderr, " Container app-web-1  Started\n")
+				io.WriteString(stderr, "container app-web-1 is unhealthy")
+				return errors.New("failed to run docker compose: exit status 1")
+			},
+		}
+		d := &Deployer{dockerService: mock, fileExists: func(path string) bool { return true }, stderr: &output}
+
+		err := d.DeployCompose(ComposeOptions{Files: []string{"path"}, Detach: true}, time.Minute)
+		expected := "stack did not become healthy within 1m0s: container app-web-1 is unhealthy: failed to run docker compose: exit status 1"
+		if err == nil || err.Error() != expected {
+			t.Errorf("Expected error %q, but got %v", expected, err)
+		}
+		if !strings.Contains(output.String(), "Container app-web-1  Started") {
+			t.Errorf("Expected the compose output to be passed through, but got %q", output.String())
+		}
+	})
+
+	t.Run("Health timeout keeps the cause of compose failing before it waits", func(t *testing.T) {
+		mock := &mockDockerService{
+			IsDaemonRunningFunc:    func() (bool, error) { return true, nil },
+			IsComposeInstalledFunc: func() (bool, error) { return true, nil },
+			ComposeVersionFunc:     func() (string, error) { return "2.29.1", nil },
+			ComposeUpFunc: func(options ComposeOptions, stdout, stderr io.Writer) error {
+				io.WriteString(stderr, "Error response from daemon: Bind for 0.0.0.0:80 failed: port is already allocated\n")
+				return errors.New("failed to run docker compose: exit status 1")
+			},
+		}
+		d := &Deployer{dockerService: mock, fileExists: func(path string) bool { return true }}
+
+		err := d.DeployCompose(ComposeOptions{Files: []string{"path"}, Detach: true}, time.Minute)
+		if err == nil || err.Error() != "failed to run docker compose: exit status 1" {
+			t.Errorf("Expected the compose error, but got %v", err)
+		}
+	})
+
+	t.Run("Health timeout polls containers on older compose versions", func(t *testing.T) {
+		polled := false
+		mock := &mockDockerService{
+			IsDaemonRunningFunc:    func() (bool, error) { return true, nil },
+			IsComposeInstalledFunc: func() (bool, error) { return true, nil },
+			ComposeVersionFunc:     func() (string, error) { return "2.12.0", nil },
+			ComposeUpFunc: func(options ComposeOptions, stdout, stderr io.Writer) error {
+				if options.WaitTimeout != 0 {
+					t.Errorf("Expected compose not to wait, but got wait timeout %s", options.WaitTimeout)
+				}
+				return nil
+			},
+			ComposePsFunc: func(options ComposeOptions) ([]Container, error) {
+				polled = true
+				return []Container{{ID: "a", Name: "app-web-1", State: "exited", ExitCode: 1}}, nil
+			},
+		}
+		d := newHealthDeployer(mock)
+		d.fileExists = func(path string) bool { return true }
+
+		if err := d.DeployCompose(ComposeOptions{Files: []string{"path"}, Detach: true}, time.Minute); err == nil {
+			t.Fatal("Expected an error for a failed container, but got nil")
+		}
+		if !polled {
+			t.Error("Expected containers to be polled, but they weren't")
+		}
+	})
+}
+
+func TestDeployer_WaitOptions(t *testing.T) {
+	testCases := []struct {
+		name          string
+		version       string
+		detach        bool
+		healthTimeout time.Duration
+		expected      time.Duration
+	}{
+		{name: "Compose waits when it supports it", version: "2.29.1", detach: true, healthTimeout: time.Minute, expected: time.Minute},
+		{name: "Older compose versions do not wait", version: "2.12.0", detach: true, healthTimeout: time.Minute},
+		{name: "Attached deploys do not wait", version: "2.29.1", healthTimeout: time.Minute},
+		{name: "No health timeout", version: "2.29.1", detach: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := NewDeployer(&mockDockerService{ComposeVersionFunc: func() (string, error) { return tc.version, nil }})
+
+			options := d.WaitOptions(ComposeOptions{Files: []string{"path"}, Detach: tc.detach, WaitTimeout: time.Hour}, tc.healthTimeout)
+			if options.WaitTimeout != tc.expected {
+				t.Errorf("Expected wait timeout %s, but got %s", tc.expected, options.WaitTimeout)
+			}
+		})
+	}
 }
@@ -0,0 +1,374 @@
+package docker
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gnugomez/voyage/log"
+)
+
+const (
+	defaultDockerHost = "unix:///var/run/docker.sock"
+
+	// Labels compose sets on the containers it creates
+	composeProjectLabel     = "com.docker.compose.project"
+	composeServiceLabel     = "com.docker.compose.service"
+	composeConfigFilesLabel = "com.docker.compose.project.config_files"
+)
+
+// EngineError is an error response of the Docker Engine API.
+type EngineError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *EngineError) Error() string {
+	return fmt.Sprintf("docker engine returned %d: %s", e.StatusCode, e.Message)
+}
+
+// Event is a container event reported by the Docker Engine, such as start, die or
+// health_status.
+type Event struct {
+	ContainerID string
+	Action      string
+	Attributes  map[string]string
+	Time        time.Time
+}
+
+// engineDockerService is the implementation of DockerService that talks to the
+// Docker Engine API directly. Compose operations still run on the docker command line.
+type engineDockerService struct {
+	*cliDockerService
+	client  *http.Client
+	baseURL string
+	// logVersion logs the engine version the first time the daemon answers
+	logVersion sync.Once
+}
+
+// NewEngineDockerService creates a DockerService for the engine at host, a
+// DOCKER_HOST style unix:// or tcp:// address. An empty host uses the default socket.
+// Like the docker command, a tcp:// host uses TLS when DOCKER_TLS_VERIFY is set, with
+// the ca.pem, cert.pem and key.pem files of DOCKER_CERT_PATH or ~/.docker.
+func NewEngineDockerService(host string) (DockerService, error) {
+	if host == "" {
+		host = defaultDockerHost
+	}
+
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid docker host %s: %w", host, err)
+	}
+
+	s := &engineDockerService{cliDockerService: &cliDockerService{}}
+	switch u.Scheme {
+	case "unix":
+		socket := u.Path
+		s.baseURL = "http://docker"
+		s.client = &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", socket)
+			},
+		}}
+	case "tcp":
+		if os.Getenv("DOCKER_TLS_VERIFY") == "" {
+			s.baseURL = "http://" + u.Host
+			s.client = &http.Client{}
+			break
+		}
+		config, err := tlsConfig(os.Getenv("DOCKER_CERT_PATH"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure TLS for docker host %s: %w", host, err)
+		}
+		s.baseURL = "https://" + u.Host
+		s.client = &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+	default:
+		return nil, fmt.Errorf("unsupported docker host %s, expected a unix:// or tcp:// address", host)
+	}
+	return s, nil
+}
+
+// tlsConfig verifies the engine with the ca.pem of certPath, and authenticates with
+// its cert.pem and key.pem. An empty certPath is ~/.docker, as for the docker command.
+func tlsConfig(certPath string) (*tls.Config, error) {
+	if certPath == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("DOCKER_CERT_PATH is not set: %w", err)
+		}
+		certPath = filepath.Join(home, ".docker")
+	}
+
+	ca, err := os.ReadFile(filepath.Join(certPath, "ca.pem"))
+	if err != nil {
+		return nil, err
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificate found in %s", filepath.Join(certPath, "ca.pem"))
+	}
+
+	cert, err := tls.LoadX509KeyPair(filepath.Join(certPath, "cert.pem"), filepath.Join(certPath, "key.pem"))
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{RootCAs: roots, Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil
+}
+
+func (s *engineDockerService) IsDaemonRunning() (bool, error) {
+	if err := s.get(context.Background(), "/_ping", nil, nil); err != nil {
+		return false, err
+	}
+
+	s.logVersion.Do(func() {
+		var version struct {
+			Version    string
+			APIVersion string `json:"ApiVersion"`
+		}
+		if err := s.get(context.Background(), "/version", nil, &version); err != nil {
+			log.Debug("Error getting docker engine version", "error", err)
+			return
+		}
+		log.Debug("Connected to docker engine", "version", version.Version, "apiVersion", version.APIVersion)
+	})
+	return true, nil
+}
+
+// ComposePs lists the containers of the project of options, recognized by the
+// project and config files labels compose puts on them.
+func (s *engineDockerService) ComposePs(options ComposeOptions) ([]Container, error) {
+	paths, err := absPaths(options.Files)
+	if err != nil {
+		return nil, err
+	}
+
+	query := url.Values{}
+	query.Set("all", "true")
+	query.Set("filters", fmt.Sprintf(`{"label":[%q]}`, projectLabelFilter(options)))
+
+	var summaries []struct {
+		ID     string `json:"Id"`
+		Names  []string
+		Image  string
+		Labels map[string]string
+	}
+	if err := s.get(context.Background(), "/containers/json", query, &summaries); err != nil {
+		return nil, err
+	}
+
+	containers := []Container{}
+	for _, summary := range summaries {
+		if !createdFrom(summary.Labels, paths) {
+			continue
+		}
+
+		inspect, err := s.inspect(summary.ID)
+		if err != nil {
+			return nil, err
+		}
+		container := Container{
+			ID:       summary.ID,
+			Service:  summary.Labels[composeServiceLabel],
+			Image:    summary.Image,
+			State:    inspect.State.Status,
+			ExitCode: inspect.State.ExitCode,
+		}
+		if len(summary.Names) > 0 {
+			container.Name = strings.TrimPrefix(summary.Names[0], "/")
+		}
+		if inspect.State.Health != nil {
+			container.Health = inspect.State.Health.Status
+		}
+		containers = append(containers, container)
+	}
+	return containers, nil
+}
+
+func (s *engineDockerService) RestartCount(containerID string) (int, error) {
+	inspect, err := s.inspect(containerID)
+	if err != nil {
+		return 0, err
+	}
+	return inspect.RestartCount, nil
+}
+
+// ContainerEvents streams the events of the containers of the project of options
+// until ctx is done.
+func (s *engineDockerService) ContainerEvents(ctx context.Context, options ComposeOptions) (<-chan Event, error) {
+	paths, err := absPaths(options.Files)
+	if err != nil {
+		return nil, err
+	}
+
+	query := url.Values{}
+	query.Set("filters", fmt.Sprintf(`{"type":["container"],"label":[%q]}`, projectLabelFilter(options)))
+	resp, err := s.do(ctx, "/events", query)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan Event)
+	go func() {
+		defer close(events)
+		defer resp.Body.Close()
+
+		decoder := json.NewDecoder(bufio.NewReader(resp.Body))
+		for {
+			var message struct {
+				Action string
+				Actor  struct {
+					ID         string
+					Attributes map[string]string
+				}
+				TimeNano int64 `json:"timeNano"`
+			}
+			if err := decoder.Decode(&message); err != nil {
+				if ctx.Err() == nil {
+					log.Debug("Docker engine event stream ended", "error", err)
+				}
+				return
+			}
+			if !createdFrom(message.Actor.Attributes, paths) {
+				continue
+			}
+
+			event := Event{
+				ContainerID: message.Actor.ID,
+				Action:      message.Action,
+				Attributes:  message.Actor.Attributes,
+				Time:        time.Unix(0, message.TimeNano),
+			}
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events, nil
+}
+
+// projectLabelFilter returns the label filter matching the containers of the project
+// of options, or of any project when it has no explicit name.
+func projectLabelFilter(options ComposeOptions) string {
+	if options.ProjectName != "" {
+		return composeProjectLabel + "=" + options.ProjectName
+	}
+	return composeProjectLabel
+}
+
+// createdFrom reports whether the config files label of a container lists every
+// one of the absolute compose file paths.
+func createdFrom(labels map[string]string, paths []string) bool {
+	configFiles := strings.Split(labels[composeConfigFilesLabel], ",")
+	for _, path := range paths {
+		if !slices.Contains(configFiles, path) {
+			return false
+		}
+	}
+	return true
+}
+
+func absPaths(paths []string) ([]string, error) {
+	abs := make([]string, 0, len(paths))
+	for _, path := range paths {
+		a, err := filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+		abs = append(abs, a)
+	}
+	return abs, nil
+}
+
+// ImageDigests returns the repository digests of the image a container runs.
+func (s *engineDockerService) ImageDigests(containerID string) ([]string, error) {
+	container, err := s.inspect(containerID)
+	if err != nil {
+		return nil, err
+	}
+	var image struct{ RepoDigests []string }
+	if err := s.get(context.Background(), "/images/"+url.PathEscape(container.Image)+"/json", nil, &image); err != nil {
+		return nil, fmt.Errorf("failed to inspect image of container %s: %w", containerID, err)
+	}
+	return image.RepoDigests, nil
+}
+
+// containerInspect holds the parts of a container inspect response voyage uses
+type containerInspect struct {
+	Image        string
+	RestartCount int
+	State        struct {
+		Status   string
+		ExitCode int
+		Health   *struct{ Status string }
+	}
+}
+
+func (s *engineDockerService) inspect(containerID string) (*containerInspect, error) {
+	var inspect containerInspect
+	if err := s.get(context.Background(), "/containers/"+url.PathEscape(containerID)+"/json", nil, &inspect); err != nil {
+		return nil, fmt.Errorf("failed to inspect container %s: %w", containerID, err)
+	}
+	return &inspect, nil
+}
+
+// get requests path and decodes the JSON response into out, unless out is nil
+func (s *engineDockerService) get(ctx context.Context, path string, query url.Values, out any) error {
+	resp, err := s.do(ctx, path, query)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		_, err := io.Copy(io.Discard, resp.Body)
+		return err
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode docker engine response for %s: %w", path, err)
+	}
+	return nil
+}
+
+// do sends a GET request for path and turns error responses into an EngineError
+func (s *engineDockerService) do(ctx context.Context, path string, query url.Values) (*http.Response, error) {
+	u := s.baseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach docker engine: %w", err)
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		defer resp.Body.Close()
+		engineErr := &EngineError{StatusCode: resp.StatusCode}
+		var body struct{ Message string }
+		if err := json.NewDecoder(resp.Body).Decode(&body); err == nil {
+			engineErr.Message = body.Message
+		}
+		return nil, engineErr
+	}
+	return resp, nil
+}
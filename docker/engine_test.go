@@ -0,0 +1,311 @@
+package docker
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newFakeEngine serves handler on a unix socket and returns an engine service connected to it
+func newFakeEngine(t *testing.T, handler http.Handler) *engineDockerService {
+	t.Helper()
+
+	// Unix socket paths are limited in length, so avoid the long t.TempDir paths
+	dir, err := os.MkdirTemp("", "engine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	socket := filepath.Join(dir, "docker.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: handler}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+
+	service, err := NewEngineDockerService("unix://" + socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return service.(*engineDockerService)
+}
+
+func TestNewEngineDockerService(t *testing.T) {
+	t.Setenv("DOCKER_TLS_VERIFY", "")
+	for _, host := range []string{"unix:///var/run/docker.sock", "tcp://127.0.0.1:2375", ""} {
+		if _, err := NewEngineDockerService(host); err != nil {
+			t.Errorf("Expected host %q to be supported, but got %v", host, err)
+		}
+	}
+	if _, err := NewEngineDockerService("ssh://user@host"); err == nil {
+		t.Error("Expected an error for an ssh host, but got nil")
+	}
+}
+
+// writeTestCertificates writes a CA, and a client certificate signed by it, to dir
+// as docker expects them. It returns the CA, to sign the certificate of the server.
+func writeTestCertificates(t *testing.T, dir string) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+
+	newCertificate := func(template, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, []byte) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parent == nil {
+			parent, parentKey = template, key
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert, key, der
+	}
+	writePEM := func(name, blockType string, content []byte) {
+		if err := os.WriteFile(filepath.Join(dir, name), pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: content}), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ca, caKey, caDER := newCertificate(&x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}, nil, nil)
+	writePEM("ca.pem", "CERTIFICATE", caDER)
+
+	_, clientKey, clientDER := newCertificate(&x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, caKey)
+	writePEM("cert.pem", "CERTIFICATE", clientDER)
+	keyDER, err := x509.MarshalECPrivateKey(clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM("key.pem", "EC PRIVATE KEY", keyDER)
+
+	return ca, caKey
+}
+
+func TestNewEngineDockerService_TLS(t *testing.T) {
+	t.Run("Verifies the engine and authenticates with DOCKER_CERT_PATH", func(t *testing.T) {
+		certPath := t.TempDir()
+		ca, caKey := writeTestCertificates(t, certPath)
+
+		serverKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		serverDER, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+			SerialNumber: big.NewInt(3),
+			Subject:      pkix.Name{CommonName: "engine"},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		}, ca, &serverKey.PublicKey, caKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		clients := x509.NewCertPool()
+		clients.AddCert(ca)
+
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { fmt.Fprint(w, "OK") }))
+		server.TLS = &tls.Config{
+			Certificates: []tls.Certificate{{Certificate: [][]byte{serverDER}, PrivateKey: serverKey}},
+			ClientCAs:    clients,
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+		}
+		server.StartTLS()
+		defer server.Close()
+
+		t.Setenv("DOCKER_TLS_VERIFY", "1")
+		t.Setenv("DOCKER_CERT_PATH", certPath)
+		service, err := NewEngineDockerService("tcp://" + server.Listener.Addr().String())
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+
+		if running, err := service.IsDaemonRunning(); !running || err != nil {
+			t.Errorf("Expected the engine to answer over TLS, but got %v, %v", running, err)
+		}
+	})
+
+	t.Run("Fails without the certificates", func(t *testing.T) {
+		t.Setenv("DOCKER_TLS_VERIFY", "1")
+		t.Setenv("DOCKER_CERT_PATH", t.TempDir())
+
+		_, err := NewEngineDockerService("tcp://127.0.0.1:2376")
+		if err == nil || !strings.Contains(err.Error(), "ca.pem") {
+			t.Errorf("Expected an error naming ca.pem, but got %v", err)
+		}
+	})
+}
+
+func TestEngineDockerService_IsDaemonRunning(t *testing.T) {
+	t.Run("Pings the engine", func(t *testing.T) {
+		mux := http.NewServeMux()
+		mux.HandleFunc("GET /_ping", func(w http.ResponseWriter, r *http.Request) { fmt.Fprint(w, "OK") })
+		mux.HandleFunc("GET /version", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"Version":"27.3.1","ApiVersion":"1.47"}`)
+		})
+
+		running, err := newFakeEngine(t, mux).IsDaemonRunning()
+		if err != nil || !running {
+			t.Fatalf("Expected daemon to be running, but got %v, %v", running, err)
+		}
+	})
+
+	t.Run("Returns engine errors", func(t *testing.T) {
+		mux := http.NewServeMux()
+		mux.HandleFunc("GET /_ping", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, `{"message":"daemon is shutting down"}`)
+		})
+
+		_, err := newFakeEngine(t, mux).IsDaemonRunning()
+		var engineErr *EngineError
+		if !errors.As(err, &engineErr) || engineErr.StatusCode != http.StatusInternalServerError || engineErr.Message != "daemon is shutting down" {
+			t.Fatalf("Expected an EngineError, but got %v", err)
+		}
+	})
+}
+
+func TestEngineDockerService_ComposePs(t *testing.T) {
+	var filters string
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /containers/json", func(w http.ResponseWriter, r *http.Request) {
+		filters = r.URL.Query().Get("filters")
+		if r.URL.Query().Get("all") != "true" {
+			t.Errorf("Expected stopped containers to be listed too, got query %s", r.URL.RawQuery)
+		}
+		fmt.Fprint(w, `[
+			{"Id":"abc","Names":["/app-web-1"],"Image":"nginx","Labels":{"com.docker.compose.service":"web","com.docker.compose.project.config_files":"/srv/app/compose.yml"}},
+			{"Id":"def","Names":["/app-db-1"],"Image":"postgres","Labels":{"com.docker.compose.service":"db","com.docker.compose.project.config_files":"/srv/app/compose.yml,/srv/app/compose.override.yml"}},
+			{"Id":"ghi","Names":["/other-web-1"],"Image":"nginx","Labels":{"com.docker.compose.service":"web","com.docker.compose.project.config_files":"/srv/other/compose.yml"}}
+		]`)
+	})
+	mux.HandleFunc("GET /containers/abc/json", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"RestartCount":2,"State":{"Status":"running","ExitCode":0,"Health":{"Status":"healthy"}}}`)
+	})
+	mux.HandleFunc("GET /containers/def/json", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"RestartCount":0,"State":{"Status":"exited","ExitCode":1}}`)
+	})
+	service := newFakeEngine(t, mux)
+
+	containers, err := service.ComposePs(ComposeOptions{Files: []string{"/srv/app/compose.yml"}})
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+
+	expected := []Container{
+		{ID: "abc", Name: "app-web-1", Service: "web", Image: "nginx", State: "running", Health: "healthy"},
+		{ID: "def", Name: "app-db-1", Service: "db", Image: "postgres", State: "exited", ExitCode: 1},
+	}
+	if fmt.Sprint(containers) != fmt.Sprint(expected) {
+		t.Errorf("Expected containers %+v, but got %+v", expected, containers)
+	}
+
+	containers, err = service.ComposePs(ComposeOptions{Files: []string{"/srv/app/compose.yml", "/srv/app/compose.override.yml"}})
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if len(containers) != 1 || containers[0].ID != "def" {
+		t.Errorf("Expected only the container created with the override file, but got %+v", containers)
+	}
+
+	if _, err := service.ComposePs(ComposeOptions{Files: []string{"/srv/app/compose.yml"}, ProjectName: "web"}); err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if expected := `{"label":["com.docker.compose.project=web"]}`; filters != expected {
+		t.Errorf("Expected filters %s for a named project, but got %s", expected, filters)
+	}
+
+	restarts, err := service.RestartCount("abc")
+	if err != nil || restarts != 2 {
+		t.Errorf("Expected 2 restarts, but got %d, %v", restarts, err)
+	}
+
+	if _, err := service.RestartCount("missing"); err == nil {
+		t.Error("Expected an error for a missing container, but got nil")
+	}
+}
+
+func TestEngineDockerService_ImageDigests(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /containers/abc/json", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"Image":"sha256:0a1b","State":{"Status":"running"}}`)
+	})
+	mux.HandleFunc("GET /images/sha256:0a1b/json", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"RepoDigests":["nginx@sha256:c0ffee"]}`)
+	})
+
+	digests, err := newFakeEngine(t, mux).ImageDigests("abc")
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if len(digests) != 1 || digests[0] != "nginx@sha256:c0ffee" {
+		t.Errorf("Expected [nginx@sha256:c0ffee], but got %v", digests)
+	}
+}
+
+func TestEngineDockerService_ContainerEvents(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /events", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"Action":"start","Actor":{"ID":"ghi","Attributes":{"com.docker.compose.project.config_files":"/srv/other/compose.yml"}},"timeNano":1}`)
+		fmt.Fprintln(w, `{"Action":"die","Actor":{"ID":"abc","Attributes":{"com.docker.compose.project.config_files":"/srv/app/compose.yml","exitCode":"1"}},"timeNano":2}`)
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := newFakeEngine(t, mux).ContainerEvents(ctx, ComposeOptions{Files: []string{"/srv/app/compose.yml"}})
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+
+	select {
+	case event := <-events:
+		if event.ContainerID != "abc" || event.Action != "die" || event.Attributes["exitCode"] != "1" {
+			t.Errorf("Expected the die event of the stack's container, but got %+v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected an event, but got none")
+	}
+
+	cancel()
+	for range events {
+	}
+}
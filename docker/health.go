@@ -0,0 +1,210 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gnugomez/voyage/log"
+)
+
+const (
+	defaultHealthPollInterval = 2 * time.Second
+	// healthSettlePeriod is how long polled containers must stay ready before the
+	// stack counts as healthy, so a container that crashes right after starting is caught
+	healthSettlePeriod = 10 * time.Second
+)
+
+// composeWaitVersion is the first compose version supporting 'up --wait-timeout'
+var composeWaitVersion = [3]int{2, 17, 0}
+
+// composeHealthErrors are the errors 'docker compose up --wait' fails with when the
+// containers do not become healthy, or not in time
+var composeHealthErrors = []string{"is unhealthy", "application not healthy after", "exited ("}
+
+// maxHealthErrorLine bounds the unterminated output healthErrorWriter keeps
+const maxHealthErrorLine = 4096
+
+// eventSource is implemented by DockerService backends that can stream container
+// events, which wake the health gate up before its next poll.
+type eventSource interface {
+	ContainerEvents(ctx context.Context, options ComposeOptions) (<-chan Event, error)
+}
+
+// supportsComposeWait reports whether the installed compose can wait for the
+// containers itself.
+func (d *Deployer) supportsComposeWait() bool {
+	version, err := d.dockerService.ComposeVersion()
+	if err != nil {
+		log.Debug("Could not determine docker compose version, polling container health", "error", err)
+		return false
+	}
+	return versionAtLeast(version, composeWaitVersion)
+}
+
+// waitHealthy polls the containers of a stack until they all stay ready for the
+// settle period. It fails as soon as a container exits with an error or is
+// restarted, or when they are not ready within timeout.
+func (d *Deployer) waitHealthy(options ComposeOptions, timeout time.Duration) error {
+	deadline := d.now().Add(timeout)
+	settle := min(healthSettlePeriod, timeout/2)
+	restarts := map[string]int{}
+	var readySince time.Time
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var events <-chan Event
+	if source, ok := d.dockerService.(eventSource); ok {
+		var err error
+		if events, err = source.ContainerEvents(ctx, options); err != nil {
+			log.Debug("Could not watch container events, only polling", "error", err)
+		}
+	}
+
+	log.Debug("Waiting for containers to become healthy", "composeFiles", options.Files, "timeout", timeout)
+	for {
+		containers, err := d.dockerService.ComposePs(options)
+		if err != nil {
+			return err
+		}
+		pending, err := d.pendingContainers(containers, restarts)
+		if err != nil {
+			return err
+		}
+
+		now := d.now()
+		if len(pending) > 0 {
+			readySince = time.Time{}
+		} else if readySince.IsZero() {
+			readySince = now
+		}
+
+		if len(pending) == 0 && (now.Sub(readySince) >= settle || !now.Before(deadline)) {
+			return nil
+		}
+		if !now.Before(deadline) {
+			return fmt.Errorf("stack did not become healthy within %s, still waiting for %s", timeout, strings.Join(pending, ", "))
+		}
+
+		select {
+		case <-d.after(min(d.pollInterval, deadline.Sub(now))):
+		case event, ok := <-events:
+			if !ok {
+				events = nil
+				continue
+			}
+			log.Debug("Container event while waiting for health", "container", event.ContainerID, "action", event.Action)
+		}
+	}
+}
+
+// pendingContainers returns the containers that are not ready yet. restarts holds
+// the restart count of every container when it was first seen, a container that
+// restarts after that is crash looping and fails the deploy.
+func (d *Deployer) pendingContainers(containers []Container, restarts map[string]int) ([]string, error) {
+	if len(containers) == 0 {
+		return []string{"containers to be created"}, nil
+	}
+
+	var pending []string
+	for _, container := range containers {
+		count, err := d.dockerService.RestartCount(container.ID)
+		if err != nil {
+			return nil, err
+		}
+		if baseline, ok := restarts[container.ID]; !ok {
+			restarts[container.ID] = count
+		} else if count > baseline {
+			return nil, fmt.Errorf("container %s restarted %d times while waiting for it to become healthy", container.Name, count-baseline)
+		}
+
+		switch {
+		case container.State == "exited" || container.State == "dead":
+			// One-off containers are expected to exit, but not with an error
+			if container.ExitCode != 0 {
+				return nil, fmt.Errorf("container %s exited with code %d", container.Name, container.ExitCode)
+			}
+		case container.State == "running" && (container.Health == "" || container.Health == "healthy"):
+		default:
+			condition := container.State
+			if container.Health != "" {
+				condition += "/" + container.Health
+			}
+			pending = append(pending, fmt.Sprintf("%s (%s)", container.Name, condition))
+		}
+	}
+	return pending, nil
+}
+
+// healthErrorWriter passes compose output through, and keeps the last line saying
+// the containers did not become healthy, to tell a failed wait apart from compose
+// failing before it waits.
+type healthErrorWriter struct {
+	w       io.Writer
+	partial []byte
+	line    string
+}
+
+func (h *healthErrorWriter) Write(p []byte) (int, error) {
+	h.partial = append(h.partial, p...)
+	for {
+		i := bytes.IndexByte(h.partial, '\n')
+		if i < 0 {
+			break
+		}
+		h.check(h.partial[:i])
+		h.partial = h.partial[i+1:]
+	}
+	if len(h.partial) > maxHealthErrorLine {
+		h.partial = h.partial[len(h.partial)-maxHealthErrorLine:]
+	}
+
+	if h.w == nil {
+		return len(p), nil
+	}
+	return h.w.Write(p)
+}
+
+// healthError returns the line compose reported the failed wait with, or "" when
+// it failed for another reason
+func (h *healthErrorWriter) healthError() string {
+	h.check(h.partial)
+	h.partial = nil
+	return h.line
+}
+
+func (h *healthErrorWriter) check(line []byte) {
+	text := strings.TrimSpace(string(line))
+	for _, message := range composeHealthErrors {
+		if strings.Contains(text, message) {
+			h.line = text
+			return
+		}
+	}
+}
+
+// versionAtLeast reports whether a major.minor.patch version is at least minimum.
+// Pre-release and build suffixes are ignored.
+func versionAtLeast(version string, minimum [3]int) bool {
+	version, _, _ = strings.Cut(version, "-")
+	version, _, _ = strings.Cut(version, "+")
+	parts := strings.Split(version, ".")
+
+	for i := range minimum {
+		n := 0
+		if i < len(parts) {
+			var err error
+			if n, err = strconv.Atoi(parts[i]); err != nil {
+				return false
+			}
+		}
+		if n != minimum[i] {
+			return n > minimum[i]
+		}
+	}
+	return true
+}
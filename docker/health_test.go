@@ -0,0 +1,143 @@
+package docker
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newHealthDeployer returns a Deployer whose clock only advances when it sleeps
+func newHealthDeployer(mock *mockDockerService) *Deployer {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	return &Deployer{
+		dockerService: mock,
+		now:           func() time.Time { return now },
+		after: func(d time.Duration) <-chan time.Time {
+			now = now.Add(d)
+			c := make(chan time.Time, 1)
+			c <- now
+			return c
+		},
+		pollInterval: defaultHealthPollInterval,
+	}
+}
+
+// mockEventSource adds container events to mockDockerService
+type mockEventSource struct {
+	*mockDockerService
+	events chan Event
+}
+
+func (m *mockEventSource) ContainerEvents(ctx context.Context, options ComposeOptions) (<-chan Event, error) {
+	return m.events, nil
+}
+
+func TestDeployer_WaitHealthy(t *testing.T) {
+	t.Run("Healthy once containers stay ready", func(t *testing.T) {
+		polls := 0
+		mock := &mockDockerService{ComposePsFunc: func(options ComposeOptions) ([]Container, error) {
+			polls++
+			health := "healthy"
+			if polls < 3 {
+				health = "starting"
+			}
+			return []Container{
+				{ID: "a", Name: "app-web-1", State: "running", Health: health},
+				{ID: "b", Name: "app-migrate-1", State: "exited", ExitCode: 0},
+			}, nil
+		}}
+
+		if err := newHealthDeployer(mock).waitHealthy(ComposeOptions{Files: []string{"compose.yml"}}, time.Minute); err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		// Ready on the third poll, then held for the settle period
+		if expected := 3 + int(healthSettlePeriod/defaultHealthPollInterval); polls != expected {
+			t.Errorf("Expected %d polls, but got %d", expected, polls)
+		}
+	})
+
+	t.Run("Fails when a container stays unhealthy", func(t *testing.T) {
+		mock := &mockDockerService{ComposePsFunc: func(options ComposeOptions) ([]Container, error) {
+			return []Container{{ID: "a", Name: "app-web-1", State: "running", Health: "unhealthy"}}, nil
+		}}
+
+		err := newHealthDeployer(mock).waitHealthy(ComposeOptions{Files: []string{"compose.yml"}}, 30*time.Second)
+		if err == nil || !strings.Contains(err.Error(), "app-web-1 (running/unhealthy)") {
+			t.Fatalf("Expected a timeout naming the unhealthy container, but got %v", err)
+		}
+	})
+
+	t.Run("Fails when a container restarts", func(t *testing.T) {
+		restarts := 4
+		mock := &mockDockerService{
+			ComposePsFunc: func(options ComposeOptions) ([]Container, error) {
+				return []Container{{ID: "a", Name: "app-web-1", State: "running"}}, nil
+			},
+			RestartCountFunc: func(containerID string) (int, error) {
+				restarts++
+				return restarts, nil
+			},
+		}
+
+		err := newHealthDeployer(mock).waitHealthy(ComposeOptions{Files: []string{"compose.yml"}}, time.Minute)
+		if err == nil || !strings.Contains(err.Error(), "restarted") {
+			t.Fatalf("Expected a crash loop error, but got %v", err)
+		}
+	})
+
+	t.Run("Fails when a container exits with an error", func(t *testing.T) {
+		mock := &mockDockerService{ComposePsFunc: func(options ComposeOptions) ([]Container, error) {
+			return []Container{{ID: "a", Name: "app-web-1", State: "exited", ExitCode: 137}}, nil
+		}}
+
+		err := newHealthDeployer(mock).waitHealthy(ComposeOptions{Files: []string{"compose.yml"}}, time.Minute)
+		if err == nil || !strings.Contains(err.Error(), "exited with code 137") {
+			t.Fatalf("Expected an exit code error, but got %v", err)
+		}
+	})
+}
+
+func TestDeployer_WaitHealthyEvents(t *testing.T) {
+	// The container dies between two polls and the event triggers the next poll right away
+	source := &mockEventSource{events: make(chan Event, 1)}
+	polls := 0
+	source.mockDockerService = &mockDockerService{ComposePsFunc: func(options ComposeOptions) ([]Container, error) {
+		polls++
+		if polls == 1 {
+			source.events <- Event{ContainerID: "a", Action: "die"}
+			return []Container{{ID: "a", Name: "app-web-1", State: "running", Health: "starting"}}, nil
+		}
+		return []Container{{ID: "a", Name: "app-web-1", State: "exited", ExitCode: 1}}, nil
+	}}
+	d := newHealthDeployer(source.mockDockerService)
+	d.dockerService = source
+	// Polls only happen on events, a timer would block the test forever
+	d.after = func(time.Duration) <-chan time.Time { return nil }
+
+	err := d.waitHealthy(ComposeOptions{Files: []string{"compose.yml"}}, time.Minute)
+	if err == nil || !strings.Contains(err.Error(), "exited with code 1") {
+		t.Fatalf("Expected an exit code error, but got %v", err)
+	}
+}
+
+func TestVersionAtLeast(t *testing.T) {
+	testCases := []struct {
+		version  string
+		expected bool
+	}{
+		{"2.17.0", true},
+		{"2.29.1-desktop.1", true},
+		{"2.17", true},
+		{"3.0.0", true},
+		{"2.16.9", false},
+		{"1.29.2", false},
+		{"dev", false},
+	}
+
+	for _, tc := range testCases {
+		if got := versionAtLeast(tc.version, composeWaitVersion); got != tc.expected {
+			t.Errorf("Expected versionAtLeast(%q) to be %v, but got %v", tc.version, tc.expected, got)
+		}
+	}
+}
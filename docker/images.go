@@ -0,0 +1,76 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/gnugomez/voyage/log"
+	"github.com/gnugomez/voyage/registry"
+)
+
+// DigestResolver resolves an image tag to the digest it points at in its registry.
+type DigestResolver interface {
+	Digest(ctx context.Context, image string) (string, error)
+}
+
+// ImageChecker finds the images of a compose project that were updated in their
+// registry since its containers were created.
+type ImageChecker struct {
+	dockerService DockerService
+	resolver      DigestResolver
+}
+
+// NewImageChecker creates an ImageChecker that inspects containers through
+// dockerService and looks tags up with resolver.
+func NewImageChecker(dockerService DockerService, resolver DigestResolver) *ImageChecker {
+	return &ImageChecker{dockerService: dockerService, resolver: resolver}
+}
+
+// UpdatedImages returns the images of the project whose tag points at another digest
+// in the registry than the image its containers run. Services that are built, pinned
+// to a digest or have no container are skipped.
+func (c *ImageChecker) UpdatedImages(ctx context.Context, options ComposeOptions) ([]string, error) {
+	images, err := c.dockerService.ComposeImages(options)
+	if err != nil {
+		return nil, err
+	}
+	containers, err := c.dockerService.ComposePs(options)
+	if err != nil {
+		return nil, err
+	}
+
+	// Several containers of a service or services sharing an image resolve it once
+	remote := map[string]string{}
+	var updated []string
+	for _, container := range containers {
+		image, ok := images[container.Service]
+		if !ok || slices.Contains(updated, image) {
+			continue
+		}
+
+		digest, ok := remote[image]
+		if !ok {
+			digest, err = c.resolver.Digest(ctx, image)
+			if errors.Is(err, registry.ErrDigestReference) {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to resolve image %s: %w", image, err)
+			}
+			remote[image] = digest
+		}
+
+		local, err := c.dockerService.ImageDigests(container.ID)
+		if err != nil {
+			return nil, err
+		}
+		if !slices.ContainsFunc(local, func(repoDigest string) bool { return strings.HasSuffix(repoDigest, "@"+digest) }) {
+			log.Debug("Image was updated in its registry", "image", image, "container", container.Name, "digest", digest, "localDigests", local)
+			updated = append(updated, image)
+		}
+	}
+	return updated, nil
+}
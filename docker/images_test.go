@@ -0,0 +1,84 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/gnugomez/voyage/registry"
+)
+
+// mockDigestResolver resolves images from a fixed map
+type mockDigestResolver map[string]string
+
+func (m mockDigestResolver) Digest(ctx context.Context, image string) (string, error) {
+	if image == "redis@sha256:pinned" {
+		return "", registry.ErrDigestReference
+	}
+	digest, ok := m[image]
+	if !ok {
+		return "", errors.New("manifest unknown")
+	}
+	return digest, nil
+}
+
+func TestImageChecker_UpdatedImages(t *testing.T) {
+	newService := func(images map[string]string) *mockDockerService {
+		return &mockDockerService{
+			ComposeImagesFunc: func(options ComposeOptions) (map[string]string, error) { return images, nil },
+			ComposePsFunc: func(options ComposeOptions) ([]Container, error) {
+				return []Container{
+					{ID: "web1", Name: "app-web-1", Service: "web"},
+					{ID: "web2", Name: "app-web-2", Service: "web"},
+					{ID: "db1", Name: "app-db-1", Service: "db"},
+					{ID: "api1", Name: "app-api-1", Service: "api"},
+				}, nil
+			},
+			ImageDigestsFunc: func(containerID string) ([]string, error) {
+				return map[string][]string{
+					"web1": {"nginx@sha256:old"},
+					"web2": {"nginx@sha256:old"},
+					"db1":  {"redis@sha256:pinned"},
+					"api1": {"ghcr.io/team/api@sha256:current"},
+				}[containerID], nil
+			},
+		}
+	}
+
+	t.Run("Returns images whose tag moved", func(t *testing.T) {
+		// api is built locally, so it is not in the images of the project
+		service := newService(map[string]string{"web": "nginx:latest", "db": "redis@sha256:pinned"})
+		checker := NewImageChecker(service, mockDigestResolver{"nginx:latest": "sha256:new"})
+
+		updated, err := checker.UpdatedImages(context.Background(), ComposeOptions{})
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if !reflect.DeepEqual(updated, []string{"nginx:latest"}) {
+			t.Errorf("Expected [nginx:latest], but got %v", updated)
+		}
+	})
+
+	t.Run("Returns nothing when the running digests are current", func(t *testing.T) {
+		service := newService(map[string]string{"api": "ghcr.io/team/api:1"})
+		checker := NewImageChecker(service, mockDigestResolver{"ghcr.io/team/api:1": "sha256:current"})
+
+		updated, err := checker.UpdatedImages(context.Background(), ComposeOptions{})
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if len(updated) != 0 {
+			t.Errorf("Expected no updated images, but got %v", updated)
+		}
+	})
+
+	t.Run("Returns error when a tag cannot be resolved", func(t *testing.T) {
+		service := newService(map[string]string{"web": "nginx:missing"})
+		checker := NewImageChecker(service, mockDigestResolver{})
+
+		if _, err := checker.UpdatedImages(context.Background(), ComposeOptions{}); err == nil {
+			t.Fatal("Expected an error, but got nil")
+		}
+	})
+}
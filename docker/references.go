@@ -0,0 +1,220 @@
+package docker
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ComposeReferences returns the local files and directories a compose file refers
+// to: env files, build contexts and Dockerfiles, config and secret files, and the
+// compose files it extends or includes, whose references are followed as well.
+// Paths are absolute. Remote build contexts and paths using variables are skipped.
+func ComposeReferences(composeFilePath string) ([]string, error) {
+	path, err := filepath.Abs(composeFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &referenceCollector{visited: map[string]bool{}}
+	if err := r.collect(path); err != nil {
+		return nil, err
+	}
+
+	slices.Sort(r.paths)
+	return slices.Compact(r.paths), nil
+}
+
+type referenceCollector struct {
+	visited map[string]bool
+	paths   []string
+}
+
+// collect adds the references of the compose file at path. Files reached through
+// extends or include that cannot be read are skipped, compose reports those itself.
+func (r *referenceCollector) collect(path string) error {
+	if r.visited[path] {
+		return nil
+	}
+	r.visited[path] = true
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var file composeFile
+	if err := yaml.Unmarshal(content, &file); err != nil {
+		return fmt.Errorf("failed to parse compose file %s: %w", path, err)
+	}
+
+	dir := filepath.Dir(path)
+	var nested []string
+	for _, service := range file.Services {
+		r.add(dir, service.EnvFile...)
+		if context := service.Build.Context; context != "" && !isRemoteContext(context) {
+			r.add(dir, context)
+			if dockerfile := service.Build.Dockerfile; dockerfile != "" {
+				r.add(r.resolve(dir, context), dockerfile)
+			}
+		}
+		if extended := service.Extends.File; extended != "" {
+			nested = append(nested, r.add(dir, extended)...)
+		}
+	}
+	for _, resource := range file.Configs {
+		r.add(dir, resource.File)
+	}
+	for _, resource := range file.Secrets {
+		r.add(dir, resource.File)
+	}
+	for _, include := range file.Include {
+		nested = append(nested, r.add(dir, include.Path...)...)
+		r.add(dir, include.EnvFile...)
+	}
+
+	for _, nestedPath := range nested {
+		if err := r.collect(nestedPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// add records paths relative to dir and returns them resolved
+func (r *referenceCollector) add(dir string, paths ...string) []string {
+	var added []string
+	for _, path := range paths {
+		if path == "" || strings.Contains(path, "$") {
+			continue
+		}
+		path = r.resolve(dir, path)
+		r.paths = append(r.paths, path)
+		added = append(added, path)
+	}
+	return added
+}
+
+func (r *referenceCollector) resolve(dir, path string) string {
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path)
+	}
+	return filepath.Join(dir, path)
+}
+
+// isRemoteContext reports whether a build context is a Git repository or URL
+func isRemoteContext(context string) bool {
+	return strings.Contains(context, "://") || strings.HasPrefix(context, "git@")
+}
+
+// composeFile holds the parts of a compose file that refer to other files
+type composeFile struct {
+	Services map[string]composeService  `yaml:"services"`
+	Configs  map[string]composeResource `yaml:"configs"`
+	Secrets  map[string]composeResource `yaml:"secrets"`
+	Include  []composeInclude           `yaml:"include"`
+}
+
+type composeService struct {
+	EnvFile envFiles       `yaml:"env_file"`
+	Build   composeBuild   `yaml:"build"`
+	Extends composeExtends `yaml:"extends"`
+}
+
+type composeResource struct {
+	File string `yaml:"file"`
+}
+
+// envFiles is a path, a list of paths or a list of {path, required} entries
+type envFiles []string
+
+func (e *envFiles) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*e = envFiles{node.Value}
+		return nil
+	}
+
+	for _, item := range node.Content {
+		if item.Kind == yaml.ScalarNode {
+			*e = append(*e, item.Value)
+			continue
+		}
+		var entry struct {
+			Path string `yaml:"path"`
+		}
+		if err := item.Decode(&entry); err != nil {
+			return err
+		}
+		*e = append(*e, entry.Path)
+	}
+	return nil
+}
+
+// stringList is a single string or a list of strings
+type stringList []string
+
+func (s *stringList) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*s = stringList{node.Value}
+		return nil
+	}
+	var list []string
+	if err := node.Decode(&list); err != nil {
+		return err
+	}
+	*s = list
+	return nil
+}
+
+// composeBuild is a build context or a {context, dockerfile} mapping
+type composeBuild struct {
+	Context    string `yaml:"context"`
+	Dockerfile string `yaml:"dockerfile"`
+}
+
+func (b *composeBuild) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		b.Context = node.Value
+		return nil
+	}
+
+	type plain composeBuild
+	if err := node.Decode((*plain)(b)); err != nil {
+		return err
+	}
+	if b.Context == "" {
+		b.Context = "."
+	}
+	return nil
+}
+
+// composeExtends is a service of the same file or a {file, service} mapping
+type composeExtends struct {
+	File string `yaml:"file"`
+}
+
+func (e *composeExtends) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return nil
+	}
+	type plain composeExtends
+	return node.Decode((*plain)(e))
+}
+
+// composeInclude is a path or a {path, env_file} mapping
+type composeInclude struct {
+	Path    stringList `yaml:"path"`
+	EnvFile stringList `yaml:"env_file"`
+}
+
+func (i *composeInclude) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		i.Path = stringList{node.Value}
+		return nil
+	}
+	type plain composeInclude
+	return node.Decode((*plain)(i))
+}
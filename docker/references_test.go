@@ -0,0 +1,125 @@
+package docker
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestComposeReferences(t *testing.T) {
+	t.Run("Resolves every kind of reference", func(t *testing.T) {
+		root := t.TempDir()
+		writeFiles(t, root, map[string]string{
+			"app/compose.yml": `
+include:
+  - ../shared/include.yml
+  - path: [../shared/other.yml]
+    env_file: ../shared/include.env
+services:
+  web:
+    image: nginx
+    env_file: ../shared/web.env
+    extends:
+      file: ../shared/base.yml
+      service: base
+  worker:
+    build:
+      context: ../worker
+      dockerfile: docker/Dockerfile
+    env_file:
+      - path: ../shared/worker.env
+        required: false
+      - .env.local
+  remote:
+    build: https://github.com/user/repo.git
+  local:
+    build: ../local
+  same-file:
+    extends: web
+  interpolated:
+    env_file: ${ENV_DIR}/app.env
+configs:
+  nginx:
+    file: ../config/nginx.conf
+secrets:
+  token:
+    file: /run/secrets/token
+`,
+			"shared/base.yml": `
+services:
+  base:
+    env_file: base.env
+`,
+			"shared/include.yml": `
+services:
+  db:
+    env_file: ../db/db.env
+`,
+		})
+
+		references, err := ComposeReferences(filepath.Join(root, "app/compose.yml"))
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+
+		expected := []string{
+			"/run/secrets/token",
+			filepath.Join(root, "app/.env.local"),
+			filepath.Join(root, "config/nginx.conf"),
+			filepath.Join(root, "db/db.env"),
+			filepath.Join(root, "local"),
+			filepath.Join(root, "shared/base.env"),
+			filepath.Join(root, "shared/base.yml"),
+			filepath.Join(root, "shared/include.env"),
+			filepath.Join(root, "shared/include.yml"),
+			filepath.Join(root, "shared/other.yml"),
+			filepath.Join(root, "shared/web.env"),
+			filepath.Join(root, "shared/worker.env"),
+			filepath.Join(root, "worker"),
+			filepath.Join(root, "worker/docker/Dockerfile"),
+		}
+		if !reflect.DeepEqual(references, expected) {
+			t.Errorf("Unexpected references.\nGot:      %v\nExpected: %v", references, expected)
+		}
+	})
+
+	t.Run("Follows cycles once", func(t *testing.T) {
+		root := t.TempDir()
+		writeFiles(t, root, map[string]string{
+			"a/compose.yml": "include: [../b/compose.yml]\n",
+			"b/compose.yml": "include: [../a/compose.yml]\n",
+		})
+
+		references, err := ComposeReferences(filepath.Join(root, "a/compose.yml"))
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		expected := []string{filepath.Join(root, "a/compose.yml"), filepath.Join(root, "b/compose.yml")}
+		if !reflect.DeepEqual(references, expected) {
+			t.Errorf("Expected %v, but got %v", expected, references)
+		}
+	})
+
+	t.Run("Returns error for an invalid compose file", func(t *testing.T) {
+		root := t.TempDir()
+		writeFiles(t, root, map[string]string{"compose.yml": "services: [\n"})
+
+		if _, err := ComposeReferences(filepath.Join(root, "compose.yml")); err == nil {
+			t.Fatal("Expected an error, but got nil")
+		}
+	})
+}
@@ -1,16 +1,85 @@
 package docker
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
+	"math"
+	"os"
 	"os/exec"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // DockerService defines a set of high-level Docker operations.
 type DockerService interface {
 	IsDaemonRunning() (bool, error)
 	IsComposeInstalled() (bool, error)
-	ComposeUp(composeFilePath string, daemonMode bool, stdout, stderr io.Writer) error
+	ComposeVersion() (string, error)
+	ComposeUp(options ComposeOptions, stdout, stderr io.Writer) error
+	ComposePs(options ComposeOptions) ([]Container, error)
+	RestartCount(containerID string) (int, error)
+	ComposeImages(options ComposeOptions) (map[string]string, error)
+	ImageDigests(containerID string) ([]string, error)
+}
+
+// ComposeOptions selects a compose project and how 'docker compose up' runs it
+type ComposeOptions struct {
+	// Files are merged, in order, into one project
+	Files            []string
+	ProjectName      string
+	ProjectDirectory string
+	Profiles         []string
+	EnvFiles         []string
+	// Detach runs the containers in the background
+	Detach        bool
+	RemoveOrphans bool
+	Build         bool
+	// Pull is one of PullPolicies, empty keeps the compose default
+	Pull          string
+	ForceRecreate bool
+	// WaitTimeout makes compose wait for the services to be running or healthy when positive
+	WaitTimeout time.Duration
+	// Stdout and Stderr receive the output of compose up instead of the deployer's
+	// when set, so stacks deployed at once can buffer theirs
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// PullPolicies are the values 'docker compose up --pull' accepts
+var PullPolicies = []string{"always", "missing", "never", "build"}
+
+// Container is a container of a compose project as reported by 'docker compose ps'.
+type Container struct {
+	ID       string `json:"ID"`
+	Name     string `json:"Name"`
+	Service  string `json:"Service"`
+	Image    string `json:"Image"`
+	State    string `json:"State"`
+	Health   string `json:"Health"`
+	ExitCode int    `json:"ExitCode"`
+	Status   string `json:"Status"`
+}
+
+// Backends a DockerService can be created for
+const (
+	BackendCLI    = "cli"
+	BackendEngine = "engine"
+)
+
+// NewDockerService creates the DockerService for backend. The engine backend
+// connects to DOCKER_HOST, or the default socket when it is not set.
+func NewDockerService(backend string) (DockerService, error) {
+	switch backend {
+	case "", BackendCLI:
+		return NewCliDockerService(), nil
+	case BackendEngine:
+		return NewEngineDockerService(os.Getenv("DOCKER_HOST"))
+	default:
+		return nil, fmt.Errorf("unknown docker backend %q, expected %s or %s", backend, BackendCLI, BackendEngine)
+	}
 }
 
 // cliDockerService is the implementation of DockerService that uses the docker command line.
@@ -38,12 +107,62 @@ func (s *cliDockerService) IsComposeInstalled() (bool, error) {
 	return true, nil
 }
 
-func (s *cliDockerService) ComposeUp(composeFilePath string, daemonMode bool, stdout, stderr io.Writer) error {
-	args := []string{"compose", "-f", composeFilePath, "up"}
-	if daemonMode {
+// ComposeVersion returns the version of the compose plugin, without a leading 'v'.
+func (s *cliDockerService) ComposeVersion() (string, error) {
+	output, err := exec.Command("docker", "compose", "version", "--short").Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to get docker compose version: %w", err)
+	}
+	return strings.TrimPrefix(strings.TrimSpace(string(output)), "v"), nil
+}
+
+// composeArgs returns the 'docker compose' arguments selecting the project of options
+func composeArgs(options ComposeOptions) []string {
+	args := []string{"compose"}
+	if options.ProjectName != "" {
+		args = append(args, "-p", options.ProjectName)
+	}
+	if options.ProjectDirectory != "" {
+		args = append(args, "--project-directory", options.ProjectDirectory)
+	}
+	for _, envFile := range options.EnvFiles {
+		args = append(args, "--env-file", envFile)
+	}
+	for _, profile := range options.Profiles {
+		args = append(args, "--profile", profile)
+	}
+	for _, path := range options.Files {
+		args = append(args, "-f", path)
+	}
+	return args
+}
+
+// ComposeUpArgs returns the docker arguments ComposeUp runs 'docker compose up' with
+func ComposeUpArgs(options ComposeOptions) []string {
+	args := append(composeArgs(options), "up")
+	if options.Detach {
 		args = append(args, "-d")
 	}
-	cmd := exec.Command("docker", args...)
+	if options.RemoveOrphans {
+		args = append(args, "--remove-orphans")
+	}
+	if options.Build {
+		args = append(args, "--build")
+	}
+	if options.Pull != "" {
+		args = append(args, "--pull", options.Pull)
+	}
+	if options.ForceRecreate {
+		args = append(args, "--force-recreate")
+	}
+	if options.WaitTimeout > 0 {
+		args = append(args, "--wait", "--wait-timeout", strconv.Itoa(int(math.Ceil(options.WaitTimeout.Seconds()))))
+	}
+	return args
+}
+
+func (s *cliDockerService) ComposeUp(options ComposeOptions, stdout, stderr io.Writer) error {
+	cmd := exec.Command("docker", ComposeUpArgs(options)...)
 
 	cmd.Stdout = stdout
 	cmd.Stderr = stderr
@@ -54,3 +173,106 @@ func (s *cliDockerService) ComposeUp(composeFilePath string, daemonMode bool, st
 
 	return nil
 }
+
+func (s *cliDockerService) ComposePs(options ComposeOptions) ([]Container, error) {
+	cmd := exec.Command("docker", append(composeArgs(options), "ps", "--all", "--format", "json")...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run docker compose ps: %w, output: %s", err, stderr.String())
+	}
+	return parseComposePs(output)
+}
+
+// RestartCount returns how many times the container was restarted by its restart policy.
+func (s *cliDockerService) RestartCount(containerID string) (int, error) {
+	output, err := exec.Command("docker", "inspect", "--format", "{{.RestartCount}}", containerID).Output()
+	if err != nil {
+		return 0, fmt.Errorf("failed to inspect container %s: %w", containerID, err)
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(string(output)))
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse restart count of container %s: %w", containerID, err)
+	}
+	return count, nil
+}
+
+// ComposeImages returns the image of every service of the project that is pulled
+// rather than built, keyed by service name.
+func (s *cliDockerService) ComposeImages(options ComposeOptions) (map[string]string, error) {
+	cmd := exec.Command("docker", append(composeArgs(options), "config", "--format", "json")...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run docker compose config: %w, output: %s", err, stderr.String())
+	}
+	return parseComposeImages(output)
+}
+
+// ImageDigests returns the repository digests of the image a container runs.
+func (s *cliDockerService) ImageDigests(containerID string) ([]string, error) {
+	image, err := exec.Command("docker", "inspect", "--format", "{{.Image}}", containerID).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect container %s: %w", containerID, err)
+	}
+	output, err := exec.Command("docker", "image", "inspect", "--format", "{{json .RepoDigests}}", strings.TrimSpace(string(image))).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect image of container %s: %w", containerID, err)
+	}
+	var digests []string
+	if err := json.Unmarshal(output, &digests); err != nil {
+		return nil, fmt.Errorf("failed to parse image digests of container %s: %w", containerID, err)
+	}
+	return digests, nil
+}
+
+// parseComposeImages decodes the images of the services in the output of
+// 'docker compose config --format json', skipping services that are built.
+func parseComposeImages(output []byte) (map[string]string, error) {
+	var config struct {
+		Services map[string]struct {
+			Image string          `json:"image"`
+			Build json.RawMessage `json:"build"`
+		} `json:"services"`
+	}
+	if err := json.Unmarshal(output, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse docker compose config output: %w", err)
+	}
+
+	images := map[string]string{}
+	for name, service := range config.Services {
+		if service.Image != "" && len(service.Build) == 0 {
+			images[name] = service.Image
+		}
+	}
+	return images, nil
+}
+
+// parseComposePs decodes the output of 'docker compose ps --format json', which is
+// a JSON array up to Compose v2.20 and one JSON object per line since then.
+func parseComposePs(output []byte) ([]Container, error) {
+	output = bytes.TrimSpace(output)
+	containers := []Container{}
+	if len(output) == 0 {
+		return containers, nil
+	}
+
+	if output[0] == '[' {
+		if err := json.Unmarshal(output, &containers); err != nil {
+			return nil, fmt.Errorf("failed to parse docker compose ps output: %w", err)
+		}
+		return containers, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(output))
+	for decoder.More() {
+		var container Container
+		if err := decoder.Decode(&container); err != nil {
+			return nil, fmt.Errorf("failed to parse docker compose ps output: %w", err)
+		}
+		containers = append(containers, container)
+	}
+	return containers, nil
+}
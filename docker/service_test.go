@@ -0,0 +1,114 @@
+package docker
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseComposePs(t *testing.T) {
+	expected := []Container{
+		{ID: "abc", Name: "app-web-1", Service: "web", Image: "nginx", State: "running", Health: "healthy", Status: "Up 2 hours (healthy)"},
+		{ID: "def", Name: "app-db-1", Service: "db", Image: "postgres", State: "exited", ExitCode: 1, Status: "Exited (1) 5 minutes ago"},
+	}
+
+	testCases := []struct {
+		name     string
+		output   string
+		expected []Container
+	}{
+		{
+			name: "One object per line",
+			output: `{"ID":"abc","Name":"app-web-1","Service":"web","Image":"nginx","State":"running","Health":"healthy","ExitCode":0,"Status":"Up 2 hours (healthy)"}
+{"ID":"def","Name":"app-db-1","Service":"db","Image":"postgres","State":"exited","Health":"","ExitCode":1,"Status":"Exited (1) 5 minutes ago"}
+`,
+			expected: expected,
+		},
+		{
+			name:     "JSON array from older Compose versions",
+			output:   `[{"ID":"abc","Name":"app-web-1","Service":"web","Image":"nginx","State":"running","Health":"healthy","ExitCode":0,"Status":"Up 2 hours (healthy)"},{"ID":"def","Name":"app-db-1","Service":"db","Image":"postgres","State":"exited","Health":"","ExitCode":1,"Status":"Exited (1) 5 minutes ago"}]`,
+			expected: expected,
+		},
+		{
+			name:     "No containers",
+			output:   "\n",
+			expected: []Container{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			containers, err := parseComposePs([]byte(tc.output))
+			if err != nil {
+				t.Fatalf("Expected no error, but got %v", err)
+			}
+			if !reflect.DeepEqual(containers, tc.expected) {
+				t.Errorf("Unexpected containers.\nGot:      %+v\nExpected: %+v", containers, tc.expected)
+			}
+		})
+	}
+
+	t.Run("Invalid output returns error", func(t *testing.T) {
+		if _, err := parseComposePs([]byte("not json")); err == nil {
+			t.Fatal("Expected an error for invalid output, but got nil")
+		}
+	})
+}
+
+func TestParseComposeImages(t *testing.T) {
+	output := `{"name":"app","services":{
+		"web":{"image":"nginx:1.27"},
+		"api":{"image":"ghcr.io/team/api:1","build":{"context":"/srv/app/api"}},
+		"worker":{"build":{"context":"/srv/app/worker"}}
+	}}`
+
+	images, err := parseComposeImages([]byte(output))
+	if err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	if expected := map[string]string{"web": "nginx:1.27"}; !reflect.DeepEqual(images, expected) {
+		t.Errorf("Expected %v, but got %v", expected, images)
+	}
+}
+
+func TestComposeUpArgs(t *testing.T) {
+	testCases := []struct {
+		name     string
+		options  ComposeOptions
+		expected []string
+	}{
+		{name: "Foreground", options: ComposeOptions{Files: []string{"compose.yml"}}, expected: []string{"compose", "-f", "compose.yml", "up"}},
+		{name: "Detached", options: ComposeOptions{Files: []string{"compose.yml"}, Detach: true}, expected: []string{"compose", "-f", "compose.yml", "up", "-d"}},
+		{name: "Waiting rounds up to seconds", options: ComposeOptions{Files: []string{"compose.yml"}, Detach: true, WaitTimeout: 1500 * time.Millisecond}, expected: []string{"compose", "-f", "compose.yml", "up", "-d", "--wait", "--wait-timeout", "2"}},
+		{name: "Override files in order", options: ComposeOptions{Files: []string{"compose.yml", "compose.prod.yml"}, Detach: true}, expected: []string{"compose", "-f", "compose.yml", "-f", "compose.prod.yml", "up", "-d"}},
+		{
+			name: "Project and up options",
+			options: ComposeOptions{
+				Files:            []string{"compose.yml"},
+				ProjectName:      "web",
+				ProjectDirectory: "/srv/web",
+				Profiles:         []string{"prod", "metrics"},
+				EnvFiles:         []string{"/srv/web/.env", "/srv/web/prod.env"},
+				Detach:           true,
+				RemoveOrphans:    true,
+				Build:            true,
+				Pull:             "always",
+				ForceRecreate:    true,
+			},
+			expected: []string{
+				"compose", "-p", "web", "--project-directory", "/srv/web",
+				"--env-file", "/srv/web/.env", "--env-file", "/srv/web/prod.env",
+				"--profile", "prod", "--profile", "metrics", "-f", "compose.yml",
+				"up", "-d", "--remove-orphans", "--build", "--pull", "always", "--force-recreate",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := ComposeUpArgs(tc.options); !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("Expected %v, but got %v", tc.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package git
+
+import (
+	"cmp"
+	"fmt"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+)
+
+// Environment variables the credential helper of the git command reads the
+// https credentials from, so they never show up in its arguments or config
+const (
+	usernameEnv = "VOYAGE_GIT_USERNAME"
+	tokenEnv    = "VOYAGE_GIT_TOKEN"
+)
+
+// defaultUsername is sent with a token when no username is set, hosts that
+// authenticate by token alone accept any username
+const defaultUsername = "git"
+
+// credentialHelper answers git's requests for https credentials from usernameEnv and tokenEnv
+const credentialHelper = `!f() { test "$1" = get && printf 'username=%s\npassword=%s\n' "$` + usernameEnv + `" "$` + tokenEnv + `"; }; f`
+
+// Auth holds the credentials used to talk to the remote. They are passed to every
+// clone, fetch and pull, and never stored in the clone. An empty Auth leaves
+// authentication to the git and ssh configuration of the host.
+type Auth struct {
+	// SSHKeyFile is the private key used for ssh remotes
+	SSHKeyFile string
+	// KnownHostsFile is the only file the host keys of ssh remotes are checked
+	// against, unknown hosts are rejected
+	KnownHostsFile string
+	// Username and Token are sent to https remotes, Username defaults to defaultUsername
+	Username string
+	Token    string
+}
+
+// gitArgs returns the options the git command talks to the remote with, they go
+// before the git subcommand so they are never written to the config of a clone
+func (a Auth) gitArgs() []string {
+	if a.Token == "" {
+		return nil
+	}
+	// An empty helper drops the helpers of the host configuration
+	return []string{"-c", "credential.helper=", "-c", "credential.helper=" + credentialHelper}
+}
+
+// env returns the environment the git command talks to the remote with
+func (a Auth) env() []string {
+	var env []string
+	if sshCommand := a.sshCommand(); sshCommand != "" {
+		env = append(env, "GIT_SSH_COMMAND="+sshCommand)
+	}
+	if a.Token != "" {
+		env = append(env,
+			usernameEnv+"="+cmp.Or(a.Username, defaultUsername),
+			tokenEnv+"="+a.Token,
+			"GIT_TERMINAL_PROMPT=0",
+		)
+	}
+	return env
+}
+
+// sshCommand returns the ssh command git connects to ssh remotes with, or an
+// empty string to keep the default one
+func (a Auth) sshCommand() string {
+	if a.SSHKeyFile == "" && a.KnownHostsFile == "" {
+		return ""
+	}
+	args := []string{"ssh"}
+	if a.SSHKeyFile != "" {
+		args = append(args, "-i", shellQuote(a.SSHKeyFile), "-o", "IdentitiesOnly=yes")
+	}
+	if a.KnownHostsFile != "" {
+		args = append(args, "-o", "UserKnownHostsFile="+shellQuote(a.KnownHostsFile), "-o", "StrictHostKeyChecking=yes")
+	}
+	return strings.Join(args, " ")
+}
+
+// shellQuote quotes s for the shell git runs GIT_SSH_COMMAND with
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// method returns the go-git authentication for the remote at url, or nil when
+// Auth has nothing for its protocol
+func (a Auth) method(url string) (transport.AuthMethod, error) {
+	endpoint, err := transport.NewEndpoint(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse remote url: %w", err)
+	}
+
+	switch endpoint.Protocol {
+	case "ssh":
+		if a.SSHKeyFile == "" && a.KnownHostsFile == "" {
+			return nil, nil
+		}
+		hostKeys := gitssh.HostKeyCallbackHelper{}
+		if a.KnownHostsFile != "" {
+			callback, err := gitssh.NewKnownHostsCallback(a.KnownHostsFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read known hosts %s: %w", a.KnownHostsFile, err)
+			}
+			hostKeys.HostKeyCallback = callback
+		}
+
+		user := cmp.Or(endpoint.User, defaultUsername)
+		if a.SSHKeyFile == "" {
+			agent, err := gitssh.NewSSHAgentAuth(user)
+			if err != nil {
+				return nil, fmt.Errorf("failed to connect to ssh agent: %w", err)
+			}
+			agent.HostKeyCallbackHelper = hostKeys
+			return agent, nil
+		}
+		keys, err := gitssh.NewPublicKeysFromFile(user, a.SSHKeyFile, "")
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ssh key %s: %w", a.SSHKeyFile, err)
+		}
+		keys.HostKeyCallbackHelper = hostKeys
+		return keys, nil
+	case "http", "https":
+		if a.Token == "" {
+			return nil, nil
+		}
+		return &githttp.BasicAuth{Username: cmp.Or(a.Username, defaultUsername), Password: a.Token}, nil
+	default:
+		return nil, nil
+	}
+}
@@ -0,0 +1,106 @@
+package git
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+)
+
+func TestAuth(t *testing.T) {
+	t.Run("Empty auth leaves git alone", func(t *testing.T) {
+		if args, env := (Auth{}).gitArgs(), (Auth{}).env(); args != nil || env != nil {
+			t.Errorf("Expected no args and env, but got %v and %v", args, env)
+		}
+	})
+
+	t.Run("Connects to ssh remotes with the key and known hosts", func(t *testing.T) {
+		auth := Auth{SSHKeyFile: "/etc/voyage/deploy key", KnownHostsFile: "/etc/voyage/known_hosts"}
+
+		expected := []string{"GIT_SSH_COMMAND=ssh -i '/etc/voyage/deploy key' -o IdentitiesOnly=yes -o UserKnownHostsFile='/etc/voyage/known_hosts' -o StrictHostKeyChecking=yes"}
+		if env := auth.env(); !reflect.DeepEqual(env, expected) {
+			t.Errorf("Expected env %v, but got %v", expected, env)
+		}
+	})
+
+	t.Run("Quotes paths for the shell", func(t *testing.T) {
+		if quoted := shellQuote("it's"); quoted != `'it'\''s'` {
+			t.Errorf("Expected a quoted path, but got %s", quoted)
+		}
+	})
+
+	t.Run("Keeps the token out of the git arguments", func(t *testing.T) {
+		auth := Auth{Token: "s3cret"}
+
+		if args := strings.Join(auth.gitArgs(), " "); strings.Contains(args, "s3cret") {
+			t.Errorf("Expected the token not to be in the arguments, but got %s", args)
+		}
+		env := auth.env()
+		for _, variable := range []string{usernameEnv + "=git", tokenEnv + "=s3cret", "GIT_TERMINAL_PROMPT=0"} {
+			if !strings.Contains(strings.Join(env, "\n"), variable) {
+				t.Errorf("Expected %s in the env, but got %v", variable, env)
+			}
+		}
+	})
+
+	t.Run("Credential helper answers with the username and token", func(t *testing.T) {
+		auth := Auth{Username: "deploy", Token: "s3cret"}
+
+		cmd := exec.Command("git", append(auth.gitArgs(), "credential", "fill")...)
+		cmd.Env = append(os.Environ(), auth.env()...)
+		cmd.Stdin = strings.NewReader("protocol=https\nhost=git.example.com\n\n")
+		output, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if !strings.Contains(string(output), "username=deploy\n") || !strings.Contains(string(output), "password=s3cret\n") {
+			t.Errorf("Expected the username and token, but got %q", output)
+		}
+	})
+
+	t.Run("Clone never writes the credentials to the config", func(t *testing.T) {
+		remote := newTestRemote(t)
+		remote.commit(map[string]string{"app/compose.yml": "v1"})
+		path := filepath.Join(t.TempDir(), "out")
+
+		if err := NewCliGitService(Auth{Token: "s3cret"}).Clone(path, remote.URL, "main", CloneOptions{}); err != nil {
+			t.Fatalf("Expected clone to succeed, but got %v", err)
+		}
+		if config := readFile(t, filepath.Join(path, ".git", "config")); strings.Contains(config, "s3cret") || strings.Contains(config, "helper") {
+			t.Errorf("Expected no credentials in the config, but got %s", config)
+		}
+	})
+
+	t.Run("Picks the go-git authentication by protocol", func(t *testing.T) {
+		auth := Auth{Token: "s3cret"}
+
+		method, err := auth.method("https://git.example.com/infra.git")
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if basic, ok := method.(*githttp.BasicAuth); !ok || basic.Username != "git" || basic.Password != "s3cret" {
+			t.Errorf("Expected basic auth with the token, but got %v", method)
+		}
+		if strings.Contains(method.String(), "s3cret") {
+			t.Errorf("Expected the token to be masked, but got %s", method.String())
+		}
+
+		for _, url := range []string{"git@git.example.com:infra.git", "/srv/git/infra.git"} {
+			if method, err := auth.method(url); err != nil || method != nil {
+				t.Errorf("Expected no authentication for %s, but got %v, %v", url, method, err)
+			}
+		}
+	})
+
+	t.Run("Fails when the ssh key cannot be read", func(t *testing.T) {
+		auth := Auth{SSHKeyFile: filepath.Join(t.TempDir(), "missing")}
+
+		if _, err := auth.method("ssh://git@git.example.com/infra.git"); err == nil {
+			t.Fatal("Expected an error, but got nil")
+		}
+	})
+}
@@ -0,0 +1,428 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	"github.com/go-git/go-git/v5/plumbing/transport/server"
+)
+
+// installFileTransport replaces the go-git transport for local repositories, which
+// runs git-upload-pack, with one that serves them in-process.
+var installFileTransport = sync.OnceFunc(func() {
+	client.InstallProtocol("file", server.NewClient(server.DefaultLoader))
+})
+
+// errUnsupported is returned for the operations the go backend cannot run
+var errUnsupported = errors.New("not supported by the go git backend")
+
+// goGitService is the implementation of GitService written in pure Go, for
+// systems without the git binary.
+type goGitService struct {
+	auth Auth
+}
+
+func NewGoGitService(auth Auth) GitService {
+	installFileTransport()
+	return &goGitService{auth: auth}
+}
+
+// remoteAuth returns the authentication for the origin remote of repo
+func (s *goGitService) remoteAuth(repo *gogit.Repository) (transport.AuthMethod, error) {
+	remote, err := repo.Remote("origin")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read remote: %w", err)
+	}
+	return s.auth.method(remote.Config().URLs[0])
+}
+
+func (s *goGitService) IsGitRepository(path string) bool {
+	_, err := gogit.PlainOpen(path)
+	return err == nil
+}
+
+func (s *goGitService) Fetch(path string) error {
+	repo, err := open(path)
+	if err != nil {
+		return err
+	}
+
+	auth, err := s.remoteAuth(repo)
+	if err != nil {
+		return err
+	}
+
+	err = repo.Fetch(&gogit.FetchOptions{RemoteName: "origin", Auth: auth})
+	if err != nil && !errors.Is(err, gogit.NoErrAlreadyUpToDate) {
+		return fmt.Errorf("failed to fetch: %w", err)
+	}
+	return nil
+}
+
+func (s *goGitService) FetchTags(path string) error {
+	repo, err := open(path)
+	if err != nil {
+		return err
+	}
+
+	auth, err := s.remoteAuth(repo)
+	if err != nil {
+		return err
+	}
+
+	err = repo.Fetch(&gogit.FetchOptions{
+		RemoteName: "origin",
+		Auth:       auth,
+		RefSpecs:   []config.RefSpec{"+refs/tags/*:refs/tags/*"},
+		Prune:      true,
+	})
+	if err != nil && !errors.Is(err, gogit.NoErrAlreadyUpToDate) {
+		return fmt.Errorf("failed to fetch tags: %w", err)
+	}
+	return nil
+}
+
+func (s *goGitService) Tags(path string) ([]Tag, error) {
+	repo, err := open(path)
+	if err != nil {
+		return nil, err
+	}
+	refs, err := repo.Tags()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tags: %w", err)
+	}
+
+	var tags []Tag
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		tag := Tag{Name: ref.Name().Short()}
+		if object, err := repo.TagObject(ref.Hash()); err == nil {
+			tag.Date = object.Tagger.When
+		} else if commit, err := repo.CommitObject(ref.Hash()); err == nil {
+			tag.Date = commit.Committer.When
+		}
+		tags = append(tags, tag)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tags: %w", err)
+	}
+	return tags, nil
+}
+
+// FetchCommit is not supported, a commit missing from a shallow clone counts as unknown
+func (s *goGitService) FetchCommit(path, commit string) error {
+	return fmt.Errorf("%w: fetching commit %s", errUnsupported, commit)
+}
+
+func (s *goGitService) SparseCheckout(path string, dirs []string) error {
+	return fmt.Errorf("%w: sparse checkouts", errUnsupported)
+}
+
+func (s *goGitService) IsBehindRemote(path, branch string) (bool, error) {
+	behindCount, err := s.CountCommits(path, branch, "origin/"+branch)
+	if err != nil {
+		return false, fmt.Errorf("failed to check if behind remote: %w", err)
+	}
+
+	return behindCount > 0, nil
+}
+
+// CountCommits returns the number of commits reachable from to but not from from
+func (s *goGitService) CountCommits(path, from, to string) (int, error) {
+	repo, err := open(path)
+	if err != nil {
+		return 0, err
+	}
+	fromCommit, err := resolveCommit(repo, from)
+	if err != nil {
+		return 0, err
+	}
+	toCommit, err := resolveCommit(repo, to)
+	if err != nil {
+		return 0, err
+	}
+	if fromCommit.Hash == toCommit.Hash {
+		return 0, nil
+	}
+
+	reachable := map[plumbing.Hash]bool{}
+	err = object.NewCommitPreorderIter(fromCommit, nil, nil).ForEach(func(c *object.Commit) error {
+		reachable[c.Hash] = true
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to walk history of %s: %w", from, err)
+	}
+
+	count := 0
+	err = object.NewCommitPreorderIter(toCommit, reachable, nil).ForEach(func(c *object.Commit) error {
+		count++
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to count commits between %s and %s: %w", from, to, err)
+	}
+	return count, nil
+}
+
+func (s *goGitService) Pull(path, branch string) error {
+	repo, err := open(path)
+	if err != nil {
+		return err
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return fmt.Errorf("failed to pull: %w", err)
+	}
+	auth, err := s.remoteAuth(repo)
+	if err != nil {
+		return err
+	}
+
+	err = worktree.Pull(&gogit.PullOptions{
+		RemoteName:    "origin",
+		Auth:          auth,
+		ReferenceName: plumbing.NewBranchReferenceName(branch),
+		SingleBranch:  true,
+	})
+	if err != nil && !errors.Is(err, gogit.NoErrAlreadyUpToDate) {
+		return fmt.Errorf("failed to pull: %w", err)
+	}
+	return nil
+}
+
+func (s *goGitService) Switch(path, rev string) error {
+	repo, err := open(path)
+	if err != nil {
+		return err
+	}
+	commit, err := resolveCommit(repo, rev)
+	if err != nil {
+		return err
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return fmt.Errorf("failed to switch to %s: %w", rev, err)
+	}
+
+	if err := worktree.Checkout(&gogit.CheckoutOptions{Hash: commit.Hash}); err != nil {
+		return fmt.Errorf("failed to switch to %s: %w", rev, err)
+	}
+	return nil
+}
+
+// Clone clones the repository, truncating its history to options.Depth. Partial
+// and sparse clones are not supported.
+func (s *goGitService) Clone(path, url, branch string, options CloneOptions) error {
+	if options.Filter != "" || options.SparseDirs != nil {
+		return fmt.Errorf("%w: partial and sparse clones", errUnsupported)
+	}
+	auth, err := s.auth.method(url)
+	if err != nil {
+		return err
+	}
+
+	// An empty reference clones the default branch
+	var reference plumbing.ReferenceName
+	if branch != "" {
+		reference = plumbing.NewBranchReferenceName(branch)
+	}
+	_, err = gogit.PlainClone(path, false, &gogit.CloneOptions{
+		URL:           url,
+		Auth:          auth,
+		ReferenceName: reference,
+		SingleBranch:  true,
+		Depth:         options.Depth,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to clone repository: %w", err)
+	}
+	return nil
+}
+
+// ChangedFiles lists the files in subDir that differ between from and to
+func (s *goGitService) ChangedFiles(path, from, to, subDir string) ([]string, error) {
+	repo, err := open(path)
+	if err != nil {
+		return nil, err
+	}
+	fromTree, err := commitTree(repo, from)
+	if err != nil {
+		return nil, err
+	}
+	toTree, err := commitTree(repo, to)
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := object.DiffTree(fromTree, toTree)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get diff for subdirectory %s: %w", subDir, err)
+	}
+
+	var files []string
+	for _, change := range changes {
+		for _, name := range []string{change.From.Name, change.To.Name} {
+			if name != "" && inSubDir(name, subDir) && !slices.Contains(files, name) {
+				files = append(files, name)
+			}
+		}
+	}
+	slices.Sort(files)
+	return files, nil
+}
+
+func (s *goGitService) HasCommit(path, rev string) bool {
+	_, err := s.RevParse(path, rev)
+	return err == nil
+}
+
+func (s *goGitService) RevParse(path, rev string) (string, error) {
+	repo, err := open(path)
+	if err != nil {
+		return "", err
+	}
+	commit, err := resolveCommit(repo, rev)
+	if err != nil {
+		return "", err
+	}
+	return commit.Hash.String(), nil
+}
+
+// Checkout restores subDirs in the working tree and index to their content at rev,
+// removing files that do not exist at rev. HEAD is left where it is.
+func (s *goGitService) Checkout(path, rev string, subDirs []string) error {
+	repo, err := open(path)
+	if err != nil {
+		return err
+	}
+	tree, err := commitTree(repo, rev)
+	if err != nil {
+		return err
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return err
+	}
+	index, err := repo.Storer.Index()
+	if err != nil {
+		return err
+	}
+
+	for _, subDir := range subDirs {
+		// Tracked files that do not exist at rev are removed
+		for _, entry := range index.Entries {
+			if !inSubDir(entry.Name, subDir) {
+				continue
+			}
+			if _, err := tree.FindEntry(entry.Name); errors.Is(err, object.ErrEntryNotFound) || errors.Is(err, object.ErrDirectoryNotFound) {
+				if _, err := worktree.Remove(entry.Name); err != nil && !errors.Is(err, os.ErrNotExist) {
+					return fmt.Errorf("failed to check out %s at %s: %w", subDir, rev, err)
+				}
+			}
+		}
+
+		err := tree.Files().ForEach(func(file *object.File) error {
+			if !inSubDir(file.Name, subDir) {
+				return nil
+			}
+			if err := writeFile(path, file); err != nil {
+				return err
+			}
+			_, err := worktree.Add(file.Name)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to check out %s at %s: %w", subDir, rev, err)
+		}
+	}
+	return nil
+}
+
+// open opens the repository at path, returning ErrNotRepository if there is none
+func open(path string) (*gogit.Repository, error) {
+	repo, err := gogit.PlainOpen(path)
+	if errors.Is(err, gogit.ErrRepositoryNotExists) {
+		return nil, fmt.Errorf("%w: %s", ErrNotRepository, path)
+	}
+	return repo, err
+}
+
+// resolveCommit returns the commit rev refers to, or ErrUnknownRevision
+func resolveCommit(repo *gogit.Repository, rev string) (*object.Commit, error) {
+	hash, err := repo.ResolveRevision(plumbing.Revision(rev))
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w: %w", rev, ErrUnknownRevision, err)
+	}
+	commit, err := repo.CommitObject(*hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w: %w", rev, ErrUnknownRevision, err)
+	}
+	return commit, nil
+}
+
+func commitTree(repo *gogit.Repository, rev string) (*object.Tree, error) {
+	commit, err := resolveCommit(repo, rev)
+	if err != nil {
+		return nil, err
+	}
+	return commit.Tree()
+}
+
+// inSubDir reports whether the repository path name is inside subDir, where an
+// empty subDir is the root of the repository
+func inSubDir(name, subDir string) bool {
+	return subDir == "" || name == subDir || strings.HasPrefix(name, strings.TrimSuffix(subDir, "/")+"/")
+}
+
+// writeFile writes file into the working tree at root, replacing what is there
+func writeFile(root string, file *object.File) error {
+	target := filepath.Join(root, filepath.FromSlash(file.Name))
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	if err := os.Remove(target); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	reader, err := file.Reader()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	if file.Mode == filemode.Symlink {
+		link, err := io.ReadAll(reader)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(string(link), target)
+	}
+
+	perm := os.FileMode(0o644)
+	if file.Mode == filemode.Executable {
+		perm = 0o755
+	}
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, reader); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
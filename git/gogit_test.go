@@ -0,0 +1,234 @@
+package git
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// testRemote is a local bare repository fed by commits from a seed repository
+type testRemote struct {
+	t    *testing.T
+	URL  string
+	seed *gogit.Repository
+	dir  string
+}
+
+func newTestRemote(t *testing.T) *testRemote {
+	t.Helper()
+	dir := t.TempDir()
+	url := filepath.Join(dir, "remote.git")
+	_, err := gogit.PlainInitWithOptions(url, &gogit.PlainInitOptions{
+		InitOptions: gogit.InitOptions{DefaultBranch: plumbing.Main},
+		Bare:        true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seedDir := filepath.Join(dir, "seed")
+	seed, err := gogit.PlainInitWithOptions(seedDir, &gogit.PlainInitOptions{
+		InitOptions: gogit.InitOptions{DefaultBranch: plumbing.Main},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := seed.CreateRemote(&config.RemoteConfig{Name: "origin", URLs: []string{url}}); err != nil {
+		t.Fatal(err)
+	}
+	return &testRemote{t: t, URL: url, seed: seed, dir: seedDir}
+}
+
+// commit writes files, removing those with empty content, and pushes them to the remote
+func (r *testRemote) commit(files map[string]string) string {
+	r.t.Helper()
+	worktree, err := r.seed.Worktree()
+	if err != nil {
+		r.t.Fatal(err)
+	}
+
+	for name, content := range files {
+		path := filepath.Join(r.dir, name)
+		if content == "" {
+			if _, err := worktree.Remove(name); err != nil {
+				r.t.Fatal(err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			r.t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			r.t.Fatal(err)
+		}
+		if _, err := worktree.Add(name); err != nil {
+			r.t.Fatal(err)
+		}
+	}
+
+	hash, err := worktree.Commit("change", &gogit.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+	})
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	if err := r.seed.Push(&gogit.PushOptions{RemoteName: "origin"}); err != nil {
+		r.t.Fatal(err)
+	}
+	return hash.String()
+}
+
+// testTaggerDate is the date of the annotated tags of testRemote
+var testTaggerDate = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// tag tags commit as name and pushes the tag to the remote, annotated when message is set
+func (r *testRemote) tag(name, commit, message string) {
+	r.t.Helper()
+	var options *gogit.CreateTagOptions
+	if message != "" {
+		options = &gogit.CreateTagOptions{
+			Tagger:  &object.Signature{Name: "test", Email: "test@example.com", When: testTaggerDate},
+			Message: message,
+		}
+	}
+	if _, err := r.seed.CreateTag(name, plumbing.NewHash(commit), options); err != nil {
+		r.t.Fatal(err)
+	}
+	refSpec := config.RefSpec("refs/tags/" + name + ":refs/tags/" + name)
+	if err := r.seed.Push(&gogit.PushOptions{RemoteName: "origin", RefSpecs: []config.RefSpec{refSpec}}); err != nil {
+		r.t.Fatal(err)
+	}
+}
+
+// deleteTag deletes the tag name from the remote
+func (r *testRemote) deleteTag(name string) {
+	r.t.Helper()
+	refSpec := config.RefSpec(":refs/tags/" + name)
+	if err := r.seed.Push(&gogit.PushOptions{RemoteName: "origin", RefSpecs: []config.RefSpec{refSpec}}); err != nil {
+		r.t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func TestGoGitService(t *testing.T) {
+	remote := newTestRemote(t)
+	c1 := remote.commit(map[string]string{"app1/compose.yml": "v1", "app2/compose.yml": "v1"})
+
+	s := NewGoGitService(Auth{})
+	path := filepath.Join(t.TempDir(), "out")
+
+	if err := s.Clone(path, remote.URL, "main", CloneOptions{}); err != nil {
+		t.Fatalf("Expected clone to succeed, but got %v", err)
+	}
+	if !s.IsGitRepository(path) {
+		t.Fatal("Expected clone to be a git repository")
+	}
+	if head, err := s.RevParse(path, "HEAD"); err != nil || head != c1 {
+		t.Fatalf("Expected HEAD at %s, but got %s, %v", c1, head, err)
+	}
+
+	c2 := remote.commit(map[string]string{"app1/compose.yml": "v2", "app1/.env": "A=1"})
+
+	t.Run("Fetch detects the new commit", func(t *testing.T) {
+		if err := s.Fetch(path); err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if behind, err := s.IsBehindRemote(path, "main"); err != nil || !behind {
+			t.Errorf("Expected to be behind remote, but got %v, %v", behind, err)
+		}
+		if count, err := s.CountCommits(path, c1, "origin/main"); err != nil || count != 1 {
+			t.Errorf("Expected 1 commit, but got %d, %v", count, err)
+		}
+		if !s.HasCommit(path, c2) {
+			t.Error("Expected fetched commit to be known")
+		}
+	})
+
+	t.Run("ChangedFiles lists the files of a subdirectory", func(t *testing.T) {
+		files, err := s.ChangedFiles(path, c1, "origin/main", "app1")
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if expected := []string{"app1/.env", "app1/compose.yml"}; !reflect.DeepEqual(files, expected) {
+			t.Errorf("Expected %v, but got %v", expected, files)
+		}
+
+		if files, err := s.ChangedFiles(path, c1, "origin/main", "app2"); err != nil || len(files) != 0 {
+			t.Errorf("Expected no changes in app2, but got %v, %v", files, err)
+		}
+		if files, err := s.ChangedFiles(path, c1, "origin/main", ""); err != nil || len(files) != 2 {
+			t.Errorf("Expected 2 changes in the repository, but got %v, %v", files, err)
+		}
+	})
+
+	t.Run("Pull fast-forwards to the remote", func(t *testing.T) {
+		if err := s.Pull(path, "main"); err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if head, err := s.RevParse(path, "HEAD"); err != nil || head != c2 {
+			t.Errorf("Expected HEAD at %s, but got %s, %v", c2, head, err)
+		}
+		if behind, err := s.IsBehindRemote(path, "main"); err != nil || behind {
+			t.Errorf("Expected to be up to date, but got %v, %v", behind, err)
+		}
+	})
+
+	t.Run("Checkout restores a subdirectory without moving HEAD", func(t *testing.T) {
+		if err := s.Checkout(path, c1[:7], []string{"app1"}); err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if content := readFile(t, filepath.Join(path, "app1/compose.yml")); content != "v1" {
+			t.Errorf("Expected app1 at v1, but got %q", content)
+		}
+		if _, err := os.Stat(filepath.Join(path, "app1/.env")); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("Expected file added later to be removed, but got %v", err)
+		}
+		if head, _ := s.RevParse(path, "HEAD"); head != c2 {
+			t.Errorf("Expected HEAD to stay at %s, but got %s", c2, head)
+		}
+
+		if err := s.Checkout(path, "HEAD", []string{"app1"}); err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if content := readFile(t, filepath.Join(path, "app1/.env")); content != "A=1" {
+			t.Errorf("Expected app1/.env to be restored, but got %q", content)
+		}
+
+		// The restored worktree must be clean enough to pull into
+		c3 := remote.commit(map[string]string{"app1/.env": ""})
+		if err := s.Fetch(path); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Pull(path, "main"); err != nil {
+			t.Fatalf("Expected pull after restore to succeed, but got %v", err)
+		}
+		if head, _ := s.RevParse(path, "HEAD"); head != c3 {
+			t.Errorf("Expected HEAD at %s, but got %s", c3, head)
+		}
+	})
+
+	t.Run("Errors are typed", func(t *testing.T) {
+		if _, err := s.RevParse(path, "does-not-exist"); !errors.Is(err, ErrUnknownRevision) {
+			t.Errorf("Expected ErrUnknownRevision, but got %v", err)
+		}
+		if err := s.Fetch(t.TempDir()); !errors.Is(err, ErrNotRepository) {
+			t.Errorf("Expected ErrNotRepository, but got %v", err)
+		}
+	})
+}
@@ -1,27 +1,94 @@
 package git
 
 import (
+	"errors"
 	"fmt"
+	"os"
 	"os/exec"
 	"strconv"
 	"strings"
+	"time"
 )
 
+var (
+	// ErrNotRepository is returned when a path is not a git repository
+	ErrNotRepository = errors.New("not a git repository")
+	// ErrUnknownRevision is returned when a revision does not resolve to a commit
+	ErrUnknownRevision = errors.New("unknown revision")
+)
+
+// Backends a GitService can be created for
+const (
+	BackendCLI = "cli"
+	BackendGo  = "go"
+)
+
+// NewGitService creates the GitService for backend, which talks to the remote with auth
+func NewGitService(backend string, auth Auth) (GitService, error) {
+	switch backend {
+	case "", BackendCLI:
+		return NewCliGitService(auth), nil
+	case BackendGo:
+		return NewGoGitService(auth), nil
+	default:
+		return nil, fmt.Errorf("unknown git backend %q, expected %s or %s", backend, BackendCLI, BackendGo)
+	}
+}
+
+// CloneOptions limits how much of the repository a clone downloads and checks out
+type CloneOptions struct {
+	// Depth truncates the history to that many commits, 0 clones all of it
+	Depth int
+	// Filter is a partial clone filter such as blob:none. The objects it leaves out
+	// are downloaded when they are needed.
+	Filter string
+	// SparseDirs are the only directories checked out besides the files at the
+	// root, in cone mode. Nil checks out everything.
+	SparseDirs []string
+}
+
 // GitService defines a set of high-level Git operations.
 type GitService interface {
-	Clone(path, url, branch string) error
+	// Clone clones branch, or the default branch of the remote when it is empty
+	Clone(path, url, branch string, options CloneOptions) error
 	Fetch(path string) error
+	// FetchTags fetches the tags of the remote, dropping the ones it deleted
+	FetchTags(path string) error
+	// Tags lists the tags of the repository with the dates they were created
+	Tags(path string) ([]Tag, error)
+	// FetchCommit downloads commit, which a shallow clone may lack
+	FetchCommit(path, commit string) error
+	// SparseCheckout replaces the directories of a sparse clone
+	SparseCheckout(path string, dirs []string) error
 	IsBehindRemote(path, branch string) (bool, error)
+	CountCommits(path, from, to string) (int, error)
 	Pull(path, branch string) error
-	HasChangesInSubdir(path, branch, subDir string) (bool, error)
+	// Switch moves a detached HEAD and the working tree to rev
+	Switch(path, rev string) error
+	ChangedFiles(path, from, to, subDir string) ([]string, error)
 	IsGitRepository(path string) bool
+	HasCommit(path, rev string) bool
+	RevParse(path, rev string) (string, error)
+	Checkout(path, rev string, subDirs []string) error
 }
 
 // cliGitService is the implementation of GitService that uses the git command line.
-type cliGitService struct{}
+type cliGitService struct {
+	auth Auth
+}
 
-func NewCliGitService() GitService {
-	return &cliGitService{}
+func NewCliGitService(auth Auth) GitService {
+	return &cliGitService{auth: auth}
+}
+
+// remoteCommand creates a git command that talks to the remote, authenticated with
+// the credentials of the service
+func (s *cliGitService) remoteCommand(args ...string) *exec.Cmd {
+	cmd := exec.Command("git", append(s.auth.gitArgs(), args...)...)
+	if env := s.auth.env(); len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	return cmd
 }
 
 func (s *cliGitService) IsGitRepository(path string) bool {
@@ -32,7 +99,7 @@ func (s *cliGitService) IsGitRepository(path string) bool {
 }
 
 func (s *cliGitService) Fetch(path string) error {
-	cmd := exec.Command("git", "fetch", "origin")
+	cmd := s.remoteCommand("fetch", "origin")
 	cmd.Dir = path
 	output, err := cmd.CombinedOutput()
 	if err != nil {
@@ -41,25 +108,88 @@ func (s *cliGitService) Fetch(path string) error {
 	return nil
 }
 
+func (s *cliGitService) FetchTags(path string) error {
+	cmd := s.remoteCommand("fetch", "--tags", "--force", "--prune", "--prune-tags", "origin")
+	cmd.Dir = path
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to fetch tags: %w, output: %s", err, string(output))
+	}
+	return nil
+}
+
+func (s *cliGitService) Tags(path string) ([]Tag, error) {
+	// The creator date is the tagger date of annotated tags, and the committer date otherwise
+	cmd := exec.Command("git", "tag", "--list", "--format=%(creatordate:unix) %(refname:short)")
+	cmd.Dir = path
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tags: %w", err)
+	}
+
+	var tags []Tag
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		date, name, ok := strings.Cut(line, " ")
+		if !ok {
+			continue
+		}
+		seconds, _ := strconv.ParseInt(date, 10, 64)
+		tags = append(tags, Tag{Name: name, Date: time.Unix(seconds, 0)})
+	}
+	return tags, nil
+}
+
+func (s *cliGitService) FetchCommit(path, commit string) error {
+	cmd := s.remoteCommand("fetch", "--depth=1", "origin", commit)
+	cmd.Dir = path
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to fetch commit %s: %w, output: %s", commit, err, string(output))
+	}
+	return nil
+}
+
+// SparseCheckout checks out only dirs and the files at the root. With a partial
+// clone, it downloads the files it adds.
+func (s *cliGitService) SparseCheckout(path string, dirs []string) error {
+	cmd := s.remoteCommand(append([]string{"sparse-checkout", "set", "--cone", "--"}, dirs...)...)
+	cmd.Dir = path
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to set sparse checkout to %s: %w, output: %s", strings.Join(dirs, ", "), err, string(output))
+	}
+	return nil
+}
+
 func (s *cliGitService) IsBehindRemote(path, branch string) (bool, error) {
-	cmd := exec.Command("git", "rev-list", "--count", fmt.Sprintf("%s..origin/%s", branch, branch))
+	behindCount, err := s.CountCommits(path, branch, "origin/"+branch)
+	if err != nil {
+		return false, fmt.Errorf("failed to check if behind remote: %w", err)
+	}
+
+	return behindCount > 0, nil
+}
+
+// CountCommits returns the number of commits reachable from to but not from from
+func (s *cliGitService) CountCommits(path, from, to string) (int, error) {
+	cmd := exec.Command("git", "rev-list", "--count", fmt.Sprintf("%s..%s", from, to))
 	cmd.Dir = path
 	countOutput, err := cmd.Output()
 	if err != nil {
-		return false, fmt.Errorf("failed to check if behind remote: %w", err)
+		return 0, fmt.Errorf("failed to count commits between %s and %s: %w", from, to, err)
 	}
 
-	behindCountStr := strings.TrimSpace(string(countOutput))
-	behindCount, err := strconv.Atoi(behindCountStr)
+	countStr := strings.TrimSpace(string(countOutput))
+	count, err := strconv.Atoi(countStr)
 	if err != nil {
-		return false, fmt.Errorf("failed to parse commit count: %w", err)
+		return 0, fmt.Errorf("failed to parse commit count: %w", err)
 	}
 
-	return behindCount > 0, nil
+	return count, nil
 }
 
 func (s *cliGitService) Pull(path, branch string) error {
-	cmd := exec.Command("git", "pull", "origin", branch)
+	cmd := s.remoteCommand("pull", "origin", branch)
 	cmd.Dir = path
 	output, err := cmd.CombinedOutput()
 	if err != nil {
@@ -68,21 +198,96 @@ func (s *cliGitService) Pull(path, branch string) error {
 	return nil
 }
 
-func (s *cliGitService) Clone(path, url, branch string) error {
-	cmd := exec.Command("git", "clone", "-b", branch, "--single-branch", url, path)
+// Switch moves HEAD to rev. With a partial clone, it downloads the files of rev it lacks.
+func (s *cliGitService) Switch(path, rev string) error {
+	cmd := s.remoteCommand("switch", "--detach", rev)
+	cmd.Dir = path
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to switch to %s: %w, output: %s", rev, err, string(output))
+	}
+	return nil
+}
+
+func (s *cliGitService) Clone(path, url, branch string, options CloneOptions) error {
+	args := []string{"clone", "--single-branch"}
+	if branch != "" {
+		args = append(args, "-b", branch)
+	}
+	if options.Depth > 0 {
+		args = append(args, "--depth", strconv.Itoa(options.Depth))
+	}
+	if options.Filter != "" {
+		args = append(args, "--filter="+options.Filter)
+	}
+	if options.SparseDirs != nil {
+		args = append(args, "--sparse")
+	}
+
+	cmd := s.remoteCommand(append(args, "--", url, path)...)
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		return fmt.Errorf("failed to clone repository: %w, output: %s", err, string(output))
 	}
+	if options.SparseDirs != nil {
+		return s.SparseCheckout(path, options.SparseDirs)
+	}
 	return nil
 }
 
-func (s *cliGitService) HasChangesInSubdir(path, branch, subDir string) (bool, error) {
-	cmd := exec.Command("git", "diff", "--name-only", fmt.Sprintf("%s..origin/%s", branch, branch), "--", subDir)
+// ChangedFiles lists the files in subDir that differ between from and to
+func (s *cliGitService) ChangedFiles(path, from, to, subDir string) ([]string, error) {
+	// Without rename detection the diff only reads trees, which partial clones have
+	cmd := exec.Command("git", "diff", "--name-only", "--no-renames", "-z", fmt.Sprintf("%s..%s", from, to), "--", subDir)
 	cmd.Dir = path
 	output, err := cmd.Output()
 	if err != nil {
-		return false, fmt.Errorf("failed to get diff for subdirectory %s: %w", subDir, err)
+		return nil, fmt.Errorf("failed to get diff for subdirectory %s: %w", subDir, err)
+	}
+
+	var files []string
+	for _, file := range strings.Split(string(output), "\x00") {
+		if file != "" {
+			files = append(files, file)
+		}
 	}
-	return len(strings.TrimSpace(string(output))) > 0, nil
+	return files, nil
+}
+
+func (s *cliGitService) HasCommit(path, rev string) bool {
+	// A partial clone downloads a commit it lacks from the remote
+	cmd := s.remoteCommand("cat-file", "-e", rev+"^{commit}")
+	cmd.Dir = path
+	return cmd.Run() == nil
+}
+
+func (s *cliGitService) RevParse(path, rev string) (string, error) {
+	cmd := exec.Command("git", "rev-parse", "--verify", rev+"^{commit}")
+	cmd.Dir = path
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w: %w", rev, ErrUnknownRevision, err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// Checkout restores subDirs in the working tree and index to their content at rev,
+// removing files that do not exist at rev. HEAD is left where it is.
+func (s *cliGitService) Checkout(path, rev string, subDirs []string) error {
+	args := []string{"restore", "--source=" + rev, "--staged", "--worktree", "--"}
+	for _, subDir := range subDirs {
+		if subDir == "" {
+			subDir = "." // root of repo
+		}
+		args = append(args, subDir)
+	}
+
+	// A partial clone downloads the files of rev it lacks
+	cmd := s.remoteCommand(args...)
+	cmd.Dir = path
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to check out %s at %s: %w, output: %s", strings.Join(subDirs, ", "), rev, err, string(output))
+	}
+	return nil
 }
@@ -0,0 +1,160 @@
+package git
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCliGitService_ShallowSparseClone(t *testing.T) {
+	remote := newTestRemote(t)
+	c1 := remote.commit(map[string]string{"app1/compose.yml": "v1", "app2/compose.yml": "v1", "shared/app.env": "A=1"})
+	c2 := remote.commit(map[string]string{"app2/compose.yml": "v2"})
+	// Partial clones need the remote to filter objects
+	if output, err := exec.Command("git", "-C", remote.URL, "config", "uploadpack.allowFilter", "true").CombinedOutput(); err != nil {
+		t.Fatalf("Expected no error, but got %v: %s", err, output)
+	}
+
+	s := NewCliGitService(Auth{})
+	path := filepath.Join(t.TempDir(), "out")
+	options := CloneOptions{Depth: 1, Filter: "blob:none", SparseDirs: []string{"app1"}}
+	if err := s.Clone(path, "file://"+remote.URL, "main", options); err != nil {
+		t.Fatalf("Expected clone to succeed, but got %v", err)
+	}
+
+	t.Run("Checks out only the sparse directories", func(t *testing.T) {
+		if _, err := os.Stat(filepath.Join(path, "app1", "compose.yml")); err != nil {
+			t.Errorf("Expected app1 to be checked out, but got %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(path, "app2")); !os.IsNotExist(err) {
+			t.Errorf("Expected app2 not to be checked out, but got %v", err)
+		}
+		if shallow := readFile(t, filepath.Join(path, ".git", "shallow")); shallow != c2+"\n" {
+			t.Errorf("Expected a clone of the last commit, but got %q", shallow)
+		}
+	})
+
+	c3 := remote.commit(map[string]string{"app1/compose.yml": "v3"})
+
+	t.Run("Detects changes after a fetch", func(t *testing.T) {
+		if err := s.Fetch(path); err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		files, err := s.ChangedFiles(path, c2, "origin/main", "app1")
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if expected := []string{"app1/compose.yml"}; !reflect.DeepEqual(files, expected) {
+			t.Errorf("Expected %v, but got %v", expected, files)
+		}
+	})
+
+	t.Run("Fetches a commit missing from the shallow history", func(t *testing.T) {
+		if err := s.FetchCommit(path, c1); err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if !s.HasCommit(path, c1) {
+			t.Fatalf("Expected %s to be fetched", c1)
+		}
+		files, err := s.ChangedFiles(path, c1, "origin/main", "app2")
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if expected := []string{"app2/compose.yml"}; !reflect.DeepEqual(files, expected) {
+			t.Errorf("Expected %v, but got %v", expected, files)
+		}
+	})
+
+	t.Run("Pulls and checks out more directories", func(t *testing.T) {
+		if err := s.Pull(path, "main"); err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if head, err := s.RevParse(path, "HEAD"); err != nil || head != c3 {
+			t.Errorf("Expected HEAD at %s, but got %s, %v", c3, head, err)
+		}
+
+		if err := s.SparseCheckout(path, []string{"app1", "shared"}); err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if content := readFile(t, filepath.Join(path, "shared", "app.env")); content != "A=1" {
+			t.Errorf("Expected shared to be checked out, but got %q", content)
+		}
+	})
+
+	t.Run("Checks out an older commit of a directory", func(t *testing.T) {
+		if err := s.Checkout(path, c1, []string{"app1"}); err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if content := readFile(t, filepath.Join(path, "app1", "compose.yml")); content != "v1" {
+			t.Errorf("Expected app1 at %s, but got %q", c1, content)
+		}
+	})
+}
+
+func tagNames(tags []Tag) []string {
+	var names []string
+	for _, tag := range tags {
+		names = append(names, tag.Name)
+	}
+	return names
+}
+
+func TestGitService_Tags(t *testing.T) {
+	for _, backend := range []string{BackendCLI, BackendGo} {
+		t.Run(backend, func(t *testing.T) {
+			remote := newTestRemote(t)
+			c1 := remote.commit(map[string]string{"app/compose.yml": "v1"})
+			remote.tag("v1.0.0", c1, "")
+			c2 := remote.commit(map[string]string{"app/compose.yml": "v2"})
+			remote.tag("v2.0.0", c2, "Release 2.0.0")
+			remote.commit(map[string]string{"app/compose.yml": "v3"})
+
+			s, err := NewGitService(backend, Auth{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join(t.TempDir(), "out")
+			if err := s.Clone(path, remote.URL, "", CloneOptions{}); err != nil {
+				t.Fatalf("Expected a clone of the default branch to succeed, but got %v", err)
+			}
+
+			if err := s.FetchTags(path); err != nil {
+				t.Fatalf("Expected no error, but got %v", err)
+			}
+			tags, err := s.Tags(path)
+			if err != nil {
+				t.Fatalf("Expected no error, but got %v", err)
+			}
+			if expected := []string{"v1.0.0", "v2.0.0"}; !reflect.DeepEqual(tagNames(tags), expected) {
+				t.Errorf("Expected tags %v, but got %v", expected, tags)
+			}
+			if time.Since(tags[0].Date) > time.Minute {
+				t.Errorf("Expected the lightweight tag to be dated by its commit, but got %s", tags[0].Date)
+			}
+			if !tags[1].Date.Equal(testTaggerDate) {
+				t.Errorf("Expected the annotated tag to be dated by its tagger, %s, but got %s", testTaggerDate, tags[1].Date)
+			}
+
+			if err := s.Switch(path, "refs/tags/v2.0.0"); err != nil {
+				t.Fatalf("Expected no error, but got %v", err)
+			}
+			if head, err := s.RevParse(path, "HEAD"); err != nil || head != c2 {
+				t.Errorf("Expected HEAD at the annotated tag commit %s, but got %s, %v", c2, head, err)
+			}
+			if content := readFile(t, filepath.Join(path, "app", "compose.yml")); content != "v2" {
+				t.Errorf("Expected the files of the tag, but got %q", content)
+			}
+
+			remote.deleteTag("v2.0.0")
+			if err := s.FetchTags(path); err != nil {
+				t.Fatalf("Expected no error, but got %v", err)
+			}
+			if tags, _ := s.Tags(path); !reflect.DeepEqual(tagNames(tags), []string{"v1.0.0"}) {
+				t.Errorf("Expected the deleted tag to be pruned, but got %v", tags)
+			}
+		})
+	}
+}
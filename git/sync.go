@@ -3,85 +3,420 @@ package git
 import (
 	"fmt"
 	"os"
+	"path"
+	"slices"
+	"strings"
 
 	"github.com/gnugomez/voyage/log"
 )
 
 type Repository struct {
-	URL             string
-	Branch          string
-	OutPath         string
+	URL     string
+	Branch  string
+	OutPath string
+	// Depth and Filter make the clone shallow and partial, see CloneOptions
+	Depth  int
+	Filter string
+	// Sparse checks out only the directories of the targets and of their references
+	Sparse bool
+	// Tag makes the repository track the newest tag it matches instead of Branch.
+	// Branch is then only the branch cloned, the default one when empty.
+	Tag *TagPattern
+	// References works out the References of a target from its checked out files.
+	// A sparse clone uses it to add them, as they are unknown before the clone.
+	References      func(target Target) []string
 	gitService      GitService
 	directoryExists func(string) bool
 }
 
-// CreateRepository creates a new Repository instance
-func CreateRepository(url, branch, outPath string) *Repository {
+// Target is a named set of subdirectories whose changes are tracked together
+type Target struct {
+	Name    string
+	SubDirs []string
+	// References are more paths whose changes count as changes to the target.
+	// Unlike SubDirs, they are not held at Pin.
+	References []string
+	// Since is the commit the target was last deployed from. An empty value
+	// means the target was never deployed and is always considered updated.
+	Since string
+	// Pin is the commit the target's files are held at by a rollback, if any.
+	// A pinned target keeps its files at Pin across pulls until it is updated.
+	Pin string
+}
+
+// ChangeReason explains why a target is considered updated
+type ChangeReason string
+
+const (
+	ReasonFirstClone    ChangeReason = "first clone"
+	ReasonNeverDeployed ChangeReason = "never deployed"
+	ReasonUnknownCommit ChangeReason = "last deployed commit not found"
+	ReasonChangedFiles  ChangeReason = "changed files"
+)
+
+// Change describes an updated target
+type Change struct {
+	Target string
+	Reason ChangeReason
+	// Files lists the changed files when Reason is ReasonChangedFiles
+	Files []string
+}
+
+// SyncResult describes the outcome of a Sync
+type SyncResult struct {
+	// Commit is the commit checked out in OutPath after the sync
+	Commit string
+	// Updated holds the names of the targets that changed since they were last deployed
+	Updated []string
+	// Changes explains why every target in Updated changed
+	Changes []Change
+}
+
+// CreateRepository creates a new Repository instance that runs git operations through gitService
+func CreateRepository(url, branch, outPath string, gitService GitService) *Repository {
 	return &Repository{
 		URL:             url,
 		Branch:          branch,
 		OutPath:         outPath,
-		gitService:      NewCliGitService(),
+		gitService:      gitService,
 		directoryExists: osDirectoryExists,
 	}
 }
 
-// Sync synchronizes the repository, checking multiple subdirectories for changes
-// Returns a slice of subdirectories that had updates and any error encountered
-func (r *Repository) Sync(subDirs []string) ([]string, error) {
-	log.Info("Trying to sync repository", "repo", r.URL, "branch", r.Branch, "subDirs", subDirs)
+// Sync synchronizes the repository with the remote branch, or the newest matching
+// tag, and checks every target for changes between the commit it was last deployed
+// from and the remote. Returns the synced commit and the targets that had updates
+func (r *Repository) Sync(targets []Target) (*SyncResult, error) {
+	if r.Tag != nil {
+		log.Info("Trying to sync repository", "repo", r.URL, "tag", r.Tag, "targets", targetNames(targets))
+	} else {
+		log.Info("Trying to sync repository", "repo", r.URL, "branch", r.Branch, "targets", targetNames(targets))
+	}
 
 	if !r.directoryExists(r.OutPath) {
-		err := r.gitService.Clone(r.OutPath, r.URL, r.Branch)
+		sparseDirs := r.sparseDirs(targets)
+		err := r.gitService.Clone(r.OutPath, r.URL, r.Branch, CloneOptions{Depth: r.Depth, Filter: r.Filter, SparseDirs: sparseDirs})
+		if err != nil {
+			return nil, err
+		}
+		if r.Tag != nil {
+			if err := r.fetch(); err != nil {
+				return nil, err
+			}
+			if err := r.switchToTag(); err != nil {
+				return nil, err
+			}
+		}
+		if sparseDirs != nil && r.References != nil {
+			if err := r.sparseCheckoutReferences(targets); err != nil {
+				return nil, err
+			}
+		}
+		commit, err := r.gitService.RevParse(r.OutPath, "HEAD")
 		if err != nil {
 			return nil, err
 		}
-		// If we cloned, all subdirectories are considered "updated"
-		return subDirs, nil
+		// If we cloned, all targets are considered "updated"
+		changes := cloneChanges(targets)
+		return &SyncResult{Commit: commit, Updated: changedTargets(changes), Changes: changes}, nil
 	}
 
-	if !r.gitService.IsGitRepository(r.OutPath) {
-		return nil, fmt.Errorf("directory %s is not a git repository", r.OutPath)
+	changes, err := r.detect(targets)
+	if err != nil {
+		return nil, err
+	}
+	updated := changedTargets(changes)
+
+	// References are only known once the compose files are checked out, and
+	// targets come and go, so the sparse directories follow them
+	if dirs := r.sparseDirs(targets); dirs != nil {
+		if err := r.gitService.SparseCheckout(r.OutPath, dirs); err != nil {
+			return nil, err
+		}
 	}
 
-	err := r.gitService.Fetch(r.OutPath)
+	isBehind, err := r.isBehind()
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch: %w", err)
+		return nil, err
 	}
 
-	var updatedSubDirs []string
-	for _, subDir := range subDirs {
-		changed, err := r.gitService.HasChangesInSubdir(r.OutPath, r.Branch, subDir)
+	// Pinned files are local changes that would block a pull, and released pins
+	// must go back to HEAD anyway.
+	for _, target := range targets {
+		if target.Pin != "" && (isBehind || slices.Contains(updated, target.Name)) {
+			if err := r.gitService.Checkout(r.OutPath, "HEAD", target.SubDirs); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if isBehind {
+		if r.Tag != nil {
+			log.Debug("Newest matching tag moved, switching to it.", "updated", updated)
+			err = r.switchToTag()
+		} else {
+			log.Debug("Remote is ahead, pulling changes.", "updated", updated)
+			err = r.gitService.Pull(r.OutPath, r.Branch)
+		}
 		if err != nil {
 			return nil, err
 		}
-		if changed {
-			updatedSubDirs = append(updatedSubDirs, subDir)
+
+		for _, target := range targets {
+			if target.Pin != "" && !slices.Contains(updated, target.Name) {
+				log.Debug("Keeping pinned target at its commit", "target", target.Name, "commit", target.Pin)
+				if err := r.gitService.Checkout(r.OutPath, target.Pin, target.SubDirs); err != nil {
+					return nil, err
+				}
+			}
 		}
+	} else {
+		log.Debug("Remote is not ahead. No pull needed.")
 	}
 
-	if len(updatedSubDirs) == 0 {
-		log.Debug("No changes in any subdirectory")
-		return nil, nil
+	commit, err := r.gitService.RevParse(r.OutPath, "HEAD")
+	if err != nil {
+		return nil, err
+	}
+
+	if len(updated) == 0 {
+		log.Debug("No changes in any target")
+	}
+
+	return &SyncResult{Commit: commit, Updated: updated, Changes: changes}, nil
+}
+
+// Detect fetches the remote and reports which targets changed since they
+// were last deployed, without touching the working tree. If the repository was
+// not cloned yet, every target is reported as changed.
+func (r *Repository) Detect(targets []Target) ([]Change, error) {
+	if !r.directoryExists(r.OutPath) {
+		return cloneChanges(targets), nil
+	}
+	return r.detect(targets)
+}
+
+func (r *Repository) detect(targets []Target) ([]Change, error) {
+	if !r.gitService.IsGitRepository(r.OutPath) {
+		return nil, fmt.Errorf("%w: %s", ErrNotRepository, r.OutPath)
 	}
 
-	// If we detected changes in subdirectories, pull the code.
-	log.Debug("Changes detected in subdirectories, pulling changes.", "subDirs", updatedSubDirs)
-	isBehind, err := r.gitService.IsBehindRemote(r.OutPath, r.Branch)
+	if err := r.fetch(); err != nil {
+		return nil, fmt.Errorf("failed to fetch: %w", err)
+	}
+	remote, err := r.remote()
 	if err != nil {
 		return nil, err
 	}
 
-	if isBehind {
-		err = r.gitService.Pull(r.OutPath, r.Branch)
+	var changes []Change
+	for _, target := range targets {
+		change, err := r.targetChange(target, remote)
 		if err != nil {
 			return nil, err
 		}
-	} else {
-		log.Debug("Subdirectory changes detected, but remote is not ahead. No pull needed.")
+		if change != nil {
+			changes = append(changes, *change)
+		}
+	}
+	return changes, nil
+}
+
+// Head returns the commit checked out in the clone, or "" when it was not cloned yet
+func (r *Repository) Head() (string, error) {
+	if !r.directoryExists(r.OutPath) {
+		return "", nil
+	}
+	if !r.gitService.IsGitRepository(r.OutPath) {
+		return "", fmt.Errorf("%w: %s", ErrNotRepository, r.OutPath)
+	}
+	return r.gitService.RevParse(r.OutPath, "HEAD")
+}
+
+// Checkout restores subDirs to their content at rev without moving HEAD
+func (r *Repository) Checkout(rev string, subDirs []string) error {
+	return r.gitService.Checkout(r.OutPath, rev, subDirs)
+}
+
+// Resolve returns the full commit hash rev refers to
+func (r *Repository) Resolve(rev string) (string, error) {
+	return r.gitService.RevParse(r.OutPath, rev)
+}
+
+// Fetch updates the remote branch, or the tags, without touching the working tree
+func (r *Repository) Fetch() error {
+	if !r.gitService.IsGitRepository(r.OutPath) {
+		return fmt.Errorf("%w: %s", ErrNotRepository, r.OutPath)
 	}
+	return r.fetch()
+}
+
+// CommitsBehind returns how many commits the remote is ahead of commit
+func (r *Repository) CommitsBehind(commit string) (int, error) {
+	remote, err := r.remote()
+	if err != nil {
+		return 0, err
+	}
+	return r.gitService.CountCommits(r.OutPath, commit, remote)
+}
+
+// RemoteCommit returns the commit of the remote branch, or of the newest matching
+// tag, as of the last fetch
+func (r *Repository) RemoteCommit() (string, error) {
+	remote, err := r.remote()
+	if err != nil {
+		return "", err
+	}
+	return r.gitService.RevParse(r.OutPath, remote)
+}
 
-	return updatedSubDirs, nil
+func (r *Repository) fetch() error {
+	if r.Tag != nil {
+		return r.gitService.FetchTags(r.OutPath)
+	}
+	return r.gitService.Fetch(r.OutPath)
+}
+
+// remote returns the revision targets are deployed from: the remote branch, or
+// the newest tag matching Tag as of the last fetch
+func (r *Repository) remote() (string, error) {
+	if r.Tag == nil {
+		return "origin/" + r.Branch, nil
+	}
+	tags, err := r.gitService.Tags(r.OutPath)
+	if err != nil {
+		return "", err
+	}
+	tag, err := r.Tag.Newest(tags)
+	if err != nil {
+		return "", err
+	}
+	return "refs/tags/" + tag, nil
+}
+
+// isBehind reports whether HEAD is behind the remote branch, or is not at the
+// newest matching tag
+func (r *Repository) isBehind() (bool, error) {
+	if r.Tag == nil {
+		return r.gitService.IsBehindRemote(r.OutPath, r.Branch)
+	}
+	remote, err := r.remote()
+	if err != nil {
+		return false, err
+	}
+	tagCommit, err := r.gitService.RevParse(r.OutPath, remote)
+	if err != nil {
+		return false, err
+	}
+	head, err := r.gitService.RevParse(r.OutPath, "HEAD")
+	if err != nil {
+		return false, err
+	}
+	return head != tagCommit, nil
+}
+
+// switchToTag moves HEAD to the newest matching tag
+func (r *Repository) switchToTag() error {
+	remote, err := r.remote()
+	if err != nil {
+		return err
+	}
+	log.Info("Checking out release tag", "tag", strings.TrimPrefix(remote, "refs/tags/"))
+	return r.gitService.Switch(r.OutPath, remote)
+}
+
+// targetChange reports how target changed between target.Since and remote, or
+// nil if it did not change
+func (r *Repository) targetChange(target Target, remote string) (*Change, error) {
+	if target.Since == "" {
+		log.Debug("Target was never deployed", "target", target.Name)
+		return &Change{Target: target.Name, Reason: ReasonNeverDeployed}, nil
+	}
+
+	if !r.gitService.HasCommit(r.OutPath, target.Since) && r.Depth > 0 {
+		// The history of a shallow clone may start after the commit
+		log.Debug("Fetching last deployed commit missing from the shallow history", "target", target.Name, "commit", target.Since)
+		if err := r.gitService.FetchCommit(r.OutPath, target.Since); err != nil {
+			log.Debug("Error fetching last deployed commit", "target", target.Name, "commit", target.Since, "error", err)
+		}
+	}
+	if !r.gitService.HasCommit(r.OutPath, target.Since) {
+		log.Info("Last deployed commit is no longer in the repository, treating target as changed", "target", target.Name, "commit", target.Since)
+		return &Change{Target: target.Name, Reason: ReasonUnknownCommit}, nil
+	}
+
+	var files []string
+	for _, subDir := range slices.Concat(target.SubDirs, target.References) {
+		changed, err := r.gitService.ChangedFiles(r.OutPath, target.Since, remote, subDir)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, changed...)
+	}
+
+	if len(files) == 0 {
+		return nil, nil
+	}
+	return &Change{Target: target.Name, Reason: ReasonChangedFiles, Files: files}, nil
+}
+
+// sparseDirs returns the directories a sparse checkout keeps for targets: their
+// subdirectories and the directories of their references. It returns nil when the
+// checkout is not sparse, or when a target lives at the root of the repository.
+func (r *Repository) sparseDirs(targets []Target) []string {
+	if !r.Sparse {
+		return nil
+	}
+	dirs := []string{}
+	for _, target := range targets {
+		for _, subDir := range target.SubDirs {
+			if subDir == "" {
+				return nil
+			}
+			dirs = append(dirs, subDir)
+		}
+		for _, reference := range target.References {
+			if dir := path.Dir(reference); dir != "." {
+				dirs = append(dirs, dir)
+			}
+		}
+	}
+	slices.Sort(dirs)
+	return slices.Compact(dirs)
+}
+
+// sparseCheckoutReferences adds the directories of the files targets reference,
+// read from the compose files a sparse clone checked out, to the sparse checkout
+func (r *Repository) sparseCheckoutReferences(targets []Target) error {
+	targets = slices.Clone(targets)
+	for i := range targets {
+		targets[i].References = r.References(targets[i])
+	}
+	return r.gitService.SparseCheckout(r.OutPath, r.sparseDirs(targets))
+}
+
+func cloneChanges(targets []Target) []Change {
+	changes := make([]Change, 0, len(targets))
+	for _, target := range targets {
+		changes = append(changes, Change{Target: target.Name, Reason: ReasonFirstClone})
+	}
+	return changes
+}
+
+func changedTargets(changes []Change) []string {
+	var names []string
+	for _, change := range changes {
+		names = append(names, change.Target)
+	}
+	return names
+}
+
+func targetNames(targets []Target) []string {
+	names := make([]string, 0, len(targets))
+	for _, target := range targets {
+		names = append(names, target.Name)
+	}
+	return names
 }
 
 func osDirectoryExists(outPath string) bool {
@@ -91,5 +426,3 @@ func osDirectoryExists(outPath string) bool {
 	}
 	return false
 }
-
-
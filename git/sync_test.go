@@ -8,12 +8,21 @@ import (
 
 // mockGitService is a mock implementation of the GitService interface for testing.
 type mockGitService struct {
-	IsGitRepositoryFunc    func(path string) bool
-	FetchFunc              func(path string) error
-	IsBehindRemoteFunc     func(path, branch string) (bool, error)
-	PullFunc               func(path, branch string) error
-	CloneFunc              func(path, url, branch string) error
-	HasChangesInSubdirFunc func(path, branch, subDir string) (bool, error)
+	IsGitRepositoryFunc func(path string) bool
+	FetchFunc           func(path string) error
+	FetchTagsFunc       func(path string) error
+	TagsFunc            func(path string) ([]Tag, error)
+	IsBehindRemoteFunc  func(path, branch string) (bool, error)
+	CountCommitsFunc    func(path, from, to string) (int, error)
+	PullFunc            func(path, branch string) error
+	SwitchFunc          func(path, rev string) error
+	CloneFunc           func(path, url, branch string, options CloneOptions) error
+	FetchCommitFunc     func(path, commit string) error
+	SparseCheckoutFunc  func(path string, dirs []string) error
+	ChangedFilesFunc    func(path, from, to, subDir string) ([]string, error)
+	HasCommitFunc       func(path, rev string) bool
+	RevParseFunc        func(path, rev string) (string, error)
+	CheckoutFunc        func(path, rev string, subDirs []string) error
 }
 
 func (m *mockGitService) IsGitRepository(path string) bool {
@@ -30,6 +39,20 @@ func (m *mockGitService) Fetch(path string) error {
 	return nil
 }
 
+func (m *mockGitService) FetchTags(path string) error {
+	if m.FetchTagsFunc != nil {
+		return m.FetchTagsFunc(path)
+	}
+	return nil
+}
+
+func (m *mockGitService) Tags(path string) ([]Tag, error) {
+	if m.TagsFunc != nil {
+		return m.TagsFunc(path)
+	}
+	return nil, nil
+}
+
 func (m *mockGitService) IsBehindRemote(path, branch string) (bool, error) {
 	if m.IsBehindRemoteFunc != nil {
 		return m.IsBehindRemoteFunc(path, branch)
@@ -37,6 +60,13 @@ func (m *mockGitService) IsBehindRemote(path, branch string) (bool, error) {
 	return false, nil
 }
 
+func (m *mockGitService) CountCommits(path, from, to string) (int, error) {
+	if m.CountCommitsFunc != nil {
+		return m.CountCommitsFunc(path, from, to)
+	}
+	return 0, nil
+}
+
 func (m *mockGitService) Pull(path, branch string) error {
 	if m.PullFunc != nil {
 		return m.PullFunc(path, branch)
@@ -44,22 +74,77 @@ func (m *mockGitService) Pull(path, branch string) error {
 	return nil
 }
 
-func (m *mockGitService) Clone(path, url, branch string) error {
+func (m *mockGitService) Switch(path, rev string) error {
+	if m.SwitchFunc != nil {
+		return m.SwitchFunc(path, rev)
+	}
+	return nil
+}
+
+func (m *mockGitService) Clone(path, url, branch string, options CloneOptions) error {
 	if m.CloneFunc != nil {
-		return m.CloneFunc(path, url, branch)
+		return m.CloneFunc(path, url, branch, options)
 	}
 	return nil
 }
 
-func (m *mockGitService) HasChangesInSubdir(path, branch, subDir string) (bool, error) {
-	if m.HasChangesInSubdirFunc != nil {
-		return m.HasChangesInSubdirFunc(path, branch, subDir)
+func (m *mockGitService) FetchCommit(path, commit string) error {
+	if m.FetchCommitFunc != nil {
+		return m.FetchCommitFunc(path, commit)
+	}
+	return nil
+}
+
+func (m *mockGitService) SparseCheckout(path string, dirs []string) error {
+	if m.SparseCheckoutFunc != nil {
+		return m.SparseCheckoutFunc(path, dirs)
+	}
+	return nil
+}
+
+func (m *mockGitService) ChangedFiles(path, from, to, subDir string) ([]string, error) {
+	if m.ChangedFilesFunc != nil {
+		return m.ChangedFilesFunc(path, from, to, subDir)
+	}
+	return nil, nil
+}
+
+// changedFiles adapts a predicate on subDir to a ChangedFiles mock
+func changedFiles(changed func(from, subDir string) bool) func(path, from, to, subDir string) ([]string, error) {
+	return func(path, from, to, subDir string) ([]string, error) {
+		if changed(from, subDir) {
+			return []string{subDir + "/compose.yml"}, nil
+		}
+		return nil, nil
 	}
-	return false, nil
+}
+
+func (m *mockGitService) HasCommit(path, rev string) bool {
+	if m.HasCommitFunc != nil {
+		return m.HasCommitFunc(path, rev)
+	}
+	return true
+}
+
+func (m *mockGitService) RevParse(path, rev string) (string, error) {
+	if m.RevParseFunc != nil {
+		return m.RevParseFunc(path, rev)
+	}
+	return "head", nil
+}
+
+func (m *mockGitService) Checkout(path, rev string, subDirs []string) error {
+	if m.CheckoutFunc != nil {
+		return m.CheckoutFunc(path, rev, subDirs)
+	}
+	return nil
 }
 
 func TestSync(t *testing.T) {
-	subDirs := []string{"app1", "app2"}
+	targets := []Target{
+		{Name: "app1/compose.yml", SubDirs: []string{"app1"}, Since: "c1"},
+		{Name: "app2/compose.yml", SubDirs: []string{"app2"}, Since: "c1"},
+	}
 
 	t.Run("Clone flow", func(t *testing.T) {
 		mock := &mockGitService{}
@@ -72,12 +157,12 @@ func TestSync(t *testing.T) {
 		}
 
 		cloneCalled := false
-		mock.CloneFunc = func(path, url, branch string) error {
+		mock.CloneFunc = func(path, url, branch string, options CloneOptions) error {
 			cloneCalled = true
 			return nil
 		}
 
-		updated, err := repo.Sync(subDirs)
+		result, err := repo.Sync(targets)
 		if err != nil {
 			t.Fatalf("Sync() returned an unexpected error: %v", err)
 		}
@@ -86,8 +171,11 @@ func TestSync(t *testing.T) {
 			t.Error("Expected Clone to be called, but it wasn't")
 		}
 
-		if !reflect.DeepEqual(updated, subDirs) {
-			t.Errorf("Expected all subdirs to be updated on clone, got %v", updated)
+		if !reflect.DeepEqual(result.Updated, []string{"app1/compose.yml", "app2/compose.yml"}) {
+			t.Errorf("Expected all targets to be updated on clone, got %v", result.Updated)
+		}
+		if result.Commit != "head" {
+			t.Errorf("Expected commit to be 'head', got %s", result.Commit)
 		}
 	})
 
@@ -100,15 +188,15 @@ func TestSync(t *testing.T) {
 
 		mock.IsGitRepositoryFunc = func(path string) bool { return true }
 		mock.FetchFunc = func(path string) error { return nil }
-		mock.HasChangesInSubdirFunc = func(path, branch, subDir string) (bool, error) { return false, nil }
+		mock.ChangedFilesFunc = func(path, from, to, subDir string) ([]string, error) { return nil, nil }
 
-		updated, err := repo.Sync(subDirs)
+		result, err := repo.Sync(targets)
 		if err != nil {
 			t.Fatalf("Sync() returned an unexpected error: %v", err)
 		}
 
-		if len(updated) != 0 {
-			t.Errorf("Expected no updated dirs, but got %v", updated)
+		if len(result.Updated) != 0 {
+			t.Errorf("Expected no updated targets, but got %v", result.Updated)
 		}
 	})
 
@@ -124,9 +212,15 @@ func TestSync(t *testing.T) {
 
 		mock.IsGitRepositoryFunc = func(path string) bool { return true }
 		mock.FetchFunc = func(path string) error { return nil }
-		mock.HasChangesInSubdirFunc = func(path, branch, subDir string) (bool, error) {
+		mock.ChangedFilesFunc = func(path, from, to, subDir string) ([]string, error) {
+			if from != "c1" || to != "origin/branch" {
+				t.Errorf("Expected diff c1..origin/branch, got %s..%s", from, to)
+			}
 			// Only app1 has changes
-			return subDir == "app1", nil
+			if subDir == "app1" {
+				return []string{"app1/compose.yml"}, nil
+			}
+			return nil, nil
 		}
 		mock.IsBehindRemoteFunc = func(path, branch string) (bool, error) { return true, nil }
 
@@ -136,7 +230,7 @@ func TestSync(t *testing.T) {
 			return nil
 		}
 
-		updated, err := repo.Sync(subDirs)
+		result, err := repo.Sync(targets)
 		if err != nil {
 			t.Fatalf("Sync() returned an unexpected error: %v", err)
 		}
@@ -145,8 +239,363 @@ func TestSync(t *testing.T) {
 			t.Error("Expected Pull to be called, but it wasn't")
 		}
 
-		if !reflect.DeepEqual(updated, []string{"app1"}) {
-			t.Errorf("Expected updated dirs to be [app1], but got %v", updated)
+		if !reflect.DeepEqual(result.Updated, []string{"app1/compose.yml"}) {
+			t.Errorf("Expected updated targets to be [app1/compose.yml], but got %v", result.Updated)
+		}
+	})
+
+	t.Run("Targets are diffed against their own last deployed commit", func(t *testing.T) {
+		mock := &mockGitService{}
+		repo := &Repository{
+			Branch:          "branch",
+			gitService:      mock,
+			directoryExists: func(s string) bool { return true },
+		}
+
+		mock.IsGitRepositoryFunc = func(path string) bool { return true }
+		// app2 was deployed from an older commit than app1
+		mock.ChangedFilesFunc = changedFiles(func(from, subDir string) bool { return from == "old" })
+
+		result, err := repo.Sync([]Target{
+			{Name: "app1/compose.yml", SubDirs: []string{"app1"}, Since: "new"},
+			{Name: "app2/compose.yml", SubDirs: []string{"app2"}, Since: "old"},
+		})
+		if err != nil {
+			t.Fatalf("Sync() returned an unexpected error: %v", err)
+		}
+
+		if !reflect.DeepEqual(result.Updated, []string{"app2/compose.yml"}) {
+			t.Errorf("Expected updated targets to be [app2/compose.yml], but got %v", result.Updated)
+		}
+	})
+
+	t.Run("Never deployed and unknown commits are treated as changed", func(t *testing.T) {
+		mock := &mockGitService{}
+		repo := &Repository{
+			gitService:      mock,
+			directoryExists: func(s string) bool { return true },
+		}
+
+		mock.IsGitRepositoryFunc = func(path string) bool { return true }
+		mock.HasCommitFunc = func(path, rev string) bool { return rev != "gone" }
+		mock.ChangedFilesFunc = func(path, from, to, subDir string) ([]string, error) { return nil, nil }
+
+		result, err := repo.Sync([]Target{
+			{Name: "new/compose.yml", SubDirs: []string{"new"}},
+			{Name: "rewritten/compose.yml", SubDirs: []string{"rewritten"}, Since: "gone"},
+			{Name: "same/compose.yml", SubDirs: []string{"same"}, Since: "c1"},
+		})
+		if err != nil {
+			t.Fatalf("Sync() returned an unexpected error: %v", err)
+		}
+
+		if !reflect.DeepEqual(result.Updated, []string{"new/compose.yml", "rewritten/compose.yml"}) {
+			t.Errorf("Expected updated targets to be [new/compose.yml rewritten/compose.yml], but got %v", result.Updated)
+		}
+	})
+
+	t.Run("Pinned targets survive pulls until they are updated", func(t *testing.T) {
+		mock := &mockGitService{}
+		repo := &Repository{
+			Branch:          "branch",
+			gitService:      mock,
+			directoryExists: func(s string) bool { return true },
+		}
+
+		mock.IsGitRepositoryFunc = func(path string) bool { return true }
+		mock.IsBehindRemoteFunc = func(path, branch string) (bool, error) { return true, nil }
+		mock.ChangedFilesFunc = changedFiles(func(from, subDir string) bool { return subDir == "released" })
+
+		var calls []string
+		mock.CheckoutFunc = func(path, rev string, subDirs []string) error {
+			calls = append(calls, "checkout "+rev+" "+subDirs[0])
+			return nil
+		}
+		mock.PullFunc = func(path, branch string) error {
+			calls = append(calls, "pull")
+			return nil
+		}
+
+		result, err := repo.Sync([]Target{
+			{Name: "kept/compose.yml", SubDirs: []string{"kept"}, Since: "base", Pin: "old"},
+			{Name: "released/compose.yml", SubDirs: []string{"released"}, Since: "base", Pin: "old"},
+			{Name: "plain/compose.yml", SubDirs: []string{"plain"}, Since: "c1"},
+		})
+		if err != nil {
+			t.Fatalf("Sync() returned an unexpected error: %v", err)
+		}
+
+		expected := []string{
+			"checkout HEAD kept",
+			"checkout HEAD released",
+			"pull",
+			"checkout old kept",
+		}
+		if !reflect.DeepEqual(calls, expected) {
+			t.Errorf("Unexpected git calls.\nGot:      %v\nExpected: %v", calls, expected)
+		}
+		if !reflect.DeepEqual(result.Updated, []string{"released/compose.yml"}) {
+			t.Errorf("Expected updated targets to be [released/compose.yml], but got %v", result.Updated)
+		}
+	})
+
+	t.Run("Changes to references update the target without being pinned", func(t *testing.T) {
+		mock := &mockGitService{}
+		repo := &Repository{
+			Branch:          "branch",
+			gitService:      mock,
+			directoryExists: func(s string) bool { return true },
+		}
+
+		mock.IsGitRepositoryFunc = func(path string) bool { return true }
+		mock.IsBehindRemoteFunc = func(path, branch string) (bool, error) { return true, nil }
+		mock.ChangedFilesFunc = func(path, from, to, subDir string) ([]string, error) {
+			if subDir == "shared/app.env" {
+				return []string{subDir}, nil
+			}
+			return nil, nil
+		}
+		var checkouts [][]string
+		mock.CheckoutFunc = func(path, rev string, subDirs []string) error {
+			checkouts = append(checkouts, subDirs)
+			return nil
+		}
+
+		result, err := repo.Sync([]Target{
+			{Name: "app/compose.yml", SubDirs: []string{"app"}, References: []string{"shared/app.env"}, Since: "c1"},
+			{Name: "kept/compose.yml", SubDirs: []string{"kept"}, References: []string{"shared/kept.env"}, Since: "base", Pin: "old"},
+		})
+		if err != nil {
+			t.Fatalf("Sync() returned an unexpected error: %v", err)
+		}
+
+		expectedChanges := []Change{{Target: "app/compose.yml", Reason: ReasonChangedFiles, Files: []string{"shared/app.env"}}}
+		if !reflect.DeepEqual(result.Changes, expectedChanges) {
+			t.Errorf("Unexpected changes.\nGot:      %+v\nExpected: %+v", result.Changes, expectedChanges)
+		}
+		for _, subDirs := range checkouts {
+			if !reflect.DeepEqual(subDirs, []string{"kept"}) {
+				t.Errorf("Expected only the pinned target's directory to be checked out, but got %v", subDirs)
+			}
+		}
+	})
+
+	t.Run("Sparse clones check out the directories of the targets and references", func(t *testing.T) {
+		mock := &mockGitService{}
+		repo := &Repository{
+			Depth:           1,
+			Filter:          "blob:none",
+			Sparse:          true,
+			gitService:      mock,
+			directoryExists: func(s string) bool { return false },
+		}
+		sparseTargets := []Target{
+			{Name: "app1/compose.yml", SubDirs: []string{"app1"}, References: []string{"shared/app.env", "root.env"}},
+			{Name: "app2/compose.yml", SubDirs: []string{"app2", "shared"}},
+		}
+
+		var cloneOptions CloneOptions
+		mock.CloneFunc = func(path, url, branch string, options CloneOptions) error {
+			cloneOptions = options
+			return nil
+		}
+		if _, err := repo.Sync(sparseTargets); err != nil {
+			t.Fatalf("Sync() returned an unexpected error: %v", err)
+		}
+		expected := CloneOptions{Depth: 1, Filter: "blob:none", SparseDirs: []string{"app1", "app2", "shared"}}
+		if !reflect.DeepEqual(cloneOptions, expected) {
+			t.Errorf("Expected clone options %+v, but got %+v", expected, cloneOptions)
+		}
+
+		var sparseDirs []string
+		repo.directoryExists = func(s string) bool { return true }
+		mock.IsGitRepositoryFunc = func(path string) bool { return true }
+		mock.SparseCheckoutFunc = func(path string, dirs []string) error {
+			sparseDirs = dirs
+			return nil
+		}
+		sparseTargets = append(sparseTargets, Target{Name: "app3/compose.yml", SubDirs: []string{"app3"}})
+		if _, err := repo.Sync(sparseTargets); err != nil {
+			t.Fatalf("Sync() returned an unexpected error: %v", err)
+		}
+		if expected := []string{"app1", "app2", "app3", "shared"}; !reflect.DeepEqual(sparseDirs, expected) {
+			t.Errorf("Expected sparse directories %v, but got %v", expected, sparseDirs)
+		}
+	})
+
+	t.Run("Sparse clones add the references read from the checked out files", func(t *testing.T) {
+		mock := &mockGitService{}
+		cloned := false
+		repo := &Repository{
+			Sparse: true,
+			References: func(target Target) []string {
+				if !cloned {
+					t.Error("Expected references to be read after the clone")
+				}
+				return []string{"shared/app.env"}
+			},
+			gitService:      mock,
+			directoryExists: func(s string) bool { return false },
+		}
+
+		mock.CloneFunc = func(path, url, branch string, options CloneOptions) error {
+			cloned = true
+			if !reflect.DeepEqual(options.SparseDirs, []string{"app"}) {
+				t.Errorf("Expected the clone to check out [app], but got %v", options.SparseDirs)
+			}
+			return nil
+		}
+		var sparseDirs []string
+		mock.SparseCheckoutFunc = func(path string, dirs []string) error {
+			sparseDirs = dirs
+			return nil
+		}
+
+		if _, err := repo.Sync([]Target{{Name: "app/compose.yml", SubDirs: []string{"app"}}}); err != nil {
+			t.Fatalf("Sync() returned an unexpected error: %v", err)
+		}
+		if expected := []string{"app", "shared"}; !reflect.DeepEqual(sparseDirs, expected) {
+			t.Errorf("Expected sparse directories %v after the clone, but got %v", expected, sparseDirs)
+		}
+	})
+
+	t.Run("Targets at the root of the repository are never sparse", func(t *testing.T) {
+		repo := &Repository{Sparse: true}
+
+		if dirs := repo.sparseDirs([]Target{{Name: "compose.yml", SubDirs: []string{""}}}); dirs != nil {
+			t.Errorf("Expected no sparse directories, but got %v", dirs)
+		}
+	})
+
+	t.Run("Shallow clones fetch a last deployed commit missing from their history", func(t *testing.T) {
+		mock := &mockGitService{}
+		repo := &Repository{
+			Depth:           1,
+			gitService:      mock,
+			directoryExists: func(s string) bool { return true },
+		}
+
+		fetched := map[string]bool{}
+		mock.IsGitRepositoryFunc = func(path string) bool { return true }
+		mock.HasCommitFunc = func(path, rev string) bool { return rev == "c1" || fetched[rev] }
+		mock.FetchCommitFunc = func(path, commit string) error {
+			if commit == "gone" {
+				return errors.New("not our ref")
+			}
+			fetched[commit] = true
+			return nil
+		}
+		mock.ChangedFilesFunc = changedFiles(func(from, subDir string) bool { return from == "old" })
+
+		result, err := repo.Sync([]Target{
+			{Name: "old/compose.yml", SubDirs: []string{"old"}, Since: "old"},
+			{Name: "rewritten/compose.yml", SubDirs: []string{"rewritten"}, Since: "gone"},
+			{Name: "same/compose.yml", SubDirs: []string{"same"}, Since: "c1"},
+		})
+		if err != nil {
+			t.Fatalf("Sync() returned an unexpected error: %v", err)
+		}
+
+		expected := []Change{
+			{Target: "old/compose.yml", Reason: ReasonChangedFiles, Files: []string{"old/compose.yml"}},
+			{Target: "rewritten/compose.yml", Reason: ReasonUnknownCommit},
+		}
+		if !reflect.DeepEqual(result.Changes, expected) {
+			t.Errorf("Expected changes %+v, but got %+v", expected, result.Changes)
+		}
+		if fetched["c1"] {
+			t.Error("Expected a commit in the history not to be fetched")
+		}
+	})
+
+	t.Run("Tags switch to the newest matching tag", func(t *testing.T) {
+		pattern, _ := ParseTagPattern("v1.*")
+		mock := &mockGitService{}
+		repo := &Repository{
+			OutPath:         "path",
+			Tag:             pattern,
+			gitService:      mock,
+			directoryExists: func(s string) bool { return true },
+		}
+
+		commits := map[string]string{"HEAD": "c1", "refs/tags/v1.10.0": "c2"}
+		mock.IsGitRepositoryFunc = func(path string) bool { return true }
+		mock.FetchFunc = func(path string) error {
+			t.Error("Expected the tags to be fetched instead of the branch")
+			return nil
+		}
+		fetchedTags := false
+		mock.FetchTagsFunc = func(path string) error { fetchedTags = true; return nil }
+		mock.TagsFunc = func(path string) ([]Tag, error) {
+			return []Tag{{Name: "v1.9.0"}, {Name: "v1.10.0"}, {Name: "v2.0.0"}}, nil
+		}
+		mock.RevParseFunc = func(path, rev string) (string, error) { return commits[rev], nil }
+		mock.ChangedFilesFunc = func(path, from, to, subDir string) ([]string, error) {
+			if to != "refs/tags/v1.10.0" {
+				t.Errorf("Expected diff against refs/tags/v1.10.0, got %s", to)
+			}
+			if subDir == "app1" {
+				return []string{"app1/compose.yml"}, nil
+			}
+			return nil, nil
+		}
+		mock.PullFunc = func(path, branch string) error {
+			t.Error("Expected no pull when tracking tags")
+			return nil
+		}
+		var switchedTo string
+		mock.SwitchFunc = func(path, rev string) error {
+			switchedTo = rev
+			commits["HEAD"] = commits[rev]
+			return nil
+		}
+
+		result, err := repo.Sync(targets)
+		if err != nil {
+			t.Fatalf("Sync() returned an unexpected error: %v", err)
+		}
+		if !fetchedTags {
+			t.Error("Expected FetchTags to be called, but it wasn't")
+		}
+		if switchedTo != "refs/tags/v1.10.0" {
+			t.Errorf("Expected a switch to refs/tags/v1.10.0, but got %q", switchedTo)
+		}
+		if result.Commit != "c2" || !reflect.DeepEqual(result.Updated, []string{"app1/compose.yml"}) {
+			t.Errorf("Expected app1 updated at c2, but got %v at %s", result.Updated, result.Commit)
+		}
+
+		switchedTo = ""
+		if _, err := repo.Sync(targets); err != nil {
+			t.Fatalf("Sync() returned an unexpected error: %v", err)
+		}
+		if switchedTo != "" {
+			t.Errorf("Expected no switch when HEAD is at the tag, but got %q", switchedTo)
+		}
+	})
+
+	t.Run("Clones with tags check out the newest matching tag", func(t *testing.T) {
+		pattern, _ := ParseTagPattern(">=1.0.0")
+		mock := &mockGitService{}
+		repo := &Repository{
+			Tag:             pattern,
+			gitService:      mock,
+			directoryExists: func(s string) bool { return false },
+		}
+
+		mock.TagsFunc = func(path string) ([]Tag, error) { return []Tag{{Name: "v1.0.0"}}, nil }
+		var switchedTo string
+		mock.SwitchFunc = func(path, rev string) error { switchedTo = rev; return nil }
+
+		if _, err := repo.Sync(targets); err != nil {
+			t.Fatalf("Sync() returned an unexpected error: %v", err)
+		}
+		if switchedTo != "refs/tags/v1.0.0" {
+			t.Errorf("Expected a switch to refs/tags/v1.0.0, but got %q", switchedTo)
+		}
+
+		mock.TagsFunc = func(path string) ([]Tag, error) { return []Tag{{Name: "v0.1.0"}}, nil }
+		if _, err := repo.Sync(targets); !errors.Is(err, ErrNoMatchingTag) {
+			t.Errorf("Expected ErrNoMatchingTag, but got %v", err)
 		}
 	})
 
@@ -160,9 +609,123 @@ func TestSync(t *testing.T) {
 		mock.IsGitRepositoryFunc = func(path string) bool { return true }
 		mock.FetchFunc = func(path string) error { return errors.New("fetch failed") }
 
-		_, err := repo.Sync(subDirs)
+		_, err := repo.Sync(targets)
 		if err == nil {
 			t.Fatal("Expected an error on fetch, but got nil")
 		}
 	})
 }
+
+func TestRepository_Head(t *testing.T) {
+	t.Run("Returns nothing before the first clone", func(t *testing.T) {
+		repo := &Repository{
+			OutPath:         "path",
+			gitService:      &mockGitService{},
+			directoryExists: func(s string) bool { return false },
+		}
+
+		head, err := repo.Head()
+		if head != "" || err != nil {
+			t.Errorf("Expected no commit, but got %q, %v", head, err)
+		}
+	})
+
+	t.Run("Returns the checked out commit of the clone", func(t *testing.T) {
+		mock := &mockGitService{
+			IsGitRepositoryFunc: func(path string) bool { return true },
+			RevParseFunc: func(path, rev string) (string, error) {
+				if rev != "HEAD" {
+					t.Errorf("Expected HEAD to be resolved, but got %q", rev)
+				}
+				return "c1", nil
+			},
+		}
+		repo := &Repository{
+			OutPath:         "path",
+			gitService:      mock,
+			directoryExists: func(s string) bool { return true },
+		}
+
+		head, err := repo.Head()
+		if head != "c1" || err != nil {
+			t.Errorf("Expected c1, but got %q, %v", head, err)
+		}
+	})
+
+	t.Run("Fails when the directory is not a repository", func(t *testing.T) {
+		repo := &Repository{
+			OutPath:         "path",
+			gitService:      &mockGitService{IsGitRepositoryFunc: func(path string) bool { return false }},
+			directoryExists: func(s string) bool { return true },
+		}
+
+		if _, err := repo.Head(); !errors.Is(err, ErrNotRepository) {
+			t.Errorf("Expected ErrNotRepository, but got %v", err)
+		}
+	})
+}
+
+func TestDetect(t *testing.T) {
+	t.Run("Reports reasons and files without touching the working tree", func(t *testing.T) {
+		mock := &mockGitService{}
+		repo := &Repository{
+			Branch:          "branch",
+			gitService:      mock,
+			directoryExists: func(s string) bool { return true },
+		}
+
+		mock.IsGitRepositoryFunc = func(path string) bool { return true }
+		mock.HasCommitFunc = func(path, rev string) bool { return rev != "gone" }
+		mock.ChangedFilesFunc = changedFiles(func(from, subDir string) bool { return subDir == "changed" })
+		mock.PullFunc = func(path, branch string) error {
+			t.Error("Expected Detect not to pull")
+			return nil
+		}
+		mock.CheckoutFunc = func(path, rev string, subDirs []string) error {
+			t.Error("Expected Detect not to check out files")
+			return nil
+		}
+
+		changes, err := repo.Detect([]Target{
+			{Name: "new/compose.yml", SubDirs: []string{"new"}},
+			{Name: "rewritten/compose.yml", SubDirs: []string{"rewritten"}, Since: "gone"},
+			{Name: "changed/compose.yml", SubDirs: []string{"changed"}, Since: "c1", Pin: "c0"},
+			{Name: "same/compose.yml", SubDirs: []string{"same"}, Since: "c1"},
+		})
+		if err != nil {
+			t.Fatalf("Detect() returned an unexpected error: %v", err)
+		}
+
+		expected := []Change{
+			{Target: "new/compose.yml", Reason: ReasonNeverDeployed},
+			{Target: "rewritten/compose.yml", Reason: ReasonUnknownCommit},
+			{Target: "changed/compose.yml", Reason: ReasonChangedFiles, Files: []string{"changed/compose.yml"}},
+		}
+		if !reflect.DeepEqual(changes, expected) {
+			t.Errorf("Unexpected changes.\nGot:      %+v\nExpected: %+v", changes, expected)
+		}
+	})
+
+	t.Run("Reports first clone without cloning", func(t *testing.T) {
+		mock := &mockGitService{}
+		repo := &Repository{
+			gitService:      mock,
+			directoryExists: func(s string) bool { return false },
+		}
+
+		mock.CloneFunc = func(path, url, branch string, options CloneOptions) error {
+			t.Error("Expected Detect not to clone")
+			return nil
+		}
+
+		changes, err := repo.Detect([]Target{{Name: "app1/compose.yml", SubDirs: []string{"app1"}}})
+		if err != nil {
+			t.Fatalf("Detect() returned an unexpected error: %v", err)
+		}
+
+		expected := []Change{{Target: "app1/compose.yml", Reason: ReasonFirstClone}}
+		if !reflect.DeepEqual(changes, expected) {
+			t.Errorf("Unexpected changes.\nGot:      %+v\nExpected: %+v", changes, expected)
+		}
+	})
+}
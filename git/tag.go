@@ -0,0 +1,188 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNoMatchingTag is returned when no tag of the repository matches the tag pattern
+var ErrNoMatchingTag = errors.New("no tag matches the pattern")
+
+// Tag is a tag of the repository with the date it was created: the tagger date of
+// an annotated tag, or the committer date of the commit of a lightweight one.
+type Tag struct {
+	Name string
+	Date time.Time
+}
+
+// TagPattern selects the release tag a repository is deployed from. It is either
+// a glob such as v1.*, or a semver range such as ">=2.0.0 <3" whose comparisons
+// must all hold. A range only considers tags that are versions without a
+// pre-release, and selects the highest. A glob selects the highest version among
+// the tags it matches, and the newest tag when none of them is a version.
+type TagPattern struct {
+	pattern     string
+	constraints []constraint
+}
+
+// constraint compares the version of a tag against version, considering only as
+// many parts as version has, so <=1.2 holds for every 1.2.x
+type constraint struct {
+	operator string
+	version  []int
+}
+
+// operators are the comparisons of a semver range, longest first
+var operators = []string{">=", "<=", "!=", ">", "<", "="}
+
+// ParseTagPattern parses pattern as a semver range when it starts with a
+// comparison operator, and as a glob otherwise
+func ParseTagPattern(pattern string) (*TagPattern, error) {
+	pattern = strings.TrimSpace(pattern)
+	if pattern == "" {
+		return nil, fmt.Errorf("empty tag pattern")
+	}
+	if !strings.ContainsAny(pattern[:1], "<>=!") {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid tag pattern %q: %w", pattern, err)
+		}
+		return &TagPattern{pattern: pattern}, nil
+	}
+
+	p := &TagPattern{pattern: pattern}
+	fields := strings.Fields(strings.ReplaceAll(pattern, ",", " "))
+	for i := 0; i < len(fields); i++ {
+		field := fields[i]
+		// Allow a space between the operator and the version
+		if slices.Contains(operators, field) && i+1 < len(fields) {
+			i++
+			field += fields[i]
+		}
+
+		var c constraint
+		for _, operator := range operators {
+			if version, ok := strings.CutPrefix(field, operator); ok {
+				c.operator = operator
+				field = version
+				break
+			}
+		}
+		if c.operator == "" {
+			return nil, fmt.Errorf("invalid tag range %q: %q has no comparison operator", pattern, field)
+		}
+		version, ok := parseVersion(field, true)
+		if !ok {
+			return nil, fmt.Errorf("invalid tag range %q: %q is not a version", pattern, field)
+		}
+		c.version = version
+		p.constraints = append(p.constraints, c)
+	}
+	return p, nil
+}
+
+func (p *TagPattern) String() string {
+	return p.pattern
+}
+
+// match reports whether tag matches the pattern, and returns its version when it
+// is one. A glob matches any tag, a range only versions.
+func (p *TagPattern) match(tag string) (version []int, matched bool) {
+	if p.constraints == nil {
+		// Globs such as v1.* also match the versions leaving out their patch part
+		version, _ = parseVersion(tag, true)
+		matched, _ = path.Match(p.pattern, tag)
+		return version, matched
+	}
+
+	version, ok := parseVersion(tag, false)
+	if !ok {
+		return nil, false
+	}
+	for _, c := range p.constraints {
+		if !c.holds(version) {
+			return nil, false
+		}
+	}
+	return version, true
+}
+
+// Newest returns the tag with the highest version among the tags matching the
+// pattern, or when none of them is a version, the most recently created one. Of
+// two tags with the same version or date, such as v1.0.0 and 1.0.0, the greater
+// name wins, so the choice does not depend on the order of tags.
+func (p *TagPattern) Newest(tags []Tag) (string, error) {
+	var newest, newestDated *Tag
+	var newestVersion []int
+	for _, tag := range tags {
+		version, ok := p.match(tag.Name)
+		if !ok {
+			continue
+		}
+		if version != nil {
+			if c := slices.Compare(version, newestVersion); newest == nil || c > 0 || (c == 0 && tag.Name > newest.Name) {
+				newest, newestVersion = &tag, version
+			}
+			continue
+		}
+		if newestDated == nil || tag.Date.After(newestDated.Date) || (tag.Date.Equal(newestDated.Date) && tag.Name > newestDated.Name) {
+			newestDated = &tag
+		}
+	}
+
+	switch {
+	case newest != nil:
+		return newest.Name, nil
+	case newestDated != nil:
+		return newestDated.Name, nil
+	default:
+		return "", fmt.Errorf("%w %s", ErrNoMatchingTag, p.pattern)
+	}
+}
+
+func (c constraint) holds(version []int) bool {
+	compared := slices.Compare(version[:len(c.version)], c.version)
+	switch c.operator {
+	case ">=":
+		return compared >= 0
+	case "<=":
+		return compared <= 0
+	case "!=":
+		return compared != 0
+	case ">":
+		return compared > 0
+	case "<":
+		return compared < 0
+	default:
+		return compared == 0
+	}
+}
+
+// parseVersion parses a version such as v1.2.3 or 1.2.3+build. Versions with a
+// pre-release are rejected. When partial is set, the minor and patch parts may
+// be left out, and only the given parts are returned.
+func parseVersion(s string, partial bool) ([]int, bool) {
+	s = strings.TrimPrefix(s, "v")
+	s, _, _ = strings.Cut(s, "+")
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 || (len(parts) < 3 && !partial) {
+		return nil, false
+	}
+
+	version := make([]int, 0, len(parts))
+	for _, part := range parts {
+		if part == "" || strings.Trim(part, "0123456789") != "" {
+			return nil, false
+		}
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, false
+		}
+		version = append(version, n)
+	}
+	return version, true
+}
@@ -0,0 +1,109 @@
+package git
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// newTags creates tags created a day apart, in order
+func newTags(names ...string) []Tag {
+	tags := make([]Tag, len(names))
+	for i, name := range names {
+		tags[i] = Tag{Name: name, Date: time.Date(2024, 1, 1+i, 0, 0, 0, 0, time.UTC)}
+	}
+	return tags
+}
+
+func TestTagPattern(t *testing.T) {
+	tags := newTags("v0.9.0", "v1.0.0", "v1.2.0", "v1.10.1", "v1.11.0-rc.1", "v2.0.0", "v2.4.1+build.7", "v3.0.0", "latest", "release-2024")
+
+	testCases := []struct {
+		name     string
+		pattern  string
+		expected string
+	}{
+		{name: "Glob selects the newest version, not the greatest name", pattern: "v1.*", expected: "v1.10.1"},
+		{name: "Exact tag", pattern: "v1.2.0", expected: "v1.2.0"},
+		{name: "Glob matching tags that are not versions", pattern: "release-*", expected: "release-2024"},
+		{name: "Range with every comparison holding", pattern: ">=2.0.0 <3", expected: "v2.4.1+build.7"},
+		{name: "Range separated by commas", pattern: ">= 1.0.0, < 1.10", expected: "v1.2.0"},
+		{name: "Partial versions compare only their parts", pattern: "<=1.10", expected: "v1.10.1"},
+		{name: "Greater than a partial version skips all of it", pattern: ">1", expected: "v3.0.0"},
+		{name: "Excluded versions", pattern: "<3 !=2.4", expected: "v2.0.0"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pattern, err := ParseTagPattern(tc.pattern)
+			if err != nil {
+				t.Fatalf("Expected no error, but got %v", err)
+			}
+			tag, err := pattern.Newest(tags)
+			if err != nil {
+				t.Fatalf("Expected no error, but got %v", err)
+			}
+			if tag != tc.expected {
+				t.Errorf("Expected %s, but got %s", tc.expected, tag)
+			}
+		})
+	}
+
+	t.Run("Globs prefer versions, skipping pre-releases and other tags", func(t *testing.T) {
+		pattern, _ := ParseTagPattern("*")
+		if tag, _ := pattern.Newest(newTags("v1.0.0", "v1.1.0-rc.1", "latest")); tag != "v1.0.0" {
+			t.Errorf("Expected v1.0.0, but got %s", tag)
+		}
+	})
+
+	t.Run("Globs match versions without their patch part", func(t *testing.T) {
+		pattern, _ := ParseTagPattern("v1.*")
+		if tag, _ := pattern.Newest(newTags("v1.0.0", "v1.2", "v1.1.5")); tag != "v1.2" {
+			t.Errorf("Expected v1.2, but got %s", tag)
+		}
+	})
+
+	t.Run("Globs select the most recent tag when none is a version", func(t *testing.T) {
+		pattern, _ := ParseTagPattern("release-*")
+		tags := newTags("release-b", "release-c", "release-a")
+		if tag, _ := pattern.Newest(tags); tag != "release-a" {
+			t.Errorf("Expected the most recent tag release-a, but got %s", tag)
+		}
+
+		tags[0].Date = tags[2].Date
+		if tag, _ := pattern.Newest(tags); tag != "release-b" {
+			t.Errorf("Expected the greater name release-b for equal dates, but got %s", tag)
+		}
+	})
+
+	t.Run("Ranges skip tags that are not full versions", func(t *testing.T) {
+		pattern, _ := ParseTagPattern(">=1")
+		if tag, _ := pattern.Newest(newTags("v1.0.0", "v1.2", "latest")); tag != "v1.0.0" {
+			t.Errorf("Expected v1.0.0, but got %s", tag)
+		}
+	})
+
+	t.Run("Prefers the greater name for equal versions", func(t *testing.T) {
+		pattern, _ := ParseTagPattern(">=1")
+		for _, tags := range [][]Tag{newTags("1.0.0", "v1.0.0"), newTags("v1.0.0", "1.0.0")} {
+			if tag, _ := pattern.Newest(tags); tag != "v1.0.0" {
+				t.Errorf("Expected v1.0.0 from %v, but got %s", tags, tag)
+			}
+		}
+	})
+
+	t.Run("Fails when no tag matches", func(t *testing.T) {
+		pattern, _ := ParseTagPattern("v4.*")
+		if _, err := pattern.Newest(tags); !errors.Is(err, ErrNoMatchingTag) {
+			t.Errorf("Expected ErrNoMatchingTag, but got %v", err)
+		}
+	})
+
+	t.Run("Rejects invalid patterns", func(t *testing.T) {
+		for _, pattern := range []string{"", "v1.[", ">=2.0.0 3", ">=two", "<1.2.3.4", ">=1.0.0-rc.1"} {
+			if _, err := ParseTagPattern(pattern); err == nil {
+				t.Errorf("Expected an error for %q, but got nil", pattern)
+			}
+		}
+	})
+}
@@ -0,0 +1,79 @@
+package lock
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/gnugomez/voyage/log"
+)
+
+// defaultPollInterval is how often a waiting Acquire tries the lock again
+const defaultPollInterval = 500 * time.Millisecond
+
+// ErrLocked is returned when the lock is held by another process
+var ErrLocked = errors.New("locked by another process")
+
+// errHeld is returned by tryLock when another process holds the lock
+var errHeld = errors.New("lock held")
+
+// Lock is an advisory lock shared by the processes that use the same lock file.
+// The file holds the PID of the process holding the lock. On unix the lock is a
+// flock on the file, which the kernel releases when that process exits, however it
+// exits. Elsewhere the lock is the file itself, and a file whose process is gone is
+// stale and replaced.
+type Lock struct {
+	path    string
+	timeout time.Duration
+	pid     int
+	platformLock
+	// now, sleep and pollInterval drive the waiting, tests replace them
+	now          func() time.Time
+	sleep        func(time.Duration)
+	pollInterval time.Duration
+}
+
+// New creates a Lock on the file at path. Acquire waits up to timeout for another
+// process to release it, a timeout of 0 fails right away.
+func New(path string, timeout time.Duration) *Lock {
+	return &Lock{
+		path:         path,
+		timeout:      timeout,
+		pid:          os.Getpid(),
+		platformLock: newPlatformLock(),
+		now:          time.Now,
+		sleep:        time.Sleep,
+		pollInterval: defaultPollInterval,
+	}
+}
+
+// Acquire takes the lock and writes the PID of this process to the lock file. It
+// returns an error wrapping ErrLocked when another process still holds the lock
+// after the timeout.
+func (l *Lock) Acquire() error {
+	deadline := l.now().Add(l.timeout)
+	waiting := false
+	for {
+		holder, err := l.tryLock()
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, errHeld) {
+			return err
+		}
+
+		remaining := deadline.Sub(l.now())
+		if remaining <= 0 {
+			if holder == 0 {
+				return fmt.Errorf("%w: %s is held", ErrLocked, l.path)
+			}
+			return fmt.Errorf("%w: %s is held by process %d", ErrLocked, l.path, holder)
+		}
+		if !waiting {
+			log.Info("Waiting for another voyage run to release the lock", "path", l.path, "pid", holder, "timeout", l.timeout)
+			waiting = true
+		}
+		l.sleep(min(l.pollInterval, remaining))
+	}
+}
@@ -0,0 +1,103 @@
+//go:build !unix
+
+package lock
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gnugomez/voyage/log"
+)
+
+// platformLock tells whether the process holding the lock file still runs, tests
+// replace alive
+type platformLock struct {
+	alive func(pid int) bool
+}
+
+func newPlatformLock() platformLock {
+	return platformLock{alive: processAlive}
+}
+
+// tryLock takes the lock by creating the lock file with the PID of this process. A
+// lock file whose process is gone is stale and replaced. When a running process
+// holds the lock, it returns errHeld and its PID.
+func (l *Lock) tryLock() (int, error) {
+	for {
+		holder, err := l.create()
+		if err == nil {
+			return 0, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return 0, fmt.Errorf("failed to create lock file %s: %w", l.path, err)
+		}
+		if holder != 0 && l.alive(holder) {
+			return holder, errHeld
+		}
+
+		log.Warn("Removing stale lock file", "path", l.path, "pid", holder)
+		if err := os.Remove(l.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return 0, fmt.Errorf("failed to remove stale lock file %s: %w", l.path, err)
+		}
+	}
+}
+
+// create creates the lock file. When it already exists, it returns the PID it
+// holds, or 0 when it holds none.
+func (l *Lock) create() (int, error) {
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if errors.Is(err, fs.ErrExist) {
+		content, readErr := os.ReadFile(l.path)
+		if errors.Is(readErr, fs.ErrNotExist) {
+			return l.create() // released in the meantime
+		}
+		pid, _ := strconv.Atoi(strings.TrimSpace(string(content)))
+		return pid, err
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = fmt.Fprintf(file, "%d\n", l.pid)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(l.path)
+		return 0, err
+	}
+	return 0, nil
+}
+
+// Release removes the lock file, unless another process took it over
+func (l *Lock) Release() error {
+	content, err := os.ReadFile(l.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read lock file %s: %w", l.path, err)
+	}
+	if pid, _ := strconv.Atoi(strings.TrimSpace(string(content))); pid != l.pid {
+		return nil
+	}
+	if err := os.Remove(l.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to remove lock file %s: %w", l.path, err)
+	}
+	return nil
+}
+
+// processAlive reports whether the process with pid is running. On Windows
+// FindProcess opens the process, so it fails once the process is gone.
+func processAlive(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	process.Release()
+	return true
+}
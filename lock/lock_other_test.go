@@ -0,0 +1,67 @@
+//go:build !unix
+
+package lock
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLock_LockFile(t *testing.T) {
+	t.Run("Release removes the lock file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "out.lock")
+		l := New(path, 0)
+		if err := l.Acquire(); err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+
+		if err := l.Release(); err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("Expected the lock file to be removed, but got %v", err)
+		}
+	})
+
+	t.Run("Replaces a stale lock of a process that is gone", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "out.lock")
+		os.WriteFile(path, []byte("4242\n"), 0o644)
+
+		l := newTestLock(path, 0, os.Getpid())
+		l.alive = func(pid int) bool {
+			if pid != 4242 {
+				t.Errorf("Expected PID 4242 to be checked, but got %d", pid)
+			}
+			return false
+		}
+
+		if err := l.Acquire(); err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if pid := readPID(t, path); pid != os.Getpid() {
+			t.Errorf("Expected PID %d in the lock file, but got %d", os.Getpid(), pid)
+		}
+	})
+
+	t.Run("Release leaves a lock taken over by another process", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "out.lock")
+		os.WriteFile(path, []byte("4242\n"), 0o644)
+
+		if err := New(path, 0).Release(); err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if pid := readPID(t, path); pid != 4242 {
+			t.Errorf("Expected the lock to stay with PID 4242, but got %d", pid)
+		}
+	})
+}
+
+func TestProcessAlive(t *testing.T) {
+	if !processAlive(os.Getpid()) {
+		t.Error("Expected the test process to be alive")
+	}
+	if processAlive(1 << 30) {
+		t.Error("Expected a PID above the maximum not to be alive")
+	}
+}
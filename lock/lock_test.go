@@ -0,0 +1,109 @@
+package lock
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestLock creates a Lock for pid whose clock only moves when it sleeps
+func newTestLock(path string, timeout time.Duration, pid int) *Lock {
+	l := New(path, timeout)
+	l.pid = pid
+	now := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	l.now = func() time.Time { return now }
+	l.sleep = func(d time.Duration) { now = now.Add(d) }
+	return l
+}
+
+func readPID(t *testing.T, path string) int {
+	t.Helper()
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Expected the lock file to exist, but got %v", err)
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(content)))
+	return pid
+}
+
+// acquire takes a lock on path for the duration of the test
+func acquire(t *testing.T, path string) *Lock {
+	t.Helper()
+	holder := New(path, 0)
+	if err := holder.Acquire(); err != nil {
+		t.Fatalf("Expected no error, but got %v", err)
+	}
+	t.Cleanup(func() { holder.Release() })
+	return holder
+}
+
+func TestLock(t *testing.T) {
+	t.Run("Acquire writes the PID", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "out.lock")
+		l := New(path, 0)
+
+		if err := l.Acquire(); err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		defer l.Release()
+		if pid := readPID(t, path); pid != os.Getpid() {
+			t.Errorf("Expected PID %d in the lock file, but got %d", os.Getpid(), pid)
+		}
+	})
+
+	t.Run("Fails right away when held without a timeout", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "out.lock")
+		acquire(t, path)
+
+		l := newTestLock(path, 0, os.Getpid()+1)
+		l.sleep = func(time.Duration) { t.Error("Expected not to wait for the lock") }
+
+		err := l.Acquire()
+		if !errors.Is(err, ErrLocked) {
+			t.Fatalf("Expected ErrLocked, but got %v", err)
+		}
+		if !strings.Contains(err.Error(), "process "+strconv.Itoa(os.Getpid())) {
+			t.Errorf("Expected the holder PID in the error, but got %v", err)
+		}
+		if pid := readPID(t, path); pid != os.Getpid() {
+			t.Errorf("Expected the lock to stay with PID %d, but got %d", os.Getpid(), pid)
+		}
+	})
+
+	t.Run("Waits up to the timeout for the lock", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "out.lock")
+		acquire(t, path)
+
+		l := newTestLock(path, 3*time.Second, os.Getpid()+1)
+		var slept time.Duration
+		sleep := l.sleep
+		l.sleep = func(d time.Duration) { slept += d; sleep(d) }
+
+		if err := l.Acquire(); !errors.Is(err, ErrLocked) {
+			t.Fatalf("Expected ErrLocked, but got %v", err)
+		}
+		if slept != 3*time.Second {
+			t.Errorf("Expected to wait 3s, but waited %s", slept)
+		}
+	})
+
+	t.Run("Takes the lock once it is released while waiting", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "out.lock")
+		holder := acquire(t, path)
+
+		l := newTestLock(path, time.Minute, os.Getpid()+1)
+		l.sleep = func(time.Duration) { holder.Release() }
+
+		if err := l.Acquire(); err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		defer l.Release()
+		if pid := readPID(t, path); pid != os.Getpid()+1 {
+			t.Errorf("Expected PID %d in the lock file, but got %d", os.Getpid()+1, pid)
+		}
+	})
+}
@@ -0,0 +1,87 @@
+//go:build unix
+
+package lock
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// platformLock holds the open lock file while the flock is held
+type platformLock struct {
+	file *os.File
+}
+
+func newPlatformLock() platformLock {
+	return platformLock{}
+}
+
+// tryLock takes the flock on the lock file without waiting. When another process
+// holds it, it returns errHeld and the PID written to the file, or 0 when it holds
+// none.
+func (l *Lock) tryLock() (int, error) {
+	file, err := os.OpenFile(l.path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open lock file %s: %w", l.path, err)
+	}
+
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		holder := holderPID(file)
+		file.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return holder, errHeld
+		}
+		return 0, fmt.Errorf("failed to lock %s: %w", l.path, err)
+	}
+
+	if err := writePID(file, l.pid); err != nil {
+		file.Close() // releases the flock
+		return 0, fmt.Errorf("failed to write lock file %s: %w", l.path, err)
+	}
+	l.file = file
+	return 0, nil
+}
+
+// Release releases the lock. The lock file is emptied but kept: removing it would
+// let a process that opened it before the removal lock a file no one else sees.
+func (l *Lock) Release() error {
+	if l.file == nil {
+		return nil
+	}
+	file := l.file
+	l.file = nil
+
+	truncateErr := file.Truncate(0)
+	// Closing the file releases the flock
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to release lock %s: %w", l.path, err)
+	}
+	if truncateErr != nil {
+		return fmt.Errorf("failed to empty lock file %s: %w", l.path, truncateErr)
+	}
+	return nil
+}
+
+// holderPID returns the PID written to the lock file, or 0 when it holds none
+func holderPID(file *os.File) int {
+	content, err := io.ReadAll(io.NewSectionReader(file, 0, 64))
+	if err != nil {
+		return 0
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(content)))
+	return pid
+}
+
+// writePID replaces the content of the lock file with pid
+func writePID(file *os.File, pid int) error {
+	if err := file.Truncate(0); err != nil {
+		return err
+	}
+	_, err := file.WriteAt([]byte(strconv.Itoa(pid)+"\n"), 0)
+	return err
+}
@@ -0,0 +1,44 @@
+//go:build unix
+
+package lock
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestLock_Flock(t *testing.T) {
+	t.Run("Release empties the lock file and keeps it", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "out.lock")
+		l := New(path, 0)
+		if err := l.Acquire(); err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+
+		if err := l.Release(); err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if pid := readPID(t, path); pid != 0 {
+			t.Errorf("Expected an empty lock file, but got PID %d", pid)
+		}
+		if err := l.Release(); err != nil {
+			t.Errorf("Expected releasing twice to do nothing, but got %v", err)
+		}
+	})
+
+	t.Run("Ignores the PID left behind by a process that exited", func(t *testing.T) {
+		// A restarted container runs as the same PID as the process that left the file
+		for _, pid := range []int{4242, os.Getpid()} {
+			path := filepath.Join(t.TempDir(), "out.lock")
+			os.WriteFile(path, []byte(strconv.Itoa(pid)+"\n"), 0o644)
+
+			l := New(path, 0)
+			if err := l.Acquire(); err != nil {
+				t.Fatalf("Expected no error for a file left by PID %d, but got %v", pid, err)
+			}
+			l.Release()
+		}
+	})
+}
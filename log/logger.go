@@ -1,12 +1,21 @@
 package log
 
-import "github.com/charmbracelet/log"
+import (
+	"io"
+	"os"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
 
 type Logger interface {
 	Info(message string, vals ...any)
+	Warn(message string, vals ...any)
 	Error(message string, vals ...any)
 	Fatal(message string, vals ...any)
 	Debug(message string, vals ...any)
+	// With returns a child logger that adds vals to every line it writes
+	With(vals ...any) Logger
 }
 
 type LogLevel string
@@ -14,6 +23,7 @@ type LogLevel string
 const (
 	DebugLevel LogLevel = "debug"
 	InfoLevel  LogLevel = "info"
+	WarnLevel  LogLevel = "warn"
 	ErrorLevel LogLevel = "error"
 	FatalLevel LogLevel = "fatal"
 )
@@ -24,6 +34,8 @@ func ParseLogLevel(level string) LogLevel {
 		return DebugLevel
 	case "info":
 		return InfoLevel
+	case "warn", "warning":
+		return WarnLevel
 	case "error":
 		return ErrorLevel
 	case "fatal":
@@ -33,6 +45,19 @@ func ParseLogLevel(level string) LogLevel {
 	}
 }
 
+// LogFormat is how log lines are written
+type LogFormat string
+
+const (
+	// TextFormat writes human readable lines, colored on a terminal
+	TextFormat   LogFormat = "text"
+	JSONFormat   LogFormat = "json"
+	LogfmtFormat LogFormat = "logfmt"
+)
+
+// LogFormats lists the supported log formats
+var LogFormats = []LogFormat{TextFormat, JSONFormat, LogfmtFormat}
+
 var GlobalLogger Logger = CreateDefaultLogger(InfoLevel)
 
 func SetLogger(logger Logger) {
@@ -43,6 +68,10 @@ func Info(message string, vals ...any) {
 	GlobalLogger.Info(message, vals...)
 }
 
+func Warn(message string, vals ...any) {
+	GlobalLogger.Warn(message, vals...)
+}
+
 func Error(message string, vals ...any) {
 	GlobalLogger.Error(message, vals...)
 }
@@ -55,35 +84,115 @@ func Debug(message string, vals ...any) {
 	GlobalLogger.Debug(message, vals...)
 }
 
+// With returns a child of the global logger that adds vals to every line it writes
+func With(vals ...any) Logger {
+	return GlobalLogger.With(vals...)
+}
+
 // Default logger implementation
 
 var DefaultLogLevel = map[LogLevel]log.Level{
 	DebugLevel: log.DebugLevel,
 	InfoLevel:  log.InfoLevel,
+	WarnLevel:  log.WarnLevel,
 	ErrorLevel: log.ErrorLevel,
 	FatalLevel: log.FatalLevel,
 }
 
+var logFormatters = map[LogFormat]log.Formatter{
+	TextFormat:   log.TextFormatter,
+	JSONFormat:   log.JSONFormatter,
+	LogfmtFormat: log.LogfmtFormatter,
+}
+
+// Options configures the logger created by CreateLogger
+type Options struct {
+	Level  LogLevel
+	Format LogFormat
+	// File is written to as well as stderr when set. It is rotated once it grows
+	// past MaxSize bytes, keeping MaxBackups rotated files.
+	File       string
+	MaxSize    int64
+	MaxBackups int
+}
+
+// defaultLogger writes every line to each of its outputs
 type defaultLogger struct {
+	loggers []*log.Logger
 }
 
 func CreateDefaultLogger(level LogLevel) *defaultLogger {
-	log.SetLevel(DefaultLogLevel[level])
-	return &defaultLogger{}
+	return &defaultLogger{loggers: []*log.Logger{newOutput(os.Stderr, level, TextFormat)}}
+}
+
+// CreateLogger creates a logger writing to stderr, and to a rotated log file when
+// options has one.
+func CreateLogger(options Options) (*defaultLogger, error) {
+	logger := &defaultLogger{loggers: []*log.Logger{newOutput(os.Stderr, options.Level, options.Format)}}
+	if options.File != "" {
+		file, err := OpenRotatingFile(options.File, options.MaxSize, options.MaxBackups)
+		if err != nil {
+			return nil, err
+		}
+		logger.loggers = append(logger.loggers, newOutput(file, options.Level, options.Format))
+	}
+	return logger, nil
+}
+
+func newOutput(w io.Writer, level LogLevel, format LogFormat) *log.Logger {
+	formatter, ok := logFormatters[format]
+	if !ok {
+		formatter = log.TextFormatter
+	}
+	// Machine readable lines carry a timestamp log pipelines can parse
+	timeFormat := log.DefaultTimeFormat
+	if formatter != log.TextFormatter {
+		timeFormat = time.RFC3339
+	}
+	return log.NewWithOptions(w, log.Options{
+		Level:           DefaultLogLevel[ParseLogLevel(string(level))],
+		Formatter:       formatter,
+		ReportTimestamp: true,
+		TimeFormat:      timeFormat,
+	})
+}
+
+func (l *defaultLogger) Info(message string, vals ...any) {
+	for _, logger := range l.loggers {
+		logger.Info(message, vals...)
+	}
+}
+
+func (l *defaultLogger) Warn(message string, vals ...any) {
+	for _, logger := range l.loggers {
+		logger.Warn(message, vals...)
+	}
 }
 
-func (*defaultLogger) Info(message string, vals ...any) {
-	log.Info(message, vals...)
+func (l *defaultLogger) Debug(message string, vals ...any) {
+	for _, logger := range l.loggers {
+		logger.Debug(message, vals...)
+	}
 }
 
-func (*defaultLogger) Debug(message string, vals ...any) {
-	log.Debug(message, vals...)
+func (l *defaultLogger) Error(message string, vals ...any) {
+	for _, logger := range l.loggers {
+		logger.Error(message, vals...)
+	}
 }
 
-func (*defaultLogger) Error(message string, vals ...any) {
-	log.Error(message, vals...)
+// Fatal writes the line to every output before exiting
+func (l *defaultLogger) Fatal(message string, vals ...any) {
+	for _, logger := range l.loggers {
+		logger.Log(log.FatalLevel, message, vals...)
+	}
+	os.Exit(1)
 }
 
-func (*defaultLogger) Fatal(message string, vals ...any) {
-	log.Fatal(message, vals...)
+func (l *defaultLogger) With(vals ...any) Logger {
+	child := &defaultLogger{loggers: make([]*log.Logger, len(l.loggers))}
+	for i, logger := range l.loggers {
+		child.loggers[i] = logger.With(vals...)
+	}
+	return child
 }
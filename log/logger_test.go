@@ -1,6 +1,15 @@
 package log
 
-import "testing"
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
 
 func TestParseLogLevel(t *testing.T) {
 	testCases := []struct {
@@ -18,6 +27,11 @@ func TestParseLogLevel(t *testing.T) {
 			input:    "info",
 			expected: InfoLevel,
 		},
+		{
+			name:     "Warn",
+			input:    "warn",
+			expected: WarnLevel,
+		},
 		{
 			name:     "Error",
 			input:    "error",
@@ -48,3 +62,88 @@ func TestParseLogLevel(t *testing.T) {
 		})
 	}
 }
+
+func TestCreateLogger(t *testing.T) {
+	t.Run("Writes JSON lines with the fields of child loggers to the log file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "voyage.log")
+		logger, err := CreateLogger(Options{Level: WarnLevel, Format: JSONFormat, File: path})
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+
+		logger.Info("Filtered out by the level")
+		logger.With("stack", "app/compose.yml", "commit", "c1").Warn("Deploy is slow", "elapsed", "1m")
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+		if len(lines) != 1 {
+			t.Fatalf("Expected one line, but got %q", content)
+		}
+		var line map[string]any
+		if err := json.Unmarshal([]byte(lines[0]), &line); err != nil {
+			t.Fatalf("Expected a JSON line, but got %q", lines[0])
+		}
+		for key, expected := range map[string]string{"level": "warn", "msg": "Deploy is slow", "stack": "app/compose.yml", "commit": "c1", "elapsed": "1m"} {
+			if line[key] != expected {
+				t.Errorf("Expected %s=%s, but got %v", key, expected, line[key])
+			}
+		}
+		if _, err := time.Parse(time.RFC3339, line["time"].(string)); err != nil {
+			t.Errorf("Expected an RFC 3339 time, but got %v", line["time"])
+		}
+	})
+
+	t.Run("Writes logfmt lines", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "voyage.log")
+		logger, err := CreateLogger(Options{Level: InfoLevel, Format: LogfmtFormat, File: path})
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+
+		logger.Info("Deploying compose file", "stack", "app/compose.yml")
+
+		content, _ := os.ReadFile(path)
+		if !strings.Contains(string(content), `level=info msg="Deploying compose file" stack=app/compose.yml`) {
+			t.Errorf("Expected a logfmt line, but got %q", content)
+		}
+	})
+
+	t.Run("Returns error when the log file cannot be opened", func(t *testing.T) {
+		if _, err := CreateLogger(Options{File: filepath.Join(t.TempDir(), "missing", "voyage.log")}); err == nil {
+			t.Fatal("Expected an error, but got nil")
+		}
+	})
+}
+
+func TestWith(t *testing.T) {
+	t.Run("Writes the lines of stacks deployed in parallel", func(t *testing.T) {
+		defer SetLogger(GlobalLogger)
+
+		path := filepath.Join(t.TempDir(), "voyage.log")
+		logger, err := CreateLogger(Options{Level: InfoLevel, Format: LogfmtFormat, File: path})
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		SetLogger(logger)
+
+		var wg sync.WaitGroup
+		for i := range 8 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				With("stack", fmt.Sprintf("app%d/compose.yml", i)).Info("Deploying compose file")
+			}()
+		}
+		wg.Wait()
+
+		content, _ := os.ReadFile(path)
+		for i := range 8 {
+			if !strings.Contains(string(content), fmt.Sprintf("stack=app%d/compose.yml", i)) {
+				t.Errorf("Expected a line for app%d/compose.yml, but got %q", i, content)
+			}
+		}
+	})
+}
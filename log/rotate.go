@@ -0,0 +1,128 @@
+package log
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+)
+
+// RotatingFile is a log file that is rotated when it would grow past its maximum
+// size. Rotated files are kept as path.1, path.2, ... with path.1 the newest.
+type RotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mu sync.Mutex
+	// file is nil when a failed rotation could not reopen it
+	file *os.File
+	size int64
+}
+
+// OpenRotatingFile opens the log file at path for appending. A maxSize of 0 never
+// rotates it, a maxBackups of 0 discards it when it is rotated.
+func OpenRotatingFile(path string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+	f := &RotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file %s: %w", f.path, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to open log file %s: %w", f.path, err)
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+// Write appends p to the file, rotating it first when p would not fit. A line
+// larger than the maximum size is written to a file of its own. When the file
+// cannot be rotated, p is still appended to it and the error returned.
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	var rotateErr error
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		rotateErr = f.rotate()
+		if f.file == nil {
+			return 0, rotateErr
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	if err == nil {
+		err = rotateErr
+	}
+	return n, err
+}
+
+// rotate shifts the rotated files up by one, dropping the oldest, and starts a new
+// file. When the files cannot be shifted, it reopens the file it could not rotate.
+func (f *RotatingFile) rotate() error {
+	err := f.file.Close()
+	f.file = nil
+	if err != nil {
+		return errors.Join(fmt.Errorf("failed to close log file %s: %w", f.path, err), f.open())
+	}
+
+	if err := f.shift(); err != nil {
+		return errors.Join(err, f.open())
+	}
+	return f.open()
+}
+
+// shift moves the file to path.1 and every rotated file up by one, dropping the
+// oldest
+func (f *RotatingFile) shift() error {
+	if f.maxBackups == 0 {
+		if err := os.Remove(f.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to rotate log file %s: %w", f.path, err)
+		}
+		return nil
+	}
+
+	for i := f.maxBackups - 1; i >= 1; i-- {
+		err := os.Rename(backupPath(f.path, i), backupPath(f.path, i+1))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to rotate log file %s: %w", f.path, err)
+		}
+	}
+	if err := os.Rename(f.path, backupPath(f.path, 1)); err != nil {
+		return fmt.Errorf("failed to rotate log file %s: %w", f.path, err)
+	}
+	return nil
+}
+
+func backupPath(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
+
+// Close closes the file
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
@@ -0,0 +1,118 @@
+package log
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRotatingFile_Write(t *testing.T) {
+	readFile := func(t *testing.T, path string) string {
+		t.Helper()
+		content, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Expected %s to exist, but got %v", filepath.Base(path), err)
+		}
+		return string(content)
+	}
+
+	t.Run("Rotates the file when a line would not fit", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "voyage.log")
+		file, err := OpenRotatingFile(path, 10, 2)
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		defer file.Close()
+
+		for _, line := range []string{"line1\n", "line2\n", "line3\n", "line4\n"} {
+			if _, err := file.Write([]byte(line)); err != nil {
+				t.Fatalf("Expected no error, but got %v", err)
+			}
+		}
+
+		if content := readFile(t, path); content != "line4\n" {
+			t.Errorf("Expected the newest line in the log file, but got %q", content)
+		}
+		if content := readFile(t, path+".1"); content != "line3\n" {
+			t.Errorf("Expected the previous line in the first backup, but got %q", content)
+		}
+		if content := readFile(t, path+".2"); content != "line2\n" {
+			t.Errorf("Expected the line before in the second backup, but got %q", content)
+		}
+		if _, err := os.Stat(path + ".3"); err == nil {
+			t.Error("Expected no more than 2 backups")
+		}
+	})
+
+	t.Run("Continues an existing file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "voyage.log")
+		if err := os.WriteFile(path, []byte("old line\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		file, err := OpenRotatingFile(path, 12, 1)
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		defer file.Close()
+
+		file.Write([]byte("new\n"))
+
+		if content := readFile(t, path); content != "new\n" {
+			t.Errorf("Expected the file to be rotated, counting its existing size, but got %q", content)
+		}
+		if content := readFile(t, path+".1"); content != "old line\n" {
+			t.Errorf("Expected the existing lines in the backup, but got %q", content)
+		}
+	})
+
+	t.Run("Keeps writing to the file when it cannot be rotated", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "voyage.log")
+		file, err := OpenRotatingFile(path, 10, 1)
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		defer file.Close()
+		// A directory in the way of the backup makes the rename fail
+		if err := os.MkdirAll(filepath.Join(path+".1", "blocked"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+
+		file.Write([]byte("line1\n"))
+		if _, err := file.Write([]byte("line2\n")); err == nil {
+			t.Error("Expected the rotation error, but got nil")
+		}
+		if content := readFile(t, path); content != "line1\nline2\n" {
+			t.Errorf("Expected the lines in the file that could not be rotated, but got %q", content)
+		}
+
+		if err := os.RemoveAll(path + ".1"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := file.Write([]byte("line3\n")); err != nil {
+			t.Fatalf("Expected the next rotation to succeed, but got %v", err)
+		}
+		if content := readFile(t, path); content != "line3\n" {
+			t.Errorf("Expected the newest line in the log file, but got %q", content)
+		}
+		if content := readFile(t, path+".1"); content != "line1\nline2\n" {
+			t.Errorf("Expected the previous lines in the backup, but got %q", content)
+		}
+	})
+
+	t.Run("Never rotates without a maximum size", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "voyage.log")
+		file, err := OpenRotatingFile(path, 0, 1)
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		defer file.Close()
+
+		file.Write([]byte(strings.Repeat("x", 1000)))
+		file.Write([]byte("y"))
+
+		if _, err := os.Stat(path + ".1"); err == nil {
+			t.Error("Expected no backup")
+		}
+	})
+}
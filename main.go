@@ -1,6 +1,8 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"os"
 
@@ -12,26 +14,49 @@ func main() {
 	if len(os.Args) < 2 {
 		log.Error("No command provided. Usage: voyage <command> [options]")
 		printAvailableCommands()
-		os.Exit(1)
+		os.Exit(command.ExitConfigError)
 	}
 
-	cmd := os.Args[1]
+	name := os.Args[1]
 	os.Args = append([]string{os.Args[0]}, os.Args[2:]...)
 
-	createCommand, ok := command.Commands[cmd]
+	createCommand, ok := command.Commands[name]
 
 	if !ok {
-		log.Error("Unknown command", "command", cmd)
+		log.Error("Unknown command", "command", name)
 		printAvailableCommands()
-		os.Exit(1)
+		os.Exit(command.ExitConfigError)
 	}
 
-	command := createCommand()
-	baseParams := command.GetBaseParameters()
+	cmd, err := createCommand()
+	if err != nil {
+		// Help was asked for, the usage is already printed
+		if !errors.Is(err, flag.ErrHelp) {
+			log.Error("Error parsing parameters", "error", err)
+		}
+		os.Exit(command.ExitCode(err))
+	}
+	baseParams := cmd.GetBaseParameters()
 
-	log.SetLogger(log.CreateDefaultLogger(log.ParseLogLevel(baseParams.LogLevel)))
+	logger, err := log.CreateLogger(log.Options{
+		Level:      log.ParseLogLevel(baseParams.LogLevel),
+		Format:     log.LogFormat(baseParams.LogFormat),
+		File:       baseParams.LogFile,
+		MaxSize:    int64(baseParams.LogMaxSize) << 20,
+		MaxBackups: baseParams.LogMaxBackups,
+	})
+	if err != nil {
+		log.Error("Error creating logger", "error", err)
+		os.Exit(command.ExitConfigError)
+	}
+	log.SetLogger(logger)
 
-	command.Handle()
+	err = cmd.Handle()
+	code := command.ExitCode(err)
+	if err != nil && code != command.ExitNoChanges {
+		log.Error("Command failed", "error", err, "exitCode", code)
+	}
+	os.Exit(code)
 }
 
 func printAvailableCommands() {
@@ -0,0 +1,110 @@
+package notify
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// smtpsPort is the port SMTP servers speak implicit TLS on, other ports are
+// upgraded with STARTTLS when the server offers it
+const smtpsPort = "465"
+
+// emailNotifier sends events as plain text emails through an SMTP server
+type emailNotifier struct {
+	config Config
+	// send delivers a message, tests replace it
+	send func(ctx context.Context, config Config, message []byte) error
+}
+
+func newEmailNotifier(config Config) *emailNotifier {
+	return &emailNotifier{config: config, send: sendMail}
+}
+
+func (n *emailNotifier) Notify(ctx context.Context, event Event) error {
+	if err := n.send(ctx, n.config, emailMessage(n.config, event)); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	return nil
+}
+
+// emailMessage formats an event as an RFC 5322 message
+func emailMessage(config Config, event Event) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", config.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(config.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", event.Title()))
+	fmt.Fprintf(&b, "Date: %s\r\n", event.Time.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(event.Message(), "\n", "\r\n"))
+	b.WriteString("\r\n")
+	return []byte(b.String())
+}
+
+// sendMail delivers message through the SMTP server of config, authenticating
+// when a username is configured
+func sendMail(ctx context.Context, config Config, message []byte) error {
+	host, port, err := net.SplitHostPort(config.Host)
+	if err != nil {
+		return fmt.Errorf("invalid SMTP host %s: %w", config.Host, err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+	dialer := &net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", config.Host)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if port == smtpsPort {
+		conn = tls.Client(conn, &tls.Config{ServerName: host})
+	}
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok && port != smtpsPort {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if config.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", config.Username, config.Password, host)); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(config.From); err != nil {
+		return err
+	}
+	for _, to := range config.To {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(message); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
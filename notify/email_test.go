@@ -0,0 +1,50 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestEmailNotifier_Notify(t *testing.T) {
+	config := Config{Type: Email, Host: "smtp.example.com:587", From: "voyage@example.com", To: []string{"ops@example.com", "dev@example.com"}}
+
+	t.Run("Sends the event as a plain text email", func(t *testing.T) {
+		var sent string
+		notifier := newEmailNotifier(config)
+		notifier.send = func(ctx context.Context, config Config, message []byte) error {
+			sent = string(message)
+			return nil
+		}
+
+		if err := notifier.Notify(context.Background(), testEvent); err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+
+		for _, header := range []string{
+			"From: voyage@example.com\r\n",
+			"To: ops@example.com, dev@example.com\r\n",
+			"Subject: voyage: failed to deploy app/compose.yml\r\n",
+			"Date: Wed, 01 May 2024 12:00:00 +0000\r\n",
+		} {
+			if !strings.Contains(sent, header) {
+				t.Errorf("Expected header %q, but got:\n%s", header, sent)
+			}
+		}
+		if !strings.HasSuffix(sent, "\r\n\r\n"+strings.ReplaceAll(testEvent.Message(), "\n", "\r\n")+"\r\n") {
+			t.Errorf("Expected the message as body, but got:\n%s", sent)
+		}
+	})
+
+	t.Run("Returns error when the email cannot be sent", func(t *testing.T) {
+		notifier := newEmailNotifier(config)
+		notifier.send = func(ctx context.Context, config Config, message []byte) error {
+			return errors.New("535 authentication failed")
+		}
+
+		if err := notifier.Notify(context.Background(), testEvent); err == nil || !strings.Contains(err.Error(), "535") {
+			t.Errorf("Expected the SMTP error, but got %v", err)
+		}
+	})
+}
@@ -0,0 +1,264 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// EventType is what happened to a stack in a deploy cycle
+type EventType string
+
+const (
+	Deployed   EventType = "deployed"
+	Failed     EventType = "failed"
+	RolledBack EventType = "rolledBack"
+	NoChanges  EventType = "noop"
+)
+
+// EventTypes lists every event a notifier can subscribe to
+var EventTypes = []EventType{Deployed, Failed, RolledBack, NoChanges}
+
+// defaultEvents are sent to notifiers that do not list their events. Cycles without
+// changes are left out, in watch mode they would be sent on every interval.
+var defaultEvents = []EventType{Deployed, Failed, RolledBack}
+
+// Event describes the result of a deploy cycle for a stack
+type Event struct {
+	Type   EventType `json:"event"`
+	Repo   string    `json:"repo"`
+	Branch string    `json:"branch"`
+	// Stack is the compose path, empty for cycles without changes
+	Stack string `json:"stack,omitempty"`
+	// From is the commit the stack ran before, To the commit it was deployed,
+	// rolled back or failed to be deployed at
+	From   string    `json:"from,omitempty"`
+	To     string    `json:"to,omitempty"`
+	Reason string    `json:"reason,omitempty"`
+	Error  string    `json:"error,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+// Title returns a one line summary of the event
+func (e Event) Title() string {
+	switch e.Type {
+	case Deployed:
+		return "voyage: deployed " + e.Stack
+	case Failed:
+		return "voyage: failed to deploy " + e.Stack
+	case RolledBack:
+		return "voyage: rolled back " + e.Stack
+	default:
+		return "voyage: no changes in " + e.Branch
+	}
+}
+
+// Message returns the details of the event, one per line
+func (e Event) Message() string {
+	lines := []string{fmt.Sprintf("Repository: %s (%s)", redactURL(e.Repo), e.Branch)}
+	if e.Stack != "" {
+		lines = append(lines, "Stack: "+e.Stack)
+	}
+	if commits := e.Commits(); commits != "" {
+		lines = append(lines, "Commits: "+commits)
+	}
+	if e.Reason != "" {
+		lines = append(lines, "Reason: "+e.Reason)
+	}
+	if e.Error != "" {
+		lines = append(lines, "Error: "+e.Error)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Commits returns the commit range of the event as from..to in short form, or
+// only to when the stack ran no commit before
+func (e Event) Commits() string {
+	switch {
+	case e.From == "" || e.From == e.To:
+		return shortCommit(e.To)
+	default:
+		return shortCommit(e.From) + ".." + shortCommit(e.To)
+	}
+}
+
+func shortCommit(commit string) string {
+	if len(commit) > 7 {
+		return commit[:7]
+	}
+	return commit
+}
+
+// redactURL removes the credentials of a repository URL, such as a token
+// given as https://token@host/repo.git
+func redactURL(repo string) string {
+	u, err := url.Parse(repo)
+	if err != nil || u.User == nil {
+		return repo
+	}
+	u.User = nil
+	return u.String()
+}
+
+// Notifier sends events to a service
+type Notifier interface {
+	Notify(ctx context.Context, event Event) error
+}
+
+// Service identifies the kind of a notifier
+type Service string
+
+const (
+	Webhook Service = "webhook"
+	Ntfy    Service = "ntfy"
+	Gotify  Service = "gotify"
+	Slack   Service = "slack"
+	Matrix  Service = "matrix"
+	Email   Service = "email"
+)
+
+// Config configures a notifier. The fields used depend on its type.
+type Config struct {
+	Type Service `json:"type" yaml:"type"`
+	// Events lists the events sent, deployed, failed and rolledBack when empty
+	Events []EventType `json:"events" yaml:"events"`
+	// URL is the webhook, ntfy topic, Gotify server or Matrix homeserver URL
+	URL string `json:"url" yaml:"url"`
+	// Token is sent as bearer token to webhooks, ntfy and Matrix, and as
+	// application token to Gotify
+	Token string `json:"token" yaml:"token"`
+	// Room is the Matrix room ID messages are sent to
+	Room string `json:"room" yaml:"room"`
+	// SMTP settings of email notifiers, Host includes the port
+	Host     string   `json:"host" yaml:"host"`
+	Username string   `json:"username" yaml:"username"`
+	Password string   `json:"password" yaml:"password"`
+	From     string   `json:"from" yaml:"from"`
+	To       []string `json:"to" yaml:"to"`
+}
+
+// Validate checks that the config has the fields its type needs
+func (c Config) Validate() error {
+	for _, event := range c.Events {
+		if !slices.Contains(EventTypes, event) {
+			return fmt.Errorf("unsupported event %q for %s notifier, expected one of %s", event, c.Type, joinEvents(EventTypes))
+		}
+	}
+
+	var missing []string
+	switch c.Type {
+	case Webhook, Ntfy, Slack:
+		if c.URL == "" {
+			missing = append(missing, "url")
+		}
+	case Gotify:
+		if c.URL == "" {
+			missing = append(missing, "url")
+		}
+		if c.Token == "" {
+			missing = append(missing, "token")
+		}
+	case Matrix:
+		if c.URL == "" {
+			missing = append(missing, "url")
+		}
+		if c.Token == "" {
+			missing = append(missing, "token")
+		}
+		if c.Room == "" {
+			missing = append(missing, "room")
+		}
+	case Email:
+		if c.Host == "" {
+			missing = append(missing, "host")
+		}
+		if c.From == "" {
+			missing = append(missing, "from")
+		}
+		if len(c.To) == 0 {
+			missing = append(missing, "to")
+		}
+	default:
+		return fmt.Errorf("unsupported notifier type %q, expected one of %s, %s, %s, %s, %s or %s", c.Type, Webhook, Ntfy, Gotify, Slack, Matrix, Email)
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%s notifier is missing %s", c.Type, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+func joinEvents(events []EventType) string {
+	names := make([]string, len(events))
+	for i, event := range events {
+		names[i] = string(event)
+	}
+	return strings.Join(names, ", ")
+}
+
+// New creates the notifier a config describes
+func New(config Config) (Notifier, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	switch config.Type {
+	case Webhook:
+		return newWebhookNotifier(config), nil
+	case Ntfy:
+		return newNtfyNotifier(config), nil
+	case Gotify:
+		return newGotifyNotifier(config), nil
+	case Slack:
+		return newSlackNotifier(config), nil
+	case Matrix:
+		return newMatrixNotifier(config), nil
+	default:
+		return newEmailNotifier(config), nil
+	}
+}
+
+type subscription struct {
+	service  Service
+	events   []EventType
+	notifier Notifier
+}
+
+// Dispatcher sends every event to the notifiers subscribed to it
+type Dispatcher struct {
+	subscriptions []subscription
+}
+
+// NewDispatcher creates a Dispatcher with a notifier for every config
+func NewDispatcher(configs []Config) (*Dispatcher, error) {
+	d := &Dispatcher{}
+	for _, config := range configs {
+		notifier, err := New(config)
+		if err != nil {
+			return nil, err
+		}
+		events := config.Events
+		if len(events) == 0 {
+			events = defaultEvents
+		}
+		d.subscriptions = append(d.subscriptions, subscription{service: config.Type, events: events, notifier: notifier})
+	}
+	return d, nil
+}
+
+// Notify sends the event to every subscribed notifier. A failing notifier does
+// not keep the event from the others, their errors are returned together.
+func (d *Dispatcher) Notify(ctx context.Context, event Event) error {
+	var errs []error
+	for _, s := range d.subscriptions {
+		if !slices.Contains(s.events, event.Type) {
+			continue
+		}
+		if err := s.notifier.Notify(ctx, event); err != nil {
+			errs = append(errs, fmt.Errorf("%s notifier: %w", s.service, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,165 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	defaultTimeout = 10 * time.Second
+	// maxErrorBody is how much of an error response ends up in the error
+	maxErrorBody = 512
+)
+
+// httpNotifier sends requests built from events to a service
+type httpNotifier struct {
+	client  *http.Client
+	request func(ctx context.Context, event Event) (*http.Request, error)
+}
+
+func (n *httpNotifier) Notify(ctx context.Context, event Event) error {
+	req, err := n.request(ctx, event)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send notification: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		return fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+func newHTTPNotifier(request func(ctx context.Context, event Event) (*http.Request, error)) *httpNotifier {
+	return &httpNotifier{client: &http.Client{Timeout: defaultTimeout}, request: request}
+}
+
+// jsonRequest creates a request with body encoded as JSON
+func jsonRequest(ctx context.Context, method, url string, body any) (*http.Request, error) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
+
+// newWebhookNotifier posts events as JSON, for services without a notifier of their own
+func newWebhookNotifier(config Config) *httpNotifier {
+	return newHTTPNotifier(func(ctx context.Context, event Event) (*http.Request, error) {
+		event.Repo = redactURL(event.Repo)
+		req, err := jsonRequest(ctx, http.MethodPost, config.URL, event)
+		if err != nil {
+			return nil, err
+		}
+		if config.Token != "" {
+			req.Header.Set("Authorization", "Bearer "+config.Token)
+		}
+		return req, nil
+	})
+}
+
+// newNtfyNotifier publishes events to the ntfy topic at the config URL
+func newNtfyNotifier(config Config) *httpNotifier {
+	return newHTTPNotifier(func(ctx context.Context, event Event) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, config.URL, strings.NewReader(event.Message()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Title", event.Title())
+		req.Header.Set("Tags", ntfyTag(event.Type))
+		if event.Type == Failed {
+			req.Header.Set("Priority", "high")
+		}
+		if config.Token != "" {
+			req.Header.Set("Authorization", "Bearer "+config.Token)
+		}
+		return req, nil
+	})
+}
+
+// ntfyTag returns the emoji shortcode ntfy shows next to an event
+func ntfyTag(eventType EventType) string {
+	switch eventType {
+	case Deployed:
+		return "white_check_mark"
+	case Failed:
+		return "x"
+	case RolledBack:
+		return "rewind"
+	default:
+		return "zzz"
+	}
+}
+
+type gotifyMessage struct {
+	Title    string `json:"title"`
+	Message  string `json:"message"`
+	Priority int    `json:"priority"`
+}
+
+// newGotifyNotifier sends events as messages of the Gotify application the token belongs to
+func newGotifyNotifier(config Config) *httpNotifier {
+	return newHTTPNotifier(func(ctx context.Context, event Event) (*http.Request, error) {
+		message := gotifyMessage{Title: event.Title(), Message: event.Message(), Priority: 5}
+		if event.Type == Failed {
+			message.Priority = 8
+		}
+		req, err := jsonRequest(ctx, http.MethodPost, strings.TrimSuffix(config.URL, "/")+"/message", message)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("X-Gotify-Key", config.Token)
+		return req, nil
+	})
+}
+
+type slackMessage struct {
+	Text string `json:"text"`
+}
+
+// newSlackNotifier posts events to a Slack compatible incoming webhook, which
+// Mattermost, Rocket.Chat and Discord (with /slack) accept as well
+func newSlackNotifier(config Config) *httpNotifier {
+	return newHTTPNotifier(func(ctx context.Context, event Event) (*http.Request, error) {
+		return jsonRequest(ctx, http.MethodPost, config.URL, slackMessage{Text: "*" + event.Title() + "*\n" + event.Message()})
+	})
+}
+
+type matrixMessage struct {
+	MsgType string `json:"msgtype"`
+	Body    string `json:"body"`
+}
+
+// newMatrixNotifier sends events as text messages to a Matrix room, through the
+// client-server API of the homeserver at the config URL
+func newMatrixNotifier(config Config) *httpNotifier {
+	return newHTTPNotifier(func(ctx context.Context, event Event) (*http.Request, error) {
+		// The transaction ID makes retries of a request idempotent, every event is new
+		txnID := fmt.Sprintf("voyage-%d", time.Now().UnixNano())
+		endpoint := fmt.Sprintf("%s/_matrix/client/v3/rooms/%s/send/m.room.message/%s",
+			strings.TrimSuffix(config.URL, "/"), url.PathEscape(config.Room), txnID)
+		req, err := jsonRequest(ctx, http.MethodPut, endpoint, matrixMessage{MsgType: "m.text", Body: event.Title() + "\n" + event.Message()})
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+config.Token)
+		return req, nil
+	})
+}
@@ -0,0 +1,157 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// receivedRequest is a request captured by a test server
+type receivedRequest struct {
+	Method string
+	Path   string
+	Header http.Header
+	Body   string
+}
+
+// newTestServer captures the requests it receives and answers them with status
+func newTestServer(t *testing.T, status int) (*httptest.Server, *[]receivedRequest) {
+	t.Helper()
+	var requests []receivedRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests = append(requests, receivedRequest{Method: r.Method, Path: r.URL.EscapedPath(), Header: r.Header, Body: string(body)})
+		w.WriteHeader(status)
+		io.WriteString(w, "rejected")
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+var testEvent = Event{
+	Type:   Failed,
+	Repo:   "https://token@git.example.com/stacks.git",
+	Branch: "main",
+	Stack:  "app/compose.yml",
+	From:   "1111111aaaa",
+	To:     "2222222bbbb",
+	Error:  "compose failed",
+	Time:   time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+}
+
+func TestNotifiers(t *testing.T) {
+	t.Run("Webhook posts the event as JSON", func(t *testing.T) {
+		server, requests := newTestServer(t, http.StatusNoContent)
+		notifier, _ := New(Config{Type: Webhook, URL: server.URL + "/hook", Token: "hook-token"})
+
+		if err := notifier.Notify(context.Background(), testEvent); err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+
+		req := (*requests)[0]
+		if req.Method != http.MethodPost || req.Path != "/hook" || req.Header.Get("Authorization") != "Bearer hook-token" {
+			t.Errorf("Expected an authorized POST to /hook, but got %s %s %v", req.Method, req.Path, req.Header)
+		}
+		var event Event
+		if err := json.Unmarshal([]byte(req.Body), &event); err != nil {
+			t.Fatalf("Expected a JSON event, but got %q", req.Body)
+		}
+		if event.Type != Failed || event.Stack != "app/compose.yml" || event.Error != "compose failed" || event.To != testEvent.To {
+			t.Errorf("Expected the event to be posted, but got %+v", event)
+		}
+		if strings.Contains(req.Body, "token@") {
+			t.Errorf("Expected the repository credentials to be left out, but got %s", req.Body)
+		}
+	})
+
+	t.Run("Ntfy publishes the message with a title", func(t *testing.T) {
+		server, requests := newTestServer(t, http.StatusOK)
+		notifier, _ := New(Config{Type: Ntfy, URL: server.URL + "/deploys"})
+
+		if err := notifier.Notify(context.Background(), testEvent); err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+
+		req := (*requests)[0]
+		if req.Path != "/deploys" || req.Header.Get("Title") != testEvent.Title() || req.Header.Get("Priority") != "high" {
+			t.Errorf("Expected a high priority message to /deploys, but got %s %v", req.Path, req.Header)
+		}
+		if req.Body != testEvent.Message() {
+			t.Errorf("Expected the message as body, but got %q", req.Body)
+		}
+		if req.Header.Get("Authorization") != "" {
+			t.Errorf("Expected no authorization without a token, but got %q", req.Header.Get("Authorization"))
+		}
+	})
+
+	t.Run("Gotify sends a message with the application token", func(t *testing.T) {
+		server, requests := newTestServer(t, http.StatusOK)
+		notifier, _ := New(Config{Type: Gotify, URL: server.URL + "/", Token: "app-token"})
+
+		if err := notifier.Notify(context.Background(), testEvent); err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+
+		req := (*requests)[0]
+		if req.Path != "/message" || req.Header.Get("X-Gotify-Key") != "app-token" {
+			t.Errorf("Expected a message with the token, but got %s %v", req.Path, req.Header)
+		}
+		var message gotifyMessage
+		json.Unmarshal([]byte(req.Body), &message)
+		if message.Title != testEvent.Title() || message.Message != testEvent.Message() || message.Priority != 8 {
+			t.Errorf("Expected a high priority message, but got %+v", message)
+		}
+	})
+
+	t.Run("Slack posts the title and message as text", func(t *testing.T) {
+		server, requests := newTestServer(t, http.StatusOK)
+		notifier, _ := New(Config{Type: Slack, URL: server.URL + "/services/T/B/X"})
+
+		if err := notifier.Notify(context.Background(), testEvent); err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+
+		var message slackMessage
+		json.Unmarshal([]byte((*requests)[0].Body), &message)
+		if expected := "*" + testEvent.Title() + "*\n" + testEvent.Message(); message.Text != expected {
+			t.Errorf("Expected text %q, but got %q", expected, message.Text)
+		}
+	})
+
+	t.Run("Matrix sends a text message to the room", func(t *testing.T) {
+		server, requests := newTestServer(t, http.StatusOK)
+		notifier, _ := New(Config{Type: Matrix, URL: server.URL, Token: "access-token", Room: "!ops:example.org"})
+
+		if err := notifier.Notify(context.Background(), testEvent); err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+
+		req := (*requests)[0]
+		if req.Method != http.MethodPut || !strings.HasPrefix(req.Path, "/_matrix/client/v3/rooms/%21ops:example.org/send/m.room.message/voyage-") {
+			t.Errorf("Expected a PUT to the room, but got %s %s", req.Method, req.Path)
+		}
+		if req.Header.Get("Authorization") != "Bearer access-token" {
+			t.Errorf("Expected the access token, but got %q", req.Header.Get("Authorization"))
+		}
+		var message matrixMessage
+		json.Unmarshal([]byte(req.Body), &message)
+		if message.MsgType != "m.text" || !strings.Contains(message.Body, "Error: compose failed") {
+			t.Errorf("Expected a text message with the error, but got %+v", message)
+		}
+	})
+
+	t.Run("Returns error with the response of a rejected notification", func(t *testing.T) {
+		server, _ := newTestServer(t, http.StatusForbidden)
+		notifier, _ := New(Config{Type: Slack, URL: server.URL})
+
+		err := notifier.Notify(context.Background(), testEvent)
+		if err == nil || !strings.Contains(err.Error(), "403 Forbidden: rejected") {
+			t.Errorf("Expected the rejected status, but got %v", err)
+		}
+	})
+}
@@ -0,0 +1,320 @@
+package registry
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// dockerHub is the registry image references without a registry host point at
+	dockerHub = "docker.io"
+	// dockerHubHost serves the registry API of Docker Hub
+	dockerHubHost = "registry-1.docker.io"
+	// dockerHubAuthKey is the key Docker Hub credentials are stored under in the docker config
+	dockerHubAuthKey = "https://index.docker.io/v1/"
+
+	defaultTimeout = 30 * time.Second
+)
+
+// manifestMediaTypes are accepted when resolving a tag, so a multi-platform tag
+// resolves to the digest of its index, like docker pull records it.
+var manifestMediaTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// ErrDigestReference is returned by Digest for an image pinned to a digest, which has no tag to check.
+var ErrDigestReference = errors.New("image reference is pinned to a digest")
+
+// Reference is a parsed image reference such as nginx:1.27 or ghcr.io/user/app@sha256:...
+type Reference struct {
+	// Registry is the registry host, docker.io for Docker Hub
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+func (r Reference) String() string {
+	s := r.Registry + "/" + r.Repository
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+	return s
+}
+
+// ParseReference parses an image reference the way docker does: a reference
+// without a registry host points at Docker Hub, official images live under library/,
+// and a reference without tag or digest uses the latest tag.
+func ParseReference(ref string) (Reference, error) {
+	var r Reference
+	name := ref
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, r.Digest = name[:i], name[i+1:]
+	}
+	if i := strings.LastIndex(name, ":"); i >= 0 && !strings.Contains(name[i:], "/") {
+		name, r.Tag = name[:i], name[i+1:]
+	}
+	r.Registry, r.Repository = dockerHub, name
+	if i := strings.Index(name, "/"); i >= 0 {
+		host := name[:i]
+		if strings.ContainsAny(host, ".:") || host == "localhost" {
+			r.Registry, r.Repository = host, name[i+1:]
+		}
+	}
+	if r.Registry == dockerHub && !strings.Contains(r.Repository, "/") {
+		r.Repository = "library/" + r.Repository
+	}
+	if r.Tag == "" && r.Digest == "" {
+		r.Tag = "latest"
+	}
+	if r.Repository == "" || strings.ToLower(r.Repository) != r.Repository {
+		return Reference{}, fmt.Errorf("invalid image reference %q", ref)
+	}
+	return r, nil
+}
+
+// Client resolves image tags to digests through the registry HTTP API.
+type Client struct {
+	client *http.Client
+	// credentials returns the username and password for a registry host
+	credentials func(registry string) (username, password string, ok bool)
+	// baseURL returns the scheme and host the API of a registry is served at
+	baseURL func(registry string) string
+}
+
+// NewClient creates a Client that reads registry credentials from the docker config
+// file, as written by docker login.
+func NewClient() *Client {
+	return &Client{
+		client:      &http.Client{Timeout: defaultTimeout},
+		credentials: dockerConfigCredentials,
+		baseURL:     defaultBaseURL,
+	}
+}
+
+// Digest returns the digest the tag of the image reference currently points at.
+func (c *Client) Digest(ctx context.Context, image string) (string, error) {
+	ref, err := ParseReference(image)
+	if err != nil {
+		return "", err
+	}
+	if ref.Digest != "" {
+		return "", ErrDigestReference
+	}
+
+	manifestURL := fmt.Sprintf("%s/v2/%s/manifests/%s", c.baseURL(ref.Registry), ref.Repository, url.PathEscape(ref.Tag))
+	var authorization string
+	resp, err := c.do(ctx, http.MethodHead, manifestURL, ref, authorization)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		if authorization, err = c.authorize(ctx, ref, resp.Header.Get("WWW-Authenticate")); err != nil {
+			return "", err
+		}
+		if resp, err = c.do(ctx, http.MethodHead, manifestURL, ref, authorization); err != nil {
+			return "", err
+		}
+		resp.Body.Close()
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("registry returned %s for %s", resp.Status, ref)
+	}
+	if digest := resp.Header.Get("Docker-Content-Digest"); digest != "" {
+		return digest, nil
+	}
+	return c.manifestDigest(ctx, manifestURL, ref, authorization)
+}
+
+// manifestDigest downloads the manifest and hashes it, for registries that do not
+// send the digest in a header.
+func (c *Client) manifestDigest(ctx context.Context, manifestURL string, ref Reference, authorization string) (string, error) {
+	resp, err := c.do(ctx, http.MethodGet, manifestURL, ref, authorization)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("registry returned %s for %s", resp.Status, ref)
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, resp.Body); err != nil {
+		return "", fmt.Errorf("failed to read manifest of %s: %w", ref, err)
+	}
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func (c *Client) do(ctx context.Context, method, manifestURL string, ref Reference, authorization string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, manifestURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach registry %s: %w", ref.Registry, err)
+	}
+	return resp, nil
+}
+
+// authorize answers the authentication challenge of a registry with the Authorization
+// header to retry with. Bearer challenges exchange the credentials, if any, for a
+// pull token.
+func (c *Client) authorize(ctx context.Context, ref Reference, challenge string) (string, error) {
+	username, password, hasCredentials := c.credentials(ref.Registry)
+	scheme, params := parseChallenge(challenge)
+
+	switch scheme {
+	case "basic":
+		if !hasCredentials {
+			return "", fmt.Errorf("registry %s requires credentials, log in with docker login", ref.Registry)
+		}
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password)), nil
+	case "bearer":
+		realm, err := url.Parse(params["realm"])
+		if err != nil || params["realm"] == "" {
+			return "", fmt.Errorf("registry %s sent an invalid token realm %q", ref.Registry, params["realm"])
+		}
+		query := realm.Query()
+		if service := params["service"]; service != "" {
+			query.Set("service", service)
+		}
+		query.Set("scope", "repository:"+ref.Repository+":pull")
+		realm.RawQuery = query.Encode()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+		if err != nil {
+			return "", err
+		}
+		if hasCredentials {
+			req.SetBasicAuth(username, password)
+		}
+		resp, err := c.client.Do(req)
+		if err != nil {
+			return "", fmt.Errorf("failed to get registry token for %s: %w", ref, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("registry token endpoint returned %s for %s", resp.Status, ref)
+		}
+
+		var token struct {
+			Token       string `json:"token"`
+			AccessToken string `json:"access_token"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+			return "", fmt.Errorf("failed to parse registry token for %s: %w", ref, err)
+		}
+		if token.Token == "" {
+			token.Token = token.AccessToken
+		}
+		return "Bearer " + token.Token, nil
+	default:
+		return "", fmt.Errorf("registry %s requires unsupported authentication %q", ref.Registry, challenge)
+	}
+}
+
+// parseChallenge splits a WWW-Authenticate header into its lowercased scheme and parameters
+func parseChallenge(challenge string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(challenge), " ")
+	params := map[string]string{}
+	for rest != "" {
+		var key, value string
+		key, rest, _ = strings.Cut(strings.TrimLeft(rest, " ,"), "=")
+		if strings.HasPrefix(rest, `"`) {
+			value, rest, _ = strings.Cut(rest[1:], `"`)
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		if key = strings.TrimSpace(key); key != "" {
+			params[strings.ToLower(key)] = value
+		}
+	}
+	return strings.ToLower(scheme), params
+}
+
+// defaultBaseURL serves Docker Hub from its API host, and like docker, talks plain
+// HTTP to registries on the loopback interface.
+func defaultBaseURL(registry string) string {
+	if registry == dockerHub {
+		return "https://" + dockerHubHost
+	}
+	host := registry
+	if h, _, err := net.SplitHostPort(registry); err == nil {
+		host = h
+	}
+	if ip := net.ParseIP(host); host == "localhost" || ip != nil && ip.IsLoopback() {
+		return "http://" + registry
+	}
+	return "https://" + registry
+}
+
+// dockerConfigCredentials reads the credentials docker login stored for a registry
+// in $DOCKER_CONFIG/config.json or ~/.docker/config.json. Credential helpers are not
+// supported.
+func dockerConfigCredentials(registry string) (string, string, bool) {
+	dir := os.Getenv("DOCKER_CONFIG")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", "", false
+		}
+		dir = filepath.Join(home, ".docker")
+	}
+
+	file, err := os.ReadFile(filepath.Join(dir, "config.json"))
+	if err != nil {
+		return "", "", false
+	}
+	var config struct {
+		Auths map[string]struct {
+			Auth string `json:"auth"`
+		} `json:"auths"`
+	}
+	if err := json.Unmarshal(file, &config); err != nil {
+		return "", "", false
+	}
+
+	key := registry
+	if registry == dockerHub {
+		key = dockerHubAuthKey
+	}
+	for _, k := range []string{key, "https://" + key} {
+		if entry, ok := config.Auths[k]; ok && entry.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+			if err != nil {
+				return "", "", false
+			}
+			username, password, ok := strings.Cut(string(decoded), ":")
+			return username, password, ok
+		}
+	}
+	return "", "", false
+}
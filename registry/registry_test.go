@@ -0,0 +1,161 @@
+package registry
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testDigest = "sha256:4f1d0e3c2b1a"
+
+// newTestRegistry serves the manifests of a local registry that requires a pull
+// token, the way Docker Hub and most registries do.
+func newTestRegistry(t *testing.T, manifests map[string]string) (*Client, *httptest.Server) {
+	t.Helper()
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	mux.HandleFunc("GET /token", func(w http.ResponseWriter, r *http.Request) {
+		if scope := r.URL.Query().Get("scope"); !strings.HasSuffix(scope, ":pull") {
+			t.Errorf("Expected a pull scope, but got %q", scope)
+		}
+		fmt.Fprint(w, `{"token":"pull-token"}`)
+	})
+	mux.HandleFunc("/v2/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer pull-token" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test-registry"`, server.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if !strings.Contains(r.Header.Get("Accept"), "application/vnd.oci.image.index.v1+json") {
+			t.Errorf("Expected image indexes to be accepted, but got %q", r.Header.Get("Accept"))
+		}
+		manifest, ok := manifests[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Method == http.MethodHead && manifest != "" {
+			w.Header().Set("Docker-Content-Digest", testDigest)
+		}
+		fmt.Fprint(w, manifest)
+	})
+
+	client := NewClient()
+	client.credentials = func(string) (string, string, bool) { return "", "", false }
+	client.baseURL = func(string) string { return server.URL }
+	return client, server
+}
+
+func TestClient_Digest(t *testing.T) {
+	client, _ := newTestRegistry(t, map[string]string{
+		"/v2/library/nginx/manifests/latest": `{"schemaVersion":2}`,
+		// A registry that only sends the digest on GET
+		"/v2/team/app/manifests/1": "",
+	})
+
+	t.Run("Resolves a tag after getting a pull token", func(t *testing.T) {
+		digest, err := client.Digest(context.Background(), "nginx")
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if digest != testDigest {
+			t.Errorf("Expected digest %s, but got %s", testDigest, digest)
+		}
+	})
+
+	t.Run("Hashes the manifest without a digest header", func(t *testing.T) {
+		digest, err := client.Digest(context.Background(), "team/app:1")
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		sum := sha256.Sum256(nil)
+		if expected := "sha256:" + hex.EncodeToString(sum[:]); digest != expected {
+			t.Errorf("Expected digest %s, but got %s", expected, digest)
+		}
+	})
+
+	t.Run("Returns error for an unknown tag", func(t *testing.T) {
+		if _, err := client.Digest(context.Background(), "nginx:missing"); err == nil {
+			t.Fatal("Expected an error, but got nil")
+		}
+	})
+
+	t.Run("Does not resolve references pinned to a digest", func(t *testing.T) {
+		_, err := client.Digest(context.Background(), "nginx@"+testDigest)
+		if !errors.Is(err, ErrDigestReference) {
+			t.Errorf("Expected ErrDigestReference, but got %v", err)
+		}
+	})
+}
+
+func TestParseReference(t *testing.T) {
+	testCases := []struct {
+		ref      string
+		expected Reference
+	}{
+		{ref: "nginx", expected: Reference{Registry: "docker.io", Repository: "library/nginx", Tag: "latest"}},
+		{ref: "grafana/grafana:11", expected: Reference{Registry: "docker.io", Repository: "grafana/grafana", Tag: "11"}},
+		{ref: "ghcr.io/user/app:v1.2", expected: Reference{Registry: "ghcr.io", Repository: "user/app", Tag: "v1.2"}},
+		{ref: "localhost:5000/app", expected: Reference{Registry: "localhost:5000", Repository: "app", Tag: "latest"}},
+		{ref: "redis@sha256:abc", expected: Reference{Registry: "docker.io", Repository: "library/redis", Digest: "sha256:abc"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.ref, func(t *testing.T) {
+			got, err := ParseReference(tc.ref)
+			if err != nil {
+				t.Fatalf("Expected no error, but got %v", err)
+			}
+			if got != tc.expected {
+				t.Errorf("Expected %+v, but got %+v", tc.expected, got)
+			}
+		})
+	}
+
+	if _, err := ParseReference("Upper/Case"); err == nil {
+		t.Error("Expected an error for an uppercase repository, but got nil")
+	}
+}
+
+func TestDefaultBaseURL(t *testing.T) {
+	testCases := map[string]string{
+		"docker.io":      "https://registry-1.docker.io",
+		"ghcr.io":        "https://ghcr.io",
+		"localhost:5000": "http://localhost:5000",
+		"127.0.0.1:5000": "http://127.0.0.1:5000",
+	}
+	for registry, expected := range testCases {
+		if got := defaultBaseURL(registry); got != expected {
+			t.Errorf("Expected %s for %s, but got %s", expected, registry, got)
+		}
+	}
+}
+
+func TestDockerConfigCredentials(t *testing.T) {
+	dir := t.TempDir()
+	config := `{"auths":{"https://index.docker.io/v1/":{"auth":"dXNlcjpodWItdG9rZW4="},"ghcr.io":{"auth":"Z2g6dG9rZW4="}}}`
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("DOCKER_CONFIG", dir)
+
+	if username, password, ok := dockerConfigCredentials("docker.io"); !ok || username != "user" || password != "hub-token" {
+		t.Errorf("Expected Docker Hub credentials, but got %q, %q, %v", username, password, ok)
+	}
+	if username, password, ok := dockerConfigCredentials("ghcr.io"); !ok || username != "gh" || password != "token" {
+		t.Errorf("Expected ghcr.io credentials, but got %q, %q, %v", username, password, ok)
+	}
+	if _, _, ok := dockerConfigCredentials("quay.io"); ok {
+		t.Error("Expected no credentials for quay.io")
+	}
+}
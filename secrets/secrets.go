@@ -0,0 +1,250 @@
+package secrets
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/gnugomez/voyage/log"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+)
+
+// Suffixes of the encrypted files a Decrypter looks for. Encrypted YAML files are
+// only picked up inside a secrets directory, next to other YAML such as compose files.
+const (
+	encryptedEnvSuffix  = ".enc.env"
+	encryptedYAMLSuffix = ".sops.yaml"
+	secretsDir          = "secrets"
+)
+
+// Decrypter writes the plaintext of the SOPS encrypted files of a repository next
+// to them, and keeps git from ever seeing the plaintext.
+type Decrypter struct {
+	root string
+	// decrypt returns the plaintext of an encrypted file, tests replace it
+	decrypt func(path string) ([]byte, error)
+}
+
+// NewDecrypter creates a Decrypter for the repository at root that decrypts with
+// the age key in keyFile.
+func NewDecrypter(root, keyFile string) *Decrypter {
+	return &Decrypter{
+		root:    root,
+		decrypt: func(path string) ([]byte, error) { return sopsDecrypt(path, keyFile) },
+	}
+}
+
+// Decrypt finds the encrypted files in dirs, relative to the repository, and writes
+// their plaintext with 0600 permissions: app.enc.env to app.env, .enc.env to .env,
+// and secrets/db.sops.yaml to secrets/db.yaml. It returns the plaintext paths,
+// relative to the repository. It fails when git tracks one of the plaintext paths.
+func (d *Decrypter) Decrypt(dirs []string) ([]string, error) {
+	var encrypted []string
+	for _, dir := range dirs {
+		files, err := findEncrypted(filepath.Join(d.root, dir))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			if !slices.Contains(encrypted, file) {
+				encrypted = append(encrypted, file)
+			}
+		}
+	}
+	if len(encrypted) == 0 {
+		return nil, nil
+	}
+
+	var plaintexts []string
+	for _, file := range encrypted {
+		rel, err := filepath.Rel(d.root, plaintextPath(file))
+		if err != nil {
+			return nil, err
+		}
+		plaintexts = append(plaintexts, filepath.ToSlash(rel))
+	}
+
+	// A tracked plaintext would be modified, and block the next pull
+	if err := d.checkUntracked(plaintexts); err != nil {
+		return nil, err
+	}
+	// Ignore the plaintext before writing it, so it is never seen as untracked
+	if err := d.exclude(plaintexts); err != nil {
+		return nil, err
+	}
+
+	for _, file := range encrypted {
+		plaintext, err := d.decrypt(file)
+		if err != nil {
+			return nil, err
+		}
+		if err := writePrivate(plaintextPath(file), plaintext); err != nil {
+			return nil, err
+		}
+		log.Debug("Decrypted secret file", "path", file)
+	}
+	return plaintexts, nil
+}
+
+// checkUntracked returns an error when the git index of the repository holds one
+// of the paths
+func (d *Decrypter) checkUntracked(paths []string) error {
+	indexPath := filepath.Join(d.root, ".git", "index")
+	file, err := os.Open(indexPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil // nothing committed yet
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", indexPath, err)
+	}
+	defer file.Close()
+
+	var idx index.Index
+	if err := index.NewDecoder(file).Decode(&idx); err != nil {
+		return fmt.Errorf("failed to read %s: %w", indexPath, err)
+	}
+	for _, path := range paths {
+		if _, err := idx.Entry(path); err == nil {
+			return fmt.Errorf("%s is tracked by git, remove it from the repository so the decrypted secret can be written there", path)
+		}
+	}
+	return nil
+}
+
+// exclude adds the paths to .git/info/exclude, unless they are already listed
+func (d *Decrypter) exclude(paths []string) error {
+	excludePath := filepath.Join(d.root, ".git", "info", "exclude")
+	existing := map[string]bool{}
+	content, err := os.ReadFile(excludePath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to read %s: %w", excludePath, err)
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		existing[strings.TrimSpace(scanner.Text())] = true
+	}
+
+	var missing []string
+	for _, path := range paths {
+		if pattern := "/" + path; !existing[pattern] {
+			missing = append(missing, pattern)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(excludePath), 0o755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(excludePath), err)
+	}
+	file, err := os.OpenFile(excludePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", excludePath, err)
+	}
+	defer file.Close()
+
+	var lines strings.Builder
+	if len(content) > 0 && !bytes.HasSuffix(content, []byte("\n")) {
+		lines.WriteString("\n")
+	}
+	for _, pattern := range missing {
+		lines.WriteString(pattern + "\n")
+	}
+	if _, err := file.WriteString(lines.String()); err != nil {
+		return fmt.Errorf("failed to write %s: %w", excludePath, err)
+	}
+	return nil
+}
+
+// findEncrypted returns the encrypted files under dir, skipping the git directory
+func findEncrypted(dir string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && path == dir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if entry.IsDir() {
+			if entry.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if isEncrypted(path) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to look for encrypted files in %s: %w", dir, err)
+	}
+	return files, nil
+}
+
+func isEncrypted(path string) bool {
+	name := filepath.Base(path)
+	if strings.HasSuffix(name, encryptedEnvSuffix) {
+		return true
+	}
+	// .sops.yaml alone is the configuration file of sops
+	return strings.HasSuffix(name, encryptedYAMLSuffix) && name != encryptedYAMLSuffix &&
+		filepath.Base(filepath.Dir(path)) == secretsDir
+}
+
+// plaintextPath returns where the plaintext of an encrypted file is written
+func plaintextPath(path string) string {
+	if strings.HasSuffix(path, encryptedEnvSuffix) {
+		return strings.TrimSuffix(path, encryptedEnvSuffix) + ".env"
+	}
+	return strings.TrimSuffix(path, encryptedYAMLSuffix) + ".yaml"
+}
+
+// writePrivate replaces the file at path with content, readable by its owner only.
+// The content goes to a temporary file first, so it is never readable by others
+// and a failed write leaves the old file alone.
+func writePrivate(path string, content []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+// sopsDecrypt runs sops to decrypt the file at path with the age key in keyFile.
+// Only sops' error output ends up in the error, never the plaintext.
+func sopsDecrypt(path, keyFile string) ([]byte, error) {
+	cmd := exec.Command("sops", "--decrypt", path)
+	cmd.Env = append(os.Environ(), "SOPS_AGE_KEY_FILE="+keyFile)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s: %w, output: %s", path, err, strings.TrimSpace(stderr.String()))
+	}
+	return output, nil
+}
@@ -0,0 +1,193 @@
+package secrets
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+)
+
+// newTestRepository creates a repository layout with encrypted and plain files
+func newTestRepository(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	files := map[string]string{
+		"app/compose.yml":                "services: {}",
+		"app/app.enc.env":                "ENC[app]",
+		"app/secrets/db.sops.yaml":       "ENC[db]",
+		"app/config.sops.yaml":           "not in a secrets directory",
+		"app/plain.env":                  "PLAIN=1",
+		"other/other.enc.env":            "ENC[other]",
+		".git/info/exclude":              "# git ls-files --others --exclude-from=.git/info/exclude\n/existing",
+		".git/modules/leak/leak.enc.env": "ENC[leak]",
+	}
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func newTestDecrypter(root string) *Decrypter {
+	return &Decrypter{
+		root: root,
+		decrypt: func(path string) ([]byte, error) {
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			return []byte(strings.NewReplacer("ENC[", "plain ", "]", "").Replace(string(content))), nil
+		},
+	}
+}
+
+func TestDecrypter_Decrypt(t *testing.T) {
+	t.Run("Writes the plaintext of encrypted files next to them", func(t *testing.T) {
+		root := newTestRepository(t)
+
+		written, err := newTestDecrypter(root).Decrypt([]string{"app"})
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+
+		expected := []string{"app/app.env", "app/secrets/db.yaml"}
+		if !reflect.DeepEqual(written, expected) {
+			t.Errorf("Expected plaintext files %v, but got %v", expected, written)
+		}
+		for path, content := range map[string]string{"app/app.env": "plain app", "app/secrets/db.yaml": "plain db"} {
+			info, err := os.Stat(filepath.Join(root, path))
+			if err != nil {
+				t.Fatalf("Expected %s to be written, but got %v", path, err)
+			}
+			if info.Mode().Perm() != 0o600 {
+				t.Errorf("Expected %s to have mode 0600, but got %s", path, info.Mode().Perm())
+			}
+			if got, _ := os.ReadFile(filepath.Join(root, path)); string(got) != content {
+				t.Errorf("Expected %s to contain %q, but got %q", path, content, got)
+			}
+		}
+		if _, err := os.Stat(filepath.Join(root, "app/config.yaml")); err == nil {
+			t.Error("Expected encrypted YAML outside a secrets directory to be left alone")
+		}
+		if _, err := os.Stat(filepath.Join(root, "other/other.env")); err == nil {
+			t.Error("Expected files of other stacks to be left alone")
+		}
+	})
+
+	t.Run("Excludes the plaintext from git once", func(t *testing.T) {
+		root := newTestRepository(t)
+		decrypter := newTestDecrypter(root)
+
+		for range 2 {
+			if _, err := decrypter.Decrypt([]string{"app"}); err != nil {
+				t.Fatalf("Expected no error, but got %v", err)
+			}
+		}
+
+		exclude, err := os.ReadFile(filepath.Join(root, ".git/info/exclude"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := "# git ls-files --others --exclude-from=.git/info/exclude\n/existing\n/app/app.env\n/app/secrets/db.yaml\n"
+		if string(exclude) != expected {
+			t.Errorf("Expected exclude file:\n%s\nbut got:\n%s", expected, exclude)
+		}
+	})
+
+	t.Run("Skips the git directory in the repository root", func(t *testing.T) {
+		root := newTestRepository(t)
+
+		written, err := newTestDecrypter(root).Decrypt([]string{""})
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		for _, path := range written {
+			if strings.HasPrefix(path, ".git/") {
+				t.Errorf("Expected nothing to be decrypted inside .git, but got %s", path)
+			}
+		}
+		if len(written) != 3 {
+			t.Errorf("Expected the 3 encrypted files of the repository, but got %v", written)
+		}
+	})
+
+	t.Run("Returns error and keeps the old plaintext when decryption fails", func(t *testing.T) {
+		root := newTestRepository(t)
+		if err := os.WriteFile(filepath.Join(root, "app/app.env"), []byte("old"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		decrypter := newTestDecrypter(root)
+		decrypter.decrypt = func(path string) ([]byte, error) { return nil, errors.New("no matching age key") }
+
+		if _, err := decrypter.Decrypt([]string{"app"}); err == nil {
+			t.Fatal("Expected an error, but got nil")
+		}
+		if got, _ := os.ReadFile(filepath.Join(root, "app/app.env")); string(got) != "old" {
+			t.Errorf("Expected the old plaintext to be kept, but got %q", got)
+		}
+	})
+
+	t.Run("Decrypts a file named .enc.env to .env", func(t *testing.T) {
+		root := newTestRepository(t)
+		if err := os.WriteFile(filepath.Join(root, "other/.enc.env"), []byte("ENC[dot]"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		written, err := newTestDecrypter(root).Decrypt([]string{"other"})
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if expected := []string{"other/.env", "other/other.env"}; !reflect.DeepEqual(written, expected) {
+			t.Errorf("Expected %v to be written, but got %v", expected, written)
+		}
+		if got, _ := os.ReadFile(filepath.Join(root, "other/.env")); string(got) != "plain dot" {
+			t.Errorf("Expected plaintext %q, but got %q", "plain dot", got)
+		}
+	})
+
+	t.Run("Fails without writing when git tracks the plaintext", func(t *testing.T) {
+		root := newTestRepository(t)
+		if err := os.WriteFile(filepath.Join(root, "app/app.env"), []byte("committed"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		file, err := os.Create(filepath.Join(root, ".git/index"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		idx := &index.Index{Version: 2, Entries: []*index.Entry{{Name: "app/app.env", Mode: filemode.Regular}}}
+		if err := index.NewEncoder(file).Encode(idx); err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+
+		_, err = newTestDecrypter(root).Decrypt([]string{"app"})
+		if err == nil || !strings.Contains(err.Error(), "app/app.env") {
+			t.Fatalf("Expected an error naming app/app.env, but got %v", err)
+		}
+		if got, _ := os.ReadFile(filepath.Join(root, "app/app.env")); string(got) != "committed" {
+			t.Errorf("Expected the tracked file to be kept, but got %q", got)
+		}
+		if got, _ := os.ReadFile(filepath.Join(root, "app/secrets/db.yaml")); got != nil {
+			t.Errorf("Expected no plaintext to be written, but got %q", got)
+		}
+	})
+
+	t.Run("Does nothing without encrypted files", func(t *testing.T) {
+		root := newTestRepository(t)
+
+		written, err := newTestDecrypter(root).Decrypt([]string{"missing"})
+		if err != nil || len(written) != 0 {
+			t.Errorf("Expected nothing to be decrypted, but got %v, %v", written, err)
+		}
+	})
+}
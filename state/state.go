@@ -0,0 +1,205 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Stack is the deployment state of a single stack.
+type Stack struct {
+	// Commit is the commit the stack was last successfully deployed from.
+	Commit     string    `json:"commit"`
+	DeployedAt time.Time `json:"deployedAt"`
+	// Previous is the commit the stack was deployed from before Commit.
+	Previous string `json:"previous,omitempty"`
+	// Failure is set while the stack has a failed deploy waiting to be retried.
+	Failure *Failure `json:"failure,omitempty"`
+	// Pin is set while the stack is held at an older commit by a rollback.
+	Pin *Pin `json:"pin,omitempty"`
+}
+
+// Pin holds a stack's files at a commit other than the one checked out.
+type Pin struct {
+	// Commit is the commit the stack's files are checked out at.
+	Commit string `json:"commit"`
+	// Base is the remote commit at the time of pinning. New changes to the stack
+	// after Base release the pin.
+	Base     string    `json:"base"`
+	PinnedAt time.Time `json:"pinnedAt"`
+}
+
+// Failure records a failed deploy of a stack.
+type Failure struct {
+	// Commit is the commit the failed deploy was made from.
+	Commit      string    `json:"commit"`
+	Attempts    int       `json:"attempts"`
+	LastError   string    `json:"lastError"`
+	FailedAt    time.Time `json:"failedAt"`
+	NextAttempt time.Time `json:"nextAttempt"`
+}
+
+// State is the deployment state of every stack deployed from an out path.
+type State struct {
+	Stacks map[string]*Stack `json:"stacks"`
+}
+
+// New returns an empty State
+func New() *State {
+	return &State{Stacks: map[string]*Stack{}}
+}
+
+// Commit returns the commit a stack was last deployed from, or an empty string
+// if it was never deployed.
+func (s *State) Commit(name string) string {
+	if stack, ok := s.Stacks[name]; ok {
+		return stack.Commit
+	}
+	return ""
+}
+
+// Failure returns the pending failure of a stack, or nil if its last deploy succeeded.
+func (s *State) Failure(name string) *Failure {
+	if stack, ok := s.Stacks[name]; ok {
+		return stack.Failure
+	}
+	return nil
+}
+
+// Previous returns the commit a stack was deployed from before its current one.
+func (s *State) Previous(name string) string {
+	if stack, ok := s.Stacks[name]; ok {
+		return stack.Previous
+	}
+	return ""
+}
+
+// Pin returns the pin of a stack, or nil if it is not pinned.
+func (s *State) Pin(name string) *Pin {
+	if stack, ok := s.Stacks[name]; ok {
+		return stack.Pin
+	}
+	return nil
+}
+
+// MarkDeployed records that a stack was deployed from commit, clearing any pending failure.
+func (s *State) MarkDeployed(name, commit string, at time.Time) {
+	stack := s.stack(name)
+	if stack.Commit != commit {
+		stack.Previous = stack.Commit
+	}
+	stack.Commit = commit
+	stack.DeployedAt = at
+	stack.Failure = nil
+}
+
+// MarkRolledBack records that a stack was deployed from an older commit and pins
+// it there. Previous is left untouched, so rolling back again is a no-op rather
+// than a return to the commit that was rolled back.
+func (s *State) MarkRolledBack(name string, pin Pin) {
+	stack := s.stack(name)
+	stack.Commit = pin.Commit
+	stack.DeployedAt = pin.PinnedAt
+	stack.Failure = nil
+	stack.Pin = &pin
+}
+
+// ClearPin releases the pin of a stack.
+func (s *State) ClearPin(name string) {
+	if stack, ok := s.Stacks[name]; ok {
+		stack.Pin = nil
+	}
+}
+
+// MarkFailed records a failed deploy of a stack. The last successful commit is kept.
+func (s *State) MarkFailed(name string, failure Failure) {
+	s.stack(name).Failure = &failure
+}
+
+func (s *State) stack(name string) *Stack {
+	stack, ok := s.Stacks[name]
+	if !ok {
+		stack = &Stack{}
+		s.Stacks[name] = stack
+	}
+	return stack
+}
+
+// Store persists State as a JSON file.
+type Store struct {
+	path string
+}
+
+// NewStore returns a Store for the repository cloned at outPath. The state file
+// lives inside the .git directory so it never shows up in the working tree.
+func NewStore(outPath string) *Store {
+	return &Store{path: filepath.Join(outPath, ".git", "voyage", "state.json")}
+}
+
+// Load reads the state file. A missing file yields an empty State.
+func (s *Store) Load() (*State, error) {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return New(), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file %s: %w", s.path, err)
+	}
+
+	state := New()
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("failed to parse state file %s: %w", s.path, err)
+	}
+	if state.Stacks == nil {
+		state.Stacks = map[string]*Stack{}
+	}
+	return state, nil
+}
+
+// Exists reports whether the state file was written
+func (s *Store) Exists() (bool, error) {
+	_, err := os.Stat(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read state file %s: %w", s.path, err)
+	}
+	return true, nil
+}
+
+// Save writes the state file atomically, so an interrupted run never leaves a
+// truncated file behind.
+func (s *Store) Save(state *State) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode state: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), "state-*.json")
+	if err != nil {
+		return fmt.Errorf("failed to create state file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to replace state file %s: %w", s.path, err)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+	t.Run("Missing file loads empty state", func(t *testing.T) {
+		store := NewStore(t.TempDir())
+
+		st, err := store.Load()
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if len(st.Stacks) != 0 {
+			t.Errorf("Expected no stacks, got %v", st.Stacks)
+		}
+		if commit := st.Commit("app/compose.yml"); commit != "" {
+			t.Errorf("Expected no commit for unknown stack, got %s", commit)
+		}
+	})
+
+	t.Run("Exists once the state is saved", func(t *testing.T) {
+		store := NewStore(t.TempDir())
+
+		if exists, err := store.Exists(); exists || err != nil {
+			t.Errorf("Expected no state before the first save, but got %v, %v", exists, err)
+		}
+		if err := store.Save(New()); err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if exists, err := store.Exists(); !exists || err != nil {
+			t.Errorf("Expected the state to exist after saving, but got %v, %v", exists, err)
+		}
+	})
+
+	t.Run("Saved state round-trips", func(t *testing.T) {
+		outPath := t.TempDir()
+		store := NewStore(outPath)
+		deployedAt := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+
+		st := New()
+		st.MarkDeployed("app/compose.yml", "abc123", deployedAt)
+		if err := store.Save(st); err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+
+		if _, err := os.Stat(filepath.Join(outPath, ".git", "voyage", "state.json")); err != nil {
+			t.Fatalf("Expected state file inside .git, got %v", err)
+		}
+
+		loaded, err := store.Load()
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		if commit := loaded.Commit("app/compose.yml"); commit != "abc123" {
+			t.Errorf("Expected commit abc123, got %s", commit)
+		}
+		if !loaded.Stacks["app/compose.yml"].DeployedAt.Equal(deployedAt) {
+			t.Errorf("Expected deployedAt %s, got %s", deployedAt, loaded.Stacks["app/compose.yml"].DeployedAt)
+		}
+	})
+
+	t.Run("Failures are kept until the next successful deploy", func(t *testing.T) {
+		store := NewStore(t.TempDir())
+
+		st := New()
+		st.MarkDeployed("app/compose.yml", "c1", time.Now())
+		st.MarkFailed("app/compose.yml", Failure{Commit: "c2", Attempts: 2, LastError: "boom"})
+		if err := store.Save(st); err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+
+		loaded, err := store.Load()
+		if err != nil {
+			t.Fatalf("Expected no error, but got %v", err)
+		}
+		failure := loaded.Failure("app/compose.yml")
+		if failure == nil || failure.Commit != "c2" || failure.Attempts != 2 || failure.LastError != "boom" {
+			t.Fatalf("Unexpected failure after reload: %+v", failure)
+		}
+		if commit := loaded.Commit("app/compose.yml"); commit != "c1" {
+			t.Errorf("Expected failure to keep last deployed commit c1, got %s", commit)
+		}
+
+		loaded.MarkDeployed("app/compose.yml", "c3", time.Now())
+		if loaded.Failure("app/compose.yml") != nil {
+			t.Error("Expected successful deploy to clear the failure")
+		}
+	})
+
+	t.Run("Rollback pins the stack and keeps its previous commit", func(t *testing.T) {
+		st := New()
+		st.MarkDeployed("app/compose.yml", "c1", time.Now())
+		st.MarkDeployed("app/compose.yml", "c2", time.Now())
+		st.MarkDeployed("app/compose.yml", "c2", time.Now())
+
+		if previous := st.Previous("app/compose.yml"); previous != "c1" {
+			t.Fatalf("Expected previous commit c1, got %q", previous)
+		}
+
+		st.MarkRolledBack("app/compose.yml", Pin{Commit: "c1", Base: "c2", PinnedAt: time.Now()})
+
+		if commit := st.Commit("app/compose.yml"); commit != "c1" {
+			t.Errorf("Expected rolled back commit c1, got %q", commit)
+		}
+		if previous := st.Previous("app/compose.yml"); previous != "c1" {
+			t.Errorf("Expected previous commit to stay c1, got %q", previous)
+		}
+		if pin := st.Pin("app/compose.yml"); pin == nil || pin.Commit != "c1" || pin.Base != "c2" {
+			t.Errorf("Unexpected pin %+v", pin)
+		}
+
+		st.ClearPin("app/compose.yml")
+		if st.Pin("app/compose.yml") != nil {
+			t.Error("Expected pin to be cleared")
+		}
+	})
+
+	t.Run("Corrupt file returns error", func(t *testing.T) {
+		outPath := t.TempDir()
+		path := filepath.Join(outPath, ".git", "voyage", "state.json")
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("{not json"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := NewStore(outPath).Load(); err == nil {
+			t.Fatal("Expected an error for a corrupt state file, but got nil")
+		}
+	})
+}
@@ -0,0 +1,211 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gnugomez/voyage/log"
+)
+
+// maxPayloadSize is the largest payload accepted, matching GitHub's own limit.
+const maxPayloadSize = 25 << 20
+
+var (
+	// ErrUnsupportedProvider is returned when a request comes from no supported forge.
+	ErrUnsupportedProvider = errors.New("unsupported webhook provider")
+	// ErrInvalidSignature is returned when a request is not signed with the configured secret.
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	// ErrNotPush is returned for events other than a push, which never trigger a deploy.
+	ErrNotPush = errors.New("webhook event is not a push")
+)
+
+// Provider identifies the forge that sent a webhook.
+type Provider string
+
+const (
+	GitHub  Provider = "github"
+	Gitea   Provider = "gitea"
+	Forgejo Provider = "forgejo"
+	GitLab  Provider = "gitlab"
+)
+
+// Push holds the provider independent fields of a push event.
+type Push struct {
+	Provider Provider
+	Ref      string
+	After    string
+}
+
+// Branch returns the branch name the push was made to, or an empty string
+// when the push was not made to a branch (e.g. a tag push).
+func (p *Push) Branch() string {
+	branch, ok := strings.CutPrefix(p.Ref, "refs/heads/")
+	if !ok {
+		return ""
+	}
+	return branch
+}
+
+// Tag returns the tag name the push was made to, or an empty string when the
+// push was not made to a tag.
+func (p *Push) Tag() string {
+	tag, ok := strings.CutPrefix(p.Ref, "refs/tags/")
+	if !ok {
+		return ""
+	}
+	return tag
+}
+
+type pushPayload struct {
+	Ref   string `json:"ref"`
+	After string `json:"after"`
+}
+
+// Parse identifies the provider of a webhook request, verifies it against the
+// shared secret and decodes the push event it carries.
+func Parse(header http.Header, body []byte, secret string) (*Push, error) {
+	provider, event, err := detectProvider(header)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := verify(provider, header, body, secret); err != nil {
+		return nil, err
+	}
+
+	if !isPushEvent(provider, event) {
+		return nil, fmt.Errorf("%w: %s event %q", ErrNotPush, provider, event)
+	}
+
+	var payload pushPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("failed to decode %s push payload: %w", provider, err)
+	}
+
+	return &Push{Provider: provider, Ref: payload.Ref, After: payload.After}, nil
+}
+
+// detectProvider returns the provider and event name of a request. Gitea and
+// Forgejo also send GitHub headers for compatibility, so they are checked first.
+func detectProvider(header http.Header) (Provider, string, error) {
+	switch {
+	case header.Get("X-Forgejo-Event") != "":
+		return Forgejo, header.Get("X-Forgejo-Event"), nil
+	case header.Get("X-Gitea-Event") != "":
+		return Gitea, header.Get("X-Gitea-Event"), nil
+	case header.Get("X-Gitlab-Event") != "":
+		return GitLab, header.Get("X-Gitlab-Event"), nil
+	case header.Get("X-GitHub-Event") != "":
+		return GitHub, header.Get("X-GitHub-Event"), nil
+	default:
+		return "", "", ErrUnsupportedProvider
+	}
+}
+
+func verify(provider Provider, header http.Header, body []byte, secret string) error {
+	switch provider {
+	case GitHub:
+		signature, ok := strings.CutPrefix(header.Get("X-Hub-Signature-256"), "sha256=")
+		if !ok {
+			return ErrInvalidSignature
+		}
+		return verifyHMAC(signature, body, secret)
+	case Gitea:
+		return verifyHMAC(header.Get("X-Gitea-Signature"), body, secret)
+	case Forgejo:
+		return verifyHMAC(header.Get("X-Forgejo-Signature"), body, secret)
+	case GitLab:
+		// GitLab does not sign payloads, it sends the configured secret token as is.
+		if subtle.ConstantTimeCompare([]byte(header.Get("X-Gitlab-Token")), []byte(secret)) != 1 {
+			return ErrInvalidSignature
+		}
+		return nil
+	default:
+		return ErrUnsupportedProvider
+	}
+}
+
+func verifyHMAC(signature string, body []byte, secret string) error {
+	got, err := hex.DecodeString(signature)
+	if err != nil || len(got) == 0 {
+		return ErrInvalidSignature
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func isPushEvent(provider Provider, event string) bool {
+	if provider == GitLab {
+		return event == "Push Hook"
+	}
+	return event == "push"
+}
+
+// Handler is an http.Handler that accepts push webhooks and calls OnPush for
+// every verified push to Branch, or to any tag when Tags is set.
+type Handler struct {
+	Secret string
+	Branch string
+	Tags   bool
+	OnPush func(push *Push)
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPayloadSize))
+	if err != nil {
+		http.Error(w, "failed to read payload", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	push, err := Parse(r.Header, body, h.Secret)
+	switch {
+	case errors.Is(err, ErrInvalidSignature):
+		log.Error("Rejected webhook with invalid signature", "remote", r.RemoteAddr)
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	case errors.Is(err, ErrNotPush):
+		log.Debug("Ignoring webhook", "reason", err)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case err != nil:
+		log.Error("Rejected webhook", "error", err, "remote", r.RemoteAddr)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !h.tracks(push) {
+		log.Debug("Ignoring push to untracked ref", "provider", push.Provider, "ref", push.Ref)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	log.Info("Received push webhook", "provider", push.Provider, "ref", push.Ref, "after", push.After)
+	h.OnPush(push)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// tracks reports whether push was made to the ref the handler deploys from
+func (h *Handler) tracks(push *Push) bool {
+	if h.Tags {
+		return push.Tag() != ""
+	}
+	return push.Branch() == h.Branch
+}
@@ -0,0 +1,221 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const testSecret = "s3cret"
+
+var testPayload = []byte(`{"ref":"refs/heads/main","after":"0123abcd"}`)
+
+func sign(body []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestParse(t *testing.T) {
+	testCases := []struct {
+		name     string
+		header   http.Header
+		provider Provider
+		err      error
+	}{
+		{
+			name: "GitHub push",
+			header: http.Header{
+				"X-Github-Event":      {"push"},
+				"X-Hub-Signature-256": {"sha256=" + sign(testPayload, testSecret)},
+			},
+			provider: GitHub,
+		},
+		{
+			name: "GitHub push with wrong secret",
+			header: http.Header{
+				"X-Github-Event":      {"push"},
+				"X-Hub-Signature-256": {"sha256=" + sign(testPayload, "other")},
+			},
+			err: ErrInvalidSignature,
+		},
+		{
+			name:   "GitHub push without signature",
+			header: http.Header{"X-Github-Event": {"push"}},
+			err:    ErrInvalidSignature,
+		},
+		{
+			name: "GitHub ping",
+			header: http.Header{
+				"X-Github-Event":      {"ping"},
+				"X-Hub-Signature-256": {"sha256=" + sign(testPayload, testSecret)},
+			},
+			err: ErrNotPush,
+		},
+		{
+			name: "Gitea push also carrying GitHub headers",
+			header: http.Header{
+				"X-Gitea-Event":       {"push"},
+				"X-Gitea-Signature":   {sign(testPayload, testSecret)},
+				"X-Github-Event":      {"push"},
+				"X-Hub-Signature-256": {"sha256=invalid"},
+			},
+			provider: Gitea,
+		},
+		{
+			name: "Forgejo push",
+			header: http.Header{
+				"X-Forgejo-Event":     {"push"},
+				"X-Forgejo-Signature": {sign(testPayload, testSecret)},
+			},
+			provider: Forgejo,
+		},
+		{
+			name: "Forgejo push with tampered signature",
+			header: http.Header{
+				"X-Forgejo-Event":     {"push"},
+				"X-Forgejo-Signature": {sign([]byte("{}"), testSecret)},
+			},
+			err: ErrInvalidSignature,
+		},
+		{
+			name: "GitLab push",
+			header: http.Header{
+				"X-Gitlab-Event": {"Push Hook"},
+				"X-Gitlab-Token": {testSecret},
+			},
+			provider: GitLab,
+		},
+		{
+			name: "GitLab push with wrong token",
+			header: http.Header{
+				"X-Gitlab-Event": {"Push Hook"},
+				"X-Gitlab-Token": {"nope"},
+			},
+			err: ErrInvalidSignature,
+		},
+		{
+			name: "GitLab tag push",
+			header: http.Header{
+				"X-Gitlab-Event": {"Tag Push Hook"},
+				"X-Gitlab-Token": {testSecret},
+			},
+			err: ErrNotPush,
+		},
+		{
+			name:   "Unknown provider",
+			header: http.Header{},
+			err:    ErrUnsupportedProvider,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			push, err := Parse(tc.header, testPayload, testSecret)
+
+			if tc.err != nil {
+				if !errors.Is(err, tc.err) {
+					t.Fatalf("Expected error %v, got %v", tc.err, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Expected no error, but got %v", err)
+			}
+			if push.Provider != tc.provider {
+				t.Errorf("Expected provider %s, got %s", tc.provider, push.Provider)
+			}
+			if push.Branch() != "main" || push.After != "0123abcd" {
+				t.Errorf("Unexpected push %+v", push)
+			}
+		})
+	}
+}
+
+func TestHandler(t *testing.T) {
+	newRequest := func(body []byte) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewReader(body))
+		req.Header.Set("X-GitHub-Event", "push")
+		req.Header.Set("X-Hub-Signature-256", "sha256="+sign(body, testSecret))
+		return req
+	}
+
+	t.Run("Triggers on push to tracked branch", func(t *testing.T) {
+		called := false
+		h := &Handler{Secret: testSecret, Branch: "main", OnPush: func(*Push) { called = true }}
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, newRequest(testPayload))
+
+		if rec.Code != http.StatusAccepted {
+			t.Errorf("Expected status %d, got %d", http.StatusAccepted, rec.Code)
+		}
+		if !called {
+			t.Error("Expected OnPush to be called, but it wasn't")
+		}
+	})
+
+	t.Run("Ignores push to other branch", func(t *testing.T) {
+		called := false
+		h := &Handler{Secret: testSecret, Branch: "main", OnPush: func(*Push) { called = true }}
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, newRequest([]byte(`{"ref":"refs/heads/feature","after":"abc"}`)))
+
+		if rec.Code != http.StatusNoContent {
+			t.Errorf("Expected status %d, got %d", http.StatusNoContent, rec.Code)
+		}
+		if called {
+			t.Error("Expected OnPush not to be called for another branch")
+		}
+	})
+
+	t.Run("Triggers on tag pushes only when tracking tags", func(t *testing.T) {
+		called := false
+		h := &Handler{Secret: testSecret, Tags: true, OnPush: func(*Push) { called = true }}
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, newRequest(testPayload))
+		if rec.Code != http.StatusNoContent || called {
+			t.Errorf("Expected a branch push to be ignored, got status %d", rec.Code)
+		}
+
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, newRequest([]byte(`{"ref":"refs/tags/v1.2.0","after":"abc"}`)))
+		if rec.Code != http.StatusAccepted || !called {
+			t.Errorf("Expected a tag push to trigger OnPush, got status %d", rec.Code)
+		}
+	})
+
+	t.Run("Rejects invalid signature", func(t *testing.T) {
+		called := false
+		h := &Handler{Secret: "different", Branch: "main", OnPush: func(*Push) { called = true }}
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, newRequest(testPayload))
+
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, rec.Code)
+		}
+		if called {
+			t.Error("Expected OnPush not to be called for an invalid signature")
+		}
+	})
+
+	t.Run("Rejects non POST requests", func(t *testing.T) {
+		h := &Handler{Secret: testSecret, Branch: "main", OnPush: func(*Push) {}}
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/webhook", nil))
+
+		if rec.Code != http.StatusMethodNotAllowed {
+			t.Errorf("Expected status %d, got %d", http.StatusMethodNotAllowed, rec.Code)
+		}
+	})
+}